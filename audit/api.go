@@ -0,0 +1,175 @@
+package audit
+
+import (
+	"encoding/json"
+	"home-ssm/awslib"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const lookupEventsTarget = "com.amazonaws.cloudtrail.v20131101.CloudTrail_20131101.LookupEvents"
+
+var lookupAttributeKeys = []string{
+	"EventId", "EventName", "ReadOnly", "Username", "ResourceType", "ResourceName", "EventSource", "AccessKeyId",
+}
+
+type LookupEventsRequest struct {
+	LookupAttributes []LookupAttribute
+	StartTime        *float64
+	EndTime          *float64
+	MaxResults       *int
+	NextToken        *string
+}
+
+type LookupEventsResource struct {
+	ResourceType string `json:"ResourceType"`
+	ResourceName string `json:"ResourceName"`
+}
+
+type LookupEventsItem struct {
+	AccessKeyId     string                 `json:"AccessKeyId,omitempty"`
+	CloudTrailEvent string                 `json:"CloudTrailEvent"`
+	EventId         string                 `json:"EventId"`
+	EventName       string                 `json:"EventName"`
+	EventSource     string                 `json:"EventSource"`
+	EventTime       float64                `json:"EventTime"`
+	ReadOnly        string                 `json:"ReadOnly"`
+	Resources       []LookupEventsResource `json:"Resources"`
+	Username        string                 `json:"Username,omitempty"`
+}
+
+type LookupEventsResponse struct {
+	Events    []LookupEventsItem `json:"Events"`
+	NextToken string             `json:"NextToken,omitempty"`
+}
+
+// LookupApi serves the CloudTrail LookupEvents operation over the audit store.
+type LookupApi struct {
+	store  *Store
+	region string
+}
+
+func NewLookupApi(store *Store, region string) *LookupApi {
+
+	return &LookupApi{store: store, region: region}
+}
+
+func (api *LookupApi) Handle(w http.ResponseWriter, r *http.Request) {
+
+	amztarget := r.Header.Get("X-Amz-Target")
+	if amztarget != lookupEventsTarget {
+
+		awslib.Logger(r.Context()).Warn("unknown target")
+		awslib.WriteErrorResponseJSON(w, awslib.ErrorCodes[awslib.ErrValidationError], r.URL, api.region)
+		return
+	}
+
+	var request LookupEventsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query, err := request.toQuery()
+	if err == nil {
+
+		query.AccountId = awslib.GetRequestInfo(r.Context()).Principal.AccountID
+
+		var events []Event
+		var nextToken string
+		events, nextToken, err = api.store.Lookup(r.Context(), query)
+		if err == nil {
+
+			awslib.WriteSuccessResponseJSON(w, newLookupEventsResponse(events, nextToken))
+			return
+		}
+	}
+
+	awslib.Logger(r.Context()).Warn("request failed", "error", err)
+	awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.region)
+}
+
+func (request *LookupEventsRequest) toQuery() (*Query, error) {
+
+	query := Query{Attributes: request.LookupAttributes}
+
+	for _, attr := range request.LookupAttributes {
+
+		if !slices.Contains(lookupAttributeKeys, attr.AttributeKey) {
+			return nil, ErrInvalidLookupAttributes
+		}
+	}
+
+	if request.StartTime != nil {
+		query.StartTime = fromEpochSeconds(*request.StartTime)
+	}
+
+	if request.EndTime != nil {
+		query.EndTime = fromEpochSeconds(*request.EndTime)
+	}
+
+	if !query.StartTime.IsZero() && !query.EndTime.IsZero() && query.EndTime.Before(query.StartTime) {
+		return nil, ErrInvalidTimeRange
+	}
+
+	if request.MaxResults != nil {
+
+		if *request.MaxResults < 1 || *request.MaxResults > DefaultMaxResults {
+			return nil, ErrInvalidMaxResults
+		}
+
+		query.MaxResults = *request.MaxResults
+	}
+
+	if request.NextToken != nil {
+		query.NextToken = *request.NextToken
+	}
+
+	return &query, nil
+}
+
+func newLookupEventsResponse(events []Event, nextToken string) *LookupEventsResponse {
+
+	response := LookupEventsResponse{Events: []LookupEventsItem{}, NextToken: nextToken}
+	for _, event := range events {
+
+		record, _ := json.Marshal(event)
+		item := LookupEventsItem{
+			AccessKeyId:     event.UserIdentity.AccessKeyId,
+			CloudTrailEvent: string(record),
+			EventId:         event.EventId,
+			EventName:       event.EventName,
+			EventSource:     event.EventSource,
+			EventTime:       float64(event.EventTime.UnixNano()) / float64(time.Second),
+			ReadOnly:        boolString(event.ReadOnly),
+			Resources:       []LookupEventsResource{},
+			Username:        event.UserIdentity.UserName,
+		}
+
+		for _, name := range event.ResourceNames() {
+			item.Resources = append(item.Resources,
+				LookupEventsResource{ResourceType: event.ResourceType(), ResourceName: name})
+		}
+
+		response.Events = append(response.Events, item)
+	}
+
+	return &response
+}
+
+func fromEpochSeconds(seconds float64) time.Time {
+
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*float64(time.Second))).UTC()
+}
+
+func boolString(b bool) string {
+
+	if b {
+		return "true"
+	}
+
+	return "false"
+}
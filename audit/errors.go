@@ -0,0 +1,49 @@
+package audit
+
+import (
+	"errors"
+	"home-ssm/awslib"
+	"net/http"
+)
+
+var (
+	ErrInvalidLookupAttributes = errors.New("The lookup attribute key is not valid.")
+	ErrInvalidTimeRange        = errors.New("The start time must be before the end time.")
+	ErrInvalidMaxResults       = errors.New("MaxResults must be between 1 and 50.")
+)
+
+type errorCodeMap map[error]awslib.APIError
+
+var AuditErrorCodes = errorCodeMap{
+	ErrInvalidNextToken: {
+		Code:           "InvalidNextTokenException",
+		Description:    ErrInvalidNextToken.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidLookupAttributes: {
+		Code:           "InvalidLookupAttributesException",
+		Description:    ErrInvalidLookupAttributes.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidTimeRange: {
+		Code:           "InvalidTimeRangeException",
+		Description:    ErrInvalidTimeRange.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidMaxResults: {
+		Code:           "InvalidMaxResultsException",
+		Description:    ErrInvalidMaxResults.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+}
+
+func translateToApiError(err error) awslib.APIError {
+
+	value, ok := AuditErrorCodes[err]
+	if ok {
+
+		return value
+	}
+
+	return awslib.ErrorCodes[awslib.ErrInternalError]
+}
@@ -0,0 +1,129 @@
+package audit
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// hiddenValue is what CloudTrail shows in place of sensitive request parameters.
+const hiddenValue = "HIDDEN_DUE_TO_SECURITY_REASONS"
+
+// request parameters whose values are never stored
+var sensitiveParameters = []string{
+	"Value", "SecretString", "SecretBinary", "Plaintext", "CiphertextBlob", "SecretAccessKey", "SessionToken",
+}
+
+type UserIdentity struct {
+	Type        string `json:"type"`
+	PrincipalId string `json:"principalId,omitempty"`
+	Arn         string `json:"arn,omitempty"`
+	AccountId   string `json:"accountId,omitempty"`
+	AccessKeyId string `json:"accessKeyId,omitempty"`
+	UserName    string `json:"userName,omitempty"`
+}
+
+// Event is a CloudTrail style record of a single API call.
+type Event struct {
+	EventVersion       string         `json:"eventVersion"`
+	UserIdentity       UserIdentity   `json:"userIdentity"`
+	EventTime          time.Time      `json:"eventTime"`
+	EventSource        string         `json:"eventSource"`
+	EventName          string         `json:"eventName"`
+	AwsRegion          string         `json:"awsRegion"`
+	SourceIPAddress    string         `json:"sourceIPAddress"`
+	UserAgent          string         `json:"userAgent"`
+	RequestParameters  map[string]any `json:"requestParameters"`
+	ErrorCode          string         `json:"errorCode,omitempty"`
+	RequestId          string         `json:"requestID"`
+	EventId            string         `json:"eventID"`
+	ReadOnly           bool           `json:"readOnly"`
+	EventType          string         `json:"eventType"`
+	RecipientAccountId string         `json:"recipientAccountId"`
+}
+
+// ResourceNames returns the parameter or resource names found in the request.
+func (e *Event) ResourceNames() []string {
+
+	var result []string
+	for _, key := range []string{"Name", "Path", "ResourceId", "SecretId", "KeyId"} {
+
+		if value, ok := e.RequestParameters[key].(string); ok && value != "" {
+			result = append(result, value)
+		}
+	}
+
+	if names, ok := e.RequestParameters["Names"].([]any); ok {
+
+		for _, name := range names {
+			if value, ok := name.(string); ok {
+				result = append(result, value)
+			}
+		}
+	}
+
+	return result
+}
+
+func (e *Event) ResourceType() string {
+
+	switch e.EventSource {
+	case "ssm.amazonaws.com":
+		return "AWS::SSM::Parameter"
+	case "kms.amazonaws.com":
+		return "AWS::KMS::Key"
+	case "secretsmanager.amazonaws.com":
+		return "AWS::SecretsManager::Secret"
+	}
+
+	return ""
+}
+
+// redactParameters decodes a JSON request body replacing sensitive values.
+func redactParameters(body []byte) map[string]any {
+
+	var params map[string]any
+	if len(body) == 0 || json.Unmarshal(body, &params) != nil {
+		return nil
+	}
+
+	redactMap(params)
+
+	return params
+}
+
+func redactMap(params map[string]any) {
+
+	for key, value := range params {
+
+		for _, sensitive := range sensitiveParameters {
+
+			if strings.EqualFold(key, sensitive) {
+				params[key] = hiddenValue
+			}
+		}
+
+		switch nested := value.(type) {
+		case map[string]any:
+			redactMap(nested)
+		case []any:
+			for _, item := range nested {
+				if m, ok := item.(map[string]any); ok {
+					redactMap(m)
+				}
+			}
+		}
+	}
+}
+
+func isReadOnly(eventName string) bool {
+
+	for _, prefix := range []string{"Get", "Describe", "List", "Lookup"} {
+
+		if strings.HasPrefix(eventName, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
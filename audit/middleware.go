@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"bytes"
+	"cmp"
+	"home-ssm/awslib"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+type UserArnGenerator func(*aws.Credentials) string
+
+// Recorder writes an audit event for every request passing through it,
+// including those rejected by authentication.
+type Recorder struct {
+	store     *Store
+	region    string
+	accountId string
+	userArn   UserArnGenerator
+	disabled  bool
+}
+
+func NewRecorder(store *Store, config Config, region string, accountId string, userArn UserArnGenerator) *Recorder {
+
+	return &Recorder{
+		store:     store,
+		region:    region,
+		accountId: accountId,
+		userArn:   userArn,
+		disabled:  config.Disabled,
+	}
+}
+
+// Middleware must run inside awslib.WithRequestId and outside SigV4
+// verification so denied requests are recorded too.
+func (rec *Recorder) Middleware(next http.HandlerFunc) http.HandlerFunc {
+
+	if rec.disabled {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, 10*(1<<20)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		eventTime := time.Now().UTC()
+		recorder := awslib.NewStatusRecorder(w)
+		next(recorder, r)
+
+		rec.store.Append(r.Context(), rec.newEvent(r, body, eventTime, recorder.ErrorCode()))
+	}
+}
+
+func (rec *Recorder) newEvent(r *http.Request, body []byte, eventTime time.Time, errorCode string) *Event {
+
+	info := awslib.GetRequestInfo(r.Context())
+	name := info.Operation[strings.LastIndex(info.Operation, ".")+1:]
+
+	event := Event{
+		EventVersion:       "1.08",
+		EventTime:          eventTime,
+		EventSource:        cmp.Or(string(info.Service), "unknown") + ".amazonaws.com",
+		EventName:          name,
+		AwsRegion:          cmp.Or(info.Region, rec.region),
+		SourceIPAddress:    info.SourceIp,
+		UserAgent:          r.UserAgent(),
+		ErrorCode:          errorCode,
+		RequestId:          info.RequestId,
+		EventId:            awslib.NewUUID(),
+		ReadOnly:           isReadOnly(name),
+		EventType:          "AwsApiCall",
+		RecipientAccountId: rec.accountId,
+	}
+
+	if isFormRequest(r) {
+		event.RequestParameters = redactForm(body)
+	} else {
+		event.RequestParameters = redactParameters(body)
+	}
+
+	if info.Principal != nil {
+
+		identityType := "IAMUser"
+		if awslib.IsAssumedRole(info.Principal) {
+			identityType = "AssumedRole"
+		}
+
+		event.UserIdentity = UserIdentity{
+			Type:        identityType,
+			PrincipalId: info.Principal.AccessKeyID,
+			Arn:         rec.userArn(info.Principal),
+			AccountId:   info.Principal.AccountID,
+			AccessKeyId: info.Principal.AccessKeyID,
+			UserName:    info.Principal.Source,
+		}
+		event.RecipientAccountId = info.Principal.AccountID
+
+	} else {
+
+		// rejected before the caller was identified
+		event.UserIdentity = UserIdentity{Type: "Unknown", AccessKeyId: info.AccessKey}
+	}
+
+	return &event
+}
+
+func isFormRequest(r *http.Request) bool {
+
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded")
+}
+
+func redactForm(body []byte) map[string]any {
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil
+	}
+
+	params := make(map[string]any, len(values))
+	for key := range values {
+		params[key] = values.Get(key)
+	}
+
+	redactMap(params)
+
+	return params
+}
@@ -0,0 +1,189 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"home-ssm/awslib"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	primaryAccountId = "000000000000"
+	otherAccountId   = "111111111111"
+)
+
+var (
+	primaryCredentials = aws.Credentials{AccessKeyID: "AKIAPRIMARY", SecretAccessKey: "secret", Source: "alice", AccountID: primaryAccountId}
+	otherCredentials   = aws.Credentials{AccessKeyID: "AKIAOTHER", SecretAccessKey: "secret", Source: "bob", AccountID: otherAccountId}
+)
+
+func newTestStore(t *testing.T) *Store {
+
+	t.Helper()
+
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return NewStore(db, Config{})
+}
+
+// newTestHandler serves SSM, STS and LookupEvents the way the server does.
+func newTestHandler(store *Store) http.HandlerFunc {
+
+	ok := func(w http.ResponseWriter, r *http.Request) { awslib.WriteSuccessResponseJSON(w, struct{}{}) }
+
+	router := awslib.NewRouter(awslib.CredentialsProvider{
+		Region:    "us-east-1",
+		AccountId: primaryAccountId,
+		Keyring:   awslib.NewKeyring([]aws.Credentials{primaryCredentials, otherCredentials}),
+	})
+	router.Register(awslib.Service{Type: awslib.ServiceSsm, TargetPrefix: "AmazonSSM",
+		Regions: []string{"eu-west-1"}, MultiAccount: true, Handler: ok})
+	router.Register(awslib.Service{Type: awslib.ServiceSts, Protocol: awslib.ProtocolQuery, Handler: ok})
+	router.Register(awslib.Service{Type: awslib.ServiceCloudTrail, MultiAccount: true,
+		TargetPrefix: "com.amazonaws.cloudtrail.v20131101.CloudTrail_20131101", Handler: NewLookupApi(store, "us-east-1").Handle})
+
+	recorder := NewRecorder(store, Config{}, "us-east-1", primaryAccountId, func(creds *aws.Credentials) string {
+		return "arn:aws:iam::" + creds.AccountID + ":user/" + creds.Source
+	})
+
+	return awslib.WithRequestId(recorder.Middleware(router.Handle))
+}
+
+func sendSigned(t *testing.T, handler http.HandlerFunc, creds aws.Credentials, service awslib.ServiceType, region string, target string, body string) *httptest.ResponseRecorder {
+
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.RemoteAddr = "192.0.2.1:1234"
+	if target != "" {
+		r.Header.Set("X-Amz-Target", target)
+		r.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	} else {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	sum := sha256.Sum256([]byte(body))
+	err := v4.NewSigner().SignHTTP(context.Background(), creds, r, hex.EncodeToString(sum[:]), string(service), region, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	return w
+}
+
+func TestRecorderEvent(t *testing.T) {
+
+	wrongSecret := otherCredentials
+	wrongSecret.SecretAccessKey = "wrong"
+
+	tests := []struct {
+		name        string
+		creds       aws.Credentials
+		service     awslib.ServiceType
+		region      string
+		target      string
+		body        string
+		source      string
+		eventName   string
+		identity    string
+		recipientId string
+	}{
+		{"json", otherCredentials, awslib.ServiceSsm, "eu-west-1", "AmazonSSM.GetParameter", `{"Name":"/a"}`,
+			"ssm.amazonaws.com", "GetParameter", "IAMUser", otherAccountId},
+		{"query", primaryCredentials, awslib.ServiceSts, "us-east-1", "", "Action=GetCallerIdentity&Version=2011-06-15",
+			"sts.amazonaws.com", "GetCallerIdentity", "IAMUser", primaryAccountId},
+		{"rejected", wrongSecret, awslib.ServiceSsm, "eu-west-1", "AmazonSSM.PutParameter", `{"Name":"/a"}`,
+			"ssm.amazonaws.com", "PutParameter", "Unknown", primaryAccountId},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			store := newTestStore(t)
+			sendSigned(t, newTestHandler(store), test.creds, test.service, test.region, test.target, test.body)
+
+			events, _, err := store.Lookup(context.Background(), &Query{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(events) != 1 {
+				t.Fatalf("got %d events, want 1", len(events))
+			}
+
+			event := events[0]
+			if event.EventSource != test.source || event.EventName != test.eventName {
+				t.Errorf("event %s %s, want %s %s", event.EventSource, event.EventName, test.source, test.eventName)
+			}
+
+			if event.AwsRegion != test.region {
+				t.Errorf("region %s, want %s", event.AwsRegion, test.region)
+			}
+
+			if event.SourceIPAddress != "192.0.2.1" {
+				t.Errorf("source ip %s, want 192.0.2.1", event.SourceIPAddress)
+			}
+
+			if event.UserIdentity.Type != test.identity || event.UserIdentity.AccessKeyId != test.creds.AccessKeyID {
+				t.Errorf("identity %s %s, want %s %s", event.UserIdentity.Type, event.UserIdentity.AccessKeyId, test.identity, test.creds.AccessKeyID)
+			}
+
+			if event.RecipientAccountId != test.recipientId {
+				t.Errorf("recipient account %s, want %s", event.RecipientAccountId, test.recipientId)
+			}
+		})
+	}
+}
+
+func TestLookupEventsByAccount(t *testing.T) {
+
+	store := newTestStore(t)
+	handler := newTestHandler(store)
+	sendSigned(t, handler, primaryCredentials, awslib.ServiceSsm, "us-east-1", "AmazonSSM.GetParameter", `{"Name":"/a"}`)
+	sendSigned(t, handler, otherCredentials, awslib.ServiceSsm, "us-east-1", "AmazonSSM.GetParameter", `{"Name":"/b"}`)
+
+	tests := []struct {
+		creds    aws.Credentials
+		username string
+	}{
+		{primaryCredentials, "alice"},
+		{otherCredentials, "bob"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.creds.AccountID, func(t *testing.T) {
+
+			w := sendSigned(t, handler, test.creds, awslib.ServiceCloudTrail, "us-east-1",
+				lookupEventsTarget, `{"LookupAttributes":[{"AttributeKey":"EventName","AttributeValue":"GetParameter"}]}`)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d %s", w.Code, w.Body.String())
+			}
+
+			var response LookupEventsResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(response.Events) != 1 || response.Events[0].Username != test.username {
+				t.Errorf("got events %+v, want only those of %s", response.Events, test.username)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"home-ssm/tracing"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	keyPrefix = "audit/"
+
+	DefaultRetention  = 90 * 24 * time.Hour
+	DefaultMaxResults = 50
+)
+
+var ErrInvalidNextToken = errors.New("The NextToken is not valid.")
+
+type Config struct {
+	// Disabled turns off recording of audit events.
+	Disabled bool `yaml:"disabled"`
+	// RetentionDays is how long events are kept, defaults to 90.
+	RetentionDays int `yaml:"retentionDays"`
+}
+
+// Store keeps audit events in their own badger keyspace; events expire
+// through badger TTLs once the retention period passes.
+type Store struct {
+	db        *badger.DB
+	retention time.Duration
+}
+
+func NewStore(db *badger.DB, config Config) *Store {
+
+	retention := DefaultRetention
+	if config.RetentionDays > 0 {
+		retention = time.Duration(config.RetentionDays) * 24 * time.Hour
+	}
+
+	return &Store{db: db, retention: retention}
+}
+
+// eventKey orders events by time, the event id keeps keys unique.
+func eventKey(eventTime time.Time, eventId string) []byte {
+
+	return []byte(fmt.Sprintf("%s%016x/%s", keyPrefix, eventTime.UnixNano(), eventId))
+}
+
+func (store *Store) Append(ctx context.Context, event *Event) error {
+
+	_, span := tracing.Start(ctx, "Audit.Append")
+	defer span.End()
+
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	err = store.db.Update(func(txn *badger.Txn) error {
+		entry := badger.NewEntry(eventKey(event.EventTime, event.EventId), value).WithTTL(store.retention)
+		return txn.SetEntry(entry)
+	})
+
+	tracing.RecordError(span, err)
+	if err != nil {
+		slog.Error("Failed to write audit event.", "error", err)
+	}
+
+	return err
+}
+
+type LookupAttribute struct {
+	AttributeKey   string
+	AttributeValue string
+}
+
+type Query struct {
+	// AccountId, when set, limits the events to those received by the account.
+	AccountId  string
+	Attributes []LookupAttribute
+	StartTime  time.Time
+	EndTime    time.Time
+	MaxResults int
+	NextToken  string
+}
+
+func (q *Query) matches(event *Event) bool {
+
+	if !q.StartTime.IsZero() && event.EventTime.Before(q.StartTime) {
+		return false
+	}
+
+	if q.AccountId != "" && event.RecipientAccountId != q.AccountId {
+		return false
+	}
+
+	for _, attr := range q.Attributes {
+
+		var ok bool
+		switch attr.AttributeKey {
+		case "EventId":
+			ok = event.EventId == attr.AttributeValue
+		case "EventName":
+			ok = event.EventName == attr.AttributeValue
+		case "ReadOnly":
+			ok = strings.EqualFold(fmt.Sprint(event.ReadOnly), attr.AttributeValue)
+		case "Username":
+			ok = event.UserIdentity.UserName == attr.AttributeValue
+		case "AccessKeyId":
+			ok = event.UserIdentity.AccessKeyId == attr.AttributeValue
+		case "EventSource":
+			ok = event.EventSource == attr.AttributeValue
+		case "ResourceType":
+			ok = event.ResourceType() == attr.AttributeValue
+		case "ResourceName":
+			for _, name := range event.ResourceNames() {
+				ok = ok || name == attr.AttributeValue
+			}
+		case "ErrorCode":
+			ok = event.ErrorCode == attr.AttributeValue
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Lookup returns matching events newest first along with a token for the
+// next page, which is empty when there are no more events.
+func (store *Store) Lookup(ctx context.Context, query *Query) ([]Event, string, error) {
+
+	_, span := tracing.Start(ctx, "Audit.Lookup")
+	defer span.End()
+
+	maxResults := query.MaxResults
+	if maxResults <= 0 || maxResults > DefaultMaxResults {
+		maxResults = DefaultMaxResults
+	}
+
+	// reverse iteration seeks to the largest key <= seek
+	seek := []byte(keyPrefix + "~")
+	if !query.EndTime.IsZero() {
+		seek = []byte(fmt.Sprintf("%s%016x~", keyPrefix, query.EndTime.UnixNano()))
+	}
+
+	if query.NextToken != "" {
+
+		token, err := base64.RawURLEncoding.DecodeString(query.NextToken)
+		if err != nil || !strings.HasPrefix(string(token), keyPrefix) {
+			return nil, "", ErrInvalidNextToken
+		}
+
+		seek = token
+	}
+
+	var events []Event
+	var nextToken string
+
+	err := store.db.View(func(txn *badger.Txn) error {
+
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = true
+		opts.Prefix = []byte(keyPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(seek); it.Valid(); it.Next() {
+
+			item := it.Item()
+			if query.NextToken != "" && string(item.Key()) == string(seek) {
+				continue
+			}
+
+			var event Event
+			if err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &event)
+			}); err != nil {
+				return err
+			}
+
+			// keys are time ordered so nothing older can match
+			if !query.StartTime.IsZero() && event.EventTime.Before(query.StartTime) {
+				break
+			}
+
+			if !query.matches(&event) {
+				continue
+			}
+
+			if len(events) == maxResults {
+				nextToken = base64.RawURLEncoding.EncodeToString(eventKey(events[len(events)-1].EventTime, events[len(events)-1].EventId))
+				break
+			}
+
+			events = append(events, event)
+		}
+
+		return nil
+	})
+
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return events, nextToken, nil
+}
@@ -9,12 +9,14 @@ import (
 
 // APIErrorResponse - error response format
 type APIErrorResponse struct {
-	Code      string `json:"__type"`
-	Message   string `json:"Message"`
-	Resource  string
-	Region    string `xml:"Region,omitempty" json:"Region,omitempty"`
-	RequestID string `xml:"RequestId" json:"RequestId"`
-	HostID    string `xml:"HostId" json:"HostId"`
+	Code    string `json:"__type"`
+	Message string `json:"Message"`
+	// some SDKs, such as KMS, only read the lowercase member
+	LowerMessage string `xml:"-" json:"message"`
+	Resource     string
+	Region       string `xml:"Region,omitempty" json:"Region,omitempty"`
+	RequestID    string `xml:"RequestId" json:"RequestId"`
+	HostID       string `xml:"HostId" json:"HostId"`
 }
 
 // APIErrorCode type of error status.
@@ -57,7 +59,7 @@ const (
 	ErrCredMalformed
 	ErrMalformedCredentialDate
 	ErrAuthorizationHeaderMalformed
-	ErrInvalidServiceSSM
+	ErrInvalidService
 	ErrInvalidRequestVersion
 	ErrMissingSignTag
 	ErrMissingSignHeadersTag
@@ -67,8 +69,63 @@ const (
 	ErrAuthHeaderEmpty
 	ErrSignatureVersionNotSupported
 	ErrValidationError
+	ErrInvalidClientTokenId
+	ErrExpiredToken
+	ErrUnknownService
+	ErrUnsupportedContentType
+	ErrInvalidQueryParams
+	ErrMalformedExpires
+	ErrExpiredPresignRequest
+	ErrRequestTimeTooSkewed
+	ErrCredentialDateMismatch
+	ErrReplayedRequest
+	ErrLockedOut
 )
 
+var errorCodeNames = map[APIErrorCode]string{
+	ErrNone:                         "None",
+	ErrAccessDenied:                 "AccessDenied",
+	ErrInternalError:                "InternalError",
+	ErrUnsignedHeaders:              "UnsignedHeaders",
+	ErrMissingDateHeader:            "MissingDateHeader",
+	ErrMissingFields:                "MissingFields",
+	ErrMissingCredTag:               "MissingCredTag",
+	ErrCredMalformed:                "CredMalformed",
+	ErrMalformedCredentialDate:      "MalformedCredentialDate",
+	ErrAuthorizationHeaderMalformed: "AuthorizationHeaderMalformed",
+	ErrInvalidService:               "InvalidService",
+	ErrInvalidRequestVersion:        "InvalidRequestVersion",
+	ErrMissingSignTag:               "MissingSignTag",
+	ErrMissingSignHeadersTag:        "MissingSignHeadersTag",
+	ErrInvalidAccessKeyID:           "InvalidAccessKeyID",
+	ErrMalformedDate:                "MalformedDate",
+	ErrSignatureDoesNotMatch:        "SignatureDoesNotMatch",
+	ErrAuthHeaderEmpty:              "AuthHeaderEmpty",
+	ErrSignatureVersionNotSupported: "SignatureVersionNotSupported",
+	ErrValidationError:              "ValidationError",
+	ErrInvalidClientTokenId:         "InvalidClientTokenId",
+	ErrExpiredToken:                 "ExpiredToken",
+	ErrUnknownService:               "UnknownService",
+	ErrUnsupportedContentType:       "UnsupportedContentType",
+	ErrInvalidQueryParams:           "InvalidQueryParams",
+	ErrMalformedExpires:             "MalformedExpires",
+	ErrExpiredPresignRequest:        "ExpiredPresignRequest",
+	ErrRequestTimeTooSkewed:         "RequestTimeTooSkewed",
+	ErrCredentialDateMismatch:       "CredentialDateMismatch",
+	ErrReplayedRequest:              "ReplayedRequest",
+	ErrLockedOut:                    "LockedOut",
+}
+
+// String returns the name of the error code, used as a metrics label.
+func (c APIErrorCode) String() string {
+
+	if name, ok := errorCodeNames[c]; ok {
+		return name
+	}
+
+	return "Unknown"
+}
+
 // ErrorCodes error code to APIError structure, these fields carry respective
 // descriptions for all the error responses.
 var ErrorCodes = errorCodeMap{
@@ -127,9 +184,9 @@ var ErrorCodes = errorCodeMap{
 		Description:    "Invalid date format header, expected to be in ISO8601, RFC1123 or RFC1123Z time format.",
 		HTTPStatusCode: http.StatusBadRequest,
 	},
-	ErrInvalidServiceSSM: {
+	ErrInvalidService: {
 		Code:           "AuthorizationParametersError",
-		Description:    "Error parsing the Credential/X-Amz-Credential parameter; incorrect service. This endpoint belongs to \"ssm\".",
+		Description:    "Error parsing the Credential/X-Amz-Credential parameter; incorrect service. The service doesn't match the X-Amz-Target of the request.",
 		HTTPStatusCode: http.StatusBadRequest,
 	},
 	ErrInvalidRequestVersion: {
@@ -172,4 +229,59 @@ var ErrorCodes = errorCodeMap{
 		Description:    "The request failed validation.",
 		HTTPStatusCode: http.StatusBadRequest,
 	},
+	ErrInvalidClientTokenId: {
+		Code:           "InvalidClientTokenId",
+		Description:    "The security token included in the request is invalid.",
+		HTTPStatusCode: http.StatusForbidden,
+	},
+	ErrExpiredToken: {
+		Code:           "ExpiredToken",
+		Description:    "The security token included in the request is expired",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrUnknownService: {
+		Code:           "UnknownOperationException",
+		Description:    "The requested service isn't hosted by this endpoint.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrUnsupportedContentType: {
+		Code:           "SerializationException",
+		Description:    "The Content-Type of the request isn't supported by the service.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidQueryParams: {
+		Code:           "AuthorizationQueryParametersError",
+		Description:    "Query-string authentication version 4 requires the X-Amz-Algorithm, X-Amz-Credential, X-Amz-Signature, X-Amz-Date, X-Amz-SignedHeaders, and X-Amz-Expires parameters.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrMalformedExpires: {
+		Code:           "AuthorizationQueryParametersError",
+		Description:    "X-Amz-Expires must be a number between 1 and 604800 seconds.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrExpiredPresignRequest: {
+		Code:           "AccessDenied",
+		Description:    "Request has expired",
+		HTTPStatusCode: http.StatusForbidden,
+	},
+	ErrRequestTimeTooSkewed: {
+		Code:           "RequestTimeTooSkewed",
+		Description:    "The difference between the request time and the server's time is too large.",
+		HTTPStatusCode: http.StatusForbidden,
+	},
+	ErrCredentialDateMismatch: {
+		Code:           "SignatureDoesNotMatch",
+		Description:    "Credential should be scoped to a valid date matching the request date.",
+		HTTPStatusCode: http.StatusForbidden,
+	},
+	ErrReplayedRequest: {
+		Code:           "InvalidSignatureException",
+		Description:    "The request signature has already been used; sign each mutating request again.",
+		HTTPStatusCode: http.StatusForbidden,
+	},
+	ErrLockedOut: {
+		Code:           "AccessDenied",
+		Description:    "Too many failed authentication attempts, try again later.",
+		HTTPStatusCode: http.StatusForbidden,
+	},
 }
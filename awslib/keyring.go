@@ -0,0 +1,129 @@
+package awslib
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// usageInterval limits how often the last use of a key is reported.
+const usageInterval = time.Minute
+
+// KeyUsage is the last authenticated use of an access key.
+type KeyUsage struct {
+	Date        time.Time
+	ServiceName string
+	Region      string
+}
+
+// Keyring indexes long-term credentials by access key. It's shared by the
+// providers of every service and safe for concurrent use.
+type Keyring struct {
+	mu       sync.RWMutex
+	keys     map[string]aws.Credentials
+	lastUsed map[string]KeyUsage
+	onUse    func(accessKey string, usage KeyUsage)
+}
+
+func NewKeyring(credentials []aws.Credentials) *Keyring {
+
+	keyring := Keyring{
+		keys:     make(map[string]aws.Credentials, len(credentials)),
+		lastUsed: make(map[string]KeyUsage),
+	}
+
+	for _, creds := range credentials {
+		keyring.keys[creds.AccessKeyID] = creds
+	}
+
+	return &keyring
+}
+
+func (k *Keyring) Lookup(accessKey string) (*aws.Credentials, bool) {
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	creds, ok := k.keys[accessKey]
+	if !ok {
+		return nil, false
+	}
+
+	return &creds, true
+}
+
+// Add adds or replaces the credentials of the access key.
+func (k *Keyring) Add(creds aws.Credentials) {
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys[creds.AccessKeyID] = creds
+}
+
+// Replace removes and adds keys in one step, so no request sees a partial
+// change.
+func (k *Keyring) Replace(removed []string, added []aws.Credentials) {
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for _, accessKey := range removed {
+		delete(k.keys, accessKey)
+	}
+
+	for _, creds := range added {
+		k.keys[creds.AccessKeyID] = creds
+	}
+}
+
+func (k *Keyring) Remove(accessKey string) {
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	delete(k.keys, accessKey)
+}
+
+// OnUse sets a callback for key usage, called at most once a minute per
+// key, e.g. to persist it.
+func (k *Keyring) OnUse(onUse func(accessKey string, usage KeyUsage)) {
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.onUse = onUse
+}
+
+// SetLastUsed restores a usage recorded earlier.
+func (k *Keyring) SetLastUsed(accessKey string, usage KeyUsage) {
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.lastUsed[accessKey] = usage
+}
+
+func (k *Keyring) LastUsed(accessKey string) (KeyUsage, bool) {
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	usage, ok := k.lastUsed[accessKey]
+	return usage, ok
+}
+
+func (k *Keyring) markUsed(accessKey string, service ServiceType, region string, now time.Time) {
+
+	k.mu.Lock()
+	previous, seen := k.lastUsed[accessKey]
+	usage := KeyUsage{Date: now.UTC(), ServiceName: string(service), Region: region}
+	k.lastUsed[accessKey] = usage
+	onUse := k.onUse
+	k.mu.Unlock()
+
+	if onUse != nil && (!seen || now.Sub(previous.Date) >= usageInterval || previous.ServiceName != usage.ServiceName) {
+		onUse(accessKey, usage)
+	}
+}
@@ -0,0 +1,263 @@
+package awslib
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxFailures    = 5
+	defaultLockoutSeconds = 60
+	defaultMaxLockout     = 3600
+	maxLockoutEntries     = 10000
+	// authenticatedRetention is how long an access key's lockout doesn't
+	// apply to a source IP it authenticated from.
+	authenticatedRetention = 7 * 24 * time.Hour
+)
+
+type LockoutConfig struct {
+	// Enabled locks out source IPs and access keys after repeated
+	// authentication failures.
+	Enabled bool `yaml:"enabled"`
+	// MaxFailures is the number of failures before a lockout, defaults to 5.
+	MaxFailures int `yaml:"maxFailures"`
+	// LockoutSeconds is the first lockout, doubled for each following one
+	// up to MaxLockoutSeconds. Defaults to 60 and 3600.
+	LockoutSeconds    int `yaml:"lockoutSeconds"`
+	MaxLockoutSeconds int `yaml:"maxLockoutSeconds"`
+	// TrustedCidrs are never locked out.
+	TrustedCidrs []string `yaml:"trustedCidrs"`
+}
+
+type lockoutEntry struct {
+	failures    int
+	lockouts    int
+	lockedUntil time.Time
+	lastFailure time.Time
+}
+
+// Lockout tracks SigV4 failures per source IP and per access key. An
+// access key's lockout doesn't apply to the source IPs it authenticated
+// from, so others can't lock out its owner.
+type Lockout struct {
+	mu      sync.Mutex
+	entries map[string]*lockoutEntry
+	// authenticated is when an access key last authenticated from a
+	// source IP, by authenticatedKey
+	authenticated map[string]time.Time
+	trusted       []netip.Prefix
+	maxFailures   int
+	lockout       time.Duration
+	maxLockout    time.Duration
+}
+
+// NewLockout returns nil when lockouts are disabled.
+func NewLockout(config LockoutConfig) (*Lockout, error) {
+
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	lockout := Lockout{
+		entries:       make(map[string]*lockoutEntry),
+		authenticated: make(map[string]time.Time),
+		maxFailures:   config.MaxFailures,
+		lockout:       time.Duration(config.LockoutSeconds) * time.Second,
+		maxLockout:    time.Duration(config.MaxLockoutSeconds) * time.Second,
+	}
+
+	if lockout.maxFailures <= 0 {
+		lockout.maxFailures = defaultMaxFailures
+	}
+	if lockout.lockout <= 0 {
+		lockout.lockout = defaultLockoutSeconds * time.Second
+	}
+	if lockout.maxLockout <= 0 {
+		lockout.maxLockout = defaultMaxLockout * time.Second
+	}
+
+	for _, cidr := range config.TrustedCidrs {
+
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted CIDR %q: %w", cidr, err)
+		}
+		lockout.trusted = append(lockout.trusted, prefix)
+	}
+
+	return &lockout, nil
+}
+
+// Locked reports whether the source IP or access key is locked out.
+func (l *Lockout) Locked(sourceIp string, accessKey string, now time.Time) bool {
+
+	if l.isTrusted(sourceIp) {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range lockoutKeys(sourceIp, accessKey) {
+		if entry, ok := l.entries[key]; ok && now.Before(entry.lockedUntil) {
+			return key != "key/"+accessKey || !l.authenticatedFrom(sourceIp, accessKey, now)
+		}
+	}
+
+	return false
+}
+
+// Failure records an authentication failure and locks out the source IP or
+// access key once it has failed too often. The access key is empty unless
+// it exists, so unknown keys don't take up entries.
+func (l *Lockout) Failure(ctx context.Context, sourceIp string, accessKey string, now time.Time) {
+
+	if l.isTrusted(sourceIp) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.entries) >= maxLockoutEntries {
+		l.prune(now)
+	}
+
+	for _, key := range lockoutKeys(sourceIp, accessKey) {
+
+		entry, ok := l.entries[key]
+		if !ok && len(l.entries) >= maxLockoutEntries {
+			Logger(ctx).Warn("lockout entries full, failure not recorded", "lockout_key", key)
+			continue
+		} else if !ok {
+			entry = &lockoutEntry{}
+			l.entries[key] = entry
+		}
+
+		entry.failures++
+		entry.lastFailure = now
+		if entry.failures < l.maxFailures {
+			continue
+		}
+
+		duration := min(l.lockout<<entry.lockouts, l.maxLockout)
+		entry.lockedUntil = now.Add(duration)
+		entry.lockouts = min(entry.lockouts+1, 30)
+		entry.failures = 0
+
+		Logger(ctx).Warn("locked out after authentication failures",
+			"lockout_key", key, "source_ip", sourceIp, "access_key", accessKey, "duration", duration.String())
+	}
+}
+
+// Success forgets the failures of the source IP and access key, and
+// exempts the source IP from the access key's lockouts.
+func (l *Lockout) Success(sourceIp string, accessKey string, now time.Time) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range lockoutKeys(sourceIp, accessKey) {
+		delete(l.entries, key)
+	}
+
+	if len(l.authenticated) >= maxLockoutEntries {
+		maps.DeleteFunc(l.authenticated, func(_ string, last time.Time) bool {
+			return now.Sub(last) > authenticatedRetention
+		})
+	}
+
+	key := authenticatedKey(sourceIp, accessKey)
+	if _, ok := l.authenticated[key]; ok || len(l.authenticated) < maxLockoutEntries {
+		l.authenticated[key] = now
+	}
+}
+
+func (l *Lockout) authenticatedFrom(sourceIp string, accessKey string, now time.Time) bool {
+
+	last, ok := l.authenticated[authenticatedKey(sourceIp, accessKey)]
+
+	return ok && now.Sub(last) <= authenticatedRetention
+}
+
+// prune drops entries that are neither locked nor failed recently, then
+// the oldest that aren't locked. Active lockouts are never dropped, new
+// failures aren't recorded while they fill every entry.
+func (l *Lockout) prune(now time.Time) {
+
+	var unlocked []string
+	for key, entry := range l.entries {
+
+		if now.Before(entry.lockedUntil) {
+			continue
+		}
+
+		if now.Sub(entry.lastFailure) > l.maxLockout {
+			delete(l.entries, key)
+			continue
+		}
+
+		unlocked = append(unlocked, key)
+	}
+
+	// make room for a tenth, so a full map isn't sorted on every failure
+	slices.SortFunc(unlocked, func(a string, b string) int {
+		return l.entries[a].lastFailure.Compare(l.entries[b].lastFailure)
+	})
+
+	for _, key := range unlocked {
+
+		if len(l.entries) < maxLockoutEntries-maxLockoutEntries/10 {
+			break
+		}
+		delete(l.entries, key)
+	}
+}
+
+func (l *Lockout) isTrusted(sourceIp string) bool {
+
+	addr, err := netip.ParseAddr(sourceIp)
+	if err != nil {
+		return false
+	}
+
+	for _, prefix := range l.trusted {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func authenticatedKey(sourceIp string, accessKey string) string {
+
+	return sourceIp + " " + accessKey
+}
+
+func lockoutKeys(sourceIp string, accessKey string) []string {
+
+	keys := []string{"ip/" + sourceIp}
+	if accessKey != "" {
+		keys = append(keys, "key/"+accessKey)
+	}
+
+	return keys
+}
+
+// isAuthenticationFailure reports whether the SigV4 error means wrong
+// credentials rather than a malformed or stale request.
+func isAuthenticationFailure(errCode APIErrorCode) bool {
+
+	switch errCode {
+	case ErrInvalidAccessKeyID, ErrSignatureDoesNotMatch, ErrCredentialDateMismatch, ErrInvalidClientTokenId:
+		return true
+	}
+
+	return false
+}
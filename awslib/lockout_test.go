@@ -0,0 +1,85 @@
+package awslib
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestLockout(t *testing.T) *Lockout {
+
+	t.Helper()
+
+	lockout, err := NewLockout(LockoutConfig{Enabled: true, MaxFailures: 2, TrustedCidrs: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return lockout
+}
+
+func TestLockout(t *testing.T) {
+
+	now := time.Now()
+	tests := []struct {
+		name string
+		// authenticated is a source IP the key authenticated from before
+		authenticated string
+		failedIp      string
+		failedKey     string
+		sourceIp      string
+		locked        bool
+	}{
+		{"source ip", "", "192.0.2.1", "", "192.0.2.1", true},
+		{"other source ip", "", "192.0.2.1", "", "192.0.2.2", false},
+		{"trusted source ip", "", "10.0.0.1", "", "10.0.0.1", false},
+		{"access key", "", "192.0.2.1", "AKIAOWNER", "192.0.2.2", true},
+		{"access key from its source ip", "192.0.2.2", "192.0.2.1", "AKIAOWNER", "192.0.2.2", false},
+		{"unknown access key", "", "192.0.2.1", "", "192.0.2.2", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			lockout := newTestLockout(t)
+			if test.authenticated != "" {
+				lockout.Success(test.authenticated, "AKIAOWNER", now)
+			}
+
+			for range 2 {
+				lockout.Failure(context.Background(), test.failedIp, test.failedKey, now)
+			}
+
+			if locked := lockout.Locked(test.sourceIp, "AKIAOWNER", now); locked != test.locked {
+				t.Errorf("Locked() = %v, want %v", locked, test.locked)
+			}
+
+			if lockout.Locked(test.sourceIp, "AKIAOWNER", now.Add(2*time.Minute)) {
+				t.Error("still locked after the lockout")
+			}
+		})
+	}
+}
+
+func TestLockoutPruneKeepsLockouts(t *testing.T) {
+
+	lockout := newTestLockout(t)
+	now := time.Now()
+	for range 2 {
+		lockout.Failure(context.Background(), "192.0.2.1", "", now)
+	}
+
+	// fill the entries with recent failures that aren't locked out
+	for i := range maxLockoutEntries + 10 {
+		lockout.Failure(context.Background(), fmt.Sprintf("198.51.%d.%d", i/256, i%256), "", now.Add(time.Second))
+	}
+
+	if len(lockout.entries) > maxLockoutEntries {
+		t.Errorf("%d entries, want at most %d", len(lockout.entries), maxLockoutEntries)
+	}
+
+	if !lockout.Locked("192.0.2.1", "", now.Add(time.Second)) {
+		t.Error("pruning dropped an active lockout")
+	}
+}
@@ -0,0 +1,36 @@
+package awslib
+
+import (
+	"home-ssm/metrics"
+	"slices"
+	"time"
+)
+
+// ObserveRequest counts a request of the service and its latency by
+// operation and result code. Operations missing from known are counted as
+// "Unknown" to keep label cardinality bounded for junk targets.
+func ObserveRequest(service string, operation string, known []string, recorder *StatusRecorder, start time.Time) {
+
+	if !slices.Contains(known, operation) {
+		operation = "Unknown"
+	}
+
+	if service != "" {
+		operation = service + ":" + operation
+	}
+
+	code := recorder.ResultCode()
+	metrics.ApiRequests.WithLabelValues(operation, code).Inc()
+	metrics.ApiRequestDuration.WithLabelValues(operation, code).Observe(time.Since(start).Seconds())
+}
+
+// ResultCode returns the error code of the response, or the success code
+// used by the metrics.
+func (r *StatusRecorder) ResultCode() string {
+
+	if code := r.ErrorCode(); code != "" {
+		return code
+	}
+
+	return metrics.SuccessCode
+}
@@ -0,0 +1,76 @@
+package awslib
+
+import (
+	"home-ssm/metrics"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// requestCount returns the api_requests_total counter of the labels.
+func requestCount(t *testing.T, operation string, code string) float64 {
+
+	t.Helper()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+
+		if family.GetName() != "home_ssm_api_requests_total" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+
+			if labels["operation"] == operation && labels["code"] == code {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+
+	return 0
+}
+
+func TestObserveRequest(t *testing.T) {
+
+	known := []string{"Decrypt"}
+	tests := []struct {
+		name      string
+		service   string
+		operation string
+		status    int
+		label     string
+		code      string
+	}{
+		{"known operation", "kms", "Decrypt", http.StatusOK, "kms:Decrypt", metrics.SuccessCode},
+		{"unknown operation", "kms", "Junk", http.StatusOK, "kms:Unknown", metrics.SuccessCode},
+		{"error", "kms", "Decrypt", http.StatusForbidden, "kms:Decrypt", "403"},
+		{"no service", "", "Decrypt", http.StatusOK, "Decrypt", metrics.SuccessCode},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			before := requestCount(t, test.label, test.code)
+
+			recorder := NewStatusRecorder(httptest.NewRecorder())
+			recorder.WriteHeader(test.status)
+			ObserveRequest(test.service, test.operation, known, recorder, time.Now())
+
+			if got := requestCount(t, test.label, test.code) - before; got != 1 {
+				t.Errorf("counted %v requests as %s %s, want 1", got, test.label, test.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package awslib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const (
+	amzAlgorithm     = "X-Amz-Algorithm"
+	amzCredential    = "X-Amz-Credential"
+	amzDate          = "X-Amz-Date"
+	amzExpires       = "X-Amz-Expires"
+	amzSignedHeaders = "X-Amz-SignedHeaders"
+	amzSignature     = "X-Amz-Signature"
+
+	// MaxPresignExpiry is the longest lifetime of a presigned request, seven days.
+	MaxPresignExpiry = 7 * 24 * time.Hour
+)
+
+// preSignValues data type represents structured form of AWS Signature V4
+// query string authentication.
+type preSignValues struct {
+	signValues
+	Date    time.Time
+	Expires time.Duration
+}
+
+// isPresigned reports whether the request is authenticated with query
+// string parameters instead of the Authorization header.
+func isPresigned(r *http.Request) bool {
+
+	return r.Header.Get(headerAuthorization) == "" && r.URL.Query().Has(amzAlgorithm)
+}
+
+// parsePreSignV4 parses the query string authentication parameters:
+//
+//	X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=<credential>&
+//	X-Amz-Date=<date>&X-Amz-Expires=<seconds>&X-Amz-SignedHeaders=<headers>&
+//	X-Amz-Signature=<signature>
+func parsePreSignV4(query url.Values, regions []string, stype ServiceType) (psv preSignValues, aec APIErrorCode) {
+
+	for _, param := range []string{amzAlgorithm, amzCredential, amzDate, amzExpires, amzSignedHeaders, amzSignature} {
+		if query.Get(param) == "" {
+			return psv, ErrInvalidQueryParams
+		}
+	}
+
+	if query.Get(amzAlgorithm) != signV4Algorithm {
+		return psv, ErrSignatureVersionNotSupported
+	}
+
+	var err APIErrorCode
+	psv.Credential, err = parseCredentialHeader("Credential="+query.Get(amzCredential), regions, stype)
+	if err != ErrNone {
+		return psv, err
+	}
+
+	var e error
+	psv.Date, e = time.Parse(iso8601Format, query.Get(amzDate))
+	if e != nil {
+		return psv, ErrMalformedDate
+	}
+
+	seconds, e := strconv.Atoi(query.Get(amzExpires))
+	if e != nil || seconds < 1 || time.Duration(seconds)*time.Second > MaxPresignExpiry {
+		return psv, ErrMalformedExpires
+	}
+	psv.Expires = time.Duration(seconds) * time.Second
+
+	psv.SignedHeaders, err = parseSignedHeader("SignedHeaders=" + query.Get(amzSignedHeaders))
+	if err != ErrNone {
+		return psv, err
+	}
+
+	psv.Signature, err = parseSignature("Signature=" + query.Get(amzSignature))
+	if err != ErrNone {
+		return psv, err
+	}
+
+	return psv, ErrNone
+}
+
+// presignedQuery returns the canonical query string of a presigned request,
+// every parameter except the signature.
+func presignedQuery(query url.Values) string {
+
+	canonical := make(url.Values, len(query))
+	for key, values := range query {
+		if key != amzSignature {
+			canonical[key] = values
+		}
+	}
+
+	return canonical.Encode()
+}
+
+// PresignURL signs the request with query string authentication valid for
+// expires. The signed headers are host and, when set, X-Amz-Target, so the
+// caller must send the same target and exactly the same body.
+func PresignURL(r *http.Request, body []byte, creds aws.Credentials, region string, stype ServiceType, signTime time.Time, expires time.Duration) string {
+
+	signTime = signTime.UTC()
+	credential := credentialHeader{accessKey: creds.AccessKeyID}
+	credential.scope.date = signTime
+	credential.scope.region = region
+	credential.scope.service = string(stype)
+	credential.scope.request = "aws4_request"
+
+	signedHeaders := http.Header{}
+	signedHeaders.Set("host", r.URL.Host)
+	if target := r.Header.Get(headerAmzTarget); target != "" {
+		signedHeaders.Set(headerAmzTarget, target)
+	}
+
+	query := r.URL.Query()
+	query.Set(amzAlgorithm, signV4Algorithm)
+	query.Set(amzCredential, creds.AccessKeyID+SlashSeparator+credential.getScope())
+	query.Set(amzDate, signTime.Format(iso8601Format))
+	query.Set(amzExpires, strconv.Itoa(int(expires.Seconds())))
+	query.Set(amzSignedHeaders, getSignedHeaders(signedHeaders))
+	if creds.SessionToken != "" {
+		query.Set(headerAmzSecurityToken, creds.SessionToken)
+	}
+
+	payload := sha256.Sum256(body)
+	canonicalRequest := getCanonicalRequest(signedHeaders, hex.EncodeToString(payload[:]),
+		query.Encode(), r.URL.Path, r.Method)
+	stringToSign := getStringToSign(canonicalRequest, signTime, credential.getScope())
+	signingKey := getSigningKey(creds.SecretAccessKey, signTime, region, stype)
+
+	query.Set(amzSignature, getSignature(signingKey, stringToSign))
+
+	signed := *r.URL
+	signed.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	return signed.String()
+}
@@ -0,0 +1,108 @@
+package awslib
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPresignedTampering(t *testing.T) {
+
+	// replace changes a query parameter of the presigned URL
+	replace := func(param string, value string) func(*testRequest) {
+		return func(request *testRequest) {
+			u, _ := url.Parse(request.url)
+			query := u.Query()
+			query.Set(param, value)
+			u.RawQuery = query.Encode()
+			request.url = u.String()
+		}
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(*testRequest)
+		status int
+		code   string
+	}{
+		{"untouched", func(*testRequest) {}, http.StatusOK, ""},
+		{"other body", func(request *testRequest) { request.body = `{"Name":"/secret"}` },
+			http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"other target", func(request *testRequest) { request.header.Set(headerAmzTarget, "AmazonSSM.DeleteParameter") },
+			http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"other path", func(request *testRequest) { request.url = strings.Replace(request.url, "/?", "/other?", 1) },
+			http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"longer expiry", replace(amzExpires, "7200"), http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"later date", replace(amzDate, time.Now().UTC().Add(time.Minute).Format(iso8601Format)),
+			http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"other signature", replace(amzSignature, strings.Repeat("0", 64)), http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"no signature", replace(amzSignature, ""), http.StatusBadRequest, "AuthorizationQueryParametersError"},
+		{"expiry past seven days", replace(amzExpires, "604801"), http.StatusBadRequest, "AuthorizationQueryParametersError"},
+		{"no expiry", replace(amzExpires, "0"), http.StatusBadRequest, "AuthorizationQueryParametersError"},
+		{"other algorithm", replace(amzAlgorithm, "AWS4-HMAC-SHA1"), http.StatusBadRequest, "InvalidRequest"},
+		{"malformed date", replace(amzDate, "yesterday"), http.StatusBadRequest, "MalformedDate"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			request := newPresignedRequest(testCredentials, ServiceSsm, http.MethodPost, "AmazonSSM.GetParameter",
+				`{"Name":"/a"}`, time.Now(), time.Hour)
+			test.tamper(request)
+
+			w, seen := request.sendRecorded(newTestProvider(ServiceSsm, ProtocolJSON11))
+			if w.Code != test.status || !strings.Contains(w.Body.String(), test.code) {
+				t.Fatalf("got %d %s, want %d %s", w.Code, w.Body.String(), test.status, test.code)
+			}
+
+			if test.status == http.StatusOK && (seen.Principal == nil || seen.Principal.AccessKeyID != testCredentials.AccessKeyID) {
+				t.Errorf("principal %v, want %s", seen.Principal, testCredentials.AccessKeyID)
+			}
+		})
+	}
+}
+
+func TestPresignURL(t *testing.T) {
+
+	signTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	r, err := http.NewRequest(http.MethodPost, "http://localhost:9080/?Extra=a+b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set(headerAmzTarget, "AmazonSSM.GetParameter")
+
+	creds := testCredentials
+	creds.SessionToken = "session-token"
+	presigned, err := url.Parse(PresignURL(r, []byte(`{}`), creds, "us-east-1", ServiceSsm, signTime, 15*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := presigned.Query()
+	want := map[string]string{
+		amzAlgorithm:           signV4Algorithm,
+		amzCredential:          "AKIATEST/20240501/us-east-1/ssm/aws4_request",
+		amzDate:                "20240501T120000Z",
+		amzExpires:             "900",
+		amzSignedHeaders:       "host;x-amz-target",
+		headerAmzSecurityToken: "session-token",
+		"Extra":                "a b",
+	}
+
+	for param, value := range want {
+		if got := query.Get(param); got != value {
+			t.Errorf("%s = %q, want %q", param, got, value)
+		}
+	}
+
+	if len(query.Get(amzSignature)) != 64 {
+		t.Errorf("%s = %q, want a hex SHA-256 signature", amzSignature, query.Get(amzSignature))
+	}
+
+	// spaces are encoded as %20, which SigV4 requires
+	if strings.Contains(presigned.RawQuery, "+") {
+		t.Errorf("query %s encodes spaces as +", presigned.RawQuery)
+	}
+}
@@ -0,0 +1,120 @@
+package awslib
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxRequestSkew is how far the request time may be from the server
+	// time, the same window AWS allows.
+	MaxRequestSkew = 15 * time.Minute
+
+	defaultReplayEntries = 100000
+)
+
+type ReplayConfig struct {
+	// Enabled rejects exact replays of mutating requests.
+	Enabled bool `yaml:"enabled"`
+	// MaxEntries bounds the number of remembered signatures, defaults to 100000.
+	MaxEntries int `yaml:"maxEntries"`
+}
+
+// ReplayCache remembers the signatures of recent mutating requests. Entries
+// are kept until the request would be rejected by its date: the skew
+// window, or the expiry of presigned requests.
+type ReplayCache struct {
+	mu sync.Mutex
+	// seen holds when each signature stops being accepted
+	seen       map[string]time.Time
+	order      []string
+	maxEntries int
+}
+
+// NewReplayCache returns nil when replay protection is disabled.
+func NewReplayCache(config ReplayConfig) *ReplayCache {
+
+	if !config.Enabled {
+		return nil
+	}
+
+	maxEntries := config.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultReplayEntries
+	}
+
+	return &ReplayCache{
+		seen:       make(map[string]time.Time),
+		maxEntries: maxEntries,
+	}
+}
+
+// Add records the signature of a request valid until expires and reports
+// whether it wasn't seen before.
+func (c *ReplayCache) Add(signature string, now time.Time, expires time.Time) bool {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// entries are in insertion order, so most expired ones are at the front;
+	// a presigned entry there holds back the others only until it expires
+	for len(c.order) > 0 && (len(c.order) >= c.maxEntries || now.After(c.seen[c.order[0]])) {
+		delete(c.seen, c.order[0])
+		c.order = c.order[1:]
+	}
+
+	if _, ok := c.seen[signature]; ok {
+		return false
+	}
+
+	c.seen[signature] = expires
+	c.order = append(c.order, signature)
+
+	return true
+}
+
+// isMutating reports whether the request changes state, by the operation
+// of its service or, on endpoints without operations, its method. Read
+// operations may be retried with the same signature.
+func isMutating(info *RequestInfo, method string) bool {
+
+	operation := info.Operation[strings.LastIndex(info.Operation, ".")+1:]
+	if operation == "" {
+		return method != http.MethodGet && method != http.MethodHead
+	}
+
+	for _, prefix := range []string{"Get", "Describe", "List", "Lookup"} {
+		if strings.HasPrefix(operation, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// queryAction returns the Action of a Query protocol request, from the URL
+// or the form body, which is kept for the handler.
+func queryAction(r *http.Request) string {
+
+	if action := r.URL.Query().Get("Action"); action != "" || r.Body == nil {
+		return action
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 10*(1<<20)))
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return ""
+	}
+
+	return form.Get("Action")
+}
@@ -0,0 +1,121 @@
+package awslib
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const headerAmznRequestID = "x-amzn-RequestId"
+const headerAmzTarget = "X-Amz-Target"
+
+// RequestInfo is shared by the middleware chain for the lifetime of a request.
+type RequestInfo struct {
+	RequestId string
+	// Service is the service the request was routed to.
+	Service ServiceType
+	// Operation is the X-Amz-Target or Query protocol Action, until the
+	// handler names it.
+	Operation string
+	Principal *aws.Credentials
+	// Session is set when the principal uses temporary credentials.
+	Session  *Session
+	SourceIp string
+	// AccessKey and Region are claimed by the credential scope, before the
+	// request is verified.
+	AccessKey string
+	Region    string
+}
+
+type requestInfoKey struct{}
+
+// GetRequestInfo returns the request info, an empty one outside WithRequestId.
+func GetRequestInfo(ctx context.Context) *RequestInfo {
+
+	if info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo); ok {
+		return info
+	}
+
+	return &RequestInfo{}
+}
+
+// Logger returns the default logger annotated with the request id and principal.
+func Logger(ctx context.Context) *slog.Logger {
+
+	info := GetRequestInfo(ctx)
+	logger := slog.Default()
+	if info.RequestId != "" {
+		logger = logger.With("request_id", info.RequestId)
+	}
+
+	if info.Operation != "" {
+		logger = logger.With("operation", info.Operation)
+	}
+
+	if info.Principal != nil {
+		logger = logger.With("principal", info.Principal.Source)
+	}
+
+	return logger
+}
+
+// WithRequestId assigns each request an id, returns it in the response headers
+// and writes an access log line once the request completes.
+func WithRequestId(next http.HandlerFunc) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+		info := &RequestInfo{
+			RequestId: NewUUID(),
+			Operation: r.Header.Get(headerAmzTarget),
+			SourceIp:  r.RemoteAddr,
+			AccessKey: credentialAccessKey(r),
+			Region:    credentialScopeRegion(r),
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			info.SourceIp = host
+		}
+
+		w.Header().Set(headerAmzRequestID, info.RequestId)
+		w.Header().Set(headerAmznRequestID, info.RequestId)
+
+		recorder := NewStatusRecorder(w)
+		next(recorder, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+
+		attrs := []any{
+			"request_id", info.RequestId,
+			"operation", info.Operation,
+			"status", recorder.Status,
+			"latency", time.Since(start),
+			"remote_addr", r.RemoteAddr,
+		}
+
+		if info.Principal != nil {
+			attrs = append(attrs, "principal", info.Principal.Source, "access_key", info.Principal.AccessKeyID)
+		}
+
+		if code := recorder.ErrorCode(); code != "" {
+			attrs = append(attrs, "error_code", code)
+		}
+
+		slog.Info("request", attrs...)
+	}
+}
+
+// NewUUID returns a random version 4 UUID.
+func NewUUID() string {
+
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
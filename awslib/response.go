@@ -5,7 +5,8 @@ package awslib
 import (
 	"bytes"
 	"encoding/json"
-	"log"
+	"encoding/xml"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -17,22 +18,112 @@ type mimeType string
 const (
 	// Means no response type.
 	mimeNone mimeType = ""
-	// Means response type is JSON.
-	mimeJSON mimeType = "application/json"
+	// Means response type is JSON, the AWS JSON 1.1 protocol.
+	mimeJSON mimeType = "application/x-amz-json-1.1"
+	// Means response type is JSON, the AWS JSON 1.0 protocol.
+	mimeJSON10 mimeType = "application/x-amz-json-1.0"
+	// Means response type is XML.
+	mimeXML mimeType = "text/xml"
 )
 
+// Protocol is the AWS wire protocol of a service, it decides the format
+// of error responses.
+type Protocol int
+
+const (
+	ProtocolJSON11 Protocol = iota
+	ProtocolJSON10
+	ProtocolQuery
+)
+
+func (p Protocol) requestType() string {
+
+	switch p {
+	case ProtocolJSON10:
+		return string(mimeJSON10)
+	case ProtocolQuery:
+		return "application/x-www-form-urlencoded"
+	}
+
+	return string(mimeJSON)
+}
+
+func (p Protocol) responseType() mimeType {
+
+	switch p {
+	case ProtocolJSON10:
+		return mimeJSON10
+	case ProtocolQuery:
+		return mimeXML
+	}
+
+	return mimeJSON
+}
+
+// queryErrorResponse is the error format of Query protocol services such as STS.
+type queryErrorResponse struct {
+	XMLName xml.Name `xml:"ErrorResponse"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	Error   struct {
+		Type    string `xml:"Type"`
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+	RequestID string `xml:"RequestId"`
+}
+
+// StatusRecorder wraps a ResponseWriter and records the status code and
+// AWS error type written through it.
+type StatusRecorder struct {
+	http.ResponseWriter
+	Status int
+}
+
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+
+	return &StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *StatusRecorder) WriteHeader(statusCode int) {
+
+	r.Status = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *StatusRecorder) Unwrap() http.ResponseWriter {
+
+	return r.ResponseWriter
+}
+
+// ErrorCode returns the AWS error code of the response, the HTTP status
+// for errors written without one, or an empty string on success.
+func (r *StatusRecorder) ErrorCode() string {
+
+	if code := r.Header().Get(headerAmzErrorType); code != "" {
+		return code
+	}
+
+	if r.Status >= http.StatusBadRequest {
+		return strconv.Itoa(r.Status)
+	}
+
+	return ""
+}
+
 // getErrorResponse gets in standard error and resource value and
 // provides a encodable populated response values
 func createAPIErrorResponse(
 	err APIError, resource, requestID, hostID string, region string) APIErrorResponse {
 
 	return APIErrorResponse{
-		Code:      err.Code,
-		Message:   err.Description,
-		Resource:  resource,
-		Region:    region,
-		RequestID: requestID,
-		HostID:    hostID,
+		Code:         err.Code,
+		Message:      err.Description,
+		LowerMessage: err.Description,
+		Resource:     resource,
+		Region:       region,
+		RequestID:    requestID,
+		HostID:       hostID,
 	}
 }
 
@@ -48,6 +139,8 @@ func WriteSuccessResponseJSON(w http.ResponseWriter, response interface{}) {
 // useful for admin APIs.
 func WriteErrorResponseJSON(w http.ResponseWriter, err APIError, reqURL *url.URL, region string) {
 
+	w.Header().Set(headerAmzErrorType, err.Code)
+
 	// Generate error response.
 	errorResponse := createAPIErrorResponse(err, reqURL.Path,
 		w.Header().Get(headerAmzRequestID), w.Header().Get(headerAmzRequestHostID), region)
@@ -56,23 +149,48 @@ func WriteErrorResponseJSON(w http.ResponseWriter, err APIError, reqURL *url.URL
 	writeResponse(w, err.HTTPStatusCode, encodedErrorResponse, mimeJSON)
 }
 
+func WriteSuccessResponseXML(w http.ResponseWriter, response interface{}) {
+
+	writeResponse(w, http.StatusOK, encodeResponseXML(response), mimeXML)
+}
+
+// WriteErrorResponseXML writes an error response in the Query protocol format.
+func WriteErrorResponseXML(w http.ResponseWriter, err APIError, xmlns string) {
+
+	w.Header().Set(headerAmzErrorType, err.Code)
+
+	var errorResponse queryErrorResponse
+	errorResponse.Xmlns = xmlns
+	errorResponse.Error.Type = "Sender"
+	if err.HTTPStatusCode >= http.StatusInternalServerError {
+		errorResponse.Error.Type = "Receiver"
+	}
+	errorResponse.Error.Code = err.Code
+	errorResponse.Error.Message = err.Description
+	errorResponse.RequestID = w.Header().Get(headerAmzRequestID)
+
+	writeResponse(w, err.HTTPStatusCode, encodeResponseXML(errorResponse), mimeXML)
+}
+
 func writeResponse(w http.ResponseWriter, statusCode int, response []byte, mType mimeType) {
 	if statusCode == 0 {
 		statusCode = 200
 	}
 	// Similar check to http.checkWriteHeaderCode
 	if statusCode < 100 || statusCode > 999 {
-		log.Printf("invalid WriteHeader code %v", statusCode)
+		slog.Error("invalid WriteHeader code", "status", statusCode)
 		statusCode = http.StatusInternalServerError
 	}
-	w.WriteHeader(statusCode)
 
+	// headers set after WriteHeader are never sent
 	w.Header().Set(headerServerInfo, "Home-SSM")
 	w.Header().Set(headerAcceptRanges, "bytes")
-	if mType != mimeNone {
+	// a content type set by the router for the service protocol wins
+	if mType != mimeNone && w.Header().Get(headerContentType) == "" {
 		w.Header().Set(headerContentType, string(mType))
 	}
 	w.Header().Set(headerContentLength, strconv.Itoa(len(response)))
+	w.WriteHeader(statusCode)
 
 	if response != nil {
 		w.Write(response)
@@ -87,3 +205,13 @@ func encodeResponseJSON(response interface{}) []byte {
 	e.Encode(response)
 	return bytesBuffer.Bytes()
 }
+
+// Encodes the response into XML format.
+func encodeResponseXML(response interface{}) []byte {
+
+	var bytesBuffer bytes.Buffer
+	bytesBuffer.WriteString(xml.Header)
+	e := xml.NewEncoder(&bytesBuffer)
+	e.Encode(response)
+	return bytesBuffer.Bytes()
+}
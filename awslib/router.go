@@ -0,0 +1,197 @@
+package awslib
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Service is an emulated AWS service hosted by a Router.
+type Service struct {
+	Type ServiceType
+	// TargetPrefix is the X-Amz-Target prefix of JSON protocol services,
+	// e.g. "AmazonSSM". Query protocol services have none.
+	TargetPrefix string
+	// Protocol decides the format of errors written before Handler runs.
+	Protocol Protocol
+	// Region overrides the signing region of global services like IAM.
+	Region string
+	// Regions are the other regions the service's requests may be signed
+	// for.
+	Regions []string
+	// MultiAccount services serve the principals of every account, the
+	// others only those of the router's account.
+	MultiAccount bool
+	Handler      http.HandlerFunc
+}
+
+// Router hosts several services on one endpoint. Requests are routed by
+// the service of the SigV4 credential scope and the X-Amz-Target prefix,
+// then verified with the credentials of the router.
+type Router struct {
+	provider CredentialsProvider
+	services map[ServiceType]Service
+	handlers map[ServiceType]http.HandlerFunc
+	targets  map[string]ServiceType
+}
+
+func NewRouter(provider CredentialsProvider) *Router {
+
+	return &Router{
+		provider: provider,
+		services: make(map[ServiceType]Service),
+		handlers: make(map[ServiceType]http.HandlerFunc),
+		targets:  make(map[string]ServiceType),
+	}
+}
+
+func (router *Router) Register(service Service) {
+
+	provider := router.provider
+	provider.Service = service.Type
+	provider.Protocol = service.Protocol
+	provider.Regions = service.Regions
+	if service.MultiAccount {
+		provider.AccountId = ""
+	}
+	if service.Region != "" {
+		provider.Region = service.Region
+	}
+
+	router.services[service.Type] = service
+	router.handlers[service.Type] = provider.WithSigV4(service.Handler)
+	if service.TargetPrefix != "" {
+		router.targets[service.TargetPrefix] = service.Type
+	}
+}
+
+func (router *Router) Handle(w http.ResponseWriter, r *http.Request) {
+
+	stype, errCode := router.route(r)
+	if errCode != ErrNone {
+
+		Logger(r.Context()).Warn("request not routed", "error", errCode.String())
+		router.writeError(w, r, ProtocolJSON11, errCode)
+		return
+	}
+
+	GetRequestInfo(r.Context()).Service = stype
+
+	service := router.services[stype]
+	w.Header().Set(headerContentType, string(service.Protocol.responseType()))
+
+	if !hasContentType(r, service.Protocol) {
+
+		Logger(r.Context()).Warn("unsupported content type", "content_type", r.Header.Get(headerContentType))
+		router.writeError(w, r, service.Protocol, ErrUnsupportedContentType)
+		return
+	}
+
+	router.handlers[stype](w, r)
+}
+
+func (router *Router) writeError(w http.ResponseWriter, r *http.Request, protocol Protocol, errCode APIErrorCode) {
+
+	if protocol == ProtocolQuery {
+		WriteErrorResponseXML(w, ErrorCodes.ToAPIErr(errCode), "")
+		return
+	}
+
+	WriteErrorResponseJSON(w, ErrorCodes.ToAPIErr(errCode), r.URL, router.provider.Region)
+}
+
+// hasContentType checks the request media type against the protocol of the
+// service. Query protocol requests may also be sent as a GET without a body.
+func hasContentType(r *http.Request, protocol Protocol) bool {
+
+	if protocol == ProtocolQuery && r.Method == http.MethodGet {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get(headerContentType))
+	if err != nil {
+		return false
+	}
+
+	return mediaType == protocol.requestType()
+}
+
+// route picks the service of the request. When both the credential scope
+// and the X-Amz-Target name a service they must agree.
+func (router *Router) route(r *http.Request) (ServiceType, APIErrorCode) {
+
+	scope := ServiceType(credentialScopeService(r))
+
+	target := r.Header.Get(headerAmzTarget)
+	if i := strings.LastIndex(target, "."); i > 0 {
+
+		stype, ok := router.targets[target[:i]]
+		if !ok {
+			return "", ErrUnknownService
+		}
+
+		if scope != "" && scope != stype {
+			return "", ErrInvalidService
+		}
+
+		return stype, ErrNone
+	}
+
+	if _, ok := router.services[scope]; !ok {
+		return "", ErrUnknownService
+	}
+
+	return scope, ErrNone
+}
+
+// credentialScope returns the fields of the Credential element of the
+// Authorization header or X-Amz-Credential query parameter: access key,
+// date, region, service and terminal.
+func credentialScope(r *http.Request) []string {
+
+	credential := r.URL.Query().Get(amzCredential)
+
+	auth := r.Header.Get(headerAuthorization)
+	if i := strings.Index(auth, "Credential="); i >= 0 {
+
+		credential = auth[i+len("Credential="):]
+		if j := strings.Index(credential, ","); j >= 0 {
+			credential = credential[:j]
+		}
+	}
+
+	fields := strings.Split(strings.TrimSpace(credential), SlashSeparator)
+	if len(fields) < 5 {
+		return nil
+	}
+
+	return fields
+}
+
+func credentialScopeService(r *http.Request) string {
+
+	if fields := credentialScope(r); fields != nil {
+		return fields[len(fields)-2]
+	}
+
+	return ""
+}
+
+func credentialScopeRegion(r *http.Request) string {
+
+	if fields := credentialScope(r); fields != nil {
+		return fields[len(fields)-3]
+	}
+
+	return ""
+}
+
+// credentialAccessKey returns the claimed access key, which may contain "/".
+func credentialAccessKey(r *http.Request) string {
+
+	if fields := credentialScope(r); fields != nil {
+		return strings.Join(fields[:len(fields)-4], SlashSeparator)
+	}
+
+	return ""
+}
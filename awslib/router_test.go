@@ -0,0 +1,132 @@
+package awslib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestRouter returns a router hosting SSM, KMS and STS whose handlers
+// record the service they were called for.
+func newTestRouter(handled *ServiceType) *Router {
+
+	router := NewRouter(*newTestProvider("", ProtocolJSON11))
+	for _, service := range []Service{
+		{Type: ServiceSsm, TargetPrefix: "AmazonSSM"},
+		{Type: ServiceKms, TargetPrefix: "TrentService"},
+		{Type: ServiceSts, Protocol: ProtocolQuery},
+	} {
+		service.Handler = func(w http.ResponseWriter, r *http.Request) {
+			*handled = GetRequestInfo(r.Context()).Service
+		}
+		router.Register(service)
+	}
+
+	return router
+}
+
+func TestRouter(t *testing.T) {
+
+	const stsBody = "Action=GetCallerIdentity&Version=2011-06-15"
+
+	tests := []struct {
+		name   string
+		scope  ServiceType
+		method string
+		url    string
+		target string
+		body   string
+		// contentType is set after signing, unset requests have none
+		contentType string
+		handled     ServiceType
+		code        string
+	}{
+		{"ssm", ServiceSsm, http.MethodPost, "/", "AmazonSSM.GetParameter", `{}`,
+			"application/x-amz-json-1.1", ServiceSsm, ""},
+		{"kms", ServiceKms, http.MethodPost, "/", "TrentService.Encrypt", `{}`,
+			"application/x-amz-json-1.1", ServiceKms, ""},
+		{"content type parameters", ServiceSsm, http.MethodPost, "/", "AmazonSSM.GetParameter", `{}`,
+			"application/x-amz-json-1.1; charset=utf-8", ServiceSsm, ""},
+		{"query protocol", ServiceSts, http.MethodPost, "/", "", stsBody,
+			"application/x-www-form-urlencoded", ServiceSts, ""},
+		{"query protocol get", ServiceSts, http.MethodGet, "/?" + stsBody, "", "",
+			"", ServiceSts, ""},
+		{"no content type", ServiceSsm, http.MethodPost, "/", "AmazonSSM.GetParameter", `{}`,
+			"", "", "SerializationException"},
+		{"json content type", ServiceSsm, http.MethodPost, "/", "AmazonSSM.GetParameter", `{}`,
+			"application/json", "", "SerializationException"},
+		{"json to query protocol", ServiceSts, http.MethodPost, "/", "", stsBody,
+			"application/x-amz-json-1.1", "", "SerializationException"},
+		{"unknown target", ServiceSsm, http.MethodPost, "/", "AmazonEC2.DescribeInstances", `{}`,
+			"application/x-amz-json-1.1", "", "UnknownOperationException"},
+		{"scope of another service", ServiceKms, http.MethodPost, "/", "AmazonSSM.GetParameter", `{}`,
+			"application/x-amz-json-1.1", "", "AuthorizationParametersError"},
+		{"unknown scope", ServiceCloudTrail, http.MethodPost, "/", "", stsBody,
+			"application/x-www-form-urlencoded", "", "UnknownOperationException"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			var handled ServiceType
+			router := newTestRouter(&handled)
+
+			request := newTestRequest(t, testCredentials, test.scope, test.method, "http://example.com"+test.url, test.target, test.body)
+			r := httptest.NewRequest(request.method, request.url, strings.NewReader(request.body))
+			r.Header = request.header.Clone()
+			if test.contentType != "" {
+				r.Header.Set(headerContentType, test.contentType)
+			}
+
+			w := httptest.NewRecorder()
+			WithRequestId(router.Handle)(w, r)
+
+			if handled != test.handled {
+				t.Errorf("handled by %q, want %q", handled, test.handled)
+			}
+
+			if test.code == "" && w.Code != http.StatusOK {
+				t.Errorf("got %d %s, want 200", w.Code, w.Body.String())
+			}
+
+			if test.code != "" && (w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), test.code)) {
+				t.Errorf("got %d %s, want %s", w.Code, w.Body.String(), test.code)
+			}
+		})
+	}
+}
+
+func TestRouterResponseContentType(t *testing.T) {
+
+	tests := []struct {
+		scope       ServiceType
+		target      string
+		contentType string
+		want        string
+	}{
+		{ServiceSsm, "AmazonSSM.GetParameter", "application/x-amz-json-1.1", "application/x-amz-json-1.1"},
+		// errors of the service are written in its protocol
+		{ServiceSsm, "AmazonSSM.GetParameter", "text/plain", "application/x-amz-json-1.1"},
+		{ServiceSts, "", "application/x-www-form-urlencoded", "text/xml"},
+		{ServiceSts, "", "text/plain", "text/xml"},
+	}
+
+	for _, test := range tests {
+
+		var handled ServiceType
+		router := newTestRouter(&handled)
+
+		request := newTestRequest(t, testCredentials, test.scope, http.MethodPost, "http://example.com/", test.target, "")
+		r := httptest.NewRequest(request.method, request.url, nil)
+		r.Header = request.header.Clone()
+		r.Header.Set(headerContentType, test.contentType)
+
+		w := httptest.NewRecorder()
+		WithRequestId(router.Handle)(w, r)
+
+		if got := w.Header().Get(headerContentType); got != test.want {
+			t.Errorf("%s request with %s answered with %s, want %s", test.scope, test.contentType, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package awslib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const headerAmzSecurityToken = "X-Amz-Security-Token"
+
+// assumedRolePrefix marks the credentials Source of role sessions, which is
+// "assumed-role/<role>/<session>".
+const assumedRolePrefix = "assumed-role/"
+
+// Session is a set of temporary credentials issued by STS.
+type Session struct {
+	Credentials aws.Credentials
+	// ParentAccessKey is the long term access key that requested the session.
+	ParentAccessKey string
+	// RoleName is set for sessions created by AssumeRole.
+	RoleName string
+}
+
+type SessionStore interface {
+	// Session returns the session for the access key, including expired ones.
+	Session(accessKey string) (*Session, bool)
+}
+
+// PrincipalArn returns the IAM user ARN of the credentials, or the STS
+// assumed role ARN for role sessions.
+func PrincipalArn(accountId string, creds *aws.Credentials) string {
+
+	if strings.HasPrefix(creds.Source, assumedRolePrefix) {
+		return fmt.Sprintf("arn:aws:sts::%s:%s", accountId, creds.Source)
+	}
+
+	return fmt.Sprintf("arn:aws:iam::%s:user/%s", accountId, creds.Source)
+}
+
+// AssumedRoleSource returns the credentials Source of a role session.
+func AssumedRoleSource(roleName string, sessionName string) string {
+
+	return assumedRolePrefix + roleName + "/" + sessionName
+}
+
+func IsAssumedRole(creds *aws.Credentials) bool {
+
+	return strings.HasPrefix(creds.Source, assumedRolePrefix)
+}
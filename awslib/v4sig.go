@@ -4,10 +4,13 @@ package awslib
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/hex"
+	"home-ssm/metrics"
+	"home-ssm/tracing"
 	"io"
 	"log"
 	"net/http"
@@ -20,6 +23,7 @@ import (
 	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const headerAmzDate = "X-Amz-Date"
@@ -31,6 +35,7 @@ const headerContentLength = "Content-Length"
 const headerContentType = "Content-Type"
 const headerAcceptRanges = "Accept-Ranges"
 const headerServerInfo = "Server"
+const headerAmzErrorType = "X-Amzn-ErrorType"
 
 const (
 	emptySHA256     = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
@@ -72,117 +77,259 @@ type signValues struct {
 type ServiceType string
 
 const (
-	ServiceSsm ServiceType = "ssm"
+	ServiceSsm        ServiceType = "ssm"
+	ServiceCloudTrail ServiceType = "cloudtrail"
+	ServiceSts        ServiceType = "sts"
+	ServiceKms        ServiceType = "kms"
+	ServiceSecrets    ServiceType = "secretsmanager"
+	ServiceIam        ServiceType = "iam"
+	// ServiceAdmin signs the home-ssm admin endpoints.
+	ServiceAdmin ServiceType = "home-ssm"
 )
 
 type CredentialsProvider struct {
-	Service     ServiceType
-	Region      string
-	Credentials []aws.Credentials
+	Service  ServiceType
+	Protocol Protocol
+	Region   string
+	// Regions are the other regions requests may be signed for.
+	Regions []string
+	// AccountId rejects principals of other accounts, empty accepts all.
+	AccountId string
+	// Keyring holds the long-term credentials of every service.
+	Keyring *Keyring
+	// Sessions resolves temporary credentials, nil if STS isn't enabled.
+	Sessions SessionStore
+	// Replays rejects replayed mutating requests, nil if disabled.
+	Replays *ReplayCache
+	// Lockout rejects callers with repeated authentication failures, nil if disabled.
+	Lockout *Lockout
+}
+
+func (p *CredentialsProvider) regions() []string {
+
+	if p.Region == "" {
+		return nil
+	}
+
+	return append([]string{p.Region}, p.Regions...)
 }
 
 func (p *CredentialsProvider) WithSigV4(next http.HandlerFunc) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		log.Println("Starting...")
-		hashedPayload := getContentSha256Cksum(r, p.Service)
+		info := GetRequestInfo(r.Context())
+		info.Service = p.Service
+		if p.Protocol == ProtocolQuery {
+			info.Operation = queryAction(r)
+		}
+
+		accessKey := info.AccessKey
+		if p.Lockout != nil && p.Lockout.Locked(info.SourceIp, accessKey, time.Now()) {
+			p.writeError(w, r, ErrLockedOut)
+			return
+		}
+
+		ctx, span := tracing.Start(r.Context(), "SigV4.Verify")
+		creds, session, err := p.verify(ctx, r)
+		span.SetAttributes(tracing.ResultCode(err.String()))
+		span.End()
 
-		// Copy request.
-		req := *r
+		if p.Lockout != nil && isAuthenticationFailure(err) {
 
-		// Save authorization header.
-		v4Auth := req.Header.Get(headerAuthorization)
+			// failures count against an access key only if it exists
+			failedKey := accessKey
+			if err == ErrInvalidAccessKeyID || err == ErrInvalidClientTokenId {
+				failedKey = ""
+			}
+			p.Lockout.Failure(r.Context(), info.SourceIp, failedKey, time.Now())
+		} else if p.Lockout != nil && err == ErrNone {
+			p.Lockout.Success(info.SourceIp, accessKey, time.Now())
+		}
 
-		// Parse signature version '4' header.
-		signV4Values, err := parseSignV4(v4Auth, p.Region, p.Service)
 		if err != ErrNone {
-			WriteErrorResponseJSON(w, ErrorCodes.ToAPIErr(err), r.URL, p.Region)
+			p.writeError(w, r, err)
 			return
 		}
 
-		log.Printf("AccessKey: %s\n", signV4Values.Credential.accessKey)
-
-		// Extract all the signed headers along with its values.
-		extractedSignedHeaders, err := extractSignedHeaders(signV4Values.SignedHeaders, r)
-		if err != ErrNone {
-			WriteErrorResponseJSON(w, ErrorCodes.ToAPIErr(err), r.URL, p.Region)
+		if p.AccountId != "" && creds.AccountID != p.AccountId {
+			Logger(r.Context()).Warn("principal of another account", "account", creds.AccountID)
+			p.writeError(w, r, ErrAccessDenied)
 			return
 		}
 
-		var validCreds *aws.Credentials
-		for _, cred := range p.Credentials {
-			if cred.AccessKeyID == signV4Values.Credential.accessKey {
-				r.Header.Set("x-home-ssm-access-key", cred.AccessKeyID)
-				validCreds = &cred
-				break
-			}
+		info.Principal = creds
+		info.Session = session
+
+		// Call the next handler.
+		next(w, r)
+	}
+}
+
+// verify checks the request signature and returns the matching credentials,
+// and the session when they are temporary credentials.
+func (p *CredentialsProvider) verify(ctx context.Context, r *http.Request) (*aws.Credentials, *Session, APIErrorCode) {
+
+	hashedPayload := getContentSha256Cksum(r)
+
+	signV4Values, t, expires, queryStr, err := p.parseAuthentication(r)
+	if err != ErrNone {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if t.Sub(now) > MaxRequestSkew || (!isPresigned(r) && now.Sub(t) > MaxRequestSkew) {
+		return nil, nil, ErrRequestTimeTooSkewed
+	}
+
+	if signV4Values.Credential.scope.date.Format(yyyymmdd) != t.UTC().Format(yyyymmdd) {
+		return nil, nil, ErrCredentialDateMismatch
+	}
+
+	Logger(ctx).Debug("verifying signature", "access_key", signV4Values.Credential.accessKey)
+	trace.SpanFromContext(ctx).SetAttributes(tracing.AccessKey(signV4Values.Credential.accessKey))
+
+	// Extract all the signed headers along with its values.
+	extractedSignedHeaders, err := extractSignedHeaders(signV4Values.SignedHeaders, r)
+	if err != ErrNone {
+		return nil, nil, err
+	}
+
+	validCreds, ok := p.Keyring.Lookup(signV4Values.Credential.accessKey)
+	if ok {
+		r.Header.Set("x-home-ssm-access-key", validCreds.AccessKeyID)
+	}
+
+	var session *Session
+	if validCreds == nil && p.Sessions != nil {
+		if found, ok := p.Sessions.Session(signV4Values.Credential.accessKey); ok {
+			session = found
+			validCreds = &found.Credentials
 		}
+	}
 
-		if validCreds == nil {
-			WriteErrorResponseJSON(w, ErrorCodes.ToAPIErr(ErrInvalidAccessKeyID), r.URL, p.Region)
-			return
+	if validCreds == nil {
+		return nil, nil, ErrInvalidAccessKeyID
+	}
+
+	// Get canonical request.
+	canonicalRequest := getCanonicalRequest(
+		extractedSignedHeaders, hashedPayload, queryStr, r.URL.Path, r.Method)
+
+	// Get string to sign from canonical request.
+	stringToSign := getStringToSign(canonicalRequest, t, signV4Values.Credential.getScope())
+
+	// Get hmac signing key.
+	signingKey := getSigningKey(validCreds.SecretAccessKey, signV4Values.Credential.scope.date,
+		signV4Values.Credential.scope.region, p.Service)
+
+	// Calculate signature.
+	newSignature := getSignature(signingKey, stringToSign)
+
+	// Verify if signature match.
+	if !compareSignatureV4(newSignature, signV4Values.Signature) {
+		return nil, nil, ErrSignatureDoesNotMatch
+	}
+
+	if p.Replays != nil && isMutating(GetRequestInfo(ctx), r.Method) && !p.Replays.Add(newSignature, now, expires) {
+		return nil, nil, ErrReplayedRequest
+	}
+
+	if session != nil {
+
+		token := r.Header.Get(headerAmzSecurityToken)
+		if isPresigned(r) {
+			token = r.URL.Query().Get(headerAmzSecurityToken)
 		}
 
-		// Extract date, if not present throw error.
-		var date string
-		if date = req.Header.Get(headerAmzDate); date == "" {
-			if date = r.Header.Get(headerDate); date == "" {
-				WriteErrorResponseJSON(w, ErrorCodes.ToAPIErr(ErrMissingDateHeader), r.URL, p.Region)
-				return
-			}
+		if token != session.Credentials.SessionToken {
+			return nil, nil, ErrInvalidClientTokenId
 		}
 
-		// Parse date header.
-		t, e := time.Parse(iso8601Format, date)
-		if e != nil {
-			WriteErrorResponseJSON(w, ErrorCodes.ToAPIErr(ErrMalformedDate), r.URL, p.Region)
-			return
+		if session.Credentials.Expired() {
+			return nil, nil, ErrExpiredToken
 		}
+	}
+
+	// temporary credentials count as a use of the key they came from
+	usedKey := validCreds.AccessKeyID
+	if session != nil {
+		usedKey = session.ParentAccessKey
+	}
+	p.Keyring.markUsed(usedKey, p.Service, signV4Values.Credential.scope.region, now)
+
+	return validCreds, session, ErrNone
+}
 
-		// Query string.
-		queryStr := req.Form.Encode()
+// parseAuthentication returns the signature values, signing time, the time
+// until which the request is accepted and the canonical query string of
+// the Authorization header or, for presigned requests, the query string.
+func (p *CredentialsProvider) parseAuthentication(r *http.Request) (signValues, time.Time, time.Time, string, APIErrorCode) {
 
-		// Get canonical request.
-		canonicalRequest := getCanonicalRequest(
-			extractedSignedHeaders, hashedPayload, queryStr, req.URL.Path, req.Method)
+	if isPresigned(r) {
 
-		// Get string to sign from canonical request.
-		stringToSign := getStringToSign(canonicalRequest, t, signV4Values.Credential.getScope())
+		preSignValues, err := parsePreSignV4(r.URL.Query(), p.regions(), p.Service)
+		if err != ErrNone {
+			return signValues{}, time.Time{}, time.Time{}, "", err
+		}
 
-		// Get hmac signing key.
-		signingKey := getSigningKey(validCreds.SecretAccessKey, signV4Values.Credential.scope.date,
-			signV4Values.Credential.scope.region, p.Service)
+		expires := preSignValues.Date.Add(preSignValues.Expires)
+		if time.Now().After(expires) {
+			return signValues{}, time.Time{}, time.Time{}, "", ErrExpiredPresignRequest
+		}
 
-		// Calculate signature.
-		newSignature := getSignature(signingKey, stringToSign)
+		return preSignValues.signValues, preSignValues.Date, expires, presignedQuery(r.URL.Query()), ErrNone
+	}
 
-		// Verify if signature match.
-		if !compareSignatureV4(newSignature, signV4Values.Signature) {
-			WriteErrorResponseJSON(w, ErrorCodes.ToAPIErr(ErrSignatureDoesNotMatch), r.URL, p.Region)
-			return
+	// Parse signature version '4' header.
+	signV4Values, err := parseSignV4(r.Header.Get(headerAuthorization), p.regions(), p.Service)
+	if err != ErrNone {
+		return signValues{}, time.Time{}, time.Time{}, "", err
+	}
+
+	// Extract date, if not present throw error.
+	var date string
+	if date = r.Header.Get(headerAmzDate); date == "" {
+		if date = r.Header.Get(headerDate); date == "" {
+			return signValues{}, time.Time{}, time.Time{}, "", ErrMissingDateHeader
 		}
+	}
 
-		// Call the next handler.
-		next(w, r)
+	// Parse date header.
+	t, e := time.Parse(iso8601Format, date)
+	if e != nil {
+		return signValues{}, time.Time{}, time.Time{}, "", ErrMalformedDate
 	}
+
+	return signV4Values, t, t.Add(MaxRequestSkew), r.URL.Query().Encode(), ErrNone
 }
 
-// Returns SHA256 for calculating canonical-request.
-func getContentSha256Cksum(r *http.Request, stype ServiceType) string {
+func (p *CredentialsProvider) writeError(w http.ResponseWriter, r *http.Request, errCode APIErrorCode) {
 
-	if stype == ServiceSsm {
+	metrics.SigV4Failures.WithLabelValues(errCode.String()).Inc()
+	if p.Protocol == ProtocolQuery {
+		WriteErrorResponseXML(w, ErrorCodes.ToAPIErr(errCode), "")
+		return
+	}
 
-		payload, err := io.ReadAll(io.LimitReader(r.Body, 10*(1<<20)))
-		if err != nil {
-			log.Panicln(err)
-		}
-		sum256 := sha256.Sum256(payload)
-		r.Body = io.NopCloser(bytes.NewReader(payload))
-		return hex.EncodeToString(sum256[:])
+	WriteErrorResponseJSON(w, ErrorCodes.ToAPIErr(errCode), r.URL, p.Region)
+}
+
+// Returns SHA256 for calculating canonical-request. Every hosted service
+// signs its payload.
+func getContentSha256Cksum(r *http.Request) string {
+
+	if r.Body == nil {
+		return emptySHA256
 	}
 
-	return emptySHA256
+	payload, err := io.ReadAll(io.LimitReader(r.Body, 10*(1<<20)))
+	if err != nil {
+		log.Panicln(err)
+	}
+	sum256 := sha256.Sum256(payload)
+	r.Body = io.NopCloser(bytes.NewReader(payload))
+	return hex.EncodeToString(sum256[:])
 }
 
 // extractSignedHeaders extract signed headers from Authorization header
@@ -383,14 +530,14 @@ func sumHMAC(key []byte, data []byte) []byte {
 	return hash.Sum(nil)
 }
 
-// isValidRegion - verify if incoming region value is valid with configured Region.
-func isValidRegion(reqRegion string, confRegion string) bool {
+// isValidRegion - verify if incoming region value is one of the configured regions.
+func isValidRegion(reqRegion string, confRegions []string) bool {
 
-	return reqRegion == confRegion
+	return slices.Contains(confRegions, reqRegion)
 }
 
 // parse credentialHeader string into its structured form.
-func parseCredentialHeader(credElement string, region string, stype ServiceType) (ch credentialHeader, aec APIErrorCode) {
+func parseCredentialHeader(credElement string, regions []string, stype ServiceType) (ch credentialHeader, aec APIErrorCode) {
 	creds := strings.SplitN(strings.TrimSpace(credElement), "=", 2)
 	if len(creds) != 2 {
 		return ch, ErrMissingFields
@@ -424,15 +571,15 @@ func parseCredentialHeader(credElement string, region string, stype ServiceType)
 	// request and proceed further. This is a work-around to address
 	// an important problem for ListBuckets() getting signed with
 	// different regions.
-	if region == "" {
-		region = sRegion
+	if len(regions) == 0 {
+		regions = []string{sRegion}
 	}
 	// Should validate region, only if region is set.
-	if !isValidRegion(sRegion, region) {
+	if !isValidRegion(sRegion, regions) {
 		return ch, ErrAuthorizationHeaderMalformed
 	}
 	if credElements[2] != string(stype) {
-		return ch, ErrInvalidServiceSSM
+		return ch, ErrInvalidService
 	}
 	cred.scope.service = credElements[2]
 	if credElements[3] != "aws4_request" {
@@ -478,7 +625,7 @@ func parseSignedHeader(signedHdrElement string) ([]string, APIErrorCode) {
 //
 //	Authorization: algorithm Credential=accessKeyID/credScope, \
 //	        SignedHeaders=signedHeaders, Signature=signature
-func parseSignV4(v4Auth string, region string, stype ServiceType) (sv signValues, aec APIErrorCode) {
+func parseSignV4(v4Auth string, regions []string, stype ServiceType) (sv signValues, aec APIErrorCode) {
 
 	// credElement is fetched first to skip replacing the space in access key.
 	credElement := strings.TrimPrefix(strings.Split(strings.TrimSpace(v4Auth), ",")[0], signV4Algorithm)
@@ -507,7 +654,7 @@ func parseSignV4(v4Auth string, region string, stype ServiceType) (sv signValues
 
 	var s3Err APIErrorCode
 	// Save credential values.
-	signV4Values.Credential, s3Err = parseCredentialHeader(strings.TrimSpace(credElement), region, stype)
+	signV4Values.Credential, s3Err = parseCredentialHeader(strings.TrimSpace(credElement), regions, stype)
 	if s3Err != ErrNone {
 		return sv, s3Err
 	}
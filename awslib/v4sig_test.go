@@ -0,0 +1,283 @@
+package awslib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+var testCredentials = aws.Credentials{AccessKeyID: "AKIATEST", SecretAccessKey: "test-secret", Source: "test", AccountID: "000000000000"}
+
+// testRequest is signed once and can be sent several times.
+type testRequest struct {
+	method string
+	url    string
+	body   string
+	header http.Header
+}
+
+func newTestRequest(t *testing.T, creds aws.Credentials, service ServiceType, method string, url string, target string, body string) *testRequest {
+
+	t.Helper()
+
+	return newTestRequestAt(t, creds, service, method, url, target, body, time.Now())
+}
+
+func newTestRequestAt(t *testing.T, creds aws.Credentials, service ServiceType, method string, url string, target string, body string, signTime time.Time) *testRequest {
+
+	t.Helper()
+
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	if target != "" {
+		r.Header.Set(headerAmzTarget, target)
+	}
+
+	sum := sha256.Sum256([]byte(body))
+	err := v4.NewSigner().SignHTTP(context.Background(), creds, r, hex.EncodeToString(sum[:]), string(service), "us-east-1", signTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testRequest{method: method, url: url, body: body, header: r.Header}
+}
+
+// newPresignedRequest signs with query string authentication instead.
+func newPresignedRequest(creds aws.Credentials, service ServiceType, method string, target string, body string, signTime time.Time, expires time.Duration) *testRequest {
+
+	r := httptest.NewRequest(method, "http://example.com/", nil)
+	if target != "" {
+		r.Header.Set(headerAmzTarget, target)
+	}
+
+	url := PresignURL(r, []byte(body), creds, "us-east-1", service, signTime, expires)
+
+	return &testRequest{method: method, url: url, body: body, header: r.Header}
+}
+
+// send returns the status and the principal the handler saw.
+func (request *testRequest) send(provider *CredentialsProvider) (int, *RequestInfo) {
+
+	w, seen := request.sendRecorded(provider)
+
+	return w.Code, seen
+}
+
+func (request *testRequest) sendRecorded(provider *CredentialsProvider) (*httptest.ResponseRecorder, *RequestInfo) {
+
+	var seen *RequestInfo
+	handler := WithRequestId(provider.WithSigV4(func(w http.ResponseWriter, r *http.Request) {
+		seen = GetRequestInfo(r.Context())
+	}))
+
+	r := httptest.NewRequest(request.method, request.url, strings.NewReader(request.body))
+	r.Header = request.header.Clone()
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	return w, seen
+}
+
+func newTestProvider(service ServiceType, protocol Protocol) *CredentialsProvider {
+
+	return &CredentialsProvider{
+		Service:  service,
+		Protocol: protocol,
+		Region:   "us-east-1",
+		Keyring:  NewKeyring([]aws.Credentials{testCredentials}),
+		Replays:  NewReplayCache(ReplayConfig{Enabled: true}),
+	}
+}
+
+func TestSigV4(t *testing.T) {
+
+	wrongSecret := testCredentials
+	wrongSecret.SecretAccessKey = "wrong"
+	unknownKey := testCredentials
+	unknownKey.AccessKeyID = "AKIAUNKNOWN"
+
+	// the credential scope names the day before the request date
+	otherScopeDate := func(request *testRequest) {
+		auth := request.header.Get(headerAuthorization)
+		date := time.Now().UTC()
+		request.header.Set(headerAuthorization, strings.Replace(auth,
+			"/"+date.Format(yyyymmdd)+"/", "/"+date.AddDate(0, 0, -1).Format(yyyymmdd)+"/", 1))
+	}
+
+	tests := []struct {
+		name   string
+		creds  aws.Credentials
+		skew   time.Duration
+		tamper func(*testRequest)
+		status int
+		// message is part of the error response
+		message string
+	}{
+		{"valid", testCredentials, 0, nil, http.StatusOK, ""},
+		{"wrong secret", wrongSecret, 0, nil, http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"unknown access key", unknownKey, 0, nil, http.StatusForbidden, "InvalidAccessKeyId"},
+		{"within skew", testCredentials, -10 * time.Minute, nil, http.StatusOK, ""},
+		{"future skew", testCredentials, 20 * time.Minute, nil, http.StatusForbidden, "RequestTimeTooSkewed"},
+		{"past skew", testCredentials, -20 * time.Minute, nil, http.StatusForbidden, "RequestTimeTooSkewed"},
+		{"scope date mismatch", testCredentials, 0, otherScopeDate, http.StatusForbidden, "Credential should be scoped"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			request := newTestRequestAt(t, test.creds, ServiceSsm, http.MethodPost, "/", "AmazonSSM.GetParameter", `{"Name":"/a"}`, time.Now().Add(test.skew))
+			if test.tamper != nil {
+				test.tamper(request)
+			}
+
+			w, info := request.sendRecorded(newTestProvider(ServiceSsm, ProtocolJSON11))
+			status := w.Code
+			if status != test.status || !strings.Contains(w.Body.String(), test.message) {
+				t.Fatalf("got %d %s, want %d %s", status, w.Body.String(), test.status, test.message)
+			}
+
+			if status == http.StatusOK && (info.Principal == nil || info.Principal.AccessKeyID != testCredentials.AccessKeyID) {
+				t.Errorf("principal %v, want %s", info.Principal, testCredentials.AccessKeyID)
+			}
+		})
+	}
+}
+
+func TestReplayProtection(t *testing.T) {
+
+	const form = "application/x-www-form-urlencoded"
+	tests := []struct {
+		name     string
+		service  ServiceType
+		protocol Protocol
+		method   string
+		url      string
+		target   string
+		body     string
+		// replayed is whether sending the request again is rejected
+		replayed bool
+	}{
+		{"json read", ServiceSsm, ProtocolJSON11, http.MethodPost, "/", "AmazonSSM.GetParameter", `{}`, false},
+		{"json mutation", ServiceSsm, ProtocolJSON11, http.MethodPost, "/", "AmazonSSM.PutParameter", `{}`, true},
+		{"query read", ServiceSts, ProtocolQuery, http.MethodPost, "/", "", "Action=GetCallerIdentity&Version=2011-06-15", false},
+		{"query mutation in form", ServiceSts, ProtocolQuery, http.MethodPost, "/", "", "Action=AssumeRole&Version=2011-06-15", true},
+		{"query mutation in url", ServiceIam, ProtocolQuery, http.MethodGet, "/?Action=CreateAccessKey&Version=2010-05-08", "", "", true},
+		{"endpoint read", ServiceSsm, ProtocolJSON11, http.MethodGet, "/watch?path=%2F", "", "", false},
+		{"endpoint mutation", ServiceAdmin, ProtocolJSON11, http.MethodPut, "/admin/faults", "", `{}`, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			provider := newTestProvider(test.service, test.protocol)
+			request := newTestRequest(t, testCredentials, test.service, test.method, test.url, test.target, test.body)
+			if test.protocol == ProtocolQuery && test.body != "" {
+				request.header.Set(headerContentType, form)
+			}
+
+			if status, _ := request.send(provider); status != http.StatusOK {
+				t.Fatalf("first request got %d", status)
+			}
+
+			status, info := request.send(provider)
+			if (status != http.StatusOK) != test.replayed {
+				t.Errorf("replay got %d, want rejected %v", status, test.replayed)
+			}
+
+			if status == http.StatusOK && info.Service != test.service {
+				t.Errorf("service %s, want %s", info.Service, test.service)
+			}
+		})
+	}
+}
+
+func TestPresignedSigV4(t *testing.T) {
+
+	now := time.Now()
+	tests := []struct {
+		name     string
+		signTime time.Time
+		expires  time.Duration
+		status   int
+		message  string
+	}{
+		{"valid", now, time.Hour, http.StatusOK, ""},
+		{"older than the skew window", now.Add(-2 * time.Hour), 3 * time.Hour, http.StatusOK, ""},
+		{"expired", now.Add(-2 * time.Hour), time.Hour, http.StatusForbidden, "Request has expired"},
+		{"future skew", now.Add(20 * time.Minute), time.Hour, http.StatusForbidden, "RequestTimeTooSkewed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			request := newPresignedRequest(testCredentials, ServiceSsm, http.MethodPost, "AmazonSSM.GetParameter", `{"Name":"/a"}`, test.signTime, test.expires)
+			w, _ := request.sendRecorded(newTestProvider(ServiceSsm, ProtocolJSON11))
+			if w.Code != test.status || !strings.Contains(w.Body.String(), test.message) {
+				t.Errorf("got %d %s, want %d %s", w.Code, w.Body.String(), test.status, test.message)
+			}
+		})
+	}
+}
+
+func TestPresignedReplay(t *testing.T) {
+
+	// signed two hours ago and valid for another hour, past the skew window
+	signTime := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	request := newPresignedRequest(testCredentials, ServiceSsm, http.MethodPost, "AmazonSSM.PutParameter", `{"Name":"/a"}`, signTime, 3*time.Hour)
+	provider := newTestProvider(ServiceSsm, ProtocolJSON11)
+
+	if status, _ := request.send(provider); status != http.StatusOK {
+		t.Fatalf("first request got %d", status)
+	}
+
+	if status, _ := request.send(provider); status != http.StatusForbidden {
+		t.Errorf("replay got %d, want %d", status, http.StatusForbidden)
+	}
+
+	for signature, expires := range provider.Replays.seen {
+		if !expires.Equal(signTime.Add(3 * time.Hour)) {
+			t.Errorf("signature %s kept until %s, want the expiry of the URL", signature, expires)
+		}
+	}
+}
+
+func TestReplayCache(t *testing.T) {
+
+	now := time.Now()
+	tests := []struct {
+		name    string
+		expires time.Duration
+		// replayAt is when the signature is sent again
+		replayAt time.Duration
+		accepted bool
+	}{
+		{"within the skew window", MaxRequestSkew, time.Minute, false},
+		{"after the skew window", MaxRequestSkew, MaxRequestSkew + time.Second, true},
+		{"presigned after the skew window", 24 * time.Hour, time.Hour, false},
+		{"presigned after expiry", 24 * time.Hour, 24*time.Hour + time.Second, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			cache := NewReplayCache(ReplayConfig{Enabled: true})
+			if !cache.Add("signature", now, now.Add(test.expires)) {
+				t.Fatal("first use rejected")
+			}
+
+			// another request pruning the cache doesn't drop the signature early
+			cache.Add("other", now.Add(test.replayAt), now.Add(test.replayAt+MaxRequestSkew))
+
+			if accepted := cache.Add("signature", now.Add(test.replayAt), now.Add(test.replayAt+MaxRequestSkew)); accepted != test.accepted {
+				t.Errorf("replay accepted %v, want %v", accepted, test.accepted)
+			}
+		})
+	}
+}
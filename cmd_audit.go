@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"home-ssm/audit"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runAuditCommand prints audit events from the database; badger only allows
+// a read-only open while no server holds the database.
+func runAuditCommand(args []string) {
+
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	dbPath := flags.String("db-path", ".home-ssm-db", "Path to badger database folder.")
+	username := flags.String("user", "", "Only events by this username.")
+	accessKey := flags.String("access-key", "", "Only events signed with this access key.")
+	eventName := flags.String("event-name", "", "Only events for this operation, e.g. GetParameter.")
+	resource := flags.String("resource", "", "Only events for this parameter or resource name.")
+	errorCode := flags.String("error-code", "", "Only events that failed with this error code.")
+	failed := flags.Bool("failed", false, "Only events that failed.")
+	since := flags.Duration("since", 24*time.Hour, "Only events newer than this.")
+	limit := flags.Int("limit", 100, "Maximum number of events to print.")
+	asJson := flags.Bool("json", false, "Print CloudTrail JSON records, one per line.")
+	flags.Parse(args)
+
+	db := openDatabaseOrDie(*dbPath, true)
+	defer db.Close()
+
+	query := audit.Query{StartTime: time.Now().Add(-*since)}
+	for key, value := range map[string]string{
+		"Username":     *username,
+		"AccessKeyId":  *accessKey,
+		"EventName":    *eventName,
+		"ResourceName": *resource,
+		"ErrorCode":    *errorCode,
+	} {
+		if value != "" {
+			query.Attributes = append(query.Attributes, audit.LookupAttribute{AttributeKey: key, AttributeValue: value})
+		}
+	}
+
+	store := audit.NewStore(db, audit.Config{})
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if !*asJson {
+		fmt.Fprintln(out, "TIME\tUSER\tSOURCE IP\tEVENT\tRESOURCES\tERROR")
+	}
+
+	printed := 0
+	for printed < *limit {
+
+		events, nextToken, err := store.Lookup(context.Background(), &query)
+		if err != nil {
+			log.Fatalln("Error reading audit events:", err)
+		}
+
+		for _, event := range events {
+
+			if (*failed && event.ErrorCode == "") || printed == *limit {
+				continue
+			}
+
+			printed++
+			if *asJson {
+
+				record, _ := json.Marshal(event)
+				fmt.Println(string(record))
+
+			} else {
+
+				user := event.UserIdentity.UserName
+				if user == "" {
+					user = event.UserIdentity.AccessKeyId
+				}
+
+				fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%s\n",
+					event.EventTime.Local().Format(time.DateTime), user, event.SourceIPAddress,
+					strings.TrimSuffix(event.EventSource, ".amazonaws.com")+":"+event.EventName,
+					strings.Join(event.ResourceNames(), ","), event.ErrorCode)
+			}
+		}
+
+		if nextToken == "" {
+			break
+		}
+
+		query.NextToken = nextToken
+	}
+
+	out.Flush()
+}
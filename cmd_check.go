@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runCheckConfigCommand validates the config file as the server would at
+// startup and prints every problem found.
+func runCheckConfigCommand(args []string) {
+
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	configFile := flags.String("config", ".home-ssm-config.yaml", "Path to the home-ssm config file.")
+	flags.Parse(args)
+
+	if !checkConfig(*configFile) {
+		os.Exit(1)
+	}
+}
+
+// checkConfig prints the problems of the config file and reports whether
+// it's valid.
+func checkConfig(configFile string) bool {
+
+	config, err := readConfig(configFile)
+	if err != nil {
+		fmt.Printf("%s: %v\n", configFile, err)
+		return false
+	}
+
+	problems := configProblems(validateConfig(config))
+	if len(problems) == 0 {
+		fmt.Printf("%s: OK\n", configFile)
+		return true
+	}
+
+	fmt.Printf("%s: %d problems\n", configFile, len(problems))
+	for _, problem := range problems {
+		fmt.Println("  " + problem)
+	}
+
+	return false
+}
+
+// configProblems flattens the joined errors of validateConfig.
+func configProblems(err error) []string {
+
+	if err == nil {
+		return nil
+	}
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	for _, err := range joined.Unwrap() {
+		problems = append(problems, configProblems(err)...)
+	}
+
+	return problems
+}
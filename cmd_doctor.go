@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"home-ssm/ssm"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// runDoctorCommand checks the config and the stored data. Like the audit
+// command it opens the database read-only, so the server must be stopped.
+func runDoctorCommand(args []string) {
+
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configFile := flags.String("config", ".home-ssm-config.yaml", "Path to the home-ssm config file.")
+	dbPath := flags.String("db-path", ".home-ssm-db", "Path to badger database folder.")
+	flags.Parse(args)
+
+	healthy := checkConfig(*configFile)
+
+	config, err := readConfig(*configFile)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	db := openDatabaseOrDie(*dbPath, true)
+	defer db.Close()
+
+	dataStore := ssm.NewDataStore(db, config.Keys)
+	parameters, problems, err := dataStore.CheckParameters(context.Background())
+	if err != nil {
+		log.Fatalln("Error reading parameters:", err)
+	}
+
+	prefixes := []string{dataStore.KeyPrefix()}
+	for _, ns := range namespaces(config) {
+
+		nsStore := ns.dataStore(db)
+		prefixes = append(prefixes, nsStore.KeyPrefix())
+		count, nsProblems, err := nsStore.CheckParameters(context.Background())
+		if err != nil {
+			log.Fatalln("Error reading parameters of", ns.key()+":", err)
+		}
+
+		parameters += count
+		for _, problem := range nsProblems {
+			problems = append(problems, ssm.Problem{Key: ns.key() + ":" + problem.Key, Message: problem.Message})
+		}
+	}
+
+	records, unreadable, err := checkRecords(db, prefixes)
+	if err != nil {
+		log.Fatalln("Error reading database:", err)
+	}
+	problems = append(problems, unreadable...)
+
+	fmt.Printf("\n%s: %d parameters", *dbPath, parameters)
+	for _, keyspace := range slices.Sorted(maps.Keys(records)) {
+		fmt.Printf(", %d %s", records[keyspace], keyspace)
+	}
+	fmt.Println()
+
+	lsm, vlog, err := storageSize(*dbPath)
+	if err != nil {
+		log.Fatalln("Error reading database size:", err)
+	}
+	fmt.Printf("storage: %s (tables %s, value log %s)\n", formatSize(lsm+vlog), formatSize(lsm), formatSize(vlog))
+
+	if len(problems) > 0 {
+
+		healthy = false
+		fmt.Printf("\n%d problems\n", len(problems))
+
+		out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, problem := range problems {
+			fmt.Fprintf(out, "  %s\t%s\n", problem.Key, problem.Message)
+		}
+		out.Flush()
+	}
+
+	if !healthy {
+		os.Exit(1)
+	}
+}
+
+// checkRecords counts the records outside the parameter prefixes by
+// keyspace, the first segment of the key, and reports those that aren't
+// valid JSON. Parameters of regions and accounts no longer configured are
+// counted as records.
+func checkRecords(db *badger.DB, prefixes []string) (map[string]int, []ssm.Problem, error) {
+
+	records := make(map[string]int)
+	var problems []ssm.Problem
+
+	err := db.View(func(txn *badger.Txn) error {
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+
+			key := string(it.Item().Key())
+			if slices.ContainsFunc(prefixes, func(prefix string) bool { return strings.HasPrefix(key, prefix) }) {
+				continue
+			}
+
+			keyspace, _, _ := strings.Cut(key, "/")
+			records[keyspace]++
+
+			err := it.Item().Value(func(val []byte) error {
+				if !json.Valid(val) {
+					problems = append(problems, ssm.Problem{Key: key, Message: "unreadable: not valid JSON"})
+				}
+				return nil
+			})
+			if err != nil {
+				problems = append(problems, ssm.Problem{Key: key, Message: fmt.Sprintf("unreadable: %v", err)})
+			}
+		}
+
+		return nil
+	})
+
+	return records, problems, err
+}
+
+// storageSize returns the size of the table and value log files.
+func storageSize(dbPath string) (int64, int64, error) {
+
+	var lsm, vlog int64
+	err := filepath.WalkDir(dbPath, func(path string, entry fs.DirEntry, err error) error {
+
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		if strings.HasSuffix(path, ".vlog") {
+			vlog += info.Size()
+		} else {
+			lsm += info.Size()
+		}
+
+		return nil
+	})
+
+	return lsm, vlog, err
+}
+
+func formatSize(size int64) string {
+
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
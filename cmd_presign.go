@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"home-ssm/awslib"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// runPresignCommand prints a presigned URL for one operation and body, for
+// clients that can't hold long-lived credentials.
+func runPresignCommand(args []string) {
+
+	flags := flag.NewFlagSet("presign", flag.ExitOnError)
+	configFile := flags.String("config", ".home-ssm-config.yaml", "Path to the home-ssm config file.")
+	accessKey := flags.String("access-key", "", "Access key to sign with, defaults to the first credential.")
+	endpoint := flags.String("endpoint", "http://localhost:9080/", "Endpoint the URL is for.")
+	service := flags.String("service", "ssm", "Service of the operation, e.g. ssm or secretsmanager.")
+	target := flags.String("target", "AmazonSSM.GetParameter", "X-Amz-Target of the operation.")
+	body := flags.String("body", "{}", "Request body, the caller must send exactly this body.")
+	expires := flags.Duration("expires", 15*time.Minute, "Lifetime of the URL, at most 7 days.")
+	flags.Parse(args)
+
+	if *expires < time.Second || *expires > awslib.MaxPresignExpiry {
+		log.Fatalln("expires must be between 1s and", awslib.MaxPresignExpiry)
+	}
+
+	config := readAuthCredsOrDie(*configFile)
+
+	url, err := presignURL(config, *accessKey, *endpoint, awslib.ServiceType(*service), *target, *body, time.Now(), *expires)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	fmt.Println(url)
+	fmt.Fprintf(os.Stderr, "\ncurl -X POST -H 'X-Amz-Target: %s' -H 'Content-Type: application/x-amz-json-1.1' -d '%s' '%s'\n",
+		*target, strings.ReplaceAll(*body, "'", `'\''`), url)
+}
+
+// presignURL signs the request with the credentials of the access key, or
+// of the first credential if it's empty.
+func presignURL(config *HomeSsmConfig, accessKey string, endpoint string, service awslib.ServiceType,
+	target string, body string, signTime time.Time, expires time.Duration) (string, error) {
+
+	var creds *aws.Credentials
+	for _, cred := range config.Credentials {
+		if accessKey == "" || cred.AccessKey == accessKey {
+			creds = &aws.Credentials{AccessKeyID: cred.AccessKey, SecretAccessKey: cred.SecretKey}
+			break
+		}
+	}
+
+	if creds == nil {
+		return "", fmt.Errorf("no credentials for access key %s", accessKey)
+	}
+
+	r, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader([]byte(body)))
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint: %w", err)
+	}
+	r.Header.Set("X-Amz-Target", target)
+
+	return awslib.PresignURL(r, []byte(body), *creds, config.Region, service, signTime, expires), nil
+}
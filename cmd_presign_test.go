@@ -0,0 +1,78 @@
+package main
+
+import (
+	"home-ssm/awslib"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestPresignURL(t *testing.T) {
+
+	config := testConfig()
+	config.Credentials = append(config.Credentials, SsmCredentials{AccessKey: "reader", SecretKey: "reader-secret", Username: "reader"})
+	provider := awslib.CredentialsProvider{
+		Service: awslib.ServiceSsm,
+		Region:  config.Region,
+		Keyring: awslib.NewKeyring(configCredentials(config)),
+	}
+
+	tests := []struct {
+		name      string
+		accessKey string
+		signTime  time.Time
+		// target and body are those the URL is sent with
+		target    string
+		body      string
+		principal string
+		status    int
+	}{
+		{"first credential", "", time.Now(), "AmazonSSM.GetParameter", `{"Name":"/a"}`, "admin", http.StatusOK},
+		{"access key", "reader", time.Now(), "AmazonSSM.GetParameter", `{"Name":"/a"}`, "reader", http.StatusOK},
+		{"expired", "", time.Now().Add(-time.Hour), "AmazonSSM.GetParameter", `{"Name":"/a"}`, "", http.StatusForbidden},
+		{"other body", "", time.Now(), "AmazonSSM.GetParameter", `{"Name":"/b"}`, "", http.StatusForbidden},
+		{"other target", "", time.Now(), "AmazonSSM.DeleteParameter", `{"Name":"/a"}`, "", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			url, err := presignURL(config, test.accessKey, "http://localhost:9080/", awslib.ServiceSsm,
+				"AmazonSSM.GetParameter", `{"Name":"/a"}`, test.signTime, 15*time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var principal *aws.Credentials
+			handler := awslib.WithRequestId(provider.WithSigV4(func(w http.ResponseWriter, r *http.Request) {
+				principal = awslib.GetRequestInfo(r.Context()).Principal
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, url, strings.NewReader(test.body))
+			r.Header.Set("X-Amz-Target", test.target)
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != test.status {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body.String(), test.status)
+			}
+
+			if test.principal != "" && (principal == nil || principal.AccessKeyID != test.principal) {
+				t.Errorf("principal %v, want %s", principal, test.principal)
+			}
+		})
+	}
+}
+
+func TestPresignURLUnknownAccessKey(t *testing.T) {
+
+	_, err := presignURL(testConfig(), "missing", "http://localhost:9080/", awslib.ServiceSsm,
+		"AmazonSSM.GetParameter", `{}`, time.Now(), time.Minute)
+	if err == nil {
+		t.Error("presignURL() signed with an unknown access key")
+	}
+}
@@ -0,0 +1,95 @@
+package faults
+
+import (
+	"encoding/json"
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"net/http"
+)
+
+// AdminApi reads and replaces the rules of an Injector at runtime:
+// GET returns the config, PUT replaces it and DELETE removes every rule.
+type AdminApi struct {
+	injector   *Injector
+	accountId  string
+	authorizer *policy.Authorizer
+}
+
+func NewAdminApi(injector *Injector, accountId string, authorizer *policy.Authorizer) *AdminApi {
+
+	return &AdminApi{injector: injector, accountId: accountId, authorizer: authorizer}
+}
+
+func (api *AdminApi) Handle(w http.ResponseWriter, r *http.Request) {
+
+	var action string
+	switch r.Method {
+	case http.MethodGet:
+		action = "GetFaultRules"
+	case http.MethodPut:
+		action = "PutFaultRules"
+	case http.MethodDelete:
+		action = "DeleteFaultRules"
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	awslib.GetRequestInfo(r.Context()).Operation = action
+	if err := api.authorize(r, action); err != nil {
+		awslib.Logger(r.Context()).Warn("request denied", "error", err)
+		writeError(w, r, http.StatusForbidden, "AccessDeniedException", err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+
+		var config Config
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			writeError(w, r, http.StatusBadRequest, "SerializationException", err.Error())
+			return
+		}
+
+		if err := api.injector.SetConfig(config); err != nil {
+			writeError(w, r, http.StatusBadRequest, "ValidationException", err.Error())
+			return
+		}
+
+		awslib.Logger(r.Context()).Info("fault rules replaced", "rules", len(config.Rules), "seed", config.Seed)
+
+	case http.MethodDelete:
+
+		config := api.injector.Config()
+		config.Rules = nil
+		api.injector.SetConfig(config)
+
+		awslib.Logger(r.Context()).Info("fault rules removed")
+	}
+
+	awslib.WriteSuccessResponseJSON(w, api.injector.Config())
+}
+
+func (api *AdminApi) authorize(r *http.Request, action string) error {
+
+	info := awslib.GetRequestInfo(r.Context())
+	principal := info.Principal
+	if api.authorizer == nil || principal == nil {
+		return nil
+	}
+
+	principalArn := awslib.PrincipalArn(api.accountId, principal)
+	request := policy.Request{
+		Action:   "home-ssm:" + action,
+		Resource: "*",
+		Context:  policy.GlobalContext(principal, principalArn, info.SourceIp),
+	}
+
+	return api.authorizer.Authorize(principal, principalArn, &request)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+
+	awslib.WriteErrorResponseJSON(w, awslib.APIError{Code: code, Description: message, HTTPStatusCode: status}, r.URL, "")
+}
@@ -0,0 +1,122 @@
+package faults
+
+import (
+	"encoding/json"
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// callAdmin sends the request to the admin api as the access key, whose
+// "reader" may only get the rules.
+func callAdmin(t *testing.T, api *AdminApi, accessKey string, method string, body string) *httptest.ResponseRecorder {
+
+	t.Helper()
+
+	handler := awslib.WithRequestId(func(w http.ResponseWriter, r *http.Request) {
+
+		info := awslib.GetRequestInfo(r.Context())
+		info.Principal = &aws.Credentials{AccessKeyID: accessKey, AccountID: "000000000000"}
+		api.Handle(w, r)
+	})
+
+	r := httptest.NewRequest(method, "/admin/faults", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	return w
+}
+
+func newTestAdminApi(t *testing.T, injector *Injector) *AdminApi {
+
+	t.Helper()
+
+	document, err := policy.Parse("reader", `{"Statement":{"Effect":"Allow","Action":"home-ssm:GetFaultRules","Resource":"*"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorizer := policy.NewAuthorizer()
+	authorizer.SetPolicies("reader", []*policy.Document{document})
+
+	return NewAdminApi(injector, "000000000000", authorizer)
+}
+
+func TestAdminApi(t *testing.T) {
+
+	injector := newTestInjector(t)
+	api := newTestAdminApi(t, injector)
+
+	steps := []struct {
+		name      string
+		accessKey string
+		method    string
+		body      string
+		status    int
+		// rules is the number of rules afterwards, failing whether
+		// GetParameter then fails
+		rules   int
+		failing bool
+	}{
+		{"enable", "admin", http.MethodPut, `{"seed":1,"rules":[{"name":"get","operation":"GetParameter","fault":"internalError"}]}`,
+			http.StatusOK, 1, true},
+		{"get", "reader", http.MethodGet, "", http.StatusOK, 1, true},
+		{"invalid rule", "admin", http.MethodPut, `{"rules":[{"name":"bad","fault":"crash"}]}`, http.StatusBadRequest, 1, true},
+		{"malformed", "admin", http.MethodPut, `{"rules":`, http.StatusBadRequest, 1, true},
+		{"denied", "reader", http.MethodDelete, "", http.StatusForbidden, 1, true},
+		{"other method", "admin", http.MethodPost, "", http.StatusMethodNotAllowed, 1, true},
+		{"disable", "admin", http.MethodDelete, "", http.StatusOK, 0, false},
+		{"enable other operation", "admin", http.MethodPut, `{"rules":[{"name":"put","operation":"PutParameter","fault":"throttling"}]}`,
+			http.StatusOK, 1, false},
+	}
+
+	for _, step := range steps {
+
+		w := callAdmin(t, api, step.accessKey, step.method, step.body)
+		if w.Code != step.status {
+			t.Fatalf("%s: got %d %s, want %d", step.name, w.Code, w.Body.String(), step.status)
+		}
+
+		if w.Code == http.StatusOK {
+
+			var config Config
+			if err := json.Unmarshal(w.Body.Bytes(), &config); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(config.Rules) != step.rules {
+				t.Errorf("%s: responded with %d rules, want %d", step.name, len(config.Rules), step.rules)
+			}
+		}
+
+		if rules := len(injector.Config().Rules); rules != step.rules {
+			t.Errorf("%s: %d rules, want %d", step.name, rules, step.rules)
+		}
+
+		if failing := serve(injector, "app", "GetParameter", `{}`).Code != http.StatusOK; failing != step.failing {
+			t.Errorf("%s: GetParameter failing %v, want %v", step.name, failing, step.failing)
+		}
+	}
+}
+
+func TestAdminApiKeepsSeed(t *testing.T) {
+
+	injector, err := NewInjector(Config{Seed: 42, Rules: []Rule{{Name: "get", Fault: FaultInternalError}}}, "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := callAdmin(t, newTestAdminApi(t, injector), "admin", http.MethodDelete, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+
+	if config := injector.Config(); config.Seed != 42 || !config.Enabled {
+		t.Errorf("config after removing the rules %+v, want seed 42 and enabled", config)
+	}
+}
@@ -0,0 +1,283 @@
+package faults
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"home-ssm/awslib"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FaultLatency       = "latency"
+	FaultInternalError = "internalError"
+	FaultThrottling    = "throttling"
+	FaultReset         = "reset"
+	FaultTruncate      = "truncate"
+)
+
+var knownFaults = []string{FaultLatency, FaultInternalError, FaultThrottling, FaultReset, FaultTruncate}
+
+var (
+	internalServerError = awslib.APIError{
+		Code:           "InternalServerError",
+		Description:    "An error occurred on the server side.",
+		HTTPStatusCode: http.StatusInternalServerError,
+	}
+	throttlingException = awslib.APIError{
+		Code:           "ThrottlingException",
+		Description:    "Rate exceeded",
+		HTTPStatusCode: http.StatusBadRequest,
+	}
+)
+
+// Rule injects a fault into matching requests. Empty match fields match
+// every request. Without Probability or Nth every matching call fails.
+type Rule struct {
+	Name string `json:"name" yaml:"name"`
+	// Operation is the operation name, e.g. GetParameter.
+	Operation string `json:"operation,omitempty" yaml:"operation"`
+	// PathPrefix matches the Name, Names or Path of the request.
+	PathPrefix string `json:"pathPrefix,omitempty" yaml:"pathPrefix"`
+	// Principal is a username or access key.
+	Principal string `json:"principal,omitempty" yaml:"principal"`
+	Fault     string `json:"fault" yaml:"fault"`
+	LatencyMs int    `json:"latencyMs,omitempty" yaml:"latencyMs"`
+	// Probability in [0, 1] of failing a matching call.
+	Probability float64 `json:"probability,omitempty" yaml:"probability"`
+	// Nth fails every Nth matching call.
+	Nth int `json:"nth,omitempty" yaml:"nth"`
+}
+
+type Config struct {
+	// Enabled installs the injector and the /admin/faults endpoint.
+	Enabled bool   `json:"-" yaml:"enabled"`
+	Seed    uint64 `json:"seed" yaml:"seed"`
+	Rules   []Rule `json:"rules" yaml:"rules"`
+}
+
+func (rule *Rule) Validate() error {
+
+	if !slices.Contains(knownFaults, rule.Fault) {
+		return fmt.Errorf("rule %q: fault must be one of %s", rule.Name, strings.Join(knownFaults, ", "))
+	}
+
+	if rule.Probability < 0 || rule.Probability > 1 {
+		return fmt.Errorf("rule %q: probability must be between 0 and 1", rule.Name)
+	}
+
+	if rule.Nth < 0 || rule.LatencyMs < 0 {
+		return fmt.Errorf("rule %q: nth and latencyMs can't be negative", rule.Name)
+	}
+
+	if rule.Fault == FaultLatency && rule.LatencyMs == 0 {
+		return fmt.Errorf("rule %q: latency faults need latencyMs", rule.Name)
+	}
+
+	return nil
+}
+
+// Injector fails requests according to its rules. Decisions come from a
+// seeded generator, so the same sequence of calls fails the same way.
+type Injector struct {
+	mu     sync.Mutex
+	region string
+	config Config
+	rng    *rand.Rand
+	calls  []int
+}
+
+func NewInjector(config Config, region string) (*Injector, error) {
+
+	injector := Injector{region: region}
+	if err := injector.SetConfig(config); err != nil {
+		return nil, err
+	}
+
+	return &injector, nil
+}
+
+// SetConfig replaces the rules and restarts the generator and call counts.
+func (injector *Injector) SetConfig(config Config) error {
+
+	for i := range config.Rules {
+		if err := config.Rules[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	injector.mu.Lock()
+	defer injector.mu.Unlock()
+
+	config.Enabled = true
+	injector.config = config
+	injector.rng = rand.New(rand.NewPCG(config.Seed, config.Seed))
+	injector.calls = make([]int, len(config.Rules))
+
+	return nil
+}
+
+func (injector *Injector) Config() Config {
+
+	injector.mu.Lock()
+	defer injector.mu.Unlock()
+
+	config := injector.config
+	config.Rules = slices.Clone(config.Rules)
+
+	return config
+}
+
+// Middleware must run after SigV4 verification so rules can match the principal.
+func (injector *Injector) Middleware(next http.HandlerFunc) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, 10*(1<<20)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		rule := injector.pick(r, body)
+		if rule == nil {
+			next(w, r)
+			return
+		}
+
+		awslib.Logger(r.Context()).Warn("injecting fault", "rule", rule.Name, "fault", rule.Fault)
+
+		switch rule.Fault {
+		case FaultLatency:
+			select {
+			case <-time.After(time.Duration(rule.LatencyMs) * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+			next(w, r)
+		case FaultInternalError:
+			awslib.WriteErrorResponseJSON(w, internalServerError, r.URL, injector.region)
+		case FaultThrottling:
+			awslib.WriteErrorResponseJSON(w, throttlingException, r.URL, injector.region)
+		case FaultReset:
+			resetConnection(w)
+		case FaultTruncate:
+			truncateResponse(w, r, next)
+		}
+	}
+}
+
+// pick returns the first matching rule that fires for the request.
+func (injector *Injector) pick(r *http.Request, body []byte) *Rule {
+
+	info := awslib.GetRequestInfo(r.Context())
+	operation := info.Operation[strings.LastIndex(info.Operation, ".")+1:]
+
+	var request struct {
+		Name  string
+		Names []string
+		Path  string
+	}
+	json.Unmarshal(body, &request)
+	names := append(request.Names, request.Name, request.Path)
+
+	injector.mu.Lock()
+	defer injector.mu.Unlock()
+
+	for i := range injector.config.Rules {
+
+		rule := &injector.config.Rules[i]
+		if rule.Operation != "" && rule.Operation != operation {
+			continue
+		}
+
+		if rule.PathPrefix != "" && !slices.ContainsFunc(names, func(name string) bool {
+			return name != "" && strings.HasPrefix(name, rule.PathPrefix)
+		}) {
+			continue
+		}
+
+		if rule.Principal != "" && (info.Principal == nil ||
+			rule.Principal != info.Principal.Source && rule.Principal != info.Principal.AccessKeyID) {
+			continue
+		}
+
+		injector.calls[i]++
+		if rule.Nth > 0 && injector.calls[i]%rule.Nth != 0 {
+			continue
+		}
+
+		if rule.Probability > 0 && injector.rng.Float64() >= rule.Probability {
+			continue
+		}
+
+		picked := *rule
+		return &picked
+	}
+
+	return nil
+}
+
+// resetConnection closes the connection without a response; the client
+// sees a reset or an unexpected EOF.
+func resetConnection(w http.ResponseWriter) {
+
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		// unwinds the server's handler and drops the connection
+		panic(http.ErrAbortHandler)
+	}
+
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// truncateResponse sends the status, headers and full Content-Length of the
+// real response but only half of its body.
+func truncateResponse(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	buffered := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+	next(buffered, r)
+
+	for key, values := range buffered.header {
+		w.Header()[key] = values
+	}
+
+	body := buffered.body.Bytes()
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(buffered.status)
+	// the server closes the connection after a body shorter than its length
+	w.Write(body[:len(body)/2])
+}
+
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(statusCode int) {
+
+	b.status = statusCode
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+
+	return b.body.Write(p)
+}
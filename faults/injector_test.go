@@ -0,0 +1,225 @@
+package faults
+
+import (
+	"home-ssm/awslib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const okBody = `{"Parameter":{"Name":"/app/a","Value":"value"}}` + "\n"
+
+func newTestInjector(t *testing.T, rules ...Rule) *Injector {
+
+	t.Helper()
+
+	injector, err := NewInjector(Config{Seed: 42, Rules: rules}, "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return injector
+}
+
+// serve sends the request through the injector as the principal. The
+// handler behind it answers with okBody.
+func serve(injector *Injector, accessKey string, target string, body string) *httptest.ResponseRecorder {
+
+	handler := awslib.WithRequestId(func(w http.ResponseWriter, r *http.Request) {
+
+		info := awslib.GetRequestInfo(r.Context())
+		info.Principal = &aws.Credentials{AccessKeyID: accessKey, Source: "user-" + accessKey}
+		injector.Middleware(func(w http.ResponseWriter, r *http.Request) {
+			awslib.WriteSuccessResponseJSON(w, map[string]any{"Parameter": map[string]string{"Name": "/app/a", "Value": "value"}})
+		})(w, r)
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("X-Amz-Target", "AmazonSSM."+target)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	return w
+}
+
+func TestValidate(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		rule  Rule
+		valid bool
+	}{
+		{"internal error", Rule{Fault: FaultInternalError}, true},
+		{"latency", Rule{Fault: FaultLatency, LatencyMs: 100}, true},
+		{"latency without duration", Rule{Fault: FaultLatency}, false},
+		{"unknown fault", Rule{Fault: "crash"}, false},
+		{"probability above one", Rule{Fault: FaultThrottling, Probability: 1.5}, false},
+		{"negative probability", Rule{Fault: FaultThrottling, Probability: -0.1}, false},
+		{"negative nth", Rule{Fault: FaultThrottling, Nth: -1}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			if err := test.rule.Validate(); (err == nil) != test.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestMatching(t *testing.T) {
+
+	injector := newTestInjector(t,
+		Rule{Name: "get", Operation: "GetParameter", Fault: FaultInternalError},
+		Rule{Name: "path", PathPrefix: "/flaky/", Fault: FaultThrottling},
+		Rule{Name: "principal", Principal: "user-ci", Operation: "PutParameter", Fault: FaultThrottling},
+		Rule{Name: "key", Principal: "AKIAKEY", Operation: "DeleteParameter", Fault: FaultThrottling},
+	)
+
+	tests := []struct {
+		name      string
+		accessKey string
+		target    string
+		body      string
+		code      string
+	}{
+		{"operation", "admin", "GetParameter", `{"Name":"/app/a"}`, "InternalServerError"},
+		{"other operation", "admin", "GetParametersByPath", `{"Path":"/app"}`, ""},
+		{"name prefix", "admin", "DeleteParameter", `{"Name":"/flaky/a"}`, "ThrottlingException"},
+		{"names prefix", "admin", "GetParameters", `{"Names":["/app/a","/flaky/b"]}`, "ThrottlingException"},
+		{"path prefix", "admin", "GetParametersByPath", `{"Path":"/flaky/"}`, "ThrottlingException"},
+		{"other path", "admin", "DeleteParameter", `{"Name":"/app/a"}`, ""},
+		{"username", "ci", "PutParameter", `{"Name":"/app/a"}`, "ThrottlingException"},
+		{"other username", "admin", "PutParameter", `{"Name":"/app/a"}`, ""},
+		{"access key", "AKIAKEY", "DeleteParameter", `{"Name":"/app/a"}`, "ThrottlingException"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			w := serve(injector, test.accessKey, test.target, test.body)
+			if test.code == "" && (w.Code != http.StatusOK || w.Body.String() != okBody) {
+				t.Errorf("got %d %s, want the response of the handler", w.Code, w.Body.String())
+			}
+
+			if test.code != "" && !strings.Contains(w.Body.String(), test.code) {
+				t.Errorf("got %d %s, want %s", w.Code, w.Body.String(), test.code)
+			}
+		})
+	}
+}
+
+func TestProbability(t *testing.T) {
+
+	// failures returns which of 200 calls failed
+	failures := func(seed uint64, probability float64) []bool {
+
+		injector, err := NewInjector(Config{Seed: seed, Rules: []Rule{
+			{Name: "flaky", Fault: FaultInternalError, Probability: probability}}}, "us-east-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var failed []bool
+		for range 200 {
+			failed = append(failed, serve(injector, "admin", "GetParameter", `{}`).Code != http.StatusOK)
+		}
+
+		return failed
+	}
+
+	count := func(failed []bool) int {
+		n := 0
+		for _, f := range failed {
+			if f {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := count(failures(42, 0.25)); n < 30 || n > 70 {
+		t.Errorf("%d of 200 calls failed with probability 0.25", n)
+	}
+
+	if n := count(failures(42, 1)); n != 200 {
+		t.Errorf("%d of 200 calls failed with probability 1", n)
+	}
+
+	// the same seed fails the same calls, another seed others
+	first, again, other := failures(42, 0.5), failures(42, 0.5), failures(7, 0.5)
+	same, differs := true, false
+	for i := range first {
+		same = same && first[i] == again[i]
+		differs = differs || first[i] != other[i]
+	}
+
+	if !same || !differs {
+		t.Errorf("seeded failures repeat %v, differ between seeds %v", same, differs)
+	}
+}
+
+func TestNth(t *testing.T) {
+
+	injector := newTestInjector(t, Rule{Name: "third", Operation: "GetParameter", Fault: FaultInternalError, Nth: 3})
+
+	var failed []int
+	for call := 1; call <= 9; call++ {
+
+		// calls of other operations don't count
+		serve(injector, "admin", "PutParameter", `{}`)
+		if serve(injector, "admin", "GetParameter", `{}`).Code != http.StatusOK {
+			failed = append(failed, call)
+		}
+	}
+
+	if len(failed) != 3 || failed[0] != 3 || failed[1] != 6 || failed[2] != 9 {
+		t.Errorf("calls %v failed, want 3, 6 and 9", failed)
+	}
+}
+
+func TestLatency(t *testing.T) {
+
+	injector := newTestInjector(t, Rule{Name: "slow", Fault: FaultLatency, LatencyMs: 50})
+
+	start := time.Now()
+	w := serve(injector, "admin", "GetParameter", `{}`)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("answered after %v, want at least 50ms", elapsed)
+	}
+
+	if w.Code != http.StatusOK || w.Body.String() != okBody {
+		t.Errorf("got %d %s, want the response of the handler", w.Code, w.Body.String())
+	}
+}
+
+func TestTruncate(t *testing.T) {
+
+	injector := newTestInjector(t, Rule{Name: "truncate", Fault: FaultTruncate})
+
+	w := serve(injector, "admin", "GetParameter", `{}`)
+	if w.Header().Get("Content-Length") != "48" || w.Body.String() != okBody[:24] {
+		t.Errorf("Content-Length %s and body %q, want 48 and the first half", w.Header().Get("Content-Length"), w.Body.String())
+	}
+}
+
+func TestReset(t *testing.T) {
+
+	injector := newTestInjector(t, Rule{Name: "reset", Fault: FaultReset})
+	server := httptest.NewServer(awslib.WithRequestId(injector.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, okBody)
+	})))
+	t.Cleanup(server.Close)
+
+	response, err := http.Post(server.URL, "application/x-amz-json-1.1", strings.NewReader(`{}`))
+	if err == nil {
+		response.Body.Close()
+		t.Fatalf("got %d, want the connection reset", response.StatusCode)
+	}
+}
@@ -0,0 +1,82 @@
+package health
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime"
+)
+
+// BuildInfo is injected at build time via -ldflags.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+type Check func() error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type Probes struct {
+	build  BuildInfo
+	checks []namedCheck
+}
+
+func NewProbes(build BuildInfo) *Probes {
+
+	build.GoVersion = runtime.Version()
+
+	return &Probes{build: build}
+}
+
+// AddReadinessCheck registers a check run on every /readyz request.
+func (p *Probes) AddReadinessCheck(name string, check Check) {
+
+	p.checks = append(p.checks, namedCheck{name: name, check: check})
+}
+
+// HandleLive reports the process is up and serving requests.
+func (p *Probes) HandleLive(w http.ResponseWriter, r *http.Request) {
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// HandleReady runs every readiness check and reports the result of each.
+func (p *Probes) HandleReady(w http.ResponseWriter, r *http.Request) {
+
+	status := http.StatusOK
+	results := make(map[string]string, len(p.checks))
+	for _, c := range p.checks {
+
+		if err := c.check(); err != nil {
+
+			slog.Warn("Readiness check failed", "check", c.name, "error", err)
+			results[c.name] = err.Error()
+			status = http.StatusServiceUnavailable
+
+		} else {
+
+			results[c.name] = "ok"
+		}
+	}
+
+	writeJSON(w, status, map[string]any{"status": http.StatusText(status), "checks": results})
+}
+
+func (p *Probes) HandleVersion(w http.ResponseWriter, r *http.Request) {
+
+	writeJSON(w, http.StatusOK, p.build)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
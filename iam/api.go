@@ -0,0 +1,718 @@
+package iam
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"home-ssm/ssm"
+	"home-ssm/sts"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const maxAccessKeys = 2
+
+var userNamePattern = regexp.MustCompile(`^[\w+=,.@-]{1,64}$`)
+
+type Config struct {
+	// KeyId encrypts the stored secret access keys, the default key if empty.
+	KeyId string `yaml:"keyId"`
+}
+
+// PolicyKey is the key under which a config policy is registered with the
+// authorizer, so it can be used as the permissions boundary of a user.
+func PolicyKey(name string) string {
+
+	return "policy/" + name
+}
+
+// Api serves the IAM Query protocol for runtime-managed users and access
+// keys. Users from the config file can be listed but not changed. A user
+// is limited to its permissions boundary, users without one are denied
+// everything.
+type Api struct {
+	region     string
+	accountId  string
+	config     Config
+	store      *Store
+	dataStore  *ssm.DataStore
+	keyring    *awslib.Keyring
+	authorizer *policy.Authorizer
+	// sessions are revoked with the access keys that requested them.
+	sessions *sts.SessionStore
+	// configKeys are the access keys of the config users by username,
+	// replaced when the config is reloaded.
+	mu         sync.RWMutex
+	configKeys map[string][]string
+}
+
+func NewApi(region string, accountId string, config Config, store *Store, dataStore *ssm.DataStore,
+	keyring *awslib.Keyring, authorizer *policy.Authorizer, sessions *sts.SessionStore, configCredentials []aws.Credentials) *Api {
+
+	api := Api{
+		region:     region,
+		accountId:  accountId,
+		config:     config,
+		store:      store,
+		dataStore:  dataStore,
+		keyring:    keyring,
+		authorizer: authorizer,
+		sessions:   sessions,
+		configKeys: usernameKeys(configCredentials),
+	}
+
+	return &api
+}
+
+// ValidateConfig checks that a reloaded config leaves the stored users
+// intact: config users can't take the name of a stored user and the
+// policies used as permissions boundaries must remain.
+func (api *Api) ValidateConfig(ctx context.Context, configCredentials []aws.Credentials, policies []string) error {
+
+	users, err := api.store.listUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+
+		if slices.ContainsFunc(configCredentials, func(creds aws.Credentials) bool { return creds.Source == user.UserName }) {
+			return fmt.Errorf("username %s is taken by an IAM user", user.UserName)
+		}
+
+		name := user.PermissionsBoundary[strings.LastIndex(user.PermissionsBoundary, "/")+1:]
+		if user.PermissionsBoundary != "" && !slices.Contains(policies, name) {
+			return fmt.Errorf("policy %s is the permissions boundary of IAM user %s", name, user.UserName)
+		}
+	}
+
+	return nil
+}
+
+// KeyReferences returns the key of every stored access key, so a config
+// reload can't remove a key still in use.
+func (api *Api) KeyReferences(ctx context.Context) ([]ssm.KeyReference, error) {
+
+	keys, err := api.store.listAccessKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var references []ssm.KeyReference
+	for _, key := range keys {
+		references = append(references, ssm.KeyReference{KeyId: key.KeyId, User: "IAM access key " + key.AccessKeyId})
+	}
+
+	return references, nil
+}
+
+// SetConfigCredentials replaces the config users after a reload and
+// reapplies the permissions boundaries, whose policies may have changed.
+func (api *Api) SetConfigCredentials(ctx context.Context, configCredentials []aws.Credentials) error {
+
+	api.mu.Lock()
+	api.configKeys = usernameKeys(configCredentials)
+	api.mu.Unlock()
+
+	keys, err := api.store.listAccessKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+
+		if key.Status != StatusActive {
+			continue
+		}
+
+		user, err := api.store.getUser(ctx, key.UserName)
+		if err != nil {
+			return err
+		}
+
+		documents, err := api.userPolicies(user)
+		if err != nil {
+			return err
+		}
+
+		api.authorizer.SetPolicies(key.AccessKeyId, documents)
+	}
+
+	return nil
+}
+
+// Load adds the active stored keys to the keyring, restores when each key
+// was last used and starts recording it.
+func (api *Api) Load(ctx context.Context) error {
+
+	if api.config.KeyId != "" {
+		if _, err := api.dataStore.FindKey(api.config.KeyId); err != nil {
+			return fmt.Errorf("iam keyId %s: %w", api.config.KeyId, err)
+		}
+	}
+
+	keys, err := api.store.listAccessKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+
+		if key.Status != StatusActive {
+			continue
+		}
+
+		user, err := api.store.getUser(ctx, key.UserName)
+		if err != nil {
+			return err
+		}
+
+		if err := api.activate(ctx, user, &key); err != nil {
+			return err
+		}
+	}
+
+	usages, err := api.store.listLastUsed()
+	if err != nil {
+		return err
+	}
+
+	for accessKey, usage := range usages {
+		api.keyring.SetLastUsed(accessKey, usage)
+	}
+
+	api.keyring.OnUse(func(accessKey string, usage awslib.KeyUsage) {
+		if err := api.store.setLastUsed(accessKey, usage); err != nil {
+			slog.Warn("Error recording access key use", "access_key", accessKey, "error", err)
+		}
+	})
+
+	slog.Info("IAM access keys loaded", "keys", len(keys))
+
+	return nil
+}
+
+func (api *Api) Handle(w http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	params := r.URL.Query()
+	if form, err := url.ParseQuery(string(body)); err == nil {
+		for key, values := range form {
+			params[key] = values
+		}
+	}
+
+	info := awslib.GetRequestInfo(r.Context())
+	action := params.Get("Action")
+	info.Operation = action
+
+	if info.Principal == nil {
+		api.writeError(w, r, ErrMissingAuthentication)
+		return
+	}
+
+	ctx := r.Context()
+	var response any
+	switch action {
+	case "CreateUser":
+		response, err = api.createUser(ctx, info, params)
+	case "CreateAccessKey":
+		response, err = api.createAccessKey(ctx, info, params)
+	case "UpdateAccessKey":
+		response, err = api.updateAccessKey(ctx, info, params)
+	case "DeleteAccessKey":
+		response, err = api.deleteAccessKey(ctx, info, params)
+	case "ListAccessKeys":
+		response, err = api.listAccessKeys(ctx, info, params)
+	case "GetAccessKeyLastUsed":
+		response, err = api.getAccessKeyLastUsed(ctx, info, params)
+	default:
+		err = ErrInvalidAction
+	}
+
+	if err != nil {
+		api.writeError(w, r, err)
+		return
+	}
+
+	awslib.WriteSuccessResponseXML(w, response)
+}
+
+func (api *Api) writeError(w http.ResponseWriter, r *http.Request, err error) {
+
+	awslib.Logger(r.Context()).Warn("request failed", "error", err)
+	awslib.WriteErrorResponseXML(w, translateToApiError(err), xmlns)
+}
+
+func (api *Api) createUser(ctx context.Context, info *awslib.RequestInfo, params url.Values) (*CreateUserResponse, error) {
+
+	name := params.Get("UserName")
+	if name == "" {
+		return nil, ErrMissingUserName
+	}
+
+	if !userNamePattern.MatchString(name) {
+		return nil, ErrInvalidUserName
+	}
+
+	if path := params.Get("Path"); path != "" && path != "/" {
+		return nil, ErrInvalidPath
+	}
+
+	if err := api.authorize(info, "CreateUser", api.userArn(name)); err != nil {
+		return nil, err
+	}
+
+	if _, ok := api.configUser(name); ok {
+		return nil, ErrUserExists
+	}
+
+	boundary := params.Get("PermissionsBoundary")
+	if boundary != "" {
+		if _, err := api.boundaryPolicies(boundary); err != nil {
+			return nil, err
+		}
+	}
+
+	userId, err := randomString(10, base32.StdEncoding.EncodeToString)
+	if err != nil {
+		return nil, err
+	}
+
+	user := User{
+		UserName:            name,
+		UserId:              "AIDA" + userId,
+		CreateDate:          time.Now(),
+		PermissionsBoundary: boundary,
+	}
+
+	if err := api.store.createUser(ctx, &user); err != nil {
+		return nil, err
+	}
+
+	response := CreateUserResponse{Xmlns: xmlns}
+	response.Result.User = UserResult{
+		Path:       "/",
+		UserName:   user.UserName,
+		UserId:     user.UserId,
+		Arn:        api.userArn(user.UserName),
+		CreateDate: formatDate(user.CreateDate),
+	}
+	if boundary != "" {
+		response.Result.User.PermissionsBoundary = &PermissionsBoundary{
+			PermissionsBoundaryType: "Policy",
+			PermissionsBoundaryArn:  boundary,
+		}
+	}
+	response.ResponseMetadata.RequestId = info.RequestId
+
+	return &response, nil
+}
+
+func (api *Api) createAccessKey(ctx context.Context, info *awslib.RequestInfo, params url.Values) (*CreateAccessKeyResponse, error) {
+
+	name, err := userName(info, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := api.authorize(info, "CreateAccessKey", api.userArn(name)); err != nil {
+		return nil, err
+	}
+
+	if _, ok := api.configUser(name); ok {
+		return nil, ErrConfigUser
+	}
+
+	user, err := api.store.getUser(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	accessKey, err := randomString(10, base32.StdEncoding.EncodeToString)
+	if err != nil {
+		return nil, err
+	}
+
+	secretKey, err := randomString(30, base64.StdEncoding.EncodeToString)
+	if err != nil {
+		return nil, err
+	}
+
+	kmsKey, err := api.dataStore.FindKey(api.keyId())
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := api.dataStore.Encrypt(ctx, secretKey, kmsKey.KeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	key := AccessKey{
+		AccessKeyId:     "AKIA" + accessKey,
+		UserName:        name,
+		SecretAccessKey: encrypted,
+		KeyId:           kmsKey.KeyId,
+		Status:          StatusActive,
+		CreateDate:      time.Now(),
+	}
+
+	if err := api.store.createAccessKey(ctx, &key); err != nil {
+		return nil, err
+	}
+
+	if err := api.activate(ctx, user, &key); err != nil {
+		return nil, err
+	}
+
+	response := CreateAccessKeyResponse{Xmlns: xmlns}
+	response.Result.AccessKey = AccessKeyResult{
+		UserName:        key.UserName,
+		AccessKeyId:     key.AccessKeyId,
+		Status:          key.Status,
+		SecretAccessKey: secretKey,
+		CreateDate:      formatDate(key.CreateDate),
+	}
+	response.ResponseMetadata.RequestId = info.RequestId
+
+	return &response, nil
+}
+
+func (api *Api) updateAccessKey(ctx context.Context, info *awslib.RequestInfo, params url.Values) (*UpdateAccessKeyResponse, error) {
+
+	name, accessKeyId, err := api.keyParams(info, params, "UpdateAccessKey")
+	if err != nil {
+		return nil, err
+	}
+
+	status := params.Get("Status")
+	if status != StatusActive && status != StatusInactive {
+		return nil, ErrInvalidStatus
+	}
+
+	key, err := api.store.updateAccessKey(ctx, name, accessKeyId, func(key *AccessKey) {
+		key.Status = status
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if status == StatusActive {
+
+		user, err := api.store.getUser(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := api.activate(ctx, user, key); err != nil {
+			return nil, err
+		}
+
+	} else {
+		api.deactivate(accessKeyId)
+	}
+
+	response := UpdateAccessKeyResponse{Xmlns: xmlns}
+	response.ResponseMetadata.RequestId = info.RequestId
+
+	return &response, nil
+}
+
+func (api *Api) deleteAccessKey(ctx context.Context, info *awslib.RequestInfo, params url.Values) (*DeleteAccessKeyResponse, error) {
+
+	name, accessKeyId, err := api.keyParams(info, params, "DeleteAccessKey")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := api.store.deleteAccessKey(ctx, name, accessKeyId); err != nil {
+		return nil, err
+	}
+
+	api.deactivate(accessKeyId)
+
+	response := DeleteAccessKeyResponse{Xmlns: xmlns}
+	response.ResponseMetadata.RequestId = info.RequestId
+
+	return &response, nil
+}
+
+func (api *Api) listAccessKeys(ctx context.Context, info *awslib.RequestInfo, params url.Values) (*ListAccessKeysResponse, error) {
+
+	name, err := userName(info, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := api.authorize(info, "ListAccessKeys", api.userArn(name)); err != nil {
+		return nil, err
+	}
+
+	response := ListAccessKeysResponse{Xmlns: xmlns}
+	response.ResponseMetadata.RequestId = info.RequestId
+
+	if configKeys, ok := api.configUser(name); ok {
+
+		for _, accessKey := range configKeys {
+			response.Result.AccessKeyMetadata = append(response.Result.AccessKeyMetadata, AccessKeyMetadata{
+				UserName:    name,
+				AccessKeyId: accessKey,
+				Status:      StatusActive,
+			})
+		}
+
+		return &response, nil
+	}
+
+	if _, err := api.store.getUser(ctx, name); err != nil {
+		return nil, err
+	}
+
+	keys, err := api.store.listAccessKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if key.UserName == name {
+			response.Result.AccessKeyMetadata = append(response.Result.AccessKeyMetadata, AccessKeyMetadata{
+				UserName:    name,
+				AccessKeyId: key.AccessKeyId,
+				Status:      key.Status,
+				CreateDate:  formatDate(key.CreateDate),
+			})
+		}
+	}
+
+	return &response, nil
+}
+
+func (api *Api) getAccessKeyLastUsed(ctx context.Context, info *awslib.RequestInfo, params url.Values) (*GetAccessKeyLastUsedResponse, error) {
+
+	accessKeyId := params.Get("AccessKeyId")
+	if accessKeyId == "" {
+		return nil, ErrMissingAccessKeyId
+	}
+
+	name, err := api.keyOwner(ctx, accessKeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := api.authorize(info, "GetAccessKeyLastUsed", api.userArn(name)); err != nil {
+		return nil, err
+	}
+
+	response := GetAccessKeyLastUsedResponse{Xmlns: xmlns}
+	response.Result.UserName = name
+	response.Result.AccessKeyLastUsed = AccessKeyLastUsed{ServiceName: "N/A", Region: "N/A"}
+	if usage, ok := api.keyring.LastUsed(accessKeyId); ok {
+		response.Result.AccessKeyLastUsed = AccessKeyLastUsed{
+			LastUsedDate: formatDate(usage.Date),
+			ServiceName:  usage.ServiceName,
+			Region:       usage.Region,
+		}
+	}
+	response.ResponseMetadata.RequestId = info.RequestId
+
+	return &response, nil
+}
+
+// keyParams returns the user and access key of a request changing a key,
+// after authorizing the action. Keys of config users can't be changed.
+func (api *Api) keyParams(info *awslib.RequestInfo, params url.Values, action string) (string, string, error) {
+
+	name, err := userName(info, params)
+	if err != nil {
+		return "", "", err
+	}
+
+	accessKeyId := params.Get("AccessKeyId")
+	if accessKeyId == "" {
+		return "", "", ErrMissingAccessKeyId
+	}
+
+	if err := api.authorize(info, action, api.userArn(name)); err != nil {
+		return "", "", err
+	}
+
+	if configKeys, _ := api.configUser(name); slices.Contains(configKeys, accessKeyId) {
+		return "", "", ErrConfigUser
+	}
+
+	return name, accessKeyId, nil
+}
+
+func (api *Api) keyOwner(ctx context.Context, accessKeyId string) (string, error) {
+
+	api.mu.RLock()
+	for name, configKeys := range api.configKeys {
+		if slices.Contains(configKeys, accessKeyId) {
+			api.mu.RUnlock()
+			return name, nil
+		}
+	}
+	api.mu.RUnlock()
+
+	key, err := api.store.getAccessKey(ctx, accessKeyId)
+	if err != nil {
+		return "", err
+	}
+
+	return key.UserName, nil
+}
+
+// activate adds the key to the keyring, limited by the permissions
+// boundary of its user.
+func (api *Api) activate(ctx context.Context, user *User, key *AccessKey) error {
+
+	secretKey, err := api.dataStore.Decrypt(ctx, key.SecretAccessKey, key.KeyId)
+	if err != nil {
+		return err
+	}
+
+	documents, err := api.userPolicies(user)
+	if err != nil {
+		return err
+	}
+
+	api.authorizer.SetPolicies(key.AccessKeyId, documents)
+
+	api.keyring.Add(aws.Credentials{
+		AccessKeyID:     key.AccessKeyId,
+		SecretAccessKey: secretKey,
+		Source:          key.UserName,
+		AccountID:       api.accountId,
+	})
+
+	return nil
+}
+
+// deactivate removes the key and ends the sessions it requested.
+func (api *Api) deactivate(accessKeyId string) {
+
+	api.keyring.Remove(accessKeyId)
+	api.authorizer.RemovePolicies(accessKeyId)
+	api.sessions.Revoke([]string{accessKeyId})
+}
+
+// userPolicies returns the permissions boundary of the user, or a policy
+// without statements, which denies everything, when it has none.
+func (api *Api) userPolicies(user *User) ([]*policy.Document, error) {
+
+	if user.PermissionsBoundary == "" {
+		return []*policy.Document{{Name: user.UserName}}, nil
+	}
+
+	return api.boundaryPolicies(user.PermissionsBoundary)
+}
+
+// boundaryPolicies resolves a policy ARN, arn:aws:iam::<account>:policy/<name>,
+// to the config policy with that name.
+func (api *Api) boundaryPolicies(policyArn string) ([]*policy.Document, error) {
+
+	name, ok := strings.CutPrefix(policyArn, "arn:aws:iam::"+api.accountId+":policy/")
+	if !ok || name == "" {
+		return nil, ErrInvalidBoundary
+	}
+
+	documents, ok := api.authorizer.Policies(PolicyKey(name))
+	if !ok {
+		return nil, ErrNoSuchPolicy
+	}
+
+	return documents, nil
+}
+
+func (api *Api) authorize(info *awslib.RequestInfo, action string, resource string) error {
+
+	if api.authorizer == nil {
+		return nil
+	}
+
+	principalArn := awslib.PrincipalArn(api.accountId, info.Principal)
+	request := policy.Request{
+		Action:   "iam:" + action,
+		Resource: resource,
+		Context:  policy.GlobalContext(info.Principal, principalArn, info.SourceIp),
+	}
+
+	return api.authorizer.Authorize(info.Principal, principalArn, &request)
+}
+
+func (api *Api) configUser(name string) ([]string, bool) {
+
+	api.mu.RLock()
+	defer api.mu.RUnlock()
+
+	configKeys, ok := api.configKeys[name]
+	return configKeys, ok
+}
+
+func (api *Api) keyId() string {
+
+	if api.config.KeyId != "" {
+		return api.config.KeyId
+	}
+
+	return api.dataStore.DefaultKeyId()
+}
+
+func (api *Api) userArn(name string) string {
+
+	return fmt.Sprintf("arn:aws:iam::%s:user/%s", api.accountId, name)
+}
+
+// userName is the UserName parameter, defaulting to the user signing the
+// request. Session credentials must name the user.
+func userName(info *awslib.RequestInfo, params url.Values) (string, error) {
+
+	if name := params.Get("UserName"); name != "" {
+		return name, nil
+	}
+
+	if info.Session != nil {
+		return "", ErrNonUserCredentials
+	}
+
+	return info.Principal.Source, nil
+}
+
+func usernameKeys(configCredentials []aws.Credentials) map[string][]string {
+
+	configKeys := make(map[string][]string)
+	for _, creds := range configCredentials {
+		configKeys[creds.Source] = append(configKeys[creds.Source], creds.AccessKeyID)
+	}
+
+	return configKeys
+}
+
+func randomString(size int, encode func([]byte) string) (string, error) {
+
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return encode(b), nil
+}
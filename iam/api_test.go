@@ -0,0 +1,177 @@
+package iam
+
+import (
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"home-ssm/ssm"
+	"home-ssm/sts"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/dgraph-io/badger/v4"
+)
+
+const testAccountId = "000000000000"
+
+var accessKeyPattern = regexp.MustCompile(`<AccessKeyId>(\w+)</AccessKeyId>`)
+
+// admin is a config user without policies, so it may manage every user.
+var admin = aws.Credentials{AccessKeyID: "AKIAADMIN", SecretAccessKey: "secret", Source: "admin", AccountID: testAccountId}
+
+type testEnv struct {
+	api        *Api
+	sts        *sts.Api
+	sessions   *sts.SessionStore
+	keyring    *awslib.Keyring
+	authorizer *policy.Authorizer
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+
+	t.Helper()
+
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	document, err := policy.Parse("read", `{"Statement":[{"Effect":"Allow","Action":"ssm:GetParameter","Resource":"*"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorizer := policy.NewAuthorizer()
+	authorizer.SetPolicies(PolicyKey("read"), []*policy.Document{document})
+
+	keys := []ssm.KmsKey{{KeyId: "default", Alias: "default", Key: "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="}}
+	keyring := awslib.NewKeyring([]aws.Credentials{admin})
+	sessions := sts.NewSessionStore(authorizer)
+
+	return &testEnv{
+		api: NewApi("us-east-1", testAccountId, Config{}, NewStore(db), ssm.NewDataStore(db, keys),
+			keyring, authorizer, sessions, []aws.Credentials{admin}),
+		sts:        sts.NewApi("us-east-1", testAccountId, nil, sessions, authorizer),
+		sessions:   sessions,
+		keyring:    keyring,
+		authorizer: authorizer,
+	}
+}
+
+// call sends a Query protocol request to the handler as the principal.
+func call(t *testing.T, handler http.HandlerFunc, principal *aws.Credentials, params url.Values) string {
+
+	t.Helper()
+
+	wrapped := awslib.WithRequestId(func(w http.ResponseWriter, r *http.Request) {
+		awslib.GetRequestInfo(r.Context()).Principal = principal
+		handler(w, r)
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(params.Encode()))
+	w := httptest.NewRecorder()
+	wrapped(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s got %d %s", params.Get("Action"), w.Code, w.Body.String())
+	}
+
+	return w.Body.String()
+}
+
+// createKey creates a user and returns the credentials of a new access key.
+func (env *testEnv) createKey(t *testing.T, userName string, boundary string) *aws.Credentials {
+
+	t.Helper()
+
+	params := url.Values{"Action": {"CreateUser"}, "UserName": {userName}}
+	if boundary != "" {
+		params.Set("PermissionsBoundary", "arn:aws:iam::"+testAccountId+":policy/"+boundary)
+	}
+	call(t, env.api.Handle, &admin, params)
+
+	body := call(t, env.api.Handle, &admin, url.Values{"Action": {"CreateAccessKey"}, "UserName": {userName}})
+	match := accessKeyPattern.FindStringSubmatch(body)
+	if match == nil {
+		t.Fatalf("no access key in %s", body)
+	}
+
+	creds, ok := env.keyring.Lookup(match[1])
+	if !ok {
+		t.Fatalf("access key %s isn't in the keyring", match[1])
+	}
+
+	return creds
+}
+
+func TestUserPermissionsBoundary(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		boundary string
+		action   string
+		allowed  bool
+	}{
+		{"boundary allows", "read", "ssm:GetParameter", true},
+		{"boundary denies", "read", "ssm:PutParameter", false},
+		{"no boundary", "", "ssm:GetParameter", false},
+		{"no boundary iam", "", "iam:CreateUser", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			env := newTestEnv(t)
+			creds := env.createKey(t, "app", test.boundary)
+
+			request := policy.Request{Action: test.action, Resource: "arn:aws:ssm:us-east-1:" + testAccountId + ":parameter/a"}
+			if err := env.authorizer.Authorize(creds, "", &request); (err == nil) != test.allowed {
+				t.Errorf("Authorize() = %v, want allowed %v", err, test.allowed)
+			}
+		})
+	}
+}
+
+func TestDeactivateRevokesSessions(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		params url.Values
+	}{
+		{"delete", url.Values{"Action": {"DeleteAccessKey"}}},
+		{"inactive", url.Values{"Action": {"UpdateAccessKey"}, "Status": {StatusInactive}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			env := newTestEnv(t)
+			creds := env.createKey(t, "app", "read")
+			other := env.createKey(t, "other", "read")
+
+			session := accessKeyPattern.FindStringSubmatch(call(t, env.sts.Handle, creds, url.Values{"Action": {"GetSessionToken"}}))
+			kept := accessKeyPattern.FindStringSubmatch(call(t, env.sts.Handle, other, url.Values{"Action": {"GetSessionToken"}}))
+			if session == nil || kept == nil {
+				t.Fatal("no session credentials")
+			}
+
+			params := test.params
+			params.Set("UserName", "app")
+			params.Set("AccessKeyId", creds.AccessKeyID)
+			call(t, env.api.Handle, &admin, params)
+
+			if _, ok := env.sessions.Session(session[1]); ok {
+				t.Error("session of the deactivated key still valid")
+			}
+
+			if _, ok := env.sessions.Session(kept[1]); !ok {
+				t.Error("session of another key revoked")
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package iam
+
+import (
+	"errors"
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"net/http"
+)
+
+var (
+	ErrInvalidAction         = errors.New("Could not find operation for version 2010-05-08.")
+	ErrMissingUserName       = errors.New("The request must contain the parameter UserName.")
+	ErrMissingAccessKeyId    = errors.New("The request must contain the parameter AccessKeyId.")
+	ErrInvalidUserName       = errors.New("UserName must satisfy regular expression pattern: [\\w+=,.@-]{1,64}")
+	ErrInvalidPath           = errors.New("Only the / path is supported.")
+	ErrInvalidStatus         = errors.New("Status must be one of Active, Inactive.")
+	ErrInvalidBoundary       = errors.New("PermissionsBoundary must be the ARN of a policy in this account.")
+	ErrNonUserCredentials    = errors.New("Must specify userName when calling with non-User credentials")
+	ErrUserExists            = errors.New("The user already exists.")
+	ErrNoSuchUser            = errors.New("The user cannot be found.")
+	ErrNoSuchAccessKey       = errors.New("The Access Key cannot be found.")
+	ErrNoSuchPolicy          = errors.New("The permissions boundary policy cannot be found.")
+	ErrKeyLimitExceeded      = errors.New("Cannot exceed quota for AccessKeysPerUser: 2")
+	ErrConfigUser            = errors.New("Users and access keys from the config file cannot be modified.")
+	ErrInternalError         = errors.New("We encountered an internal error, please try again.")
+	ErrMissingAuthentication = errors.New("Request is missing Authentication Token")
+)
+
+type errorCodeMap map[error]awslib.APIError
+
+var IamErrorCodes = errorCodeMap{
+	ErrInvalidAction: {
+		Code:           "InvalidAction",
+		Description:    ErrInvalidAction.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrMissingUserName: {
+		Code:           "MissingParameter",
+		Description:    ErrMissingUserName.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrMissingAccessKeyId: {
+		Code:           "MissingParameter",
+		Description:    ErrMissingAccessKeyId.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidUserName: {
+		Code:           "ValidationError",
+		Description:    ErrInvalidUserName.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidPath: {
+		Code:           "ValidationError",
+		Description:    ErrInvalidPath.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidStatus: {
+		Code:           "ValidationError",
+		Description:    ErrInvalidStatus.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidBoundary: {
+		Code:           "InvalidInput",
+		Description:    ErrInvalidBoundary.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrNonUserCredentials: {
+		Code:           "ValidationError",
+		Description:    ErrNonUserCredentials.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrUserExists: {
+		Code:           "EntityAlreadyExists",
+		Description:    ErrUserExists.Error(),
+		HTTPStatusCode: http.StatusConflict,
+	},
+	ErrNoSuchUser: {
+		Code:           "NoSuchEntity",
+		Description:    ErrNoSuchUser.Error(),
+		HTTPStatusCode: http.StatusNotFound,
+	},
+	ErrNoSuchAccessKey: {
+		Code:           "NoSuchEntity",
+		Description:    ErrNoSuchAccessKey.Error(),
+		HTTPStatusCode: http.StatusNotFound,
+	},
+	ErrNoSuchPolicy: {
+		Code:           "NoSuchEntity",
+		Description:    ErrNoSuchPolicy.Error(),
+		HTTPStatusCode: http.StatusNotFound,
+	},
+	ErrKeyLimitExceeded: {
+		Code:           "LimitExceeded",
+		Description:    ErrKeyLimitExceeded.Error(),
+		HTTPStatusCode: http.StatusConflict,
+	},
+	ErrConfigUser: {
+		Code:           "UnmodifiableEntity",
+		Description:    ErrConfigUser.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInternalError: {
+		Code:           "InternalFailure",
+		Description:    ErrInternalError.Error(),
+		HTTPStatusCode: http.StatusInternalServerError,
+	},
+	ErrMissingAuthentication: {
+		Code:           "MissingAuthenticationToken",
+		Description:    ErrMissingAuthentication.Error(),
+		HTTPStatusCode: http.StatusForbidden,
+	},
+}
+
+func translateToApiError(err error) awslib.APIError {
+
+	value, ok := IamErrorCodes[err]
+	if ok {
+
+		return value
+	}
+
+	var accessDenied *policy.AccessDeniedError
+	if errors.As(err, &accessDenied) {
+
+		return awslib.APIError{
+			Code:           "AccessDenied",
+			Description:    accessDenied.Error(),
+			HTTPStatusCode: http.StatusForbidden,
+		}
+	}
+
+	return IamErrorCodes[ErrInternalError]
+}
@@ -0,0 +1,340 @@
+package iam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"home-ssm/awslib"
+	"home-ssm/tracing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	userPrefix     = "iam/user/"
+	keyPrefix      = "iam/key/"
+	lastUsedPrefix = "iam/lastused/"
+
+	StatusActive   = "Active"
+	StatusInactive = "Inactive"
+)
+
+type User struct {
+	UserName   string
+	UserId     string
+	Path       string
+	CreateDate time.Time
+	// PermissionsBoundary is the ARN of the config policy limiting the user.
+	PermissionsBoundary string `json:",omitempty"`
+}
+
+// AccessKey is a runtime-managed key. The secret is stored encrypted under
+// KeyId.
+type AccessKey struct {
+	AccessKeyId     string
+	UserName        string
+	SecretAccessKey string
+	KeyId           string
+	Status          string
+	CreateDate      time.Time
+}
+
+// Store keeps users and access keys in their own badger keyspace, with
+// the last use of each key kept apart so recording it doesn't rewrite keys.
+type Store struct {
+	db *badger.DB
+}
+
+func NewStore(db *badger.DB) *Store {
+
+	return &Store{db: db}
+}
+
+func (store *Store) getUser(ctx context.Context, userName string) (*User, error) {
+
+	_, span := tracing.Start(ctx, "Iam.getUser")
+	defer span.End()
+
+	var user User
+	err := store.db.View(func(txn *badger.Txn) error {
+		return readJSON(txn, userPrefix+userName, &user, ErrNoSuchUser)
+	})
+
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (store *Store) listUsers(ctx context.Context) ([]User, error) {
+
+	_, span := tracing.Start(ctx, "Iam.listUsers")
+	defer span.End()
+
+	var users []User
+	err := store.db.View(func(txn *badger.Txn) error {
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte(userPrefix)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+
+			var user User
+			if err := it.Item().Value(func(val []byte) error { return json.Unmarshal(val, &user) }); err != nil {
+				return err
+			}
+
+			users = append(users, user)
+		}
+
+		return nil
+	})
+
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (store *Store) createUser(ctx context.Context, user *User) error {
+
+	_, span := tracing.Start(ctx, "Iam.createUser")
+	defer span.End()
+
+	err := store.db.Update(func(txn *badger.Txn) error {
+
+		var existing User
+		err := readJSON(txn, userPrefix+user.UserName, &existing, ErrNoSuchUser)
+		if err == nil {
+			return ErrUserExists
+		} else if !errors.Is(err, ErrNoSuchUser) {
+			return err
+		}
+
+		return writeJSON(txn, userPrefix+user.UserName, user)
+	})
+
+	tracing.RecordError(span, err)
+
+	return err
+}
+
+// createAccessKey adds a key to an existing user that has fewer than
+// maxAccessKeys keys.
+func (store *Store) createAccessKey(ctx context.Context, key *AccessKey) error {
+
+	_, span := tracing.Start(ctx, "Iam.createAccessKey")
+	defer span.End()
+
+	err := store.db.Update(func(txn *badger.Txn) error {
+
+		var user User
+		if err := readJSON(txn, userPrefix+key.UserName, &user, ErrNoSuchUser); err != nil {
+			return err
+		}
+
+		keys, err := readAccessKeys(txn)
+		if err != nil {
+			return err
+		}
+
+		count := 0
+		for _, existing := range keys {
+			if existing.UserName == key.UserName {
+				count++
+			}
+		}
+
+		if count >= maxAccessKeys {
+			return ErrKeyLimitExceeded
+		}
+
+		return writeJSON(txn, keyPrefix+key.AccessKeyId, key)
+	})
+
+	tracing.RecordError(span, err)
+
+	return err
+}
+
+func (store *Store) getAccessKey(ctx context.Context, accessKeyId string) (*AccessKey, error) {
+
+	_, span := tracing.Start(ctx, "Iam.getAccessKey")
+	defer span.End()
+
+	var key AccessKey
+	err := store.db.View(func(txn *badger.Txn) error {
+		return readJSON(txn, keyPrefix+accessKeyId, &key, ErrNoSuchAccessKey)
+	})
+
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// updateAccessKey applies change to a key of the user in a single transaction.
+func (store *Store) updateAccessKey(ctx context.Context, userName string, accessKeyId string, change func(*AccessKey)) (*AccessKey, error) {
+
+	_, span := tracing.Start(ctx, "Iam.updateAccessKey")
+	defer span.End()
+
+	var key AccessKey
+	err := store.db.Update(func(txn *badger.Txn) error {
+
+		if err := readJSON(txn, keyPrefix+accessKeyId, &key, ErrNoSuchAccessKey); err != nil {
+			return err
+		}
+
+		if key.UserName != userName {
+			return ErrNoSuchAccessKey
+		}
+
+		change(&key)
+
+		return writeJSON(txn, keyPrefix+accessKeyId, &key)
+	})
+
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (store *Store) deleteAccessKey(ctx context.Context, userName string, accessKeyId string) error {
+
+	_, span := tracing.Start(ctx, "Iam.deleteAccessKey")
+	defer span.End()
+
+	err := store.db.Update(func(txn *badger.Txn) error {
+
+		var key AccessKey
+		if err := readJSON(txn, keyPrefix+accessKeyId, &key, ErrNoSuchAccessKey); err != nil {
+			return err
+		}
+
+		if key.UserName != userName {
+			return ErrNoSuchAccessKey
+		}
+
+		if err := txn.Delete([]byte(lastUsedPrefix + accessKeyId)); err != nil {
+			return err
+		}
+
+		return txn.Delete([]byte(keyPrefix + accessKeyId))
+	})
+
+	tracing.RecordError(span, err)
+
+	return err
+}
+
+func (store *Store) listAccessKeys(ctx context.Context) ([]AccessKey, error) {
+
+	_, span := tracing.Start(ctx, "Iam.listAccessKeys")
+	defer span.End()
+
+	var keys []AccessKey
+	err := store.db.View(func(txn *badger.Txn) error {
+
+		var err error
+		keys, err = readAccessKeys(txn)
+		return err
+	})
+
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (store *Store) setLastUsed(accessKeyId string, usage awslib.KeyUsage) error {
+
+	return store.db.Update(func(txn *badger.Txn) error {
+		return writeJSON(txn, lastUsedPrefix+accessKeyId, &usage)
+	})
+}
+
+func (store *Store) listLastUsed() (map[string]awslib.KeyUsage, error) {
+
+	usages := make(map[string]awslib.KeyUsage)
+	err := store.db.View(func(txn *badger.Txn) error {
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte(lastUsedPrefix)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+
+			var usage awslib.KeyUsage
+			if err := it.Item().Value(func(val []byte) error { return json.Unmarshal(val, &usage) }); err != nil {
+				return err
+			}
+
+			usages[string(it.Item().Key()[len(prefix):])] = usage
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return usages, nil
+}
+
+func readAccessKeys(txn *badger.Txn) ([]AccessKey, error) {
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	var keys []AccessKey
+	prefix := []byte(keyPrefix)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+
+		var key AccessKey
+		if err := it.Item().Value(func(val []byte) error { return json.Unmarshal(val, &key) }); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// readJSON reads the value of key, returning notFound if it doesn't exist.
+func readJSON(txn *badger.Txn, key string, value any, notFound error) error {
+
+	item, err := txn.Get([]byte(key))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return notFound
+	} else if err != nil {
+		return err
+	}
+
+	return item.Value(func(val []byte) error { return json.Unmarshal(val, value) })
+}
+
+func writeJSON(txn *badger.Txn, key string, value any) error {
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return txn.Set([]byte(key), data)
+}
@@ -0,0 +1,110 @@
+package iam
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+const xmlns = "https://iam.amazonaws.com/doc/2010-05-08/"
+
+type ResponseMetadata struct {
+	RequestId string `xml:"RequestId"`
+}
+
+type PermissionsBoundary struct {
+	PermissionsBoundaryType string `xml:"PermissionsBoundaryType"`
+	PermissionsBoundaryArn  string `xml:"PermissionsBoundaryArn"`
+}
+
+type UserResult struct {
+	Path                string               `xml:"Path"`
+	UserName            string               `xml:"UserName"`
+	UserId              string               `xml:"UserId"`
+	Arn                 string               `xml:"Arn"`
+	CreateDate          string               `xml:"CreateDate"`
+	PermissionsBoundary *PermissionsBoundary `xml:"PermissionsBoundary,omitempty"`
+}
+
+type CreateUserResult struct {
+	User UserResult `xml:"User"`
+}
+
+type CreateUserResponse struct {
+	XMLName          xml.Name         `xml:"CreateUserResponse"`
+	Xmlns            string           `xml:"xmlns,attr"`
+	Result           CreateUserResult `xml:"CreateUserResult"`
+	ResponseMetadata ResponseMetadata `xml:"ResponseMetadata"`
+}
+
+type AccessKeyResult struct {
+	UserName        string `xml:"UserName"`
+	AccessKeyId     string `xml:"AccessKeyId"`
+	Status          string `xml:"Status"`
+	SecretAccessKey string `xml:"SecretAccessKey"`
+	CreateDate      string `xml:"CreateDate"`
+}
+
+type CreateAccessKeyResult struct {
+	AccessKey AccessKeyResult `xml:"AccessKey"`
+}
+
+type CreateAccessKeyResponse struct {
+	XMLName          xml.Name              `xml:"CreateAccessKeyResponse"`
+	Xmlns            string                `xml:"xmlns,attr"`
+	Result           CreateAccessKeyResult `xml:"CreateAccessKeyResult"`
+	ResponseMetadata ResponseMetadata      `xml:"ResponseMetadata"`
+}
+
+type UpdateAccessKeyResponse struct {
+	XMLName          xml.Name         `xml:"UpdateAccessKeyResponse"`
+	Xmlns            string           `xml:"xmlns,attr"`
+	ResponseMetadata ResponseMetadata `xml:"ResponseMetadata"`
+}
+
+type DeleteAccessKeyResponse struct {
+	XMLName          xml.Name         `xml:"DeleteAccessKeyResponse"`
+	Xmlns            string           `xml:"xmlns,attr"`
+	ResponseMetadata ResponseMetadata `xml:"ResponseMetadata"`
+}
+
+type AccessKeyMetadata struct {
+	UserName    string `xml:"UserName"`
+	AccessKeyId string `xml:"AccessKeyId"`
+	Status      string `xml:"Status"`
+	CreateDate  string `xml:"CreateDate,omitempty"`
+}
+
+type ListAccessKeysResult struct {
+	AccessKeyMetadata []AccessKeyMetadata `xml:"AccessKeyMetadata>member"`
+	IsTruncated       bool                `xml:"IsTruncated"`
+}
+
+type ListAccessKeysResponse struct {
+	XMLName          xml.Name             `xml:"ListAccessKeysResponse"`
+	Xmlns            string               `xml:"xmlns,attr"`
+	Result           ListAccessKeysResult `xml:"ListAccessKeysResult"`
+	ResponseMetadata ResponseMetadata     `xml:"ResponseMetadata"`
+}
+
+type AccessKeyLastUsed struct {
+	LastUsedDate string `xml:"LastUsedDate,omitempty"`
+	ServiceName  string `xml:"ServiceName"`
+	Region       string `xml:"Region"`
+}
+
+type GetAccessKeyLastUsedResult struct {
+	UserName          string            `xml:"UserName"`
+	AccessKeyLastUsed AccessKeyLastUsed `xml:"AccessKeyLastUsed"`
+}
+
+type GetAccessKeyLastUsedResponse struct {
+	XMLName          xml.Name                   `xml:"GetAccessKeyLastUsedResponse"`
+	Xmlns            string                     `xml:"xmlns,attr"`
+	Result           GetAccessKeyLastUsedResult `xml:"GetAccessKeyLastUsedResult"`
+	ResponseMetadata ResponseMetadata           `xml:"ResponseMetadata"`
+}
+
+func formatDate(t time.Time) string {
+
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
@@ -0,0 +1,505 @@
+package kms
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"home-ssm/awslib"
+	"home-ssm/metrics"
+	"home-ssm/policy"
+	"home-ssm/ssm"
+	"home-ssm/tracing"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	symmetricDefault  = "SYMMETRIC_DEFAULT"
+	encryptDecrypt    = "ENCRYPT_DECRYPT"
+	defaultListLimit  = 100
+	maxListLimit      = 1000
+	maxPlaintextBytes = 4096
+)
+
+var aliasNamePattern = regexp.MustCompile(`^alias/[a-zA-Z0-9/_-]+$`)
+
+var knownOperations = []string{
+	"CreateAlias",
+	"CreateKey",
+	"Decrypt",
+	"DescribeKey",
+	"DisableKeyRotation",
+	"EnableKeyRotation",
+	"Encrypt",
+	"GenerateDataKey",
+	"GetKeyRotationStatus",
+	"ListAliases",
+	"ListKeys",
+}
+
+type Config struct {
+	// KeyId wraps the material of keys created through the API, the default
+	// key if empty. It must be a configured key.
+	KeyId string `yaml:"keyId"`
+}
+
+// Api serves the KMS JSON 1.1 protocol over the keys of the DataStore.
+type Api struct {
+	region     string
+	accountId  string
+	config     Config
+	dataStore  *ssm.DataStore
+	authorizer *policy.Authorizer
+}
+
+func NewApi(region string, accountId string, config Config, dataStore *ssm.DataStore, authorizer *policy.Authorizer) *Api {
+
+	return &Api{region: region, accountId: accountId, config: config, dataStore: dataStore, authorizer: authorizer}
+}
+
+// CheckConfig verifies the key wrapping created keys exists.
+func (api *Api) CheckConfig() error {
+
+	if api.config.KeyId == "" {
+		return nil
+	}
+
+	if _, err := api.dataStore.FindKey(api.config.KeyId); err != nil {
+		return fmt.Errorf("kms keyId %s: %w", api.config.KeyId, err)
+	}
+
+	return nil
+}
+
+func (api *Api) Handle(w http.ResponseWriter, r *http.Request) {
+
+	amztarget := r.Header.Get("X-Amz-Target")
+	operation := strings.TrimPrefix(amztarget, "TrentService.")
+
+	recorder := awslib.NewStatusRecorder(w)
+	defer awslib.ObserveRequest("kms", operation, knownOperations, recorder, time.Now())
+	w = recorder
+
+	ctx, span := tracing.Start(r.Context(), "KMS.Dispatch", tracing.Operation(amztarget))
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	var response any
+	var err error
+	switch operation {
+	case "Encrypt":
+		response, err = handle(r, api.encrypt)
+	case "Decrypt":
+		response, err = handle(r, api.decrypt)
+	case "GenerateDataKey":
+		response, err = handle(r, api.generateDataKey)
+	case "DescribeKey":
+		response, err = handle(r, api.describeKey)
+	case "ListKeys":
+		response, err = handle(r, api.listKeys)
+	case "ListAliases":
+		response, err = handle(r, api.listAliases)
+	case "CreateKey":
+		response, err = handle(r, api.createKey)
+	case "CreateAlias":
+		response, err = handle(r, api.createAlias)
+	case "EnableKeyRotation":
+		response, err = handle(r, api.enableKeyRotation)
+	case "DisableKeyRotation":
+		response, err = handle(r, api.disableKeyRotation)
+	case "GetKeyRotationStatus":
+		response, err = handle(r, api.getKeyRotationStatus)
+	default:
+		awslib.Logger(r.Context()).Warn("unknown target")
+		err = ErrUnsupportedOperation
+	}
+
+	if err != nil {
+
+		span.SetAttributes(tracing.ResultCode(translateToApiError(err).Code))
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
+		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.region)
+		return
+	}
+
+	span.SetAttributes(tracing.ResultCode(metrics.SuccessCode))
+	awslib.WriteSuccessResponseJSON(w, response)
+}
+
+// handle decodes the JSON request body and calls the operation.
+func handle[Request any, Response any](r *http.Request, operation func(*http.Request, *Request) (Response, error)) (any, error) {
+
+	var request Request
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, ErrInvalidRequest
+	}
+
+	return operation(r, &request)
+}
+
+func (api *Api) encrypt(r *http.Request, request *EncryptRequest) (*EncryptResponse, error) {
+
+	if len(request.Plaintext) == 0 || len(request.Plaintext) > maxPlaintextBytes {
+		return nil, ErrMissingPlaintext
+	}
+
+	key, err := api.useKey(r, request.KeyId, ssm.KeyOperationEncrypt)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := seal(key, request.Plaintext, request.EncryptionContext)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncryptResponse{
+		CiphertextBlob:      blob,
+		KeyId:               key.Arn(api.region, api.accountId),
+		EncryptionAlgorithm: symmetricDefault,
+	}, nil
+}
+
+func (api *Api) decrypt(r *http.Request, request *DecryptRequest) (*DecryptResponse, error) {
+
+	keyId, sealed, err := parseCiphertext(request.CiphertextBlob)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := api.useKey(r, keyId, ssm.KeyOperationDecrypt)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.KeyId != "" {
+
+		requested, err := api.dataStore.FindKey(request.KeyId)
+		if err != nil {
+			return nil, err
+		}
+
+		if requested.KeyId != key.KeyId {
+			return nil, ErrIncorrectKey
+		}
+	}
+
+	plaintext, err := key.Open(sealed, additionalData(request.EncryptionContext))
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+
+	return &DecryptResponse{
+		KeyId:               key.Arn(api.region, api.accountId),
+		Plaintext:           plaintext,
+		EncryptionAlgorithm: symmetricDefault,
+	}, nil
+}
+
+func (api *Api) generateDataKey(r *http.Request, request *GenerateDataKeyRequest) (*GenerateDataKeyResponse, error) {
+
+	var size int
+	switch {
+	case request.NumberOfBytes != nil && request.KeySpec != "":
+		return nil, ErrInvalidKeySpec
+	case request.NumberOfBytes != nil:
+		size = *request.NumberOfBytes
+	case request.KeySpec == "AES_256":
+		size = 32
+	case request.KeySpec == "AES_128":
+		size = 16
+	}
+
+	if size < 1 || size > 1024 {
+		return nil, ErrInvalidKeySpec
+	}
+
+	key, err := api.useKey(r, request.KeyId, ssm.KeyOperationGenerateDataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, size)
+	if _, err := rand.Read(plaintext); err != nil {
+		return nil, err
+	}
+
+	blob, err := seal(key, plaintext, request.EncryptionContext)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GenerateDataKeyResponse{
+		CiphertextBlob: blob,
+		KeyId:          key.Arn(api.region, api.accountId),
+		Plaintext:      plaintext,
+	}, nil
+}
+
+func (api *Api) describeKey(r *http.Request, request *KeyIdRequest) (*KeyMetadataResponse, error) {
+
+	key, err := api.findKey(r, request.KeyId, "DescribeKey")
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyMetadataResponse{KeyMetadata: api.keyMetadata(key)}, nil
+}
+
+func (api *Api) listKeys(r *http.Request, request *ListRequest) (*ListKeysResponse, error) {
+
+	if err := api.authorize(r, "ListKeys", "*"); err != nil {
+		return nil, err
+	}
+
+	keys, err := api.dataStore.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, next, err := page(len(keys), request)
+	if err != nil {
+		return nil, err
+	}
+
+	response := ListKeysResponse{Keys: []KeyListEntry{}, NextMarker: next, Truncated: next != ""}
+	for _, key := range keys[start:end] {
+		response.Keys = append(response.Keys, KeyListEntry{KeyId: key.KeyId, KeyArn: key.Arn(api.region, api.accountId)})
+	}
+
+	return &response, nil
+}
+
+func (api *Api) listAliases(r *http.Request, request *ListRequest) (*ListAliasesResponse, error) {
+
+	if err := api.authorize(r, "ListAliases", "*"); err != nil {
+		return nil, err
+	}
+
+	aliases, err := api.dataStore.Aliases()
+	if err != nil {
+		return nil, err
+	}
+
+	if request.KeyId != "" {
+
+		key, err := api.dataStore.FindKey(request.KeyId)
+		if err != nil {
+			return nil, err
+		}
+
+		aliases = slices.DeleteFunc(aliases, func(alias ssm.KeyAlias) bool { return alias.KeyId != key.KeyId })
+	}
+
+	start, end, next, err := page(len(aliases), request)
+	if err != nil {
+		return nil, err
+	}
+
+	response := ListAliasesResponse{Aliases: []AliasListEntry{}, NextMarker: next, Truncated: next != ""}
+	for _, alias := range aliases[start:end] {
+		response.Aliases = append(response.Aliases, AliasListEntry{
+			AliasArn:    fmt.Sprintf("arn:aws:kms:%s:%s:alias/%s", api.region, api.accountId, alias.Name),
+			AliasName:   "alias/" + alias.Name,
+			TargetKeyId: alias.KeyId,
+		})
+	}
+
+	return &response, nil
+}
+
+func (api *Api) createKey(r *http.Request, request *CreateKeyRequest) (*KeyMetadataResponse, error) {
+
+	if err := api.authorize(r, "CreateKey", "*"); err != nil {
+		return nil, err
+	}
+
+	for _, spec := range []string{request.KeySpec, request.CustomerMasterKeySpec} {
+		if spec != "" && spec != symmetricDefault {
+			return nil, ErrUnsupportedKeySpec
+		}
+	}
+
+	if request.KeyUsage != "" && request.KeyUsage != encryptDecrypt {
+		return nil, ErrUnsupportedKeySpec
+	}
+
+	key, err := api.dataStore.CreateKey(r.Context(), request.Description, api.keyId())
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyMetadataResponse{KeyMetadata: api.keyMetadata(key)}, nil
+}
+
+func (api *Api) createAlias(r *http.Request, request *CreateAliasRequest) (*EmptyResponse, error) {
+
+	if !aliasNamePattern.MatchString(request.AliasName) || strings.HasPrefix(request.AliasName, "alias/aws/") {
+		return nil, ErrInvalidAliasName
+	}
+
+	key, err := api.findKey(r, request.TargetKeyId, "CreateAlias")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := api.dataStore.CreateAlias(r.Context(), strings.TrimPrefix(request.AliasName, "alias/"), key.KeyId); err != nil {
+		return nil, err
+	}
+
+	return &EmptyResponse{}, nil
+}
+
+func (api *Api) enableKeyRotation(r *http.Request, request *KeyIdRequest) (*EmptyResponse, error) {
+
+	return api.setKeyRotation(r, request, "EnableKeyRotation", true)
+}
+
+func (api *Api) disableKeyRotation(r *http.Request, request *KeyIdRequest) (*EmptyResponse, error) {
+
+	return api.setKeyRotation(r, request, "DisableKeyRotation", false)
+}
+
+func (api *Api) setKeyRotation(r *http.Request, request *KeyIdRequest, action string, enabled bool) (*EmptyResponse, error) {
+
+	key, err := api.findKey(r, request.KeyId, action)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := api.dataStore.SetKeyRotation(r.Context(), key.KeyId, enabled); err != nil {
+		return nil, err
+	}
+
+	return &EmptyResponse{}, nil
+}
+
+func (api *Api) getKeyRotationStatus(r *http.Request, request *KeyIdRequest) (*GetKeyRotationStatusResponse, error) {
+
+	key, err := api.findKey(r, request.KeyId, "GetKeyRotationStatus")
+	if err != nil {
+		return nil, err
+	}
+
+	enabled, err := api.dataStore.KeyRotationEnabled(key.KeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GetKeyRotationStatusResponse{KeyRotationEnabled: enabled}, nil
+}
+
+// findKey resolves the key and checks the caller's policies for the action.
+func (api *Api) findKey(r *http.Request, keyId string, action string) (*ssm.KmsKey, error) {
+
+	if keyId == "" {
+		return nil, ErrMissingKeyId
+	}
+
+	key, err := api.dataStore.FindKey(keyId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := api.authorize(r, action, key.Arn(api.region, api.accountId)); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// useKey is findKey for cryptographic operations, which also require a key grant.
+func (api *Api) useKey(r *http.Request, keyId string, operation string) (*ssm.KmsKey, error) {
+
+	key, err := api.findKey(r, keyId, operation)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ssm.AuthorizeKey(r.Context(), key, operation, api.region, api.accountId); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (api *Api) authorize(r *http.Request, action string, resource string) error {
+
+	info := awslib.GetRequestInfo(r.Context())
+	principal := info.Principal
+	if api.authorizer == nil || principal == nil {
+		return nil
+	}
+
+	principalArn := awslib.PrincipalArn(api.accountId, principal)
+	request := policy.Request{
+		Action:   "kms:" + action,
+		Resource: resource,
+		Context:  policy.GlobalContext(principal, principalArn, info.SourceIp),
+	}
+
+	return api.authorizer.Authorize(principal, principalArn, &request)
+}
+
+func (api *Api) keyMetadata(key *ssm.KmsKey) KeyMetadata {
+
+	metadata := KeyMetadata{
+		AWSAccountId:          api.accountId,
+		Arn:                   key.Arn(api.region, api.accountId),
+		CustomerMasterKeySpec: symmetricDefault,
+		Description:           key.Description,
+		Enabled:               true,
+		EncryptionAlgorithms:  []string{symmetricDefault},
+		KeyId:                 key.KeyId,
+		KeyManager:            "CUSTOMER",
+		KeySpec:               symmetricDefault,
+		KeyState:              "Enabled",
+		KeyUsage:              encryptDecrypt,
+		Origin:                "AWS_KMS",
+	}
+
+	if !key.CreationDate.IsZero() {
+		metadata.CreationDate = float64(key.CreationDate.UnixMilli()) / 1000
+	}
+
+	return metadata
+}
+
+// page returns the slice bounds for the request and the marker of the next page.
+func page(total int, request *ListRequest) (int, int, string, error) {
+
+	limit := defaultListLimit
+	if request.Limit != nil {
+		limit = min(max(*request.Limit, 1), maxListLimit)
+	}
+
+	start := 0
+	if request.Marker != "" {
+
+		var err error
+		start, err = strconv.Atoi(request.Marker)
+		if err != nil || start < 0 || start > total {
+			return 0, 0, "", ErrInvalidMarker
+		}
+	}
+
+	end := min(start+limit, total)
+	if end < total {
+		return start, end, strconv.Itoa(end), nil
+	}
+
+	return start, end, "", nil
+}
+
+func (api *Api) keyId() string {
+
+	if api.config.KeyId != "" {
+		return api.config.KeyId
+	}
+
+	return api.dataStore.DefaultKeyId()
+}
@@ -0,0 +1,56 @@
+package kms
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"home-ssm/ssm"
+)
+
+// ciphertextVersion prefixes every blob so the format can change later.
+const ciphertextVersion = 1
+
+// The blob is version | uvarint key id length | key id | nonce and AES-GCM
+// sealed data, so Decrypt can find the key without a KeyId, as KMS does.
+func seal(key *ssm.KmsKey, plaintext []byte, context map[string]string) ([]byte, error) {
+
+	sealed, err := key.Seal(plaintext, additionalData(context))
+	if err != nil {
+		return nil, err
+	}
+
+	blob := binary.AppendUvarint([]byte{ciphertextVersion}, uint64(len(key.KeyId)))
+	blob = append(blob, key.KeyId...)
+
+	return append(blob, sealed...), nil
+}
+
+// parseCiphertext returns the key id and sealed data of a blob.
+func parseCiphertext(blob []byte) (string, []byte, error) {
+
+	if len(blob) < 2 || blob[0] != ciphertextVersion {
+		return "", nil, ErrInvalidCiphertext
+	}
+
+	size, n := binary.Uvarint(blob[1:])
+	if n <= 0 || size > uint64(len(blob)-1-n) {
+		return "", nil, ErrInvalidCiphertext
+	}
+
+	start := 1 + n
+	end := start + int(size)
+
+	return string(blob[start:end]), blob[end:], nil
+}
+
+// additionalData binds the encryption context to the ciphertext; the JSON
+// encoding sorts the keys so it's canonical.
+func additionalData(context map[string]string) []byte {
+
+	if len(context) == 0 {
+		return nil
+	}
+
+	data, _ := json.Marshal(context)
+
+	return data
+}
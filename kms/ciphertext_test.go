@@ -0,0 +1,95 @@
+package kms
+
+import (
+	"bytes"
+	"encoding/base64"
+	"home-ssm/ssm"
+	"strings"
+	"testing"
+)
+
+func newTestKey(keyId string) *ssm.KmsKey {
+
+	return &ssm.KmsKey{KeyId: keyId, Key: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))}
+}
+
+func TestCiphertextRoundTrip(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		keyId string
+	}{
+		{"short key id", "app"},
+		{"key id of 127 bytes", strings.Repeat("a", 127)},
+		{"key id of 128 bytes", strings.Repeat("a", 128)},
+		{"key id of 256 bytes", strings.Repeat("a", 256)},
+		{"key id of 300 bytes", strings.Repeat("a", 300)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			key := newTestKey(test.keyId)
+			context := map[string]string{"app": "test"}
+			blob, err := seal(key, []byte("secret"), context)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keyId, sealed, err := parseCiphertext(blob)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if keyId != test.keyId {
+				t.Fatalf("key id of %d bytes, want %d", len(keyId), len(test.keyId))
+			}
+
+			plaintext, err := key.Open(sealed, additionalData(context))
+			if err != nil || string(plaintext) != "secret" {
+				t.Errorf("Open() = %q, %v", plaintext, err)
+			}
+
+			if _, err := key.Open(sealed, additionalData(map[string]string{"app": "other"})); err == nil {
+				t.Error("opened with another encryption context")
+			}
+		})
+	}
+}
+
+func TestParseCiphertext(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		blob   []byte
+		keyId  string
+		sealed string
+		valid  bool
+	}{
+		{"short key id", []byte("\x01\x03appsealed"), "app", "sealed", true},
+		{"empty sealed data", []byte("\x01\x03app"), "app", "", true},
+		{"long key id", append(append([]byte{1, 0xac, 0x02}, strings.Repeat("a", 300)...), "sealed"...),
+			strings.Repeat("a", 300), "sealed", true},
+		{"empty", nil, "", "", false},
+		{"version only", []byte{1}, "", "", false},
+		{"unknown version", []byte("\x02\x03appsealed"), "", "", false},
+		{"version zero", []byte("\x00\x03appsealed"), "", "", false},
+		{"truncated key id", []byte("\x01\x05app"), "", "", false},
+		{"truncated length", []byte{1, 0x80}, "", "", false},
+		{"length overflow", []byte{1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, "", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			keyId, sealed, err := parseCiphertext(test.blob)
+			if (err == nil) != test.valid {
+				t.Fatalf("parseCiphertext() error %v, want valid %v", err, test.valid)
+			}
+
+			if keyId != test.keyId || string(sealed) != test.sealed {
+				t.Errorf("parseCiphertext() = %q, %q, want %q, %q", keyId, sealed, test.keyId, test.sealed)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package kms
+
+import (
+	"errors"
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"home-ssm/ssm"
+	"net/http"
+)
+
+var (
+	ErrNotFound             = errors.New("The specified key or alias does not exist.")
+	ErrInvalidCiphertext    = errors.New("The ciphertext is invalid or was encrypted under a different encryption context.")
+	ErrIncorrectKey         = errors.New("The key ID in the request does not identify the key that encrypted the ciphertext.")
+	ErrAliasExists          = errors.New("An alias with the name already exists.")
+	ErrInvalidAliasName     = errors.New("Alias must start with the prefix \"alias/\" and the prefix \"alias/aws/\" is reserved.")
+	ErrMissingKeyId         = errors.New("KeyId is required.")
+	ErrMissingPlaintext     = errors.New("Plaintext must be between 1 and 4096 bytes.")
+	ErrInvalidKeySpec       = errors.New("Only one of KeySpec or NumberOfBytes may be specified, KeySpec must be AES_256 or AES_128 and NumberOfBytes between 1 and 1024.")
+	ErrUnsupportedKeySpec   = errors.New("Only SYMMETRIC_DEFAULT keys with ENCRYPT_DECRYPT usage are supported.")
+	ErrInvalidMarker        = errors.New("The Marker is not valid.")
+	ErrUnsupportedOperation = errors.New("The operation isn't supported.")
+	ErrInvalidRequest       = errors.New("The request body is not valid JSON.")
+	ErrInternalError        = errors.New("We encountered an internal error, please try again.")
+)
+
+type errorCodeMap map[error]awslib.APIError
+
+var KmsErrorCodes = errorCodeMap{
+	ErrNotFound: {
+		Code:           "NotFoundException",
+		Description:    ErrNotFound.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidCiphertext: {
+		Code:           "InvalidCiphertextException",
+		Description:    ErrInvalidCiphertext.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrIncorrectKey: {
+		Code:           "IncorrectKeyException",
+		Description:    ErrIncorrectKey.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrAliasExists: {
+		Code:           "AlreadyExistsException",
+		Description:    ErrAliasExists.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidAliasName: {
+		Code:           "InvalidAliasNameException",
+		Description:    ErrInvalidAliasName.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrMissingKeyId: {
+		Code:           "ValidationException",
+		Description:    ErrMissingKeyId.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrMissingPlaintext: {
+		Code:           "ValidationException",
+		Description:    ErrMissingPlaintext.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidKeySpec: {
+		Code:           "ValidationException",
+		Description:    ErrInvalidKeySpec.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrUnsupportedKeySpec: {
+		Code:           "UnsupportedOperationException",
+		Description:    ErrUnsupportedKeySpec.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidMarker: {
+		Code:           "InvalidMarkerException",
+		Description:    ErrInvalidMarker.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrUnsupportedOperation: {
+		Code:           "UnsupportedOperationException",
+		Description:    ErrUnsupportedOperation.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidRequest: {
+		Code:           "SerializationException",
+		Description:    ErrInvalidRequest.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInternalError: {
+		Code:           "KMSInternalException",
+		Description:    ErrInternalError.Error(),
+		HTTPStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func translateToApiError(err error) awslib.APIError {
+
+	switch {
+	case errors.Is(err, ssm.ErrInvalidKeyId):
+		err = ErrNotFound
+	case errors.Is(err, ssm.ErrAliasAlreadyExists):
+		err = ErrAliasExists
+	}
+
+	value, ok := KmsErrorCodes[err]
+	if ok {
+
+		return value
+	}
+
+	var accessDenied *policy.AccessDeniedError
+	if errors.As(err, &accessDenied) {
+
+		return awslib.APIError{
+			Code:           "AccessDeniedException",
+			Description:    accessDenied.Error(),
+			HTTPStatusCode: http.StatusBadRequest,
+		}
+	}
+
+	return KmsErrorCodes[ErrInternalError]
+}
@@ -0,0 +1,112 @@
+package kms
+
+type EncryptRequest struct {
+	KeyId               string
+	Plaintext           []byte
+	EncryptionContext   map[string]string
+	EncryptionAlgorithm string
+}
+
+type EncryptResponse struct {
+	CiphertextBlob      []byte `json:"CiphertextBlob"`
+	KeyId               string `json:"KeyId"`
+	EncryptionAlgorithm string `json:"EncryptionAlgorithm"`
+}
+
+type DecryptRequest struct {
+	CiphertextBlob      []byte
+	KeyId               string
+	EncryptionContext   map[string]string
+	EncryptionAlgorithm string
+}
+
+type DecryptResponse struct {
+	KeyId               string `json:"KeyId"`
+	Plaintext           []byte `json:"Plaintext"`
+	EncryptionAlgorithm string `json:"EncryptionAlgorithm"`
+}
+
+type GenerateDataKeyRequest struct {
+	KeyId             string
+	KeySpec           string
+	NumberOfBytes     *int
+	EncryptionContext map[string]string
+}
+
+type GenerateDataKeyResponse struct {
+	CiphertextBlob []byte `json:"CiphertextBlob"`
+	KeyId          string `json:"KeyId"`
+	Plaintext      []byte `json:"Plaintext"`
+}
+
+type KeyIdRequest struct {
+	KeyId string
+}
+
+type KeyMetadata struct {
+	AWSAccountId          string   `json:"AWSAccountId"`
+	Arn                   string   `json:"Arn"`
+	CreationDate          float64  `json:"CreationDate,omitempty"`
+	CustomerMasterKeySpec string   `json:"CustomerMasterKeySpec"`
+	Description           string   `json:"Description"`
+	Enabled               bool     `json:"Enabled"`
+	EncryptionAlgorithms  []string `json:"EncryptionAlgorithms"`
+	KeyId                 string   `json:"KeyId"`
+	KeyManager            string   `json:"KeyManager"`
+	KeySpec               string   `json:"KeySpec"`
+	KeyState              string   `json:"KeyState"`
+	KeyUsage              string   `json:"KeyUsage"`
+	MultiRegion           bool     `json:"MultiRegion"`
+	Origin                string   `json:"Origin"`
+}
+
+type KeyMetadataResponse struct {
+	KeyMetadata KeyMetadata `json:"KeyMetadata"`
+}
+
+type ListRequest struct {
+	KeyId  string
+	Limit  *int
+	Marker string
+}
+
+type KeyListEntry struct {
+	KeyId  string `json:"KeyId"`
+	KeyArn string `json:"KeyArn"`
+}
+
+type ListKeysResponse struct {
+	Keys       []KeyListEntry `json:"Keys"`
+	NextMarker string         `json:"NextMarker,omitempty"`
+	Truncated  bool           `json:"Truncated"`
+}
+
+type AliasListEntry struct {
+	AliasArn    string `json:"AliasArn"`
+	AliasName   string `json:"AliasName"`
+	TargetKeyId string `json:"TargetKeyId,omitempty"`
+}
+
+type ListAliasesResponse struct {
+	Aliases    []AliasListEntry `json:"Aliases"`
+	NextMarker string           `json:"NextMarker,omitempty"`
+	Truncated  bool             `json:"Truncated"`
+}
+
+type CreateKeyRequest struct {
+	Description           string
+	KeySpec               string
+	CustomerMasterKeySpec string
+	KeyUsage              string
+}
+
+type CreateAliasRequest struct {
+	AliasName   string
+	TargetKeyId string
+}
+
+type GetKeyRotationStatusResponse struct {
+	KeyRotationEnabled bool `json:"KeyRotationEnabled"`
+}
+
+type EmptyResponse struct{}
@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+type Config struct {
+	// Format is json or text, defaults to text.
+	Format string `yaml:"format"`
+	// Level is debug, info, warn or error, defaults to info.
+	Level string `yaml:"level"`
+}
+
+const redacted = "[REDACTED]"
+
+// attribute keys whose values are never written to the log
+var sensitiveKeys = []string{"value", "secret", "secretkey", "password", "token", "sessiontoken", "plaintext"}
+
+// Init installs the default slog logger; the standard log package writes
+// through it too.
+func Init(config Config) error {
+
+	handler, err := NewHandler(os.Stderr, config)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(slog.New(handler))
+
+	return nil
+}
+
+func NewHandler(w io.Writer, config Config) (slog.Handler, error) {
+
+	var level slog.Level
+	if config.Level != "" {
+		if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q", config.Level)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+
+	switch strings.ToLower(config.Format) {
+	case "", "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+
+	return nil, fmt.Errorf("invalid log format %q", config.Format)
+}
+
+func redact(groups []string, attr slog.Attr) slog.Attr {
+
+	key := strings.ToLower(attr.Key)
+	for _, sensitive := range sensitiveKeys {
+
+		if key == sensitive {
+			return slog.String(attr.Key, redacted)
+		}
+	}
+
+	return attr
+}
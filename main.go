@@ -1,12 +1,32 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"home-ssm/audit"
 	"home-ssm/awslib"
+	"home-ssm/faults"
+	"home-ssm/health"
+	"home-ssm/iam"
+	"home-ssm/kms"
+	"home-ssm/logging"
+	"home-ssm/metrics"
+	"home-ssm/policy"
+	"home-ssm/secrets"
 	"home-ssm/ssm"
+	"home-ssm/sts"
+	"home-ssm/tracing"
+	"home-ssm/webhooks"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"syscall"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/dgraph-io/badger/v4"
@@ -14,98 +34,680 @@ import (
 )
 
 type SsmCredentials struct {
-	AccessKey string `yaml:"accessKey"`
-	SecretKey string `yaml:"secretKey"`
-	Username  string `yaml:"username"`
+	AccessKey string   `yaml:"accessKey"`
+	SecretKey string   `yaml:"secretKey"`
+	Username  string   `yaml:"username"`
+	Policies  []string `yaml:"policies"`
+}
+
+type PolicyConfig struct {
+	Name     string `yaml:"name"`
+	Document string `yaml:"document"`
+}
+
+// RegionConfig adds a region with its own parameters. Without keys it
+// uses the keys of the primary region.
+type RegionConfig struct {
+	Name string       `yaml:"name"`
+	Keys []ssm.KmsKey `yaml:"keys"`
 }
 
 type HomeSsmConfig struct {
-	Region      string           `yaml:"region"`
-	Credentials []SsmCredentials `yaml:"credentials"`
-	Keys        []ssm.KmsKey     `yaml:"keys"`
+	Region      string               `yaml:"region"`
+	Regions     []RegionConfig       `yaml:"regions"`
+	Accounts    []AccountConfig      `yaml:"accounts"`
+	Credentials []SsmCredentials     `yaml:"credentials"`
+	Keys        []ssm.KmsKey         `yaml:"keys"`
+	Policies    []PolicyConfig       `yaml:"policies"`
+	Roles       []sts.Role           `yaml:"roles"`
+	Tracing     tracing.Config       `yaml:"tracing"`
+	Logging     logging.Config       `yaml:"logging"`
+	Audit       audit.Config         `yaml:"audit"`
+	Replay      awslib.ReplayConfig  `yaml:"replayProtection"`
+	Lockout     awslib.LockoutConfig `yaml:"lockout"`
+	RateLimits  ssm.RateLimitConfig  `yaml:"rateLimits"`
+	Faults      faults.Config        `yaml:"faults"`
+	Iam         iam.Config           `yaml:"iam"`
+	Kms         kms.Config           `yaml:"kms"`
+	Webhooks    webhooks.Config      `yaml:"webhooks"`
+	Watch       ssm.WatchConfig      `yaml:"watch"`
 }
 
 const (
 	ZeroAccountId string = "000000000000"
 )
 
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+$`)
+
+// set at build time with -ldflags "-X main.version=..."
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
 func main() {
 
+	if len(os.Args) > 1 {
+
+		switch os.Args[1] {
+		case "audit":
+			runAuditCommand(os.Args[2:])
+			return
+		case "presign":
+			runPresignCommand(os.Args[2:])
+			return
+		case "check-config":
+			runCheckConfigCommand(os.Args[2:])
+			return
+		case "doctor":
+			runDoctorCommand(os.Args[2:])
+			return
+		}
+	}
+
 	configFilePtr := flag.String("config", ".home-ssm-config.yaml", "Path to the home-ssm config file.")
 	dbPathPtr := flag.String("db-path", ".home-ssm-db", "Path to badger database folder.")
 	flag.Parse()
 
 	ssmConfig := readAuthCredsOrDie(*configFilePtr)
+	if err := logging.Init(ssmConfig.Logging); err != nil {
+		log.Panicln("Error initializing logging:", err)
+	}
+
 	simplePrintConfig(ssmConfig)
+	if err := validateConfig(ssmConfig); err != nil {
+		log.Panicln("Error in config:", err)
+	}
 
+	keyring := awslib.NewKeyring(configCredentials(ssmConfig))
 	credentialsProvider := awslib.CredentialsProvider{
-		Service:     awslib.ServiceSsm,
-		Region:      ssmConfig.Region,
-		Credentials: []aws.Credentials{},
+		Service:   awslib.ServiceSsm,
+		Region:    ssmConfig.Region,
+		AccountId: ZeroAccountId,
+		Keyring:   keyring,
 	}
 
-	credentialsProvider.Region = ssmConfig.Region
-	for _, cred := range ssmConfig.Credentials {
-		credentialsProvider.Credentials = append(credentialsProvider.Credentials, aws.Credentials{
-			AccessKeyID:     cred.AccessKey,
-			SecretAccessKey: cred.SecretKey,
-			Source:          cred.Username,
-			AccountID:       ZeroAccountId,
-		})
+	shutdownTracing, err := tracing.Init(context.Background(), ssmConfig.Tracing, version)
+	if err != nil {
+		log.Panicln("Error initializing tracing:", err)
 	}
+	defer shutdownTracing(context.Background())
 
-	service := initialServiceOrDie(ssmConfig, ZeroAccountId, *dbPathPtr)
+	db := openDatabaseOrDie(*dbPathPtr, false)
+	dataStore := createDataStoreOrDie(ssmConfig, db)
+	service := ssm.NewParameterService(ssmConfig.Region, ZeroAccountId, dataStore)
 	defer service.Close()
 
-	api := ssm.NewParameterApi(service, &credentialsProvider)
+	authorizer := createAuthorizerOrDie(ssmConfig)
+	sessions := sts.NewSessionStore(authorizer)
+	credentialsProvider.Sessions = sessions
+	credentialsProvider.Replays = awslib.NewReplayCache(ssmConfig.Replay)
+	credentialsProvider.Lockout = createLockoutOrDie(ssmConfig)
+
+	api := ssm.NewParameterApi(service, &credentialsProvider, authorizer)
+	api.SetRateLimiter(createRateLimiterOrDie(ssmConfig))
+
+	auditStore := audit.NewStore(db, ssmConfig.Audit)
+	auditor := audit.NewRecorder(auditStore, ssmConfig.Audit, ssmConfig.Region, ZeroAccountId, service.CreateUserArn)
+
+	lookupApi := audit.NewLookupApi(auditStore, ssmConfig.Region)
+	stsApi := sts.NewApi(ssmConfig.Region, ZeroAccountId, ssmConfig.Roles, sessions, authorizer)
+	kmsApi := kms.NewApi(ssmConfig.Region, ZeroAccountId, ssmConfig.Kms, dataStore, authorizer)
+	if err := kmsApi.CheckConfig(); err != nil {
+		log.Panicln("Error in KMS config:", err)
+	}
+	secretsApi := secrets.NewApi(ssmConfig.Region, ZeroAccountId, secrets.NewStore(db), dataStore, authorizer)
+	service.SetSecretResolver(secretsApi)
+
+	var dispatcher *webhooks.Dispatcher
+	if len(ssmConfig.Webhooks.Endpoints) > 0 {
+		dispatcher = createDispatcherOrDie(ssmConfig, db)
+		service.AddChangeListener(dispatcher)
+	}
+
+	var journal *ssm.ChangeJournal
+	if !ssmConfig.Watch.Disabled {
+		journal = createJournalOrDie(ssmConfig, db)
+		service.AddChangeListener(journal)
+		api.SetJournal(journal)
+	}
+
+	storage := ssm.NewStorageCollector(service)
+	stores := make(map[string]*ssm.DataStore)
+	for _, ns := range namespaces(ssmConfig) {
+
+		stores[ns.key()] = ns.dataStore(db)
+		nsService := ns.service(ssmConfig, stores[ns.key()])
+		if ns.AccountId == ZeroAccountId {
+			// secrets belong to the default account
+			nsService.SetSecretResolver(secretsApi)
+		}
+		if dispatcher != nil {
+			nsService.AddChangeListener(dispatcher)
+		}
+		if journal != nil {
+			nsService.AddChangeListener(journal)
+		}
+		api.AddService(nsService)
+		storage.AddService(nsService)
+	}
+
+	iamApi := iam.NewApi(ssmConfig.Region, ZeroAccountId, ssmConfig.Iam, iam.NewStore(db), dataStore,
+		keyring, authorizer, sessions, accountCredentials(configCredentials(ssmConfig), ZeroAccountId))
+	if err := iamApi.Load(context.Background()); err != nil {
+		log.Panicln("Error loading IAM access keys:", err)
+	}
+
+	ssmHandler := api.Handle
+	var injector *faults.Injector
+	if ssmConfig.Faults.Enabled {
+		injector = createInjectorOrDie(ssmConfig)
+		ssmHandler = injector.Middleware(api.Handle)
+	}
+
+	router := awslib.NewRouter(credentialsProvider)
+	router.Register(awslib.Service{Type: awslib.ServiceSsm, TargetPrefix: "AmazonSSM",
+		Regions: regionNames(ssmConfig), MultiAccount: true, Handler: ssmHandler})
+	router.Register(awslib.Service{Type: awslib.ServiceCloudTrail,
+		TargetPrefix: "com.amazonaws.cloudtrail.v20131101.CloudTrail_20131101", Handler: lookupApi.Handle})
+	router.Register(awslib.Service{Type: awslib.ServiceSts, Protocol: awslib.ProtocolQuery, Handler: stsApi.Handle})
+	router.Register(awslib.Service{Type: awslib.ServiceKms, TargetPrefix: "TrentService", Handler: kmsApi.Handle})
+	router.Register(awslib.Service{Type: awslib.ServiceSecrets, TargetPrefix: "secretsmanager", Handler: secretsApi.Handle})
+	// IAM is global, its requests are signed for us-east-1
+	router.Register(awslib.Service{Type: awslib.ServiceIam, Protocol: awslib.ProtocolQuery, Region: "us-east-1", Handler: iamApi.Handle})
+
+	probes := health.NewProbes(health.BuildInfo{Version: version, Commit: commit, BuildDate: buildDate})
+	reloader := newConfigReloader(*configFilePtr, ssmConfig)
+	reloader.keyring = keyring
+	reloader.dataStore = dataStore
+	reloader.stores = stores
+	reloader.authorizer = authorizer
+	reloader.sessions = sessions
+	reloader.stsApi = stsApi
+	reloader.iamApi = iamApi
+	reloader.secretsApi = secretsApi
+
+	probes.AddReadinessCheck("config", func() error { return checkConfigLoaded(reloader.config()) })
+	probes.AddReadinessCheck("datastore", service.CheckReady)
+
+	// SDKs and tools expect services at the root, the per-service paths
+	// predate the router and are kept for existing clients
+	routed := awslib.WithRequestId(tracing.Middleware(auditor.Middleware(router.Handle)))
+	for _, path := range []string{"/", "/ssm", "/cloudtrail", "/sts", "/kms", "/secretsmanager", "/iam"} {
+		http.HandleFunc(path, routed)
+	}
+
+	adminProvider := credentialsProvider
+	adminProvider.Service = awslib.ServiceAdmin
+	if len(ssmConfig.Regions) > 0 {
+		http.HandleFunc("/admin/copy-parameters", awslib.WithRequestId(tracing.Middleware(
+			auditor.Middleware(adminProvider.WithSigV4(api.HandleCopy)))))
+	}
+
+	if journal != nil {
+		// watchers sign for ssm like the parameter API, in any account and region
+		watchProvider := credentialsProvider
+		watchProvider.Regions = regionNames(ssmConfig)
+		watchProvider.AccountId = ""
+		http.HandleFunc("/watch", awslib.WithRequestId(tracing.Middleware(
+			auditor.Middleware(watchProvider.WithSigV4(api.HandleWatch)))))
+	}
+
+	if injector != nil {
+		faultsApi := faults.NewAdminApi(injector, ZeroAccountId, authorizer)
+		http.HandleFunc("/admin/faults", awslib.WithRequestId(tracing.Middleware(
+			auditor.Middleware(adminProvider.WithSigV4(faultsApi.Handle)))))
+	}
+	http.HandleFunc("/healthz", probes.HandleLive)
+	http.HandleFunc("/readyz", probes.HandleReady)
+	http.HandleFunc("/version", probes.HandleVersion)
 
-	http.HandleFunc("/ssm", credentialsProvider.WithSigV4(api.Handle))
+	metrics.Register(storage)
+	http.Handle("/metrics", metrics.Handler())
 
 	addr := ":9080"
-	log.Printf("Listening on %s", addr)
-	http.ListenAndServe(addr, nil)
+	server := &http.Server{Addr: addr}
+
+	// shut down cleanly so the deferred closes flush the database
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go reloader.run(ctx)
+	if dispatcher != nil {
+		go dispatcher.Run(ctx)
+	}
+	go func() {
+		<-ctx.Done()
+		slog.Info("Shutting down")
+		server.Shutdown(context.Background())
+	}()
+
+	slog.Info("Listening", "addr", addr, "version", version)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Server failed", "error", err)
+	}
+}
+
+func createLockoutOrDie(config *HomeSsmConfig) *awslib.Lockout {
+
+	lockout, err := awslib.NewLockout(config.Lockout)
+	if err != nil {
+		log.Panicln("Error in lockout config:", err)
+	}
+
+	return lockout
+}
+
+func createRateLimiterOrDie(config *HomeSsmConfig) *ssm.RateLimiter {
+
+	limiter, err := ssm.NewRateLimiter(config.RateLimits)
+	if err != nil {
+		log.Panicln("Error in rate limit config:", err)
+	}
+
+	return limiter
+}
+
+func createInjectorOrDie(config *HomeSsmConfig) *faults.Injector {
+
+	injector, err := faults.NewInjector(config.Faults, config.Region)
+	if err != nil {
+		log.Panicln("Error in faults config:", err)
+	}
+
+	slog.Warn("Fault injection enabled", "rules", len(config.Faults.Rules), "seed", config.Faults.Seed)
+
+	return injector
+}
+
+func createDispatcherOrDie(config *HomeSsmConfig, db *badger.DB) *webhooks.Dispatcher {
+
+	dispatcher, err := webhooks.NewDispatcher(db, config.Webhooks)
+	if err != nil {
+		log.Panicln("Error in webhooks config:", err)
+	}
+
+	slog.Info("Webhooks enabled", "endpoints", len(config.Webhooks.Endpoints))
+
+	return dispatcher
+}
+
+func createJournalOrDie(config *HomeSsmConfig, db *badger.DB) *ssm.ChangeJournal {
+
+	journal, err := ssm.NewChangeJournal(db, config.Watch)
+	if err != nil {
+		log.Panicln("Error opening change journal:", err)
+	}
+
+	return journal
 }
 
 func readAuthCredsOrDie(configFileName string) *HomeSsmConfig {
 
-	configFile, err := os.ReadFile(configFileName) // Replace with your yaml file name/path
+	config, err := readConfig(configFileName)
 	if err != nil {
-		log.Panicln("Error reading config file:", err)
+		log.Panicln("Error reading config:", err)
 	}
 
-	var config HomeSsmConfig
-	err = yaml.Unmarshal(configFile, &config)
+	return config
+}
+
+func readConfig(configFileName string) (*HomeSsmConfig, error) {
+
+	configFile, err := os.ReadFile(configFileName)
 	if err != nil {
-		log.Panicln("Error unmarshalling config:", err)
+		return nil, err
 	}
 
-	return &config
+	var config HomeSsmConfig
+	if err := yaml.Unmarshal(configFile, &config); err != nil {
+		return nil, fmt.Errorf("unmarshalling %s: %w", configFileName, err)
+	}
+
+	return &config, nil
 }
 
-func initialServiceOrDie(config *HomeSsmConfig, accountId string, databasePath string) *ssm.ParameterService {
+func openDatabaseOrDie(databasePath string, readOnly bool) *badger.DB {
 
-	opts := badger.DefaultOptions(databasePath).WithLoggingLevel(badger.ERROR)
+	opts := badger.DefaultOptions(databasePath).WithLoggingLevel(badger.ERROR).WithReadOnly(readOnly)
 	db, err := badger.Open(opts)
 	if err != nil {
 		log.Panicln("Error opening badger db:", err)
 	}
 
-	dataStore := ssm.NewDataStore(db, config.Keys)
+	return db
+}
+
+func createDataStoreOrDie(config *HomeSsmConfig, db *badger.DB) *ssm.DataStore {
+
+	if err := validateGrants(config); err != nil {
+		log.Panicln("Error in key grants:", err)
+	}
+
+	return ssm.NewDataStore(db, config.Keys)
+}
+
+func createAuthorizerOrDie(config *HomeSsmConfig) *policy.Authorizer {
+
+	authorizer := policy.NewAuthorizer()
+	if err := loadPolicies(authorizer, config); err != nil {
+		log.Panicln("Error in policies:", err)
+	}
+
+	return authorizer
+}
+
+// validateConfig runs every check made at startup, so a reload can't swap
+// in a config the server wouldn't start with. All problems are returned.
+func validateConfig(config *HomeSsmConfig) error {
+
+	var errs []error
+	if !regionPattern.MatchString(config.Region) {
+		errs = append(errs, fmt.Errorf("region %q isn't a region name like us-east-1", config.Region))
+	}
+
+	errs = append(errs, validateCredentials(config))
+	errs = append(errs, ssm.ValidateKeys(config.Keys))
+	errs = append(errs, validateRegions(config))
+	errs = append(errs, validateAccounts(config))
+	errs = append(errs, validateGrants(config))
+
+	policies := make(map[string]bool)
+	for _, cfg := range config.Policies {
+
+		if policies[cfg.Name] {
+			errs = append(errs, fmt.Errorf("duplicate policy %s", cfg.Name))
+		}
+		policies[cfg.Name] = true
+	}
+
+	roles := make(map[string]bool)
+	for _, role := range config.Roles {
+
+		if roles[role.Name] {
+			errs = append(errs, fmt.Errorf("duplicate role %s", role.Name))
+		}
+		roles[role.Name] = true
+	}
+
+	errs = append(errs, loadPolicies(policy.NewAuthorizer(), config))
+
+	if _, err := awslib.NewLockout(config.Lockout); err != nil {
+		errs = append(errs, fmt.Errorf("lockout: %w", err))
+	}
+
+	if _, err := ssm.NewRateLimiter(config.RateLimits); err != nil {
+		errs = append(errs, fmt.Errorf("rate limits: %w", err))
+	}
+
+	if _, err := faults.NewInjector(config.Faults, config.Region); err != nil {
+		errs = append(errs, fmt.Errorf("faults: %w", err))
+	}
+
+	errs = append(errs, config.Webhooks.Validate())
+
+	if config.Watch.RetentionHours < 0 {
+		errs = append(errs, errors.New("watch: retentionHours can't be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateCredentials(config *HomeSsmConfig) error {
+
+	if len(config.Credentials) == 0 {
+		return errors.New("no credentials configured")
+	}
+
+	var errs []error
+	accessKeys := make(map[string]bool)
+	check := func(credentials []SsmCredentials, holder string) {
+
+		for i, cred := range credentials {
+
+			if cred.AccessKey == "" || cred.SecretKey == "" || cred.Username == "" {
+				errs = append(errs, fmt.Errorf("credentials %d%s need an accessKey, secretKey and username", i+1, holder))
+			}
+
+			if accessKeys[cred.AccessKey] {
+				errs = append(errs, fmt.Errorf("duplicate access key %s", cred.AccessKey))
+			}
+			accessKeys[cred.AccessKey] = true
+		}
+	}
+
+	check(config.Credentials, "")
+	for _, account := range config.Accounts {
+		check(account.Credentials, " of account "+account.Name)
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateRegions checks the additional regions, their keys are checked
+// like those of the primary region.
+func validateRegions(config *HomeSsmConfig) error {
+
+	var errs []error
+	regions := map[string]bool{config.Region: true}
+	for _, region := range config.Regions {
+
+		if !regionPattern.MatchString(region.Name) {
+			errs = append(errs, fmt.Errorf("region %q isn't a region name like us-east-1", region.Name))
+		}
+
+		if regions[region.Name] {
+			errs = append(errs, fmt.Errorf("duplicate region %s", region.Name))
+		}
+		regions[region.Name] = true
+
+		if len(region.Keys) > 0 {
+			if err := ssm.ValidateKeys(region.Keys); err != nil {
+				errs = append(errs, fmt.Errorf("keys of region %s: %w", region.Name, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// regionKeys returns the keys of an additional region.
+func regionKeys(config *HomeSsmConfig, region RegionConfig) []ssm.KmsKey {
+
+	if len(region.Keys) > 0 {
+		return region.Keys
+	}
+
+	return config.Keys
+}
+
+// regionNames returns the names of the additional regions.
+func regionNames(config *HomeSsmConfig) []string {
+
+	var names []string
+	for _, region := range config.Regions {
+		names = append(names, region.Name)
+	}
+
+	return names
+}
+
+func validateGrants(config *HomeSsmConfig) error {
+
+	keys := slices.Clip(config.Keys)
+	for _, region := range config.Regions {
+		keys = append(keys, region.Keys...)
+	}
+	for _, account := range config.Accounts {
+		keys = append(keys, account.Keys...)
+	}
+
+	for _, key := range keys {
+		for _, grant := range key.Grants {
+
+			if err := grant.Validate(); err != nil {
+				return fmt.Errorf("grants of key alias/%s: %w", key.Alias, err)
+			}
+
+			if grant.Role != "" && !slices.ContainsFunc(config.Roles, func(role sts.Role) bool {
+				return role.Name == grant.Role
+			}) {
+				return fmt.Errorf("unknown role %s granted key alias/%s", grant.Role, key.Alias)
+			}
+
+			// IAM users and their access keys are created at runtime, so
+			// they're granted keys by user name
+			if grant.AccessKey != "" && !slices.ContainsFunc(allCredentials(config), func(cred SsmCredentials) bool {
+				return cred.AccessKey == grant.AccessKey
+			}) {
+				return fmt.Errorf("unknown access key %s granted key alias/%s, grant IAM access keys by user", grant.AccessKey, key.Alias)
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadPolicies registers the config policies with the authorizer by name,
+// for IAM permissions boundaries, and attached to credentials and roles.
+func loadPolicies(authorizer *policy.Authorizer, config *HomeSsmConfig) error {
+
+	policies, err := compilePolicies(config)
+	if err != nil {
+		return err
+	}
+
+	for key, documents := range policies {
+		authorizer.SetPolicies(key, documents)
+	}
+
+	return nil
+}
+
+// compilePolicies parses the config policies and returns them by their
+// authorizer key, without changing an authorizer.
+func compilePolicies(config *HomeSsmConfig) (map[string][]*policy.Document, error) {
+
+	documents := make(map[string]*policy.Document)
+	for _, cfg := range config.Policies {
+
+		document, err := policy.Parse(cfg.Name, cfg.Document)
+		if err != nil {
+			return nil, err
+		}
+
+		documents[cfg.Name] = document
+	}
+
+	attach := func(names []string, holder string) ([]*policy.Document, error) {
+
+		var attached []*policy.Document
+		for _, name := range names {
+
+			document, ok := documents[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown policy %q attached to %s", name, holder)
+			}
+
+			attached = append(attached, document)
+		}
+
+		return attached, nil
+	}
+
+	var err error
+	attached := make(map[string][]*policy.Document)
+	for name, document := range documents {
+		attached[iam.PolicyKey(name)] = []*policy.Document{document}
+	}
+
+	for _, cred := range allCredentials(config) {
+		if attached[cred.AccessKey], err = attach(cred.Policies, "access key "+cred.AccessKey); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, role := range config.Roles {
+
+		if err := role.Validate(); err != nil {
+			return nil, err
+		}
 
-	return ssm.NewParameterService(config.Region, accountId, dataStore)
+		if attached[role.PolicyKey()], err = attach(role.Policies, "role "+role.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	return attached, nil
+}
+
+// configCredentials returns the credentials of the config users of every
+// account.
+func configCredentials(config *HomeSsmConfig) []aws.Credentials {
+
+	var credentials []aws.Credentials
+	add := func(creds []SsmCredentials, accountId string) {
+		for _, cred := range creds {
+			credentials = append(credentials, aws.Credentials{
+				AccessKeyID:     cred.AccessKey,
+				SecretAccessKey: cred.SecretKey,
+				Source:          cred.Username,
+				AccountID:       accountId,
+			})
+		}
+	}
+
+	add(config.Credentials, ZeroAccountId)
+	for _, account := range config.Accounts {
+		add(account.Credentials, account.Id)
+	}
+
+	return credentials
+}
+
+func checkConfigLoaded(config *HomeSsmConfig) error {
+
+	if config == nil {
+		return errors.New("config not loaded")
+	}
+
+	if config.Region == "" {
+		return errors.New("region is not set")
+	}
+
+	if len(config.Credentials) == 0 {
+		return errors.New("no credentials configured")
+	}
+
+	return nil
 }
 
 func simplePrintConfig(config *HomeSsmConfig) {
 
-	log.Println("Region:", config.Region)
+	slog.Info("Region", "region", config.Region)
 
-	log.Println("Credentials:")
 	for i, cred := range config.Credentials {
-		log.Printf("\tAccessKey %02d: %s\n", i+1, cred.AccessKey)
+		slog.Info("Credentials", "index", i+1, "access_key", cred.AccessKey, "username", cred.Username, "policies", cred.Policies)
 	}
 
-	log.Println("Keys:")
 	for i, key := range config.Keys {
-		log.Printf("\tKMS Key %02d: alias/%s\n", i+1, key.Alias)
+		slog.Info("Keys", "index", i+1, "alias", "alias/"+key.Alias, "id", key.KeyId)
+	}
+
+	for i, region := range config.Regions {
+		slog.Info("Regions", "index", i+1, "region", region.Name, "keys", len(region.Keys))
+	}
+
+	for i, account := range config.Accounts {
+		slog.Info("Accounts", "index", i+1, "name", account.Name, "id", account.Id,
+			"credentials", len(account.Credentials), "keys", len(account.Keys))
+	}
+
+	for i, role := range config.Roles {
+		slog.Info("Roles", "index", i+1, "name", role.Name, "policies", role.Policies)
+	}
+
+	if config.Tracing.Endpoint != "" {
+		slog.Info("Tracing", "endpoint", config.Tracing.Endpoint)
 	}
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"home-ssm/ssm"
+	"home-ssm/sts"
+	"testing"
+)
+
+func TestValidateGrants(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		grant ssm.KeyGrant
+		valid bool
+	}{
+		{"config access key", ssm.KeyGrant{AccessKey: "app", Operations: []string{"Decrypt"}}, true},
+		{"unknown access key", ssm.KeyGrant{AccessKey: "AKIAIAM", Operations: []string{"Decrypt"}}, false},
+		// IAM users may be created after the grant
+		{"user", ssm.KeyGrant{User: "ci", Operations: []string{"Decrypt"}}, true},
+		{"role", ssm.KeyGrant{Role: "deploy", Operations: []string{"Decrypt"}}, true},
+		{"unknown role", ssm.KeyGrant{Role: "other", Operations: []string{"Decrypt"}}, false},
+		{"user and role", ssm.KeyGrant{User: "ci", Role: "deploy", Operations: []string{"Decrypt"}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			config := testConfig()
+			config.Roles = []sts.Role{{Name: "deploy"}}
+			config.Keys[0].Grants = []ssm.KeyGrant{test.grant}
+
+			if err := validateGrants(config); (err == nil) != test.valid {
+				t.Errorf("validateGrants() = %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "home_ssm"
+
+var (
+	ApiRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_requests_total",
+		Help:      "Number of API requests by operation and error code.",
+	}, []string{"operation", "code"})
+
+	ApiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "API request latency by operation and error code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "code"})
+
+	SigV4Failures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sigv4_failures_total",
+		Help:      "Number of requests rejected by SigV4 verification by reason.",
+	}, []string{"reason"})
+
+	Decrypts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "securestring_decrypts_total",
+		Help:      "Number of SecureString and KMS decrypt operations by key id.",
+	}, []string{"key_id"})
+
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Number of webhook delivery attempts by webhook and result.",
+	}, []string{"webhook", "result"})
+)
+
+// SuccessCode is the code label used for requests that didn't return an error.
+const SuccessCode = "OK"
+
+func Handler() http.Handler {
+
+	return promhttp.Handler()
+}
+
+func Register(collector prometheus.Collector) {
+
+	prometheus.MustRegister(collector)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"home-ssm/ssm"
+	"regexp"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// AccountConfig is a tenant with its own credentials, keys and parameters.
+// Its principals can only use the parameter API.
+type AccountConfig struct {
+	Name        string           `yaml:"name"`
+	Id          string           `yaml:"id"`
+	Credentials []SsmCredentials `yaml:"credentials"`
+	Keys        []ssm.KmsKey     `yaml:"keys"`
+}
+
+var accountIdPattern = regexp.MustCompile(`^\d{12}$`)
+
+// namespace holds the parameters of an account in a region, apart from
+// those of the default account in the primary region.
+type namespace struct {
+	AccountId string
+	// Region is empty for the primary region
+	Region string
+	Keys   []ssm.KmsKey
+}
+
+// namespaces lists the parameter namespaces of the additional regions and
+// accounts. Accounts use their keys in every region.
+func namespaces(config *HomeSsmConfig) []namespace {
+
+	var result []namespace
+	for _, region := range config.Regions {
+		result = append(result, namespace{AccountId: ZeroAccountId, Region: region.Name, Keys: regionKeys(config, region)})
+	}
+
+	for _, account := range config.Accounts {
+
+		result = append(result, namespace{AccountId: account.Id, Keys: account.Keys})
+		for _, region := range config.Regions {
+			result = append(result, namespace{AccountId: account.Id, Region: region.Name, Keys: account.Keys})
+		}
+	}
+
+	return result
+}
+
+func (ns namespace) key() string {
+
+	return ns.AccountId + "/" + ns.Region
+}
+
+func (ns namespace) dataStore(db *badger.DB) *ssm.DataStore {
+
+	if ns.AccountId == ZeroAccountId {
+		return ssm.NewRegionDataStore(db, ns.Region, ns.Keys)
+	}
+
+	return ssm.NewAccountDataStore(db, ns.AccountId, ns.Region, ns.Keys)
+}
+
+func (ns namespace) service(config *HomeSsmConfig, dataStore *ssm.DataStore) *ssm.ParameterService {
+
+	return ssm.NewParameterService(cmp.Or(ns.Region, config.Region), ns.AccountId, dataStore)
+}
+
+func validateAccounts(config *HomeSsmConfig) error {
+
+	var errs []error
+	names := make(map[string]bool)
+	ids := map[string]bool{ZeroAccountId: true}
+	for _, account := range config.Accounts {
+
+		if account.Name == "" || names[account.Name] {
+			errs = append(errs, fmt.Errorf("account %q needs a unique name", account.Name))
+		}
+		names[account.Name] = true
+
+		if !accountIdPattern.MatchString(account.Id) {
+			errs = append(errs, fmt.Errorf("account %s: id %q isn't a 12 digit account id", account.Name, account.Id))
+		} else if ids[account.Id] {
+			errs = append(errs, fmt.Errorf("account %s: id %s is already used", account.Name, account.Id))
+		}
+		ids[account.Id] = true
+
+		if len(account.Keys) > 0 {
+			if err := ssm.ValidateKeys(account.Keys); err != nil {
+				errs = append(errs, fmt.Errorf("keys of account %s: %w", account.Name, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// accountIds returns the ids of the accounts besides the default one.
+func accountIds(config *HomeSsmConfig) []string {
+
+	var ids []string
+	for _, account := range config.Accounts {
+		ids = append(ids, account.Id)
+	}
+
+	return ids
+}
+
+// allCredentials returns the credentials of the default account followed
+// by those of the other accounts.
+func allCredentials(config *HomeSsmConfig) []SsmCredentials {
+
+	credentials := slices.Clip(config.Credentials)
+	for _, account := range config.Accounts {
+		credentials = append(credentials, account.Credentials...)
+	}
+
+	return credentials
+}
+
+// accountCredentials returns the credentials of one account.
+func accountCredentials(credentials []aws.Credentials, accountId string) []aws.Credentials {
+
+	return slices.DeleteFunc(slices.Clone(credentials), func(creds aws.Credentials) bool {
+		return creds.AccountID != accountId
+	})
+}
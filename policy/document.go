@@ -0,0 +1,174 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	EffectAllow = "Allow"
+	EffectDeny  = "Deny"
+)
+
+// StringList accepts either a single JSON string or an array of strings,
+// as IAM does for Action, Resource and condition values.
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(data []byte) error {
+
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = StringList{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("expected a string or an array of strings")
+	}
+
+	*l = list
+
+	return nil
+}
+
+// Principal is the Principal element of resource policies: "*" or a map
+// such as {"AWS": ["111111111111", "arn:aws:iam::111111111111:user/bob"]}.
+type Principal map[string]StringList
+
+func (p *Principal) UnmarshalJSON(data []byte) error {
+
+	var wildcard string
+	if err := json.Unmarshal(data, &wildcard); err == nil {
+
+		if wildcard != "*" {
+			return errors.New(`expected "*" or a map of principals`)
+		}
+
+		*p = Principal{"AWS": {"*"}}
+		return nil
+	}
+
+	var principals map[string]StringList
+	if err := json.Unmarshal(data, &principals); err != nil {
+		return err
+	}
+
+	*p = principals
+
+	return nil
+}
+
+// Condition maps an operator such as StringEquals to context keys and values.
+type Condition map[string]map[string]StringList
+
+type Statement struct {
+	Sid         string     `json:"Sid,omitempty"`
+	Effect      string     `json:"Effect"`
+	Principal   Principal  `json:"Principal,omitempty"`
+	Action      StringList `json:"Action,omitempty"`
+	NotAction   StringList `json:"NotAction,omitempty"`
+	Resource    StringList `json:"Resource,omitempty"`
+	NotResource StringList `json:"NotResource,omitempty"`
+	Condition   Condition  `json:"Condition,omitempty"`
+}
+
+type Document struct {
+	Name      string      `json:"-"`
+	Version   string      `json:"Version"`
+	Statement []Statement `json:"Statement"`
+}
+
+// UnmarshalJSON accepts a single statement object in place of the array.
+func (s *Document) UnmarshalJSON(data []byte) error {
+
+	var raw struct {
+		Version   string          `json:"Version"`
+		Statement json.RawMessage `json:"Statement"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	s.Version = raw.Version
+	if len(raw.Statement) > 0 && raw.Statement[0] == '{' {
+
+		var statement Statement
+		if err := json.Unmarshal(raw.Statement, &statement); err != nil {
+			return err
+		}
+
+		s.Statement = []Statement{statement}
+		return nil
+	}
+
+	return json.Unmarshal(raw.Statement, &s.Statement)
+}
+
+// Parse decodes and validates a policy document.
+func Parse(name string, document string) (*Document, error) {
+
+	var result Document
+	if err := json.Unmarshal([]byte(document), &result); err != nil {
+		return nil, fmt.Errorf("policy %s: %w", name, err)
+	}
+
+	result.Name = name
+
+	if len(result.Statement) == 0 {
+		return nil, fmt.Errorf("policy %s: no statements", name)
+	}
+
+	for i, statement := range result.Statement {
+
+		if statement.Effect != EffectAllow && statement.Effect != EffectDeny {
+			return nil, fmt.Errorf("policy %s: statement %d: invalid effect %q", name, i, statement.Effect)
+		}
+
+		if (len(statement.Action) == 0) == (len(statement.NotAction) == 0) {
+			return nil, fmt.Errorf("policy %s: statement %d: exactly one of Action or NotAction is required", name, i)
+		}
+
+		if (len(statement.Resource) == 0) == (len(statement.NotResource) == 0) {
+			return nil, fmt.Errorf("policy %s: statement %d: exactly one of Resource or NotResource is required", name, i)
+		}
+
+		for operator := range statement.Condition {
+
+			if _, ok := lookupOperator(operator); !ok {
+				return nil, fmt.Errorf("policy %s: statement %d: unsupported condition operator %q", name, i, operator)
+			}
+		}
+
+		for _, action := range append(statement.Action, statement.NotAction...) {
+
+			if action != "*" && !strings.Contains(action, ":") {
+				return nil, fmt.Errorf("policy %s: statement %d: invalid action %q", name, i, action)
+			}
+		}
+	}
+
+	return &result, nil
+}
+
+// ParseResourcePolicy decodes and validates a policy attached to a
+// resource, where every statement names the principals it applies to.
+func ParseResourcePolicy(name string, document string) (*Document, error) {
+
+	result, err := Parse(name, document)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, statement := range result.Statement {
+
+		if len(statement.Principal["AWS"]) == 0 {
+			return nil, fmt.Errorf("policy %s: statement %d: an AWS Principal is required", name, i)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,367 @@
+package policy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Request is a single action on a single resource to authorize.
+type Request struct {
+	Action   string
+	Resource string
+	// Context holds condition keys such as ssm:resourceTag/env or kms:KeyId.
+	Context map[string][]string
+}
+
+type Decision int
+
+const (
+	ImplicitDeny Decision = iota
+	Allow
+	ExplicitDeny
+)
+
+// Evaluate applies IAM evaluation logic: an explicit deny in any statement
+// wins, otherwise at least one statement must allow.
+func Evaluate(documents []*Document, request *Request) Decision {
+
+	decision := ImplicitDeny
+	for _, document := range documents {
+
+		for _, statement := range document.Statement {
+
+			if !statement.applies(request) {
+				continue
+			}
+
+			if statement.Effect == EffectDeny {
+				return ExplicitDeny
+			}
+
+			decision = Allow
+		}
+	}
+
+	return decision
+}
+
+func (s *Statement) applies(request *Request) bool {
+
+	if s.Principal != nil && !s.Principal.matches(request.Context) {
+		return false
+	}
+
+	if len(s.Action) > 0 && !matchAny(s.Action, request.Action, true) {
+		return false
+	}
+
+	if len(s.NotAction) > 0 && matchAny(s.NotAction, request.Action, true) {
+		return false
+	}
+
+	if len(s.Resource) > 0 && !matchAny(s.Resource, request.Resource, false) {
+		return false
+	}
+
+	if len(s.NotResource) > 0 && matchAny(s.NotResource, request.Resource, false) {
+		return false
+	}
+
+	for operator, conditions := range s.Condition {
+
+		for key, values := range conditions {
+
+			if !evaluateCondition(operator, request.Context[key], values) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// matches reports whether the caller described by the aws: condition keys
+// is one of the principals: "*", an account id or root ARN matching the
+// whole account, or a principal ARN pattern.
+func (p Principal) matches(context map[string][]string) bool {
+
+	account := firstValue(context["aws:PrincipalAccount"])
+	for _, principal := range p["AWS"] {
+
+		switch {
+		case principal == "*":
+			return true
+		case principal == account, principal == "arn:aws:iam::"+account+":root":
+			if account != "" {
+				return true
+			}
+		case wildcardMatch(principal, firstValue(context["aws:PrincipalArn"])):
+			return true
+		}
+	}
+
+	return false
+}
+
+func firstValue(values []string) string {
+
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func matchAny(patterns []string, value string, ignoreCase bool) bool {
+
+	for _, pattern := range patterns {
+
+		if ignoreCase {
+			if wildcardMatch(strings.ToLower(pattern), strings.ToLower(value)) {
+				return true
+			}
+		} else if wildcardMatch(pattern, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wildcardMatch matches IAM style patterns where * matches any sequence
+// and ? matches a single character.
+func wildcardMatch(pattern string, value string) bool {
+
+	p, v := 0, 0
+	star, mark := -1, 0
+	for v < len(value) {
+
+		if p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]) {
+			p++
+			v++
+		} else if p < len(pattern) && pattern[p] == '*' {
+			star, mark = p, v
+			p++
+		} else if star >= 0 {
+			p = star + 1
+			mark++
+			v = mark
+		} else {
+			return false
+		}
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+type operatorFunc func(actual string, expected string) bool
+
+type operator struct {
+	match operatorFunc
+	// negated operators are true when no value matches
+	negated bool
+}
+
+var operators = map[string]operator{
+	"StringEquals":              {match: func(a, e string) bool { return a == e }},
+	"StringNotEquals":           {match: func(a, e string) bool { return a == e }, negated: true},
+	"StringEqualsIgnoreCase":    {match: strings.EqualFold},
+	"StringNotEqualsIgnoreCase": {match: strings.EqualFold, negated: true},
+	"StringLike":                {match: func(a, e string) bool { return wildcardMatch(e, a) }},
+	"StringNotLike":             {match: func(a, e string) bool { return wildcardMatch(e, a) }, negated: true},
+	"ArnEquals":                 {match: func(a, e string) bool { return a == e }},
+	"ArnNotEquals":              {match: func(a, e string) bool { return a == e }, negated: true},
+	"ArnLike":                   {match: func(a, e string) bool { return wildcardMatch(e, a) }},
+	"ArnNotLike":                {match: func(a, e string) bool { return wildcardMatch(e, a) }, negated: true},
+	"Bool":                      {match: strings.EqualFold},
+	"IpAddress":                 {match: ipMatch},
+	"NotIpAddress":              {match: ipMatch, negated: true},
+}
+
+func lookupOperator(name string) (operator, bool) {
+
+	if name == "Null" {
+		return operator{}, true
+	}
+
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "ForAnyValue:"), "ForAllValues:")
+	op, ok := operators[strings.TrimSuffix(name, "IfExists")]
+
+	return op, ok
+}
+
+func evaluateCondition(name string, actual []string, expected []string) bool {
+
+	if name == "Null" {
+		// "true" means the key must be absent
+		for _, value := range expected {
+			if strings.EqualFold(value, "true") != (len(actual) == 0) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	op, ok := lookupOperator(name)
+	if !ok {
+		return false
+	}
+
+	// as in IAM, ForAllValues holds for a missing key since no value fails
+	if len(actual) == 0 {
+		return strings.HasSuffix(name, "IfExists") || op.negated || strings.HasPrefix(name, "ForAllValues:")
+	}
+
+	matches := func(value string) bool {
+		for _, e := range expected {
+			if op.match(value, e) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if strings.HasPrefix(name, "ForAllValues:") {
+		for _, value := range actual {
+			if matches(value) == op.negated {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, value := range actual {
+		if matches(value) {
+			return !op.negated
+		}
+	}
+
+	return op.negated
+}
+
+func ipMatch(actual string, expected string) bool {
+
+	ip := net.ParseIP(actual)
+	if ip == nil {
+		return false
+	}
+
+	if !strings.Contains(expected, "/") {
+		return ip.Equal(net.ParseIP(expected))
+	}
+
+	_, network, err := net.ParseCIDR(expected)
+
+	return err == nil && network.Contains(ip)
+}
+
+// AccessDeniedError carries the AWS formatted access denied message.
+type AccessDeniedError struct {
+	PrincipalArn string
+	Action       string
+	Resource     string
+	Explicit     bool
+	// ResourceBased denials come from key grants or resource policies
+	ResourceBased bool
+}
+
+func (e *AccessDeniedError) Error() string {
+
+	kind := "identity-based"
+	if e.ResourceBased {
+		kind = "resource-based"
+	}
+
+	reason := "because no " + kind + " policy allows the " + e.Action + " action"
+	if e.Explicit {
+		reason = "with an explicit deny in an " + kind + " policy"
+	}
+
+	return fmt.Sprintf("User: %s is not authorized to perform: %s on resource: %s %s",
+		e.PrincipalArn, e.Action, e.Resource, reason)
+}
+
+// Authorizer holds the policies attached to each access key. Access keys
+// without any attached policy are unrestricted.
+type Authorizer struct {
+	mu       sync.RWMutex
+	policies map[string][]*Document
+}
+
+func NewAuthorizer() *Authorizer {
+
+	return &Authorizer{policies: make(map[string][]*Document)}
+}
+
+func (a *Authorizer) SetPolicies(accessKey string, documents []*Document) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(documents) == 0 {
+		delete(a.policies, accessKey)
+		return
+	}
+
+	a.policies[accessKey] = documents
+}
+
+// Policies returns the policies attached to the access key, if any.
+func (a *Authorizer) Policies(accessKey string) ([]*Document, bool) {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	documents, ok := a.policies[accessKey]
+
+	return documents, ok
+}
+
+func (a *Authorizer) RemovePolicies(accessKey string) {
+
+	a.SetPolicies(accessKey, nil)
+}
+
+// Authorize returns an *AccessDeniedError unless the request is allowed.
+func (a *Authorizer) Authorize(creds *aws.Credentials, principalArn string, request *Request) error {
+
+	a.mu.RLock()
+	documents, restricted := a.policies[creds.AccessKeyID]
+	a.mu.RUnlock()
+
+	if !restricted {
+		return nil
+	}
+
+	decision := Evaluate(documents, request)
+	if decision == Allow {
+		return nil
+	}
+
+	return &AccessDeniedError{
+		PrincipalArn: principalArn,
+		Action:       request.Action,
+		Resource:     request.Resource,
+		Explicit:     decision == ExplicitDeny,
+	}
+}
+
+// GlobalContext returns the aws: condition keys describing the caller.
+func GlobalContext(creds *aws.Credentials, principalArn string, sourceIp string) map[string][]string {
+
+	return map[string][]string{
+		"aws:username":         {creds.Source},
+		"aws:PrincipalArn":     {principalArn},
+		"aws:PrincipalAccount": {creds.AccountID},
+		"aws:SourceIp":         {sourceIp},
+	}
+}
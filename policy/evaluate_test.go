@@ -0,0 +1,301 @@
+package policy
+
+import (
+	"testing"
+)
+
+const testParameterArn = "arn:aws:ssm:us-east-1:000000000000:parameter/app/db/password"
+
+func mustParse(t *testing.T, document string) *Document {
+
+	t.Helper()
+
+	result, err := Parse("test", document)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func TestWildcardMatch(t *testing.T) {
+
+	tests := []struct {
+		pattern string
+		value   string
+		match   bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"ssm:Get*", "ssm:GetParameter", true},
+		{"ssm:Get*", "ssm:PutParameter", false},
+		{"ssm:*Parameter", "ssm:GetParameter", true},
+		{"ssm:*Parameter", "ssm:GetParameters", false},
+		{"ssm:Get?arameter", "ssm:GetParameter", true},
+		{"ssm:Get?arameter", "ssm:Getarameter", false},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXbYcZ", false},
+		{"a*b", "abab", true},
+		{"**", "x", true},
+		{"", "", true},
+		{"", "x", false},
+		{"x", "", false},
+	}
+
+	for _, test := range tests {
+
+		if got := wildcardMatch(test.pattern, test.value); got != test.match {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", test.pattern, test.value, got, test.match)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		documents []string
+		action    string
+		resource  string
+		want      Decision
+	}{
+		{
+			name:      "no statement applies",
+			documents: []string{`{"Statement":{"Effect":"Allow","Action":"ssm:GetParameter","Resource":"*"}}`},
+			action:    "ssm:PutParameter",
+			want:      ImplicitDeny,
+		},
+		{
+			name:      "action wildcard",
+			documents: []string{`{"Statement":{"Effect":"Allow","Action":"ssm:Get*","Resource":"*"}}`},
+			action:    "ssm:GetParametersByPath",
+			want:      Allow,
+		},
+		{
+			name:      "action ignores case",
+			documents: []string{`{"Statement":{"Effect":"Allow","Action":"SSM:getparameter","Resource":"*"}}`},
+			action:    "ssm:GetParameter",
+			want:      Allow,
+		},
+		{
+			name:      "resource wildcard",
+			documents: []string{`{"Statement":{"Effect":"Allow","Action":"*","Resource":"arn:aws:ssm:*:*:parameter/app/*"}}`},
+			action:    "ssm:GetParameter",
+			want:      Allow,
+		},
+		{
+			name:      "resource is case sensitive",
+			documents: []string{`{"Statement":{"Effect":"Allow","Action":"*","Resource":"arn:aws:ssm:*:*:parameter/App/*"}}`},
+			action:    "ssm:GetParameter",
+			want:      ImplicitDeny,
+		},
+		{
+			name: "deny wins over allow",
+			documents: []string{`{"Statement":[
+				{"Effect":"Allow","Action":"ssm:*","Resource":"*"},
+				{"Effect":"Deny","Action":"ssm:GetParameter","Resource":"*"}]}`},
+			action: "ssm:GetParameter",
+			want:   ExplicitDeny,
+		},
+		{
+			name: "deny in another document",
+			documents: []string{
+				`{"Statement":{"Effect":"Deny","Action":"ssm:*","Resource":"arn:aws:ssm:*:*:parameter/app/db/*"}}`,
+				`{"Statement":{"Effect":"Allow","Action":"*","Resource":"*"}}`,
+			},
+			action: "ssm:GetParameter",
+			want:   ExplicitDeny,
+		},
+		{
+			name: "deny of another resource",
+			documents: []string{`{"Statement":[
+				{"Effect":"Allow","Action":"ssm:*","Resource":"*"},
+				{"Effect":"Deny","Action":"ssm:*","Resource":"arn:aws:ssm:*:*:parameter/other/*"}]}`},
+			action: "ssm:GetParameter",
+			want:   Allow,
+		},
+		{
+			name:      "not action excludes",
+			documents: []string{`{"Statement":{"Effect":"Allow","NotAction":"ssm:Delete*","Resource":"*"}}`},
+			action:    "ssm:DeleteParameter",
+			want:      ImplicitDeny,
+		},
+		{
+			name:      "not action allows others",
+			documents: []string{`{"Statement":{"Effect":"Allow","NotAction":"ssm:Delete*","Resource":"*"}}`},
+			action:    "ssm:GetParameter",
+			want:      Allow,
+		},
+		{
+			name: "deny not action",
+			documents: []string{`{"Statement":[
+				{"Effect":"Allow","Action":"*","Resource":"*"},
+				{"Effect":"Deny","NotAction":"ssm:Get*","Resource":"*"}]}`},
+			action: "ssm:PutParameter",
+			want:   ExplicitDeny,
+		},
+		{
+			name:      "not resource excludes",
+			documents: []string{`{"Statement":{"Effect":"Allow","Action":"*","NotResource":"arn:aws:ssm:*:*:parameter/app/db/*"}}`},
+			action:    "ssm:GetParameter",
+			want:      ImplicitDeny,
+		},
+		{
+			name:      "not resource allows others",
+			documents: []string{`{"Statement":{"Effect":"Allow","Action":"*","NotResource":"arn:aws:ssm:*:*:parameter/other/*"}}`},
+			action:    "ssm:GetParameter",
+			want:      Allow,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			var documents []*Document
+			for _, document := range test.documents {
+				documents = append(documents, mustParse(t, document))
+			}
+
+			resource := test.resource
+			if resource == "" {
+				resource = testParameterArn
+			}
+
+			if got := Evaluate(documents, &Request{Action: test.action, Resource: resource}); got != test.want {
+				t.Errorf("Evaluate() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateCondition(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		condition string
+		context   map[string][]string
+		allowed   bool
+	}{
+		{
+			name:      "string equals",
+			condition: `{"StringEquals":{"ssm:resourceTag/env":"prod"}}`,
+			context:   map[string][]string{"ssm:resourceTag/env": {"prod"}},
+			allowed:   true,
+		},
+		{
+			name:      "string equals other value",
+			condition: `{"StringEquals":{"ssm:resourceTag/env":"prod"}}`,
+			context:   map[string][]string{"ssm:resourceTag/env": {"dev"}},
+		},
+		{
+			name:      "string equals missing key",
+			condition: `{"StringEquals":{"ssm:resourceTag/env":"prod"}}`,
+		},
+		{
+			name:      "string not equals missing key",
+			condition: `{"StringNotEquals":{"ssm:resourceTag/env":"prod"}}`,
+			allowed:   true,
+		},
+		{
+			name:      "if exists missing key",
+			condition: `{"StringEqualsIfExists":{"ssm:resourceTag/env":"prod"}}`,
+			allowed:   true,
+		},
+		{
+			name:      "if exists other value",
+			condition: `{"StringEqualsIfExists":{"ssm:resourceTag/env":"prod"}}`,
+			context:   map[string][]string{"ssm:resourceTag/env": {"dev"}},
+		},
+		{
+			name:      "if exists matching value",
+			condition: `{"StringLikeIfExists":{"ssm:resourceTag/env":"pr*"}}`,
+			context:   map[string][]string{"ssm:resourceTag/env": {"prod"}},
+			allowed:   true,
+		},
+		{
+			name:      "null true missing key",
+			condition: `{"Null":{"ssm:resourceTag/env":"true"}}`,
+			allowed:   true,
+		},
+		{
+			name:      "null true present key",
+			condition: `{"Null":{"ssm:resourceTag/env":"true"}}`,
+			context:   map[string][]string{"ssm:resourceTag/env": {"prod"}},
+		},
+		{
+			name:      "null false missing key",
+			condition: `{"Null":{"ssm:resourceTag/env":"false"}}`,
+		},
+		{
+			name:      "null false present key",
+			condition: `{"Null":{"ssm:resourceTag/env":"false"}}`,
+			context:   map[string][]string{"ssm:resourceTag/env": {"prod"}},
+			allowed:   true,
+		},
+		{
+			name:      "for any value one matches",
+			condition: `{"ForAnyValue:StringEquals":{"aws:TagKeys":["env","team"]}}`,
+			context:   map[string][]string{"aws:TagKeys": {"owner", "env"}},
+			allowed:   true,
+		},
+		{
+			name:      "for any value none matches",
+			condition: `{"ForAnyValue:StringEquals":{"aws:TagKeys":["env","team"]}}`,
+			context:   map[string][]string{"aws:TagKeys": {"owner"}},
+		},
+		{
+			name:      "for any value missing key",
+			condition: `{"ForAnyValue:StringEquals":{"aws:TagKeys":["env","team"]}}`,
+		},
+		{
+			name:      "for all values all match",
+			condition: `{"ForAllValues:StringEquals":{"aws:TagKeys":["env","team"]}}`,
+			context:   map[string][]string{"aws:TagKeys": {"team", "env"}},
+			allowed:   true,
+		},
+		{
+			name:      "for all values one doesn't match",
+			condition: `{"ForAllValues:StringEquals":{"aws:TagKeys":["env","team"]}}`,
+			context:   map[string][]string{"aws:TagKeys": {"env", "owner"}},
+		},
+		{
+			name:      "for all values missing key",
+			condition: `{"ForAllValues:StringEquals":{"aws:TagKeys":["env","team"]}}`,
+			allowed:   true,
+		},
+		{
+			name:      "for all values not equals",
+			condition: `{"ForAllValues:StringNotEquals":{"aws:TagKeys":["owner"]}}`,
+			context:   map[string][]string{"aws:TagKeys": {"env", "team"}},
+			allowed:   true,
+		},
+		{
+			name:      "ip address in network",
+			condition: `{"IpAddress":{"aws:SourceIp":"10.0.0.0/8"}}`,
+			context:   map[string][]string{"aws:SourceIp": {"10.1.2.3"}},
+			allowed:   true,
+		},
+		{
+			name:      "not ip address in network",
+			condition: `{"NotIpAddress":{"aws:SourceIp":"10.0.0.0/8"}}`,
+			context:   map[string][]string{"aws:SourceIp": {"10.1.2.3"}},
+		},
+		{
+			name: "every operator must hold",
+			condition: `{"StringEquals":{"ssm:resourceTag/env":"prod"},
+				"IpAddress":{"aws:SourceIp":"192.168.0.0/16"}}`,
+			context: map[string][]string{"ssm:resourceTag/env": {"prod"}, "aws:SourceIp": {"10.1.2.3"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			document := mustParse(t, `{"Statement":{"Effect":"Allow","Action":"*","Resource":"*","Condition":`+test.condition+`}}`)
+			request := Request{Action: "ssm:GetParameter", Resource: testParameterArn, Context: test.context}
+			if got := Evaluate([]*Document{document}, &request); (got == Allow) != test.allowed {
+				t.Errorf("Evaluate() = %v, want allowed %v", got, test.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"home-ssm/awslib"
+	"home-ssm/iam"
+	"home-ssm/policy"
+	"home-ssm/secrets"
+	"home-ssm/ssm"
+	"home-ssm/sts"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// reloadInterval is how often the config file is checked for changes.
+const reloadInterval = 2 * time.Second
+
+// configReloader applies changes of the config file on SIGHUP or when the
+// file is modified. Credentials, policies, roles and keys are swapped in
+// while running; other settings, including the region, need a restart.
+type configReloader struct {
+	path      string
+	keyring   *awslib.Keyring
+	dataStore *ssm.DataStore
+	// stores are the stores of the other namespaces by key
+	stores     map[string]*ssm.DataStore
+	authorizer *policy.Authorizer
+	sessions   *sts.SessionStore
+	stsApi     *sts.Api
+	iamApi     *iam.Api
+	secretsApi *secrets.Api
+	// started is the config the server started with, current the one
+	// last applied
+	started *HomeSsmConfig
+	current atomic.Pointer[HomeSsmConfig]
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+func newConfigReloader(path string, config *HomeSsmConfig) *configReloader {
+
+	reloader := configReloader{path: path, started: config}
+	reloader.current.Store(config)
+	if info, err := os.Stat(path); err == nil {
+		reloader.modTime = info.ModTime()
+	}
+
+	return &reloader
+}
+
+func (reloader *configReloader) config() *HomeSsmConfig {
+
+	return reloader.current.Load()
+}
+
+func (reloader *configReloader) run(ctx context.Context) {
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			reloader.reload(ctx)
+		case <-ticker.C:
+			if info, err := os.Stat(reloader.path); err == nil && !info.ModTime().Equal(reloader.modTime) {
+				reloader.reload(ctx)
+			}
+		}
+	}
+}
+
+// reload applies the config file, keeping the current config if the new
+// one is invalid.
+func (reloader *configReloader) reload(ctx context.Context) {
+
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+
+	if info, err := os.Stat(reloader.path); err == nil {
+		reloader.modTime = info.ModTime()
+	}
+
+	config, err := readConfig(reloader.path)
+	if err == nil {
+		err = reloader.apply(ctx, config)
+	}
+
+	if err != nil {
+		slog.Error("Config reload failed, keeping the current config", "path", reloader.path, "error", err)
+	}
+}
+
+func (reloader *configReloader) apply(ctx context.Context, config *HomeSsmConfig) error {
+
+	current := reloader.current.Load()
+
+	if err := validateConfig(config); err != nil {
+		return err
+	}
+
+	if !slices.Equal(accountIds(config), accountIds(reloader.started)) {
+		return errors.New("adding or removing accounts needs a restart")
+	}
+
+	credentials := configCredentials(config)
+	iamCredentials := accountCredentials(credentials, ZeroAccountId)
+	var policies []string
+	for _, cfg := range config.Policies {
+		policies = append(policies, cfg.Name)
+	}
+
+	if err := reloader.iamApi.ValidateConfig(ctx, iamCredentials, policies); err != nil {
+		return err
+	}
+
+	iamReferences, err := reloader.iamApi.KeyReferences(ctx)
+	if err != nil {
+		return err
+	}
+
+	secretReferences, err := reloader.secretsApi.KeyReferences(ctx)
+	if err != nil {
+		return err
+	}
+
+	// compiled before the first change, so only storing them can fail
+	attached, err := compilePolicies(config)
+	if err != nil {
+		return err
+	}
+
+	previous, err := compilePolicies(current)
+	if err != nil {
+		return err
+	}
+
+	// the first change, it fails if a key in use would be removed
+	references := append(iamReferences, secretReferences...)
+	if err := reloader.dataStore.SetKeys(ctx, config.Keys, references); err != nil {
+		return err
+	}
+
+	if err := reloader.setNamespaceKeys(ctx, current, config); err != nil {
+		reloader.dataStore.SetKeys(ctx, current.Keys, references)
+		return err
+	}
+
+	// attach the new policies before adding keys and drop removed ones after
+	for key, documents := range attached {
+		reloader.authorizer.SetPolicies(key, documents)
+	}
+
+	if err := reloader.iamApi.SetConfigCredentials(ctx, iamCredentials); err != nil {
+
+		for key := range attached {
+			if _, ok := previous[key]; !ok {
+				reloader.authorizer.RemovePolicies(key)
+			}
+		}
+
+		for key, documents := range previous {
+			reloader.authorizer.SetPolicies(key, documents)
+		}
+
+		reloader.iamApi.SetConfigCredentials(ctx, accountCredentials(configCredentials(current), ZeroAccountId))
+		reloader.setNamespaceKeys(ctx, config, current)
+		reloader.dataStore.SetKeys(ctx, current.Keys, references)
+
+		return err
+	}
+
+	_, removedPolicies, _ := diffConfig(current.Policies, config.Policies, func(cfg PolicyConfig) string { return cfg.Name })
+	for _, name := range removedPolicies {
+		reloader.authorizer.RemovePolicies(iam.PolicyKey(name))
+	}
+
+	_, removedKeys, _ := diffConfig(allCredentials(current), allCredentials(config), func(cred SsmCredentials) string { return cred.AccessKey })
+	reloader.keyring.Replace(removedKeys, credentials)
+	for _, accessKey := range removedKeys {
+		reloader.authorizer.RemovePolicies(accessKey)
+	}
+
+	_, removedRoles, changedRoles := diffConfig(current.Roles, config.Roles, func(role sts.Role) string { return role.Name })
+	reloader.stsApi.SetRoles(config.Roles)
+	for _, name := range removedRoles {
+		role := sts.Role{Name: name}
+		reloader.authorizer.RemovePolicies(role.PolicyKey())
+	}
+
+	// sessions of a removed key or role would otherwise stay valid until
+	// they expire, and those of a changed role keep its old policies
+	reloader.sessions.Revoke(removedKeys)
+	reloader.sessions.RevokeRoles(append(removedRoles, changedRoles...))
+
+	reloader.current.Store(config)
+	logConfigDiff(reloader.started, current, config)
+
+	return nil
+}
+
+// setNamespaceKeys replaces the keys of the other regions and accounts,
+// restoring those already replaced if a key in use would be removed.
+// Regions added or removed since the start need a restart.
+func (reloader *configReloader) setNamespaceKeys(ctx context.Context, current *HomeSsmConfig, config *HomeSsmConfig) error {
+
+	previous := make(map[string][]ssm.KmsKey)
+	for _, ns := range namespaces(current) {
+		previous[ns.key()] = ns.Keys
+	}
+
+	var updated []string
+	for _, ns := range namespaces(config) {
+
+		store, ok := reloader.stores[ns.key()]
+		if !ok {
+			continue
+		}
+
+		if err := store.SetKeys(ctx, ns.Keys, nil); err != nil {
+
+			for _, key := range updated {
+				reloader.stores[key].SetKeys(ctx, previous[key], nil)
+			}
+
+			return fmt.Errorf("account %s, region %s: %w", ns.AccountId, cmp.Or(ns.Region, config.Region), err)
+		}
+
+		updated = append(updated, ns.key())
+	}
+
+	return nil
+}
+
+// logConfigDiff logs what a reload changed, and the changed settings that
+// only take effect after a restart.
+func logConfigDiff(started *HomeSsmConfig, current *HomeSsmConfig, config *HomeSsmConfig) {
+
+	credsAdded, credsRemoved, credsChanged := diffConfig(allCredentials(current), allCredentials(config),
+		func(cred SsmCredentials) string { return cred.AccessKey })
+	keysAdded, keysRemoved, keysChanged := diffConfig(current.Keys, config.Keys,
+		func(key ssm.KmsKey) string { return "alias/" + key.Alias })
+	policiesAdded, policiesRemoved, policiesChanged := diffConfig(current.Policies, config.Policies,
+		func(cfg PolicyConfig) string { return cfg.Name })
+	rolesAdded, rolesRemoved, rolesChanged := diffConfig(current.Roles, config.Roles,
+		func(role sts.Role) string { return role.Name })
+
+	slog.Info("Config reloaded",
+		"credentials_added", credsAdded, "credentials_removed", credsRemoved, "credentials_changed", credsChanged,
+		"keys_added", keysAdded, "keys_removed", keysRemoved, "keys_changed", keysChanged,
+		"policies_added", policiesAdded, "policies_removed", policiesRemoved, "policies_changed", policiesChanged,
+		"roles_added", rolesAdded, "roles_removed", rolesRemoved, "roles_changed", rolesChanged)
+
+	restartOnly := map[string][2]any{
+		"region":           {started.Region, config.Region},
+		"regions":          {regionNames(started), regionNames(config)},
+		"tracing":          {started.Tracing, config.Tracing},
+		"logging":          {started.Logging, config.Logging},
+		"audit":            {started.Audit, config.Audit},
+		"replayProtection": {started.Replay, config.Replay},
+		"lockout":          {started.Lockout, config.Lockout},
+		"rateLimits":       {started.RateLimits, config.RateLimits},
+		"faults":           {started.Faults, config.Faults},
+		"iam":              {started.Iam, config.Iam},
+		"kms":              {started.Kms, config.Kms},
+		"webhooks":         {started.Webhooks, config.Webhooks},
+		"watch":            {started.Watch, config.Watch},
+	}
+
+	var changed []string
+	for name, values := range restartOnly {
+		if !reflect.DeepEqual(values[0], values[1]) {
+			changed = append(changed, name)
+		}
+	}
+
+	if len(changed) > 0 {
+		slices.Sort(changed)
+		slog.Warn("Config changes take effect after a restart", "settings", changed)
+	}
+}
+
+// diffConfig compares two lists of config entries by id.
+func diffConfig[T any](current []T, config []T, id func(T) string) (added []string, removed []string, changed []string) {
+
+	entries := make(map[string]T)
+	for _, entry := range current {
+		entries[id(entry)] = entry
+	}
+
+	for _, entry := range config {
+
+		previous, ok := entries[id(entry)]
+		if !ok {
+			added = append(added, id(entry))
+		} else if !reflect.DeepEqual(previous, entry) {
+			changed = append(changed, id(entry))
+		}
+
+		delete(entries, id(entry))
+	}
+
+	for _, entry := range current {
+		if _, ok := entries[id(entry)]; ok {
+			removed = append(removed, id(entry))
+		}
+	}
+
+	return added, removed, changed
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"home-ssm/awslib"
+	"home-ssm/iam"
+	"home-ssm/secrets"
+	"home-ssm/ssm"
+	"home-ssm/sts"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const testKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+func testConfig() *HomeSsmConfig {
+
+	return &HomeSsmConfig{
+		Region: "us-east-1",
+		Credentials: []SsmCredentials{
+			{AccessKey: "admin", SecretKey: "admin-secret", Username: "admin"},
+			{AccessKey: "app", SecretKey: "app-secret", Username: "app", Policies: []string{"read"}},
+		},
+		Keys: []ssm.KmsKey{{KeyId: "k1", Alias: "one", Key: testKey}},
+		Policies: []PolicyConfig{{Name: "read", Document: `{"Statement":[
+			{"Effect":"Allow","Action":"ssm:GetParameter","Resource":"*"}]}`}},
+	}
+}
+
+func newTestReloader(t *testing.T, config *HomeSsmConfig) (*configReloader, *badger.DB) {
+
+	t.Helper()
+
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	reloader := newConfigReloader(t.TempDir()+"/config.yaml", config)
+	reloader.keyring = awslib.NewKeyring(configCredentials(config))
+	reloader.dataStore = ssm.NewDataStore(db, config.Keys)
+	reloader.stores = make(map[string]*ssm.DataStore)
+	reloader.authorizer = createAuthorizerOrDie(config)
+	reloader.sessions = sts.NewSessionStore(reloader.authorizer)
+	reloader.stsApi = sts.NewApi(config.Region, ZeroAccountId, config.Roles, reloader.sessions, reloader.authorizer)
+	reloader.iamApi = iam.NewApi(config.Region, ZeroAccountId, config.Iam, iam.NewStore(db), reloader.dataStore,
+		reloader.keyring, reloader.authorizer, reloader.sessions, accountCredentials(configCredentials(config), ZeroAccountId))
+	reloader.secretsApi = secrets.NewApi(config.Region, ZeroAccountId, secrets.NewStore(db), reloader.dataStore, reloader.authorizer)
+
+	return reloader, db
+}
+
+func TestReloadApply(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		change func(config *HomeSsmConfig)
+		// setup breaks the stores before the reload
+		setup   func(t *testing.T, db *badger.DB)
+		applied bool
+	}{
+		{
+			name: "remove credential and add key",
+			change: func(config *HomeSsmConfig) {
+				config.Credentials = config.Credentials[:1]
+				config.Keys = append(config.Keys, ssm.KmsKey{KeyId: "k2", Alias: "two", Key: testKey})
+			},
+			applied: true,
+		},
+		{
+			name: "unknown policy",
+			change: func(config *HomeSsmConfig) {
+				config.Credentials = config.Credentials[:1]
+				config.Credentials[0].Policies = []string{"missing"}
+			},
+		},
+		{
+			name: "iam store fails",
+			change: func(config *HomeSsmConfig) {
+				config.Credentials = config.Credentials[:1]
+				config.Keys = append(config.Keys, ssm.KmsKey{KeyId: "k2", Alias: "two", Key: testKey})
+			},
+			// a stored access key whose user is missing fails applying the
+			// permissions boundaries, after the keys were swapped
+			setup: func(t *testing.T, db *badger.DB) {
+
+				err := db.Update(func(txn *badger.Txn) error {
+					return txn.Set([]byte("iam/key/AKIAORPHAN"),
+						[]byte(`{"AccessKeyId":"AKIAORPHAN","UserName":"ghost","KeyId":"k1","Status":"Active"}`))
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			current := testConfig()
+			reloader, db := newTestReloader(t, current)
+			if test.setup != nil {
+				test.setup(t, db)
+			}
+
+			config := testConfig()
+			test.change(config)
+			err := reloader.apply(context.Background(), config)
+			if (err == nil) != test.applied {
+				t.Fatalf("apply() = %v, want applied %v", err, test.applied)
+			}
+
+			want := current
+			if test.applied {
+				want = config
+			}
+
+			if reloader.config() != want {
+				t.Error("the current config wasn't updated as expected")
+			}
+
+			_, found := reloader.keyring.Lookup("app")
+			_, attached := reloader.authorizer.Policies("app")
+			if found != !test.applied || attached != !test.applied {
+				t.Errorf("access key app: in keyring %v, policies attached %v, want %v", found, attached, !test.applied)
+			}
+
+			if _, err := reloader.dataStore.FindKey("k2"); (err == nil) != test.applied {
+				t.Errorf("FindKey(k2) = %v, want found %v", err, test.applied)
+			}
+
+			if documents, _ := reloader.authorizer.Policies("admin"); len(documents) != 0 {
+				t.Error("policies of a failed reload are still attached to access key admin")
+			}
+		})
+	}
+}
+
+var accessKeyPattern = regexp.MustCompile(`<AccessKeyId>(\w+)</AccessKeyId>`)
+
+// assumeRole assumes the role as access key admin and returns the access key
+// of the session, empty if it was denied.
+func assumeRole(t *testing.T, reloader *configReloader, roleName string) string {
+
+	t.Helper()
+
+	admin, ok := reloader.keyring.Lookup("admin")
+	if !ok {
+		t.Fatal("access key admin isn't in the keyring")
+	}
+
+	handler := awslib.WithRequestId(func(w http.ResponseWriter, r *http.Request) {
+		awslib.GetRequestInfo(r.Context()).Principal = admin
+		reloader.stsApi.Handle(w, r)
+	})
+
+	params := url.Values{
+		"Action":          {"AssumeRole"},
+		"RoleArn":         {"arn:aws:iam::" + ZeroAccountId + ":role/" + roleName},
+		"RoleSessionName": {"test"},
+	}
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(params.Encode())))
+
+	if match := accessKeyPattern.FindStringSubmatch(w.Body.String()); w.Code == http.StatusOK && match != nil {
+		return match[1]
+	}
+
+	return ""
+}
+
+func TestReloadRoles(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		change func(config *HomeSsmConfig)
+		// assumable and kept are whether role ops can be assumed after the
+		// reload and whether its session from before stays valid
+		assumable bool
+		kept      bool
+		attached  bool
+	}{
+		{
+			name:      "unchanged",
+			change:    func(config *HomeSsmConfig) {},
+			assumable: true,
+			kept:      true,
+			attached:  true,
+		},
+		{
+			name: "role added",
+			change: func(config *HomeSsmConfig) {
+				config.Roles = append(config.Roles, sts.Role{Name: "new", Principals: []string{"admin"}})
+			},
+			assumable: true,
+			kept:      true,
+			attached:  true,
+		},
+		{
+			name: "role removed",
+			change: func(config *HomeSsmConfig) {
+				config.Roles = nil
+			},
+		},
+		{
+			name: "principals changed",
+			change: func(config *HomeSsmConfig) {
+				config.Roles[0].Principals = []string{"app"}
+			},
+			attached: true,
+		},
+		{
+			name: "policies changed",
+			change: func(config *HomeSsmConfig) {
+				config.Roles[0].Policies = nil
+			},
+			assumable: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			current := testConfig()
+			current.Roles = []sts.Role{{Name: "ops", Principals: []string{"admin"}, Policies: []string{"read"}}}
+			reloader, _ := newTestReloader(t, current)
+
+			session := assumeRole(t, reloader, "ops")
+			if session == "" {
+				t.Fatal("role ops can't be assumed before the reload")
+			}
+
+			config := testConfig()
+			config.Roles = []sts.Role{{Name: "ops", Principals: []string{"admin"}, Policies: []string{"read"}}}
+			test.change(config)
+			if err := reloader.apply(context.Background(), config); err != nil {
+				t.Fatal(err)
+			}
+
+			if assumed := assumeRole(t, reloader, "ops"); (assumed != "") != test.assumable {
+				t.Errorf("role ops assumable %v, want %v", assumed != "", test.assumable)
+			}
+
+			if _, ok := reloader.sessions.Session(session); ok != test.kept {
+				t.Errorf("session of role ops valid %v, want %v", ok, test.kept)
+			}
+
+			role := sts.Role{Name: "ops"}
+			if _, attached := reloader.authorizer.Policies(role.PolicyKey()); attached != test.attached {
+				t.Errorf("policies of role ops attached %v, want %v", attached, test.attached)
+			}
+
+			for _, role := range config.Roles[min(1, len(config.Roles)):] {
+				if assumeRole(t, reloader, role.Name) == "" {
+					t.Errorf("added role %s can't be assumed", role.Name)
+				}
+			}
+		})
+	}
+}
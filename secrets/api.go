@@ -0,0 +1,748 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"home-ssm/awslib"
+	"home-ssm/metrics"
+	"home-ssm/policy"
+	"home-ssm/ssm"
+	"home-ssm/tracing"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRecoveryWindow = 30
+	minRecoveryWindow     = 7
+	maxListResults        = 100
+	maxTags               = 50
+	arnSuffixAlphabet     = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9/_+=.@-]{1,512}$`)
+
+var knownOperations = []string{
+	"CreateSecret",
+	"DeleteSecret",
+	"DescribeSecret",
+	"GetSecretValue",
+	"ListSecrets",
+	"PutSecretValue",
+	"RestoreSecret",
+	"TagResource",
+}
+
+// Api serves the Secrets Manager JSON 1.1 protocol. Secret values are
+// encrypted with the keys of the DataStore.
+type Api struct {
+	region     string
+	accountId  string
+	store      *Store
+	dataStore  *ssm.DataStore
+	authorizer *policy.Authorizer
+}
+
+func NewApi(region string, accountId string, store *Store, dataStore *ssm.DataStore, authorizer *policy.Authorizer) *Api {
+
+	return &Api{region: region, accountId: accountId, store: store, dataStore: dataStore, authorizer: authorizer}
+}
+
+func (api *Api) Handle(w http.ResponseWriter, r *http.Request) {
+
+	amztarget := r.Header.Get("X-Amz-Target")
+	operation := strings.TrimPrefix(amztarget, "secretsmanager.")
+
+	recorder := awslib.NewStatusRecorder(w)
+	defer awslib.ObserveRequest("secretsmanager", operation, knownOperations, recorder, time.Now())
+	w = recorder
+
+	ctx, span := tracing.Start(r.Context(), "SecretsManager.Dispatch", tracing.Operation(amztarget))
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	var response any
+	var err error
+	switch operation {
+	case "CreateSecret":
+		response, err = handle(r, api.createSecret)
+	case "GetSecretValue":
+		response, err = handle(r, api.getSecretValue)
+	case "PutSecretValue":
+		response, err = handle(r, api.putSecretValue)
+	case "DescribeSecret":
+		response, err = handle(r, api.describeSecret)
+	case "ListSecrets":
+		response, err = handle(r, api.listSecrets)
+	case "DeleteSecret":
+		response, err = handle(r, api.deleteSecret)
+	case "RestoreSecret":
+		response, err = handle(r, api.restoreSecret)
+	case "TagResource":
+		response, err = handle(r, api.tagResource)
+	default:
+		awslib.Logger(r.Context()).Warn("unknown target")
+		err = ErrUnsupportedOperation
+	}
+
+	if err != nil {
+
+		span.SetAttributes(tracing.ResultCode(translateToApiError(err).Code))
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
+		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.region)
+		return
+	}
+
+	span.SetAttributes(tracing.ResultCode(metrics.SuccessCode))
+	awslib.WriteSuccessResponseJSON(w, response)
+}
+
+// handle decodes the JSON request body and calls the operation.
+func handle[Request any, Response any](r *http.Request, operation func(context.Context, *Request) (Response, error)) (any, error) {
+
+	var request Request
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, ErrInvalidRequest
+	}
+
+	return operation(r.Context(), &request)
+}
+
+func (api *Api) createSecret(ctx context.Context, request *CreateSecretRequest) (*CreateSecretResponse, error) {
+
+	if !namePattern.MatchString(request.Name) {
+		return nil, ErrInvalidName
+	}
+
+	if err := validateTags(request.Tags); err != nil {
+		return nil, err
+	}
+
+	suffix, err := newArnSuffix()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	secret := Secret{
+		Name:            request.Name,
+		ArnSuffix:       suffix,
+		Description:     request.Description,
+		KmsKeyId:        request.KmsKeyId,
+		Tags:            request.Tags,
+		CreatedDate:     now,
+		LastChangedDate: now,
+	}
+
+	if err := api.authorize(ctx, "CreateSecret", api.arn(&secret)); err != nil {
+		return nil, err
+	}
+
+	response := CreateSecretResponse{ARN: api.arn(&secret), Name: secret.Name}
+
+	// a secret can be created without a value
+	if request.SecretString != nil || request.SecretBinary != nil {
+
+		version, err := api.newVersion(ctx, &secret, request.ClientRequestToken, request.SecretString, request.SecretBinary, []string{StageCurrent})
+		if err != nil {
+			return nil, err
+		}
+
+		secret.addVersion(*version)
+		response.VersionId = version.VersionId
+	}
+
+	if err := api.store.create(ctx, &secret); err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+func (api *Api) getSecretValue(ctx context.Context, request *GetSecretValueRequest) (*GetSecretValueResponse, error) {
+
+	secret, err := api.findSecret(ctx, request.SecretId, "GetSecretValue")
+	if err != nil {
+		return nil, err
+	}
+
+	if secret.DeletionDate != nil {
+		return nil, ErrScheduledForDeletion
+	}
+
+	stage := request.VersionStage
+	if stage == "" && request.VersionId == "" {
+		stage = StageCurrent
+	}
+
+	version := secret.version(request.VersionId, stage)
+	if version == nil {
+		return nil, ErrVersionNotFound
+	}
+
+	secretString, secretBinary, err := api.decryptVersion(ctx, secret, version)
+	if err != nil {
+		return nil, err
+	}
+
+	api.recordAccess(ctx, secret)
+
+	return &GetSecretValueResponse{
+		ARN:           api.arn(secret),
+		Name:          secret.Name,
+		VersionId:     version.VersionId,
+		SecretString:  secretString,
+		SecretBinary:  secretBinary,
+		VersionStages: version.Stages,
+		CreatedDate:   epochSeconds(version.CreatedDate),
+	}, nil
+}
+
+func (api *Api) putSecretValue(ctx context.Context, request *PutSecretValueRequest) (*PutSecretValueResponse, error) {
+
+	secret, err := api.findSecret(ctx, request.SecretId, "PutSecretValue")
+	if err != nil {
+		return nil, err
+	}
+
+	stages := request.VersionStages
+	if len(stages) == 0 {
+		stages = []string{StageCurrent}
+	}
+
+	var version *Version
+	secret, err = api.store.update(ctx, secret.Name, func(secret *Secret) error {
+
+		if secret.DeletionDate != nil {
+			return ErrScheduledForDeletion
+		}
+
+		var err error
+		version, err = api.newVersion(ctx, secret, request.ClientRequestToken, request.SecretString, request.SecretBinary, stages)
+		if err != nil {
+			return err
+		}
+
+		// a retried request returns the existing version unchanged
+		if secret.version(version.VersionId, "") == nil {
+			secret.addVersion(*version)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PutSecretValueResponse{
+		ARN:           api.arn(secret),
+		Name:          secret.Name,
+		VersionId:     version.VersionId,
+		VersionStages: version.Stages,
+	}, nil
+}
+
+func (api *Api) describeSecret(ctx context.Context, request *SecretIdRequest) (*DescribeSecretResponse, error) {
+
+	secret, err := api.findSecret(ctx, request.SecretId, "DescribeSecret")
+	if err != nil {
+		return nil, err
+	}
+
+	return api.describe(secret), nil
+}
+
+func (api *Api) listSecrets(ctx context.Context, request *ListSecretsRequest) (*ListSecretsResponse, error) {
+
+	if err := api.authorize(ctx, "ListSecrets", "*"); err != nil {
+		return nil, err
+	}
+
+	for _, filter := range request.Filters {
+		if !slices.Contains([]string{"name", "description", "tag-key", "tag-value", "all"}, filter.Key) {
+			return nil, ErrInvalidFilter
+		}
+	}
+
+	secrets, err := api.store.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets = slices.DeleteFunc(secrets, func(secret Secret) bool {
+		return secret.DeletionDate != nil && !request.IncludePlannedDeletion || !matchesFilters(&secret, request.Filters)
+	})
+
+	if request.SortOrder == "desc" {
+		slices.Reverse(secrets)
+	}
+
+	limit := maxListResults
+	if request.MaxResults != nil {
+		limit = min(max(*request.MaxResults, 1), maxListResults)
+	}
+
+	start := 0
+	if request.NextToken != "" {
+
+		start, err = strconv.Atoi(request.NextToken)
+		if err != nil || start < 0 || start > len(secrets) {
+			return nil, ErrInvalidNextToken
+		}
+	}
+
+	end := min(start+limit, len(secrets))
+	response := ListSecretsResponse{SecretList: []DescribeSecretResponse{}}
+	for i := range secrets[start:end] {
+		response.SecretList = append(response.SecretList, *api.describe(&secrets[start+i]))
+	}
+
+	if end < len(secrets) {
+		response.NextToken = strconv.Itoa(end)
+	}
+
+	return &response, nil
+}
+
+func (api *Api) deleteSecret(ctx context.Context, request *DeleteSecretRequest) (*DeleteSecretResponse, error) {
+
+	if request.ForceDeleteWithoutRecovery && request.RecoveryWindowInDays != nil {
+		return nil, ErrInvalidWindow
+	}
+
+	window := defaultRecoveryWindow
+	if request.RecoveryWindowInDays != nil {
+		window = *request.RecoveryWindowInDays
+	}
+
+	if window < minRecoveryWindow || window > defaultRecoveryWindow {
+		return nil, ErrInvalidWindow
+	}
+
+	secret, err := api.findSecret(ctx, request.SecretId, "DeleteSecret")
+	if err != nil {
+		return nil, err
+	}
+
+	if request.ForceDeleteWithoutRecovery {
+
+		if err := api.store.delete(ctx, secret.Name); err != nil {
+			return nil, err
+		}
+
+		return &DeleteSecretResponse{ARN: api.arn(secret), Name: secret.Name, DeletionDate: epochSeconds(time.Now())}, nil
+	}
+
+	secret, err = api.store.update(ctx, secret.Name, func(secret *Secret) error {
+
+		if secret.DeletionDate != nil {
+			return ErrScheduledForDeletion
+		}
+
+		deletionDate := time.Now().UTC().AddDate(0, 0, window)
+		secret.DeletionDate = &deletionDate
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeleteSecretResponse{ARN: api.arn(secret), Name: secret.Name, DeletionDate: epochSeconds(*secret.DeletionDate)}, nil
+}
+
+func (api *Api) restoreSecret(ctx context.Context, request *SecretIdRequest) (*RestoreSecretResponse, error) {
+
+	secret, err := api.findSecret(ctx, request.SecretId, "RestoreSecret")
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err = api.store.update(ctx, secret.Name, func(secret *Secret) error {
+
+		if secret.DeletionDate == nil {
+			return ErrNotScheduled
+		}
+
+		secret.DeletionDate = nil
+		secret.LastChangedDate = time.Now().UTC()
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RestoreSecretResponse{ARN: api.arn(secret), Name: secret.Name}, nil
+}
+
+func (api *Api) tagResource(ctx context.Context, request *TagResourceRequest) (*EmptyResponse, error) {
+
+	if err := validateTags(request.Tags); err != nil {
+		return nil, err
+	}
+
+	secret, err := api.findSecret(ctx, request.SecretId, "TagResource")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = api.store.update(ctx, secret.Name, func(secret *Secret) error {
+
+		if secret.DeletionDate != nil {
+			return ErrScheduledForDeletion
+		}
+
+		for _, tag := range request.Tags {
+
+			i := slices.IndexFunc(secret.Tags, func(existing Tag) bool { return existing.Key == tag.Key })
+			if i >= 0 {
+				secret.Tags[i] = tag
+			} else {
+				secret.Tags = append(secret.Tags, tag)
+			}
+		}
+
+		if len(secret.Tags) > maxTags {
+			return ErrInvalidTags
+		}
+
+		secret.LastChangedDate = time.Now().UTC()
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &EmptyResponse{}, nil
+}
+
+// KeyReferences returns the key of every secret, so a config reload can't
+// remove a key still in use.
+func (api *Api) KeyReferences(ctx context.Context) ([]ssm.KeyReference, error) {
+
+	secrets, err := api.store.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var references []ssm.KeyReference
+	for _, secret := range secrets {
+		references = append(references, ssm.KeyReference{KeyId: secret.KmsKeyId, User: "secret " + secret.Name})
+	}
+
+	return references, nil
+}
+
+// ResolveSecret implements ssm.SecretResolver for parameters named
+// /aws/reference/secretsmanager/<secret>.
+func (api *Api) ResolveSecret(ctx context.Context, secretId string) (*ssm.SecretReference, error) {
+
+	secret, err := api.findSecret(ctx, secretId, "GetSecretValue")
+	if errors.Is(err, ErrResourceNotFound) {
+		return nil, ssm.ErrParameterNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	version := secret.version("", StageCurrent)
+	if secret.DeletionDate != nil || version == nil {
+		return nil, ssm.ErrParameterNotFound
+	}
+
+	secretString, secretBinary, err := api.decryptVersion(ctx, secret, version)
+	if err != nil {
+		return nil, err
+	}
+
+	value := base64.StdEncoding.EncodeToString(secretBinary)
+	if secretString != nil {
+		value = *secretString
+	}
+
+	sourceResult, err := json.Marshal(api.describe(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	api.recordAccess(ctx, secret)
+
+	return &ssm.SecretReference{
+		ARN:          api.arn(secret),
+		Value:        value,
+		CreatedDate:  version.CreatedDate,
+		SourceResult: string(sourceResult),
+	}, nil
+}
+
+// findSecret loads the secret and checks the caller's policies for the action.
+func (api *Api) findSecret(ctx context.Context, secretId string, action string) (*Secret, error) {
+
+	if secretId == "" {
+		return nil, ErrMissingSecretId
+	}
+
+	secret, err := api.store.get(ctx, nameFromId(secretId))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := api.authorize(ctx, action, api.arn(secret)); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// newVersion encrypts the value of a new version. A ClientRequestToken that
+// names an existing version with the same value returns that version.
+func (api *Api) newVersion(ctx context.Context, secret *Secret, token string, secretString *string, secretBinary []byte, stages []string) (*Version, error) {
+
+	if (secretString == nil) == (secretBinary == nil) {
+		return nil, ErrInvalidValue
+	}
+
+	if token == "" {
+		token = awslib.NewUUID()
+	} else if len(token) < 32 || len(token) > 64 {
+		return nil, ErrInvalidToken
+	}
+
+	if existing := secret.version(token, ""); existing != nil {
+
+		existingString, existingBinary, err := api.decryptVersion(ctx, secret, existing)
+		if err != nil {
+			return nil, err
+		}
+
+		if secretString != nil && existingString != nil && *secretString == *existingString ||
+			secretBinary != nil && string(secretBinary) == string(existingBinary) {
+			return existing, nil
+		}
+
+		return nil, ErrTokenReused
+	}
+
+	key, err := api.useKey(ctx, secret, ssm.KeyOperationEncrypt)
+	if err != nil {
+		return nil, err
+	}
+
+	version := Version{VersionId: token, Stages: stages, CreatedDate: time.Now().UTC()}
+	if secretString != nil {
+		version.SecretString, err = api.dataStore.Encrypt(ctx, *secretString, key.KeyId)
+	} else {
+		version.SecretBinary, err = api.dataStore.Encrypt(ctx, string(secretBinary), key.KeyId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &version, nil
+}
+
+func (api *Api) decryptVersion(ctx context.Context, secret *Secret, version *Version) (*string, []byte, error) {
+
+	key, err := api.useKey(ctx, secret, ssm.KeyOperationDecrypt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if version.SecretBinary != "" {
+
+		plaintext, err := api.dataStore.Decrypt(ctx, version.SecretBinary, key.KeyId)
+		if err != nil {
+			return nil, nil, ErrDecryptionFailure
+		}
+
+		return nil, []byte(plaintext), nil
+	}
+
+	plaintext, err := api.dataStore.Decrypt(ctx, version.SecretString, key.KeyId)
+	if err != nil {
+		return nil, nil, ErrDecryptionFailure
+	}
+
+	return &plaintext, nil, nil
+}
+
+// useKey resolves the KMS key of the secret and checks the caller's key grants.
+func (api *Api) useKey(ctx context.Context, secret *Secret, operation string) (*ssm.KmsKey, error) {
+
+	keyId := secret.KmsKeyId
+	if keyId == "" {
+		keyId = api.dataStore.DefaultKeyId()
+	}
+
+	key, err := api.dataStore.FindKey(keyId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ssm.AuthorizeKey(ctx, key, operation, api.region, api.accountId); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// recordAccess updates LastAccessedDate, which like AWS only has day precision.
+func (api *Api) recordAccess(ctx context.Context, secret *Secret) {
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	if !secret.LastAccessedDate.Before(today) {
+		return
+	}
+
+	_, err := api.store.update(ctx, secret.Name, func(secret *Secret) error {
+		secret.LastAccessedDate = today
+		return nil
+	})
+	if err != nil {
+		awslib.Logger(ctx).Warn("failed to record secret access", "error", err)
+	}
+}
+
+func (api *Api) authorize(ctx context.Context, action string, resource string) error {
+
+	info := awslib.GetRequestInfo(ctx)
+	if api.authorizer == nil || info.Principal == nil {
+		return nil
+	}
+
+	principalArn := awslib.PrincipalArn(api.accountId, info.Principal)
+	request := policy.Request{
+		Action:   "secretsmanager:" + action,
+		Resource: resource,
+		Context:  policy.GlobalContext(info.Principal, principalArn, info.SourceIp),
+	}
+
+	return api.authorizer.Authorize(info.Principal, principalArn, &request)
+}
+
+func (api *Api) arn(secret *Secret) string {
+
+	return fmt.Sprintf("arn:aws:secretsmanager:%s:%s:secret:%s-%s", api.region, api.accountId, secret.Name, secret.ArnSuffix)
+}
+
+func (api *Api) describe(secret *Secret) *DescribeSecretResponse {
+
+	response := DescribeSecretResponse{
+		ARN:              api.arn(secret),
+		Name:             secret.Name,
+		Description:      secret.Description,
+		KmsKeyId:         secret.KmsKeyId,
+		CreatedDate:      epochSeconds(secret.CreatedDate),
+		LastChangedDate:  epochSeconds(secret.LastChangedDate),
+		LastAccessedDate: epochSeconds(secret.LastAccessedDate),
+		Tags:             secret.Tags,
+	}
+
+	if secret.DeletionDate != nil {
+		response.DeletedDate = epochSeconds(*secret.DeletionDate)
+	}
+
+	if len(secret.Versions) > 0 {
+
+		response.VersionIdsToStages = make(map[string][]string, len(secret.Versions))
+		for _, version := range secret.Versions {
+			response.VersionIdsToStages[version.VersionId] = version.Stages
+		}
+	}
+
+	return &response
+}
+
+// matchesFilters applies ListSecrets filters. Values match by prefix and
+// a leading "!" negates the value.
+func matchesFilters(secret *Secret, filters []Filter) bool {
+
+	for _, filter := range filters {
+
+		var fields []string
+		switch filter.Key {
+		case "name":
+			fields = []string{secret.Name}
+		case "description":
+			fields = []string{secret.Description}
+		case "tag-key", "tag-value", "all":
+			if filter.Key == "all" {
+				fields = []string{secret.Name, secret.Description}
+			}
+			for _, tag := range secret.Tags {
+				if filter.Key != "tag-value" {
+					fields = append(fields, tag.Key)
+				}
+				if filter.Key != "tag-key" {
+					fields = append(fields, tag.Value)
+				}
+			}
+		}
+
+		matched := false
+		for _, value := range filter.Values {
+
+			negated := strings.HasPrefix(value, "!")
+			value = strings.TrimPrefix(value, "!")
+
+			found := slices.ContainsFunc(fields, func(field string) bool { return strings.HasPrefix(field, value) })
+			if found != negated {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validateTags(tags []Tag) error {
+
+	if len(tags) > maxTags {
+		return ErrInvalidTags
+	}
+
+	for _, tag := range tags {
+		if tag.Key == "" {
+			return ErrInvalidTags
+		}
+	}
+
+	return nil
+}
+
+func newArnSuffix() (string, error) {
+
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	for i, b := range buf {
+		buf[i] = arnSuffixAlphabet[int(b)%len(arnSuffixAlphabet)]
+	}
+
+	return string(buf), nil
+}
+
+func epochSeconds(t time.Time) float64 {
+
+	if t.IsZero() {
+		return 0
+	}
+
+	return float64(t.UnixMilli()) / 1000
+}
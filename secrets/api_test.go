@@ -0,0 +1,395 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"home-ssm/ssm"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/dgraph-io/badger/v4"
+)
+
+const testAccountId = "000000000000"
+
+// testKeys has a single key only the "admin" access key may use.
+var testKeys = []ssm.KmsKey{
+	{KeyId: "admin-key", Alias: "admin", Key: "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=", Grants: []ssm.KeyGrant{
+		{AccessKey: "admin", Operations: []string{ssm.KeyOperationEncrypt, ssm.KeyOperationDecrypt}}}},
+}
+
+// newTestApi returns an api whose "restricted" access key may only
+// describe secrets.
+func newTestApi(t *testing.T) *Api {
+
+	t.Helper()
+
+	db := openTestDB(t)
+
+	document, err := policy.Parse("restricted", `{"Statement":
+		{"Effect":"Allow","Action":"secretsmanager:DescribeSecret","Resource":"*"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorizer := policy.NewAuthorizer()
+	authorizer.SetPolicies("restricted", []*policy.Document{document})
+
+	return NewApi("us-east-1", testAccountId, NewStore(db), ssm.NewDataStore(db, testKeys), authorizer)
+}
+
+func callApi(api *Api, accessKey string, target string, body string) *httptest.ResponseRecorder {
+
+	handler := awslib.WithRequestId(func(w http.ResponseWriter, r *http.Request) {
+
+		info := awslib.GetRequestInfo(r.Context())
+		info.Principal = &aws.Credentials{AccessKeyID: accessKey, AccountID: testAccountId}
+		info.Region = "us-east-1"
+		api.Handle(w, r)
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("X-Amz-Target", "secretsmanager."+target)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	return w
+}
+
+// mustCall calls the api as admin and decodes the response, failing the
+// test on an error response.
+func mustCall(t *testing.T, api *Api, target string, body string, response any) {
+
+	t.Helper()
+
+	w := callApi(api, "admin", target, body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s got %d %s", target, w.Code, w.Body.String())
+	}
+
+	if response != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), response); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func errorCode(w *httptest.ResponseRecorder) string {
+
+	if w.Code == http.StatusOK {
+		return ""
+	}
+
+	var response struct {
+		Type string `json:"__type"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		return w.Body.String()
+	}
+
+	return response.Type
+}
+
+func TestCreateSecret(t *testing.T) {
+
+	tests := []struct {
+		name string
+		body string
+		code string
+	}{
+		{"string", `{"Name":"app/db","SecretString":"s3cret"}`, ""},
+		{"binary", `{"Name":"app/db","SecretBinary":"AAEC"}`, ""},
+		{"without value", `{"Name":"app/db"}`, ""},
+		{"both values", `{"Name":"app/db","SecretString":"s3cret","SecretBinary":"AAEC"}`, "InvalidParameterException"},
+		{"invalid name", `{"Name":"app db","SecretString":"s3cret"}`, "InvalidParameterException"},
+		{"existing", `{"Name":"existing","SecretString":"s3cret"}`, "ResourceExistsException"},
+		{"unknown key", `{"Name":"app/db","SecretString":"s3cret","KmsKeyId":"alias/missing"}`, "InvalidParameterException"},
+		{"short token", `{"Name":"app/db","SecretString":"s3cret","ClientRequestToken":"short"}`, "InvalidParameterException"},
+		{"invalid json", `{"Name":`, "SerializationException"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			api := newTestApi(t)
+			mustCall(t, api, "CreateSecret", `{"Name":"existing","SecretString":"value"}`, nil)
+
+			w := callApi(api, "admin", "CreateSecret", test.body)
+			if code := errorCode(w); code != test.code {
+				t.Fatalf("got %d %s, want %q", w.Code, w.Body.String(), test.code)
+			}
+		})
+	}
+}
+
+func TestSecretVersions(t *testing.T) {
+
+	api := newTestApi(t)
+	mustCall(t, api, "CreateSecret", `{"Name":"app/db","SecretString":"v1","ClientRequestToken":"00000000-0000-0000-0000-000000000001"}`, nil)
+	mustCall(t, api, "PutSecretValue", `{"SecretId":"app/db","SecretString":"v2","ClientRequestToken":"00000000-0000-0000-0000-000000000002"}`, nil)
+	mustCall(t, api, "PutSecretValue", `{"SecretId":"app/db","SecretString":"v3","ClientRequestToken":"00000000-0000-0000-0000-000000000003",
+		"VersionStages":["AWSPENDING"]}`, nil)
+
+	tests := []struct {
+		name  string
+		body  string
+		value string
+		code  string
+	}{
+		{"current by default", `{"SecretId":"app/db"}`, "v2", ""},
+		{"previous", `{"SecretId":"app/db","VersionStage":"AWSPREVIOUS"}`, "v1", ""},
+		{"pending", `{"SecretId":"app/db","VersionStage":"AWSPENDING"}`, "v3", ""},
+		{"version id", `{"SecretId":"app/db","VersionId":"00000000-0000-0000-0000-000000000001"}`, "v1", ""},
+		{"version id and stage", `{"SecretId":"app/db","VersionId":"00000000-0000-0000-0000-000000000001","VersionStage":"AWSCURRENT"}`,
+			"", "ResourceNotFoundException"},
+		{"unknown stage", `{"SecretId":"app/db","VersionStage":"blue"}`, "", "ResourceNotFoundException"},
+		{"unknown secret", `{"SecretId":"app/other"}`, "", "ResourceNotFoundException"},
+		{"missing secret id", `{}`, "", "InvalidParameterException"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			w := callApi(api, "admin", "GetSecretValue", test.body)
+			if code := errorCode(w); code != test.code {
+				t.Fatalf("got %d %s, want %q", w.Code, w.Body.String(), test.code)
+			}
+
+			var response GetSecretValueResponse
+			json.Unmarshal(w.Body.Bytes(), &response)
+			if test.code == "" && aws.ToString(response.SecretString) != test.value {
+				t.Errorf("SecretString %q, want %q", aws.ToString(response.SecretString), test.value)
+			}
+		})
+	}
+}
+
+func TestPutSecretValueRetry(t *testing.T) {
+
+	const token = "00000000-0000-0000-0000-000000000002"
+
+	api := newTestApi(t)
+	mustCall(t, api, "CreateSecret", `{"Name":"app/db","SecretString":"v1"}`, nil)
+
+	var first, retried PutSecretValueResponse
+	mustCall(t, api, "PutSecretValue", `{"SecretId":"app/db","SecretString":"v2","ClientRequestToken":"`+token+`"}`, &first)
+	mustCall(t, api, "PutSecretValue", `{"SecretId":"app/db","SecretString":"v2","ClientRequestToken":"`+token+`"}`, &retried)
+	if first.VersionId != token || retried.VersionId != token {
+		t.Errorf("versions %s and %s, want %s", first.VersionId, retried.VersionId, token)
+	}
+
+	// the retry must not have moved AWSCURRENT to a new version
+	var described DescribeSecretResponse
+	mustCall(t, api, "DescribeSecret", `{"SecretId":"app/db"}`, &described)
+	if len(described.VersionIdsToStages) != 2 {
+		t.Errorf("versions %v, want the first and the retried one", described.VersionIdsToStages)
+	}
+
+	w := callApi(api, "admin", "PutSecretValue", `{"SecretId":"app/db","SecretString":"other","ClientRequestToken":"`+token+`"}`)
+	if code := errorCode(w); code != "ResourceExistsException" {
+		t.Errorf("reused token with another value got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSecretEncryption(t *testing.T) {
+
+	api := newTestApi(t)
+	mustCall(t, api, "CreateSecret", `{"Name":"app/db","SecretString":"s3cret"}`, nil)
+	mustCall(t, api, "CreateSecret", `{"Name":"app/binary","SecretBinary":"AAECAw=="}`, nil)
+
+	// values are sealed with the key, so the plaintext isn't stored
+	err := api.store.db.View(func(txn *badger.Txn) error {
+
+		for _, name := range []string{"app/db", "app/binary"} {
+
+			item, err := txn.Get([]byte(keyPrefix + name))
+			if err != nil {
+				return err
+			}
+
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			if strings.Contains(string(value), "s3cret") || strings.Contains(string(value), "AAECAw") {
+				t.Errorf("plaintext of %s stored: %s", name, value)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var binary GetSecretValueResponse
+	mustCall(t, api, "GetSecretValue", `{"SecretId":"app/binary"}`, &binary)
+	if string(binary.SecretBinary) != "\x00\x01\x02\x03" || binary.SecretString != nil {
+		t.Errorf("SecretBinary %v, SecretString %v", binary.SecretBinary, binary.SecretString)
+	}
+
+	// "other" has no policies but no grant for the key either
+	w := callApi(api, "other", "GetSecretValue", `{"SecretId":"app/db"}`)
+	if code := errorCode(w); code != "AccessDeniedException" || !strings.Contains(w.Body.String(), "kms:Decrypt") {
+		t.Errorf("caller without key grant got %d %s", w.Code, w.Body.String())
+	}
+
+	w = callApi(api, "restricted", "GetSecretValue", `{"SecretId":"app/db"}`)
+	if code := errorCode(w); code != "AccessDeniedException" || !strings.Contains(w.Body.String(), "secretsmanager:GetSecretValue") {
+		t.Errorf("caller without policy got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDeleteSecret(t *testing.T) {
+
+	tests := []struct {
+		name string
+		body string
+		code string
+		// deleted is whether the secret is gone, scheduled whether it is
+		// scheduled for deletion
+		deleted   bool
+		scheduled bool
+	}{
+		{"default window", `{"SecretId":"app/db"}`, "", false, true},
+		{"window", `{"SecretId":"app/db","RecoveryWindowInDays":7}`, "", false, true},
+		{"window too short", `{"SecretId":"app/db","RecoveryWindowInDays":6}`, "InvalidParameterException", false, false},
+		{"force", `{"SecretId":"app/db","ForceDeleteWithoutRecovery":true}`, "", true, false},
+		{"force with window", `{"SecretId":"app/db","ForceDeleteWithoutRecovery":true,"RecoveryWindowInDays":7}`,
+			"InvalidParameterException", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			api := newTestApi(t)
+			mustCall(t, api, "CreateSecret", `{"Name":"app/db","SecretString":"s3cret"}`, nil)
+
+			w := callApi(api, "admin", "DeleteSecret", test.body)
+			if code := errorCode(w); code != test.code {
+				t.Fatalf("got %d %s, want %q", w.Code, w.Body.String(), test.code)
+			}
+
+			secret, err := api.store.get(context.Background(), "app/db")
+			if deleted := errors.Is(err, ErrResourceNotFound); deleted != test.deleted {
+				t.Fatalf("get() = %v, want deleted %v", err, test.deleted)
+			}
+
+			if test.deleted {
+				return
+			}
+
+			if scheduled := secret.DeletionDate != nil; scheduled != test.scheduled {
+				t.Fatalf("scheduled %v, want %v", scheduled, test.scheduled)
+			}
+
+			if !test.scheduled {
+				return
+			}
+
+			if code := errorCode(callApi(api, "admin", "GetSecretValue", `{"SecretId":"app/db"}`)); code != "InvalidRequestException" {
+				t.Errorf("GetSecretValue of a scheduled secret got %s", code)
+			}
+
+			mustCall(t, api, "RestoreSecret", `{"SecretId":"app/db"}`, nil)
+			mustCall(t, api, "GetSecretValue", `{"SecretId":"app/db"}`, nil)
+		})
+	}
+}
+
+func TestListSecrets(t *testing.T) {
+
+	api := newTestApi(t)
+	for _, body := range []string{
+		`{"Name":"app/db","Description":"database","Tags":[{"Key":"env","Value":"prod"}]}`,
+		`{"Name":"app/api","Tags":[{"Key":"env","Value":"dev"}]}`,
+		`{"Name":"other"}`,
+	} {
+		mustCall(t, api, "CreateSecret", body, nil)
+	}
+	mustCall(t, api, "DeleteSecret", `{"SecretId":"other"}`, nil)
+
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"all", `{}`, []string{"app/api", "app/db"}},
+		{"planned deletion", `{"IncludePlannedDeletion":true}`, []string{"app/api", "app/db", "other"}},
+		{"name prefix", `{"Filters":[{"Key":"name","Values":["app/d"]}]}`, []string{"app/db"}},
+		{"negated", `{"Filters":[{"Key":"tag-value","Values":["!prod"]}]}`, []string{"app/api"}},
+		{"description", `{"Filters":[{"Key":"all","Values":["data"]}]}`, []string{"app/db"}},
+		{"page", `{"MaxResults":1}`, []string{"app/api"}},
+		{"next page", `{"MaxResults":1,"NextToken":"1"}`, []string{"app/db"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			var response ListSecretsResponse
+			mustCall(t, api, "ListSecrets", test.body, &response)
+
+			var names []string
+			for _, secret := range response.SecretList {
+				names = append(names, secret.Name)
+			}
+
+			if strings.Join(names, ",") != strings.Join(test.want, ",") {
+				t.Errorf("secrets %v, want %v", names, test.want)
+			}
+		})
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+
+	api := newTestApi(t)
+	mustCall(t, api, "CreateSecret", `{"Name":"app/db","SecretString":"s3cret"}`, nil)
+	mustCall(t, api, "CreateSecret", `{"Name":"app/binary","SecretBinary":"AAECAw=="}`, nil)
+	mustCall(t, api, "CreateSecret", `{"Name":"app/empty"}`, nil)
+	mustCall(t, api, "CreateSecret", `{"Name":"app/deleted","SecretString":"s3cret"}`, nil)
+	mustCall(t, api, "DeleteSecret", `{"SecretId":"app/deleted"}`, nil)
+
+	tests := []struct {
+		secretId string
+		value    string
+		err      error
+	}{
+		{"app/db", "s3cret", nil},
+		// binary values resolve base64 encoded
+		{"app/binary", "AAECAw==", nil},
+		{"app/empty", "", ssm.ErrParameterNotFound},
+		{"app/deleted", "", ssm.ErrParameterNotFound},
+		{"app/missing", "", ssm.ErrParameterNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.secretId, func(t *testing.T) {
+
+			reference, err := api.ResolveSecret(context.Background(), test.secretId)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("ResolveSecret() = %v, want %v", err, test.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if reference.Value != test.value || !strings.HasPrefix(reference.ARN, "arn:aws:secretsmanager:us-east-1:"+testAccountId+":secret:"+test.secretId+"-") {
+				t.Errorf("ResolveSecret() = %s %q, want value %q", reference.ARN, reference.Value, test.value)
+			}
+
+			var source DescribeSecretResponse
+			if err := json.Unmarshal([]byte(reference.SourceResult), &source); err != nil || source.Name != test.secretId {
+				t.Errorf("SourceResult %s, %v", reference.SourceResult, err)
+			}
+		})
+	}
+}
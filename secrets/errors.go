@@ -0,0 +1,155 @@
+package secrets
+
+import (
+	"errors"
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"home-ssm/ssm"
+	"net/http"
+)
+
+var (
+	ErrResourceNotFound     = errors.New("Secrets Manager can't find the specified secret.")
+	ErrVersionNotFound      = errors.New("Secrets Manager can't find the specified secret value for the version or staging label.")
+	ErrResourceExists       = errors.New("The operation failed because the secret already exists.")
+	ErrScheduledForDeletion = errors.New("You can't perform this operation on the secret because it was marked for deletion.")
+	ErrNotScheduled         = errors.New("You can't restore a secret that isn't marked for deletion.")
+	ErrTokenReused          = errors.New("You can't modify an existing version, you can only create a new version.")
+	ErrMissingSecretId      = errors.New("SecretId is required.")
+	ErrInvalidName          = errors.New("The secret name can contain ASCII letters, numbers, and the following characters: /_+=.@-")
+	ErrInvalidValue         = errors.New("You must provide exactly one of SecretString or SecretBinary.")
+	ErrInvalidToken         = errors.New("ClientRequestToken must be between 32 and 64 characters.")
+	ErrInvalidWindow        = errors.New("RecoveryWindowInDays must be between 7 and 30 and can't be combined with ForceDeleteWithoutRecovery.")
+	ErrInvalidTags          = errors.New("Tags must have a Key and at most 50 tags are allowed.")
+	ErrInvalidNextToken     = errors.New("The NextToken value is invalid.")
+	ErrInvalidFilter        = errors.New("Filter keys must be name, description, tag-key, tag-value or all.")
+	ErrDecryptionFailure    = errors.New("Secrets Manager can't decrypt the protected secret text using the provided KMS key.")
+	ErrInvalidRequest       = errors.New("The request body is not valid JSON.")
+	ErrUnsupportedOperation = errors.New("The operation isn't supported.")
+	ErrInternalError        = errors.New("An error occurred on the server side.")
+)
+
+type errorCodeMap map[error]awslib.APIError
+
+var SecretsErrorCodes = errorCodeMap{
+	ErrResourceNotFound: {
+		Code:           "ResourceNotFoundException",
+		Description:    ErrResourceNotFound.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrVersionNotFound: {
+		Code:           "ResourceNotFoundException",
+		Description:    ErrVersionNotFound.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrResourceExists: {
+		Code:           "ResourceExistsException",
+		Description:    ErrResourceExists.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrScheduledForDeletion: {
+		Code:           "InvalidRequestException",
+		Description:    ErrScheduledForDeletion.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrNotScheduled: {
+		Code:           "InvalidRequestException",
+		Description:    ErrNotScheduled.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrTokenReused: {
+		Code:           "ResourceExistsException",
+		Description:    ErrTokenReused.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrMissingSecretId: {
+		Code:           "InvalidParameterException",
+		Description:    ErrMissingSecretId.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidName: {
+		Code:           "InvalidParameterException",
+		Description:    ErrInvalidName.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidValue: {
+		Code:           "InvalidParameterException",
+		Description:    ErrInvalidValue.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidToken: {
+		Code:           "InvalidParameterException",
+		Description:    ErrInvalidToken.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidWindow: {
+		Code:           "InvalidParameterException",
+		Description:    ErrInvalidWindow.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidTags: {
+		Code:           "InvalidParameterException",
+		Description:    ErrInvalidTags.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidNextToken: {
+		Code:           "InvalidNextTokenException",
+		Description:    ErrInvalidNextToken.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidFilter: {
+		Code:           "InvalidParameterException",
+		Description:    ErrInvalidFilter.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrDecryptionFailure: {
+		Code:           "DecryptionFailure",
+		Description:    ErrDecryptionFailure.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidRequest: {
+		Code:           "SerializationException",
+		Description:    ErrInvalidRequest.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrUnsupportedOperation: {
+		Code:           "UnsupportedOperationException",
+		Description:    ErrUnsupportedOperation.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInternalError: {
+		Code:           "InternalServiceError",
+		Description:    ErrInternalError.Error(),
+		HTTPStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func translateToApiError(err error) awslib.APIError {
+
+	if errors.Is(err, ssm.ErrInvalidKeyId) {
+
+		return awslib.APIError{
+			Code:           "InvalidParameterException",
+			Description:    "The KmsKeyId doesn't identify a key.",
+			HTTPStatusCode: http.StatusBadRequest,
+		}
+	}
+
+	value, ok := SecretsErrorCodes[err]
+	if ok {
+
+		return value
+	}
+
+	var accessDenied *policy.AccessDeniedError
+	if errors.As(err, &accessDenied) {
+
+		return awslib.APIError{
+			Code:           "AccessDeniedException",
+			Description:    accessDenied.Error(),
+			HTTPStatusCode: http.StatusBadRequest,
+		}
+	}
+
+	return SecretsErrorCodes[ErrInternalError]
+}
@@ -0,0 +1,295 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"home-ssm/tracing"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	keyPrefix = "secret/"
+
+	StageCurrent  = "AWSCURRENT"
+	StagePrevious = "AWSPREVIOUS"
+)
+
+// Version is one value of a secret. Values are stored encrypted and
+// versions without a staging label are dropped.
+type Version struct {
+	VersionId    string
+	Stages       []string
+	SecretString string `json:",omitempty"`
+	SecretBinary string `json:",omitempty"`
+	CreatedDate  time.Time
+}
+
+type Tag struct {
+	Key   string
+	Value string
+}
+
+type Secret struct {
+	Name             string
+	ArnSuffix        string
+	Description      string
+	KmsKeyId         string
+	Tags             []Tag
+	CreatedDate      time.Time
+	LastChangedDate  time.Time
+	LastAccessedDate time.Time
+	// DeletionDate is set while the secret is scheduled for deletion.
+	DeletionDate *time.Time `json:",omitempty"`
+	Versions     []Version
+}
+
+// Store keeps secrets in their own badger keyspace, one entry per secret
+// holding all of its versions.
+type Store struct {
+	db *badger.DB
+}
+
+func NewStore(db *badger.DB) *Store {
+
+	return &Store{db: db}
+}
+
+func (secret *Secret) version(versionId string, stage string) *Version {
+
+	for i := range secret.Versions {
+
+		version := &secret.Versions[i]
+		if versionId != "" && version.VersionId != versionId {
+			continue
+		}
+
+		if stage != "" && !slices.Contains(version.Stages, stage) {
+			continue
+		}
+
+		return version
+	}
+
+	return nil
+}
+
+// addVersion stages the new version, moving the labels from the versions
+// that had them. Moving AWSCURRENT labels the old current AWSPREVIOUS.
+func (secret *Secret) addVersion(version Version) {
+
+	for i := range secret.Versions {
+
+		existing := &secret.Versions[i]
+		wasCurrent := slices.Contains(existing.Stages, StageCurrent)
+
+		existing.Stages = slices.DeleteFunc(existing.Stages, func(stage string) bool {
+			return slices.Contains(version.Stages, stage) || stage == StagePrevious && slices.Contains(version.Stages, StageCurrent)
+		})
+
+		if wasCurrent && slices.Contains(version.Stages, StageCurrent) {
+			existing.Stages = append(existing.Stages, StagePrevious)
+		}
+	}
+
+	secret.Versions = append(secret.Versions, version)
+	secret.Versions = slices.DeleteFunc(secret.Versions, func(v Version) bool { return len(v.Stages) == 0 })
+	secret.LastChangedDate = version.CreatedDate
+}
+
+// expired reports whether the recovery window of a deleted secret has passed.
+func (secret *Secret) expired(now time.Time) bool {
+
+	return secret.DeletionDate != nil && now.After(*secret.DeletionDate)
+}
+
+func (store *Store) get(ctx context.Context, name string) (*Secret, error) {
+
+	_, span := tracing.Start(ctx, "Secrets.get")
+	defer span.End()
+
+	var secret *Secret
+	err := store.db.View(func(txn *badger.Txn) error {
+
+		var err error
+		secret, err = readSecret(txn, name)
+		return err
+	})
+
+	if err == nil && secret.expired(time.Now()) {
+		err = ErrResourceNotFound
+		store.delete(ctx, name)
+	}
+
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// create writes a new secret, failing if one with the name exists and its
+// recovery window hasn't passed.
+func (store *Store) create(ctx context.Context, secret *Secret) error {
+
+	_, span := tracing.Start(ctx, "Secrets.create")
+	defer span.End()
+
+	err := store.db.Update(func(txn *badger.Txn) error {
+
+		existing, err := readSecret(txn, secret.Name)
+		if err == nil && !existing.expired(time.Now()) {
+
+			if existing.DeletionDate != nil {
+				return ErrScheduledForDeletion
+			}
+			return ErrResourceExists
+
+		} else if err != nil && !errors.Is(err, ErrResourceNotFound) {
+			return err
+		}
+
+		return writeSecret(txn, secret)
+	})
+
+	tracing.RecordError(span, err)
+
+	return err
+}
+
+// update applies change to the stored secret in a single transaction.
+func (store *Store) update(ctx context.Context, name string, change func(*Secret) error) (*Secret, error) {
+
+	_, span := tracing.Start(ctx, "Secrets.update")
+	defer span.End()
+
+	var secret *Secret
+	err := store.db.Update(func(txn *badger.Txn) error {
+
+		var err error
+		secret, err = readSecret(txn, name)
+		if err != nil {
+			return err
+		}
+
+		if secret.expired(time.Now()) {
+			return ErrResourceNotFound
+		}
+
+		if err := change(secret); err != nil {
+			return err
+		}
+
+		return writeSecret(txn, secret)
+	})
+
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+func (store *Store) delete(ctx context.Context, name string) error {
+
+	_, span := tracing.Start(ctx, "Secrets.delete")
+	defer span.End()
+
+	err := store.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(keyPrefix + name))
+	})
+
+	tracing.RecordError(span, err)
+
+	return err
+}
+
+func (store *Store) list(ctx context.Context) ([]Secret, error) {
+
+	_, span := tracing.Start(ctx, "Secrets.list")
+	defer span.End()
+
+	now := time.Now()
+	var secrets []Secret
+
+	err := store.db.View(func(txn *badger.Txn) error {
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte(keyPrefix)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+
+			var secret Secret
+			if err := it.Item().Value(func(val []byte) error { return json.Unmarshal(val, &secret) }); err != nil {
+				return err
+			}
+
+			if !secret.expired(now) {
+				secrets = append(secrets, secret)
+			}
+		}
+
+		return nil
+	})
+
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return secrets, nil
+}
+
+func readSecret(txn *badger.Txn, name string) (*Secret, error) {
+
+	item, err := txn.Get([]byte(keyPrefix + name))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrResourceNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var secret Secret
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &secret)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &secret, nil
+}
+
+func writeSecret(txn *badger.Txn, secret *Secret) error {
+
+	value, err := json.Marshal(secret)
+	if err != nil {
+		return err
+	}
+
+	return txn.Set([]byte(keyPrefix+secret.Name), value)
+}
+
+// nameFromId returns the secret name of a name, full ARN or partial ARN.
+func nameFromId(secretId string) string {
+
+	// arn:aws:secretsmanager:<region>:<account>:secret:<name>-<suffix>
+	fields := strings.SplitN(secretId, ":", 7)
+	if len(fields) != 7 || fields[0] != "arn" || fields[5] != "secret" {
+		return secretId
+	}
+
+	name := fields[6]
+	if i := strings.LastIndex(name, "-"); i > 0 && len(name)-i == 7 {
+		name = name[:i]
+	}
+
+	return name
+}
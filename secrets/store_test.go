@@ -0,0 +1,192 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func openTestDB(t *testing.T) *badger.DB {
+
+	t.Helper()
+
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestAddVersion(t *testing.T) {
+
+	tests := []struct {
+		name string
+		// versions are given as id:stage,stage
+		versions []string
+		added    string
+		want     []string
+	}{
+		{
+			name:  "first version",
+			added: "v1:AWSCURRENT",
+			want:  []string{"v1:AWSCURRENT"},
+		},
+		{
+			name:     "current becomes previous",
+			versions: []string{"v1:AWSCURRENT"},
+			added:    "v2:AWSCURRENT",
+			want:     []string{"v1:AWSPREVIOUS", "v2:AWSCURRENT"},
+		},
+		{
+			name:     "unlabeled versions are dropped",
+			versions: []string{"v1:AWSPREVIOUS", "v2:AWSCURRENT"},
+			added:    "v3:AWSCURRENT",
+			want:     []string{"v2:AWSPREVIOUS", "v3:AWSCURRENT"},
+		},
+		{
+			name:     "custom stage moves",
+			versions: []string{"v1:AWSCURRENT,blue"},
+			added:    "v2:blue",
+			want:     []string{"v1:AWSCURRENT", "v2:blue"},
+		},
+		{
+			name:     "pending doesn't touch current",
+			versions: []string{"v1:AWSPREVIOUS", "v2:AWSCURRENT"},
+			added:    "v3:AWSPENDING",
+			want:     []string{"v1:AWSPREVIOUS", "v2:AWSCURRENT", "v3:AWSPENDING"},
+		},
+	}
+
+	parse := func(version string) Version {
+		id, stages, _ := strings.Cut(version, ":")
+		return Version{VersionId: id, Stages: strings.Split(stages, ",")}
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			var secret Secret
+			for _, version := range test.versions {
+				secret.Versions = append(secret.Versions, parse(version))
+			}
+
+			secret.addVersion(parse(test.added))
+
+			var got []string
+			for _, version := range secret.Versions {
+				got = append(got, version.VersionId+":"+strings.Join(version.Stages, ","))
+			}
+
+			if !slices.Equal(got, test.want) {
+				t.Errorf("versions %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNameFromId(t *testing.T) {
+
+	tests := []struct {
+		secretId string
+		want     string
+	}{
+		{"app/db", "app/db"},
+		{"arn:aws:secretsmanager:us-east-1:000000000000:secret:app/db-AbCdEf", "app/db"},
+		// a partial ARN has no suffix to strip
+		{"arn:aws:secretsmanager:us-east-1:000000000000:secret:app/db", "app/db"},
+		{"arn:aws:secretsmanager:us-east-1:000000000000:secret:app-db", "app-db"},
+		{"arn:aws:ssm:us-east-1:000000000000:parameter/app", "arn:aws:ssm:us-east-1:000000000000:parameter/app"},
+	}
+
+	for _, test := range tests {
+
+		if got := nameFromId(test.secretId); got != test.want {
+			t.Errorf("nameFromId(%q) = %q, want %q", test.secretId, got, test.want)
+		}
+	}
+}
+
+func TestStoreCreate(t *testing.T) {
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name string
+		// existing is the secret stored before, if any
+		existing *Secret
+		err      error
+	}{
+		{"new", nil, nil},
+		{"existing", &Secret{Name: "app"}, ErrResourceExists},
+		{"scheduled for deletion", &Secret{Name: "app", DeletionDate: &future}, ErrScheduledForDeletion},
+		{"recovery window passed", &Secret{Name: "app", DeletionDate: &past}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			store := NewStore(openTestDB(t))
+			if test.existing != nil {
+				err := store.db.Update(func(txn *badger.Txn) error { return writeSecret(txn, test.existing) })
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := store.create(context.Background(), &Secret{Name: "app", Description: "created"})
+			if !errors.Is(err, test.err) {
+				t.Fatalf("create() = %v, want %v", err, test.err)
+			}
+
+			secret, err := store.get(context.Background(), "app")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if created := secret.Description == "created"; created != (test.err == nil) {
+				t.Errorf("secret created %v, want %v", created, test.err == nil)
+			}
+		})
+	}
+}
+
+func TestStoreExpired(t *testing.T) {
+
+	store := NewStore(openTestDB(t))
+	past := time.Now().Add(-time.Hour)
+	for _, secret := range []*Secret{{Name: "expired", DeletionDate: &past}, {Name: "kept"}} {
+		if err := store.db.Update(func(txn *badger.Txn) error { return writeSecret(txn, secret) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	secrets, err := store.list(context.Background())
+	if err != nil || len(secrets) != 1 || secrets[0].Name != "kept" {
+		t.Fatalf("list() = %v, %v, want only kept", secrets, err)
+	}
+
+	if _, err := store.update(context.Background(), "expired", func(*Secret) error { return nil }); !errors.Is(err, ErrResourceNotFound) {
+		t.Errorf("update() = %v, want %v", err, ErrResourceNotFound)
+	}
+
+	if _, err := store.get(context.Background(), "expired"); !errors.Is(err, ErrResourceNotFound) {
+		t.Fatalf("get() = %v, want %v", err, ErrResourceNotFound)
+	}
+
+	// get removes the secret once its recovery window passed
+	err = store.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte(keyPrefix + "expired"))
+		return err
+	})
+	if !errors.Is(err, badger.ErrKeyNotFound) {
+		t.Errorf("expired secret still stored: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package secrets
+
+type CreateSecretRequest struct {
+	Name               string
+	ClientRequestToken string
+	Description        string
+	KmsKeyId           string
+	SecretString       *string
+	SecretBinary       []byte
+	Tags               []Tag
+}
+
+type CreateSecretResponse struct {
+	ARN       string `json:"ARN"`
+	Name      string `json:"Name"`
+	VersionId string `json:"VersionId,omitempty"`
+}
+
+type GetSecretValueRequest struct {
+	SecretId     string
+	VersionId    string
+	VersionStage string
+}
+
+type GetSecretValueResponse struct {
+	ARN           string   `json:"ARN"`
+	Name          string   `json:"Name"`
+	VersionId     string   `json:"VersionId"`
+	SecretString  *string  `json:"SecretString,omitempty"`
+	SecretBinary  []byte   `json:"SecretBinary,omitempty"`
+	VersionStages []string `json:"VersionStages"`
+	CreatedDate   float64  `json:"CreatedDate"`
+}
+
+type PutSecretValueRequest struct {
+	SecretId           string
+	ClientRequestToken string
+	SecretString       *string
+	SecretBinary       []byte
+	VersionStages      []string
+}
+
+type PutSecretValueResponse struct {
+	ARN           string   `json:"ARN"`
+	Name          string   `json:"Name"`
+	VersionId     string   `json:"VersionId"`
+	VersionStages []string `json:"VersionStages"`
+}
+
+type SecretIdRequest struct {
+	SecretId string
+}
+
+type DescribeSecretResponse struct {
+	ARN                string              `json:"ARN"`
+	Name               string              `json:"Name"`
+	Description        string              `json:"Description,omitempty"`
+	KmsKeyId           string              `json:"KmsKeyId,omitempty"`
+	CreatedDate        float64             `json:"CreatedDate"`
+	LastChangedDate    float64             `json:"LastChangedDate,omitempty"`
+	LastAccessedDate   float64             `json:"LastAccessedDate,omitempty"`
+	DeletedDate        float64             `json:"DeletedDate,omitempty"`
+	Tags               []Tag               `json:"Tags,omitempty"`
+	VersionIdsToStages map[string][]string `json:"VersionIdsToStages,omitempty"`
+}
+
+type Filter struct {
+	Key    string
+	Values []string
+}
+
+type ListSecretsRequest struct {
+	MaxResults             *int
+	NextToken              string
+	Filters                []Filter
+	IncludePlannedDeletion bool
+	SortOrder              string
+}
+
+type ListSecretsResponse struct {
+	SecretList []DescribeSecretResponse `json:"SecretList"`
+	NextToken  string                   `json:"NextToken,omitempty"`
+}
+
+type DeleteSecretRequest struct {
+	SecretId                   string
+	RecoveryWindowInDays       *int
+	ForceDeleteWithoutRecovery bool
+}
+
+type DeleteSecretResponse struct {
+	ARN          string  `json:"ARN"`
+	Name         string  `json:"Name"`
+	DeletionDate float64 `json:"DeletionDate"`
+}
+
+type RestoreSecretResponse struct {
+	ARN  string `json:"ARN"`
+	Name string `json:"Name"`
+}
+
+type TagResourceRequest struct {
+	SecretId string
+	Tags     []Tag
+}
+
+type EmptyResponse struct{}
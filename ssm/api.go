@@ -4,8 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"home-ssm/awslib"
-	"log"
+	"home-ssm/policy"
+	"home-ssm/tracing"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
@@ -14,11 +18,54 @@ import (
 type ParameterApi struct {
 	service     *ParameterService
 	credentials *awslib.CredentialsProvider
+	authorizer  *policy.Authorizer
+	limiter     *RateLimiter
+	journal     *ChangeJournal
+	// services are the services of every account and region by
+	// serviceKey
+	services map[string]*ParameterService
 }
 
-func NewParameterApi(service *ParameterService, credentials *awslib.CredentialsProvider) *ParameterApi {
+func NewParameterApi(
+	service *ParameterService, credentials *awslib.CredentialsProvider, authorizer *policy.Authorizer) *ParameterApi {
 
-	return &ParameterApi{service: service, credentials: credentials}
+	api := ParameterApi{
+		service: service, credentials: credentials, authorizer: authorizer, services: make(map[string]*ParameterService)}
+	api.AddService(service)
+
+	return &api
+}
+
+// AddService serves the parameters of another region or account to its
+// principals' requests signed for the region.
+func (api *ParameterApi) AddService(service *ParameterService) {
+
+	api.services[serviceKey(service.accountId, service.region)] = service
+}
+
+func serviceKey(accountId string, region string) string {
+
+	return accountId + "/" + region
+}
+
+// serviceFor returns the service of the principal's account and the region
+// in the request's credential scope.
+func (api *ParameterApi) serviceFor(r *http.Request) *ParameterService {
+
+	info := awslib.GetRequestInfo(r.Context())
+	if info.Principal != nil {
+		if service, ok := api.services[serviceKey(info.Principal.AccountID, info.Region)]; ok {
+			return service
+		}
+	}
+
+	return api.service
+}
+
+// SetRateLimiter throttles requests once their token buckets are empty.
+func (api *ParameterApi) SetRateLimiter(limiter *RateLimiter) {
+
+	api.limiter = limiter
 }
 
 /*
@@ -33,15 +80,39 @@ o put-parameter
 
 func (api *ParameterApi) Handle(w http.ResponseWriter, r *http.Request) {
 
+	amztarget := r.Header.Get("X-Amz-Target")
+	operation := strings.TrimPrefix(amztarget, "AmazonSSM.")
+
+	recorder := awslib.NewStatusRecorder(w)
+	defer awslib.ObserveRequest("", operation, knownOperations, recorder, time.Now())
+	w = recorder
+
+	ctx, span := tracing.Start(r.Context(), "SSM.Dispatch", tracing.Operation(amztarget))
+	defer func() {
+		span.SetAttributes(tracing.ResultCode(recorder.ResultCode()))
+		span.End()
+	}()
+	r = r.WithContext(ctx)
+
 	creds, err := api.parseCredentials(r)
 	if err != nil {
-		log.Println("Error:", err)
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
 		awslib.WriteErrorResponseJSON(w, awslib.ErrorCodes[awslib.ErrInternalError], r.URL, api.credentials.Region)
 		return
 	}
 
-	amztarget := r.Header.Get("X-Amz-Target")
-	log.Printf("Amazon-Target: %s\n", amztarget)
+	if api.limiter != nil && !api.limiter.Allow(creds.AccessKeyID, strings.TrimPrefix(amztarget, "AmazonSSM."), time.Now()) {
+		awslib.Logger(r.Context()).Warn("request throttled")
+		awslib.WriteErrorResponseJSON(w, translateToApiError(ErrThrottled), r.URL, api.credentials.Region)
+		return
+	}
+
+	if err := api.authorize(r, creds, strings.TrimPrefix(amztarget, "AmazonSSM.")); err != nil {
+		awslib.Logger(r.Context()).Warn("request denied", "error", err)
+		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
+		return
+	}
+
 	if amztarget == "AmazonSSM.DeleteParameter" {
 
 		api.deleteParameter(w, r)
@@ -52,7 +123,7 @@ func (api *ParameterApi) Handle(w http.ResponseWriter, r *http.Request) {
 
 	} else if amztarget == "AmazonSSM.DescribeParameters" {
 
-		api.describeParameters(w, r)
+		api.describeParameters(creds, w, r)
 
 	} else if amztarget == "AmazonSSM.GetParameter" {
 
@@ -64,7 +135,7 @@ func (api *ParameterApi) Handle(w http.ResponseWriter, r *http.Request) {
 
 	} else if amztarget == "AmazonSSM.GetParametersByPath" {
 
-		api.getParametersByPath(w, r)
+		api.getParametersByPath(creds, w, r)
 
 	} else if amztarget == "AmazonSSM.PutParameter" {
 
@@ -82,9 +153,21 @@ func (api *ParameterApi) Handle(w http.ResponseWriter, r *http.Request) {
 
 		api.removeTagsFromResource(w, r)
 
+	} else if amztarget == "AmazonSSM.PutResourcePolicy" {
+
+		api.putResourcePolicy(w, r)
+
+	} else if amztarget == "AmazonSSM.GetResourcePolicies" {
+
+		api.getResourcePolicies(w, r)
+
+	} else if amztarget == "AmazonSSM.DeleteResourcePolicy" {
+
+		api.deleteResourcePolicy(w, r)
+
 	} else {
 
-		log.Println("Unknown Target:", amztarget)
+		awslib.Logger(r.Context()).Warn("unknown target")
 		awslib.WriteErrorResponseJSON(w, awslib.ErrorCodes[awslib.ErrValidationError], r.URL, api.credentials.Region)
 	}
 }
@@ -92,14 +175,22 @@ func (api *ParameterApi) Handle(w http.ResponseWriter, r *http.Request) {
 func (api *ParameterApi) getParameter(w http.ResponseWriter, r *http.Request) {
 
 	var request awsssm.GetParameterInput
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if err := decodeRequest(r.Body, &request); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	response, err := api.service.GetParameter(&request)
+	service, name, err := api.resolveName(r, aws.ToString(request.Name))
+	if err != nil {
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
+		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
+		return
+	}
+	request.Name = aws.String(name)
+
+	response, err := service.GetParameter(r.Context(), &request)
 	if err != nil {
-		log.Println("Error:", err)
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
 		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
 		return
 	}
@@ -110,34 +201,62 @@ func (api *ParameterApi) getParameter(w http.ResponseWriter, r *http.Request) {
 func (api *ParameterApi) getParameters(w http.ResponseWriter, r *http.Request) {
 
 	var request awsssm.GetParametersInput
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if err := decodeRequest(r.Body, &request); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	response, err := api.service.GetParameters(&request)
-	if err != nil {
+	// names are parameters of the caller's account or ARNs of shared ones,
+	// read from the service of each
+	var response GetParametersResponse
+	var services []*ParameterService
+	names := make(map[*ParameterService][]string)
+	for _, name := range request.Names {
+
+		service, paramName, err := api.resolveName(r, name)
+		if err != nil {
+			response.InvalidParameters = append(response.InvalidParameters, name)
+			continue
+		}
 
-		log.Println("Error:", err)
-		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
-		return
+		if _, ok := names[service]; !ok {
+			services = append(services, service)
+		}
+		names[service] = append(names[service], paramName)
 	}
 
-	awslib.WriteSuccessResponseJSON(w, response)
+	for _, service := range services {
+
+		request.Names = names[service]
+		result, err := service.GetParameters(r.Context(), &request)
+		if err != nil {
+
+			awslib.Logger(r.Context()).Warn("request failed", "error", err)
+			awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
+			return
+		}
+
+		response.Parameters = append(response.Parameters, result.Parameters...)
+		response.InvalidParameters = append(response.InvalidParameters, result.InvalidParameters...)
+	}
+
+	awslib.WriteSuccessResponseJSON(w, &response)
 }
 
-func (api *ParameterApi) getParametersByPath(w http.ResponseWriter, r *http.Request) {
+func (api *ParameterApi) getParametersByPath(creds *aws.Credentials, w http.ResponseWriter, r *http.Request) {
 
 	var request awsssm.GetParametersByPathInput
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if err := decodeRequest(r.Body, &request); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	response, err := api.service.GetParametersByPath(&request)
+	response, err := api.serviceFor(r).GetParametersByPath(r.Context(), &request, func(name ParamName) bool {
+		return api.isAllowed(r, creds, "GetParametersByPath", name)
+	})
 	if err != nil {
 
-		log.Println("Error:", err)
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
 		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
 		return
 	}
@@ -145,37 +264,50 @@ func (api *ParameterApi) getParametersByPath(w http.ResponseWriter, r *http.Requ
 	awslib.WriteSuccessResponseJSON(w, response)
 }
 
-func (api *ParameterApi) describeParameters(w http.ResponseWriter, r *http.Request) {
+func (api *ParameterApi) describeParameters(creds *aws.Credentials, w http.ResponseWriter, r *http.Request) {
 
 	var request awsssm.DescribeParametersInput
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if err := decodeRequest(r.Body, &request); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	response, err := api.service.DescribeParameters(&request)
+	if aws.ToBool(request.Shared) {
+		api.describeSharedParameters(creds, &request, w, r)
+		return
+	}
+
+	response, err := api.serviceFor(r).DescribeParameters(r.Context(), &request)
 	if err != nil {
 
-		log.Println("Error:", err)
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
 		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
 		return
 	}
 
+	allowed := response.Parameters[:0]
+	for _, param := range response.Parameters {
+		if api.isAllowed(r, creds, "DescribeParameters", ParamName(param.Name)) {
+			allowed = append(allowed, param)
+		}
+	}
+	response.Parameters = allowed
+
 	awslib.WriteSuccessResponseJSON(w, response)
 }
 
 func (api *ParameterApi) deleteParameter(w http.ResponseWriter, r *http.Request) {
 
 	var request awsssm.DeleteParameterInput
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if err := decodeRequest(r.Body, &request); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	response, err := api.service.DeleteParameter(&request)
+	response, err := api.serviceFor(r).DeleteParameter(r.Context(), &request)
 	if err != nil {
 
-		log.Println("Error:", err)
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
 		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
 		return
 	}
@@ -186,15 +318,15 @@ func (api *ParameterApi) deleteParameter(w http.ResponseWriter, r *http.Request)
 func (api *ParameterApi) deleteParameters(w http.ResponseWriter, r *http.Request) {
 
 	var request awsssm.DeleteParametersInput
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if err := decodeRequest(r.Body, &request); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	response, err := api.service.DeleteParameters(&request)
+	response, err := api.serviceFor(r).DeleteParameters(r.Context(), &request)
 	if err != nil {
 
-		log.Println("Error:", err)
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
 		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
 		return
 	}
@@ -206,15 +338,15 @@ func (api *ParameterApi) deleteParameters(w http.ResponseWriter, r *http.Request
 func (api *ParameterApi) putParameter(creds *aws.Credentials, w http.ResponseWriter, r *http.Request) {
 
 	var request awsssm.PutParameterInput
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if err := decodeRequest(r.Body, &request); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	response, err := api.service.PutParameter(creds, &request)
+	response, err := api.serviceFor(r).PutParameter(r.Context(), creds, &request)
 	if err != nil {
 
-		log.Println("Error:", err)
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
 		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
 		return
 	}
@@ -225,15 +357,15 @@ func (api *ParameterApi) putParameter(creds *aws.Credentials, w http.ResponseWri
 func (api *ParameterApi) addTagsToResource(w http.ResponseWriter, r *http.Request) {
 
 	var request awsssm.AddTagsToResourceInput
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if err := decodeRequest(r.Body, &request); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	response, err := api.service.AddTagsToResource(&request)
+	response, err := api.serviceFor(r).AddTagsToResource(r.Context(), &request)
 	if err != nil {
 
-		log.Println("Error:", err)
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
 		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
 		return
 	}
@@ -244,15 +376,15 @@ func (api *ParameterApi) addTagsToResource(w http.ResponseWriter, r *http.Reques
 func (api *ParameterApi) removeTagsFromResource(w http.ResponseWriter, r *http.Request) {
 
 	var request awsssm.RemoveTagsFromResourceInput
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if err := decodeRequest(r.Body, &request); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	response, err := api.service.RemoveTagsFromResource(&request)
+	response, err := api.serviceFor(r).RemoveTagsFromResource(r.Context(), &request)
 	if err != nil {
 
-		log.Println("Error:", err)
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
 		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
 		return
 	}
@@ -263,15 +395,15 @@ func (api *ParameterApi) removeTagsFromResource(w http.ResponseWriter, r *http.R
 func (api *ParameterApi) listTagsForResource(w http.ResponseWriter, r *http.Request) {
 
 	var request awsssm.ListTagsForResourceInput
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if err := decodeRequest(r.Body, &request); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	response, err := api.service.ListTagsForResource(&request)
+	response, err := api.serviceFor(r).ListTagsForResource(r.Context(), &request)
 	if err != nil {
 
-		log.Println("Error:", err)
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
 		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
 		return
 	}
@@ -279,23 +411,87 @@ func (api *ParameterApi) listTagsForResource(w http.ResponseWriter, r *http.Requ
 	awslib.WriteSuccessResponseJSON(w, response)
 }
 
-func (api *ParameterApi) parseCredentials(r *http.Request) (*aws.Credentials, error) {
+func (api *ParameterApi) putResourcePolicy(w http.ResponseWriter, r *http.Request) {
+
+	var request awsssm.PutResourcePolicyInput
+	if err := decodeRequest(r.Body, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	service := api.resourceService(r, aws.ToString(request.ResourceArn))
+	response, err := service.PutResourcePolicy(r.Context(), &request)
+	if err != nil {
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
+		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
+		return
+	}
 
-	var result aws.Credentials
-	accessKey := r.Header.Get("x-home-ssm-access-key")
+	awslib.WriteSuccessResponseJSON(w, response)
+}
 
-	for _, cred := range api.credentials.Credentials {
+func (api *ParameterApi) getResourcePolicies(w http.ResponseWriter, r *http.Request) {
 
-		if accessKey == cred.AccessKeyID {
-			result = cred
-			break
-		}
+	var request awsssm.GetResourcePoliciesInput
+	if err := decodeRequest(r.Body, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	service := api.resourceService(r, aws.ToString(request.ResourceArn))
+	response, err := service.GetResourcePolicies(r.Context(), &request)
+	if err != nil {
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
+		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
+		return
 	}
 
-	if result.AccessKeyID == "" {
+	awslib.WriteSuccessResponseJSON(w, response)
+}
+
+func (api *ParameterApi) deleteResourcePolicy(w http.ResponseWriter, r *http.Request) {
+
+	var request awsssm.DeleteResourcePolicyInput
+	if err := decodeRequest(r.Body, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	service := api.resourceService(r, aws.ToString(request.ResourceArn))
+	response, err := service.DeleteResourcePolicy(r.Context(), &request)
+	if err != nil {
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
+		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
+		return
+	}
+
+	awslib.WriteSuccessResponseJSON(w, response)
+}
+
+func (api *ParameterApi) parseCredentials(r *http.Request) (*aws.Credentials, error) {
+
+	principal := awslib.GetRequestInfo(r.Context()).Principal
+	if principal == nil {
+
+		return nil, errors.New("request has no authenticated principal")
+	}
+
+	return principal, nil
+}
+
+// decodeRequest reads exactly one JSON value from body. Authorization and
+// the operations decode the same body with it, so trailing data can't make
+// them see different requests.
+func decodeRequest(body io.Reader, value any) error {
+
+	decoder := json.NewDecoder(body)
+	if err := decoder.Decode(value); err != nil {
+		return ErrMalformedRequest
+	}
 
-		return nil, errors.New("unable to find access key in headers")
+	if _, err := decoder.Token(); err != io.EOF {
+		return ErrMalformedRequest
 	}
 
-	return &result, nil
+	return nil
 }
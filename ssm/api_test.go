@@ -0,0 +1,260 @@
+package ssm
+
+import (
+	"context"
+	"encoding/json"
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/dgraph-io/badger/v4"
+)
+
+const testAccountId = "000000000000"
+
+func openTestDB(t *testing.T) *badger.DB {
+
+	t.Helper()
+
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// testKeys has a single key only the "admin" access key may use.
+var testKeys = []KmsKey{
+	{KeyId: "admin-key", Alias: "admin", Key: "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=", Grants: []KeyGrant{
+		{AccessKey: "admin", Operations: []string{KeyOperationEncrypt, KeyOperationDecrypt}}}},
+}
+
+// newTestApi returns an api whose "restricted" access key may only get
+// parameters outside /secret.
+func newTestApi(t *testing.T) (*ParameterApi, *ParameterService) {
+
+	t.Helper()
+
+	service := NewParameterService("us-east-1", testAccountId, NewDataStore(openTestDB(t), testKeys))
+
+	document, err := policy.Parse("restricted", `{"Statement":[
+		{"Effect":"Allow","Action":"ssm:GetParameter*","Resource":"*"},
+		{"Effect":"Deny","Action":"ssm:*","Resource":"arn:aws:ssm:*:*:parameter/secret/*"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorizer := policy.NewAuthorizer()
+	authorizer.SetPolicies("restricted", []*policy.Document{document})
+
+	provider := awslib.CredentialsProvider{Region: "us-east-1", AccountId: testAccountId}
+
+	return NewParameterApi(service, &provider, authorizer), service
+}
+
+func putTestParameter(t *testing.T, service *ParameterService, name string, value string) {
+
+	t.Helper()
+	putTestParameterOfType(t, service, name, value, awstypes.ParameterTypeString)
+}
+
+func putTestParameterOfType(t *testing.T, service *ParameterService, name string, value string, paramType awstypes.ParameterType) {
+
+	t.Helper()
+
+	creds := aws.Credentials{AccessKeyID: "admin", AccountID: testAccountId}
+	_, err := service.PutParameter(context.Background(), &creds, &awsssm.PutParameterInput{
+		Name: aws.String(name), Value: aws.String(value), Type: paramType})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// callApi sends a request as the principal with the access key.
+func callApi(api *ParameterApi, accessKey string, target string, body string) *httptest.ResponseRecorder {
+
+	handler := awslib.WithRequestId(func(w http.ResponseWriter, r *http.Request) {
+
+		info := awslib.GetRequestInfo(r.Context())
+		info.Principal = &aws.Credentials{AccessKeyID: accessKey, AccountID: testAccountId}
+		info.Region = "us-east-1"
+		api.Handle(w, r)
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("X-Amz-Target", "AmazonSSM."+target)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	return w
+}
+
+func TestDecodeRequest(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		body  string
+		valid bool
+	}{
+		{"object", `{"Name":"/a"}`, true},
+		{"trailing whitespace", "{\"Name\":\"/a\"}\n", true},
+		{"empty", ``, false},
+		{"trailing junk", `{"Name":"/a"} junk`, false},
+		{"second object", `{"Name":"/a"}{"Name":"/secret/b"}`, false},
+		{"truncated", `{"Name":"/a"`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			var request awsssm.GetParameterInput
+			err := decodeRequest(strings.NewReader(test.body), &request)
+			if (err == nil) != test.valid {
+				t.Errorf("decodeRequest(%q) = %v, want valid %v", test.body, err, test.valid)
+			}
+		})
+	}
+}
+
+func TestHandle(t *testing.T) {
+
+	tests := []struct {
+		target string
+		body   string
+		status int
+		// want is part of the response body
+		want string
+	}{
+		{"GetParameter", `{"Name":"/app/a"}`, http.StatusOK, `"Value":"app-value"`},
+		{"GetParameters", `{"Names":["/app/a","/missing"]}`, http.StatusOK, `"InvalidParameters":["/missing"]`},
+		{"GetParametersByPath", `{"Path":"/app"}`, http.StatusOK, `"Name":"/app/a"`},
+		{"DescribeParameters", `{"ParameterFilters":[{"Key":"Path","Option":"Recursive","Values":["/"]}]}`, http.StatusOK, `"Name":"/app/a"`},
+		{"PutParameter", `{"Name":"/app/b","Value":"b","Type":"String"}`, http.StatusOK, `"Version":1`},
+		{"AddTagsToResource", `{"ResourceType":"Parameter","ResourceId":"/app/a","Tags":[{"Key":"env","Value":"prod"}]}`, http.StatusOK, `{}`},
+		{"ListTagsForResource", `{"ResourceType":"Parameter","ResourceId":"/app/a"}`, http.StatusOK, `"TagList"`},
+		{"DeleteParameter", `{"Name":"/app/a"}`, http.StatusOK, `{}`},
+		{"DeleteParameters", `{"Names":["/app/a"]}`, http.StatusOK, `"DeletedParameters":["/app/a"]`},
+		{"GetParameter", `{"Name":"/missing"}`, http.StatusBadRequest, "ParameterNotFound"},
+		{"GetParameter", `{"Name":"/app/a"} junk`, http.StatusBadRequest, "SerializationException"},
+		{"PutParameter", `{"Name":"/app/b","Value":"b","Type":"String"}{"Name":"/app/c"}`, http.StatusBadRequest, "SerializationException"},
+		{"SendCommand", `{}`, http.StatusBadRequest, "ValidationError"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.target, func(t *testing.T) {
+
+			api, service := newTestApi(t)
+			putTestParameter(t, service, "/app/a", "app-value")
+
+			w := callApi(api, "admin", test.target, test.body)
+			if w.Code != test.status || !strings.Contains(w.Body.String(), test.want) {
+				t.Errorf("got %d %s, want %d %s", w.Code, w.Body.String(), test.status, test.want)
+			}
+		})
+	}
+}
+
+func TestHandleWithoutPrincipal(t *testing.T) {
+
+	api, _ := newTestApi(t)
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"Name":"/app/a"}`))
+	r.Header.Set("X-Amz-Target", "AmazonSSM.GetParameter")
+	w := httptest.NewRecorder()
+	awslib.WithRequestId(api.Handle)(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("request without principal got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+
+	api, service := newTestApi(t)
+	putTestParameter(t, service, "/app/a", "app-value")
+	putTestParameter(t, service, "/secret/b", "secret-value")
+
+	tests := []struct {
+		name      string
+		accessKey string
+		body      string
+		status    int
+		code      string
+	}{
+		{"allowed", "restricted", `{"Name":"/app/a"}`, http.StatusOK, ""},
+		{"denied", "restricted", `{"Name":"/secret/b"}`, http.StatusBadRequest, "AccessDeniedException"},
+		{"unrestricted", "admin", `{"Name":"/secret/b"}`, http.StatusOK, ""},
+		// authorization used to be skipped for ARNs of unknown accounts
+		{"unknown account", "restricted", `{"Name":"arn:aws:ssm:us-east-1:111111111111:parameter/secret/b"}`,
+			http.StatusBadRequest, "AccessDeniedException"},
+		{"unknown region", "admin", `{"Name":"arn:aws:ssm:eu-west-1:` + testAccountId + `:parameter/secret/b"}`,
+			http.StatusBadRequest, "AccessDeniedException"},
+		// the handler used to ignore the junk the policy check failed on
+		{"trailing junk", "restricted", `{"Name":"/secret/b"} junk`, http.StatusBadRequest, "SerializationException"},
+		{"second object", "restricted", `{"Name":"/app/a"}{"Name":"/secret/b"}`, http.StatusBadRequest, "SerializationException"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			w := callApi(api, test.accessKey, "GetParameter", test.body)
+			if w.Code != test.status || !strings.Contains(w.Body.String(), test.code) {
+				t.Errorf("got %d %s, want %d %s", w.Code, w.Body.String(), test.status, test.code)
+			}
+
+			if test.accessKey == "restricted" && strings.Contains(w.Body.String(), "secret-value") {
+				t.Errorf("restricted principal read /secret/b: %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetParametersByPath(t *testing.T) {
+
+	api, service := newTestApi(t)
+	putTestParameter(t, service, "/app/a", "app-value")
+	putTestParameterOfType(t, service, "/secret/b", "secret-value", awstypes.ParameterTypeSecureString)
+
+	tests := []struct {
+		name      string
+		accessKey string
+		want      []string
+	}{
+		// the key of the hidden parameter used to fail the request
+		{"restricted", "restricted", []string{"app-value"}},
+		{"unrestricted", "admin", []string{"app-value", "secret-value"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			w := callApi(api, test.accessKey, "GetParametersByPath", `{"Path":"/","Recursive":true,"WithDecryption":true}`)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d %s", w.Code, w.Body.String())
+			}
+
+			var response GetParametersByPathResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, param := range response.Parameters {
+				got = append(got, param.Value)
+			}
+
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package ssm
+
+import (
+	"bytes"
+	"context"
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// authorizationInput is the union of the request fields needed to
+// authorize any of the supported operations.
+type authorizationInput struct {
+	Name         *string
+	Names        []string
+	Path         *string
+	ResourceId   *string
+	ResourceArn  *string
+	ResourceType awstypes.ResourceTypeForTagging
+	KeyId        *string
+	Type         awstypes.ParameterType
+	Tags         []awstypes.Tag
+}
+
+// arnOperations take parameters by ARN, which may be in another account.
+var arnOperations = []string{
+	"GetParameter", "GetParameters", "PutResourcePolicy", "GetResourcePolicies", "DeleteResourcePolicy"}
+
+// authorize evaluates the caller's policies for every resource named in the
+// request, and the resource policies of parameters in other accounts; a
+// denial on any resource denies the whole request.
+func (api *ParameterApi) authorize(r *http.Request, creds *aws.Credentials, operation string) error {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var input authorizationInput
+	if err := decodeRequest(bytes.NewReader(body), &input); err != nil {
+		return err
+	}
+
+	action := "ssm:" + operation
+	for _, name := range input.resourceNames(operation) {
+
+		service := api.serviceFor(r)
+		if slices.Contains(arnOperations, operation) {
+
+			// parameters shared by other accounts are read by ARN, those of
+			// unknown accounts or regions are denied like missing ones
+			resolved, paramName, err := api.resolveName(r, name)
+			if err != nil {
+				return &policy.AccessDeniedError{
+					PrincipalArn:  service.CreateUserArn(creds),
+					Action:        action,
+					Resource:      name,
+					ResourceBased: true,
+				}
+			}
+			service, name = resolved, paramName
+		}
+
+		if api.authorizer != nil {
+
+			request := policy.Request{
+				Action:   action,
+				Resource: service.resourceArn(name),
+			}
+
+			if err := api.authorizeRequest(r, service, creds, &request, name, &input); err != nil {
+				return err
+			}
+		}
+
+		if service.accountId != creds.AccountID {
+			if err := api.authorizeShared(r, service, creds, action, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (api *ParameterApi) authorizeRequest(r *http.Request, service *ParameterService,
+	creds *aws.Credentials, request *policy.Request, name string, input *authorizationInput) error {
+
+	principalArn := service.CreateUserArn(creds)
+	request.Context = policy.GlobalContext(creds, principalArn, awslib.GetRequestInfo(r.Context()).SourceIp)
+
+	if name != "" {
+		service.addResourceContext(r.Context(), request.Context, name)
+	}
+
+	if input != nil {
+
+		for _, tag := range input.Tags {
+			request.Context["aws:RequestTag/"+aws.ToString(tag.Key)] = []string{aws.ToString(tag.Value)}
+		}
+
+		if input.Type == awstypes.ParameterTypeSecureString {
+			request.Context["kms:KeyId"] = []string{service.keyIdOrDefault(aws.ToString(input.KeyId))}
+		}
+	}
+
+	return api.authorizer.Authorize(creds, principalArn, request)
+}
+
+// isAllowed reports whether the caller may see a parameter returned by a
+// path or describe query.
+func (api *ParameterApi) isAllowed(r *http.Request, creds *aws.Credentials, operation string, name ParamName) bool {
+
+	if api.authorizer == nil {
+		return true
+	}
+
+	service := api.serviceFor(r)
+	request := policy.Request{
+		Action:   "ssm:" + operation,
+		Resource: service.createParameterArn(name),
+	}
+
+	return api.authorizeRequest(r, service, creds, &request, string(name), nil) == nil
+}
+
+func (input *authorizationInput) resourceNames(operation string) []string {
+
+	switch operation {
+	case "GetParameter", "DeleteParameter", "PutParameter":
+		return []string{aws.ToString(input.Name)}
+	case "GetParameters", "DeleteParameters":
+		return input.Names
+	case "GetParametersByPath":
+		return []string{aws.ToString(input.Path)}
+	case "AddTagsToResource", "RemoveTagsFromResource", "ListTagsForResource":
+		return []string{aws.ToString(input.ResourceId)}
+	case "PutResourcePolicy", "GetResourcePolicies", "DeleteResourcePolicy":
+		return []string{aws.ToString(input.ResourceArn)}
+	}
+
+	// operations such as DescribeParameters aren't resource scoped
+	return []string{""}
+}
+
+// resourceArn returns the parameter ARN, or the account wide ARN used for
+// operations that aren't scoped to a parameter.
+func (service *ParameterService) resourceArn(name string) string {
+
+	if name == "" {
+		return "arn:aws:ssm:" + service.region + ":" + service.accountId + ":*"
+	}
+
+	paramName, err := NewParamName(&name)
+	if err != nil {
+		return service.createParameterArn(ParamName(name))
+	}
+
+	return service.createParameterArn(paramName.asPathName())
+}
+
+// addResourceContext adds the tag and key condition keys of an existing parameter.
+func (service *ParameterService) addResourceContext(ctx context.Context, conditions map[string][]string, name string) {
+
+	paramName, err := NewParamName(&name)
+	if err != nil {
+		return
+	}
+
+	param, err := service.dataStore.getParameter(ctx, string(paramName.asPathName()))
+	if err != nil {
+		return
+	}
+
+	for _, tag := range param.Tags {
+		conditions["ssm:resourceTag/"+tag.Key] = []string{tag.Value}
+		conditions["aws:ResourceTag/"+tag.Key] = []string{tag.Value}
+	}
+
+	if param.Type == awstypes.ParameterTypeSecureString {
+		conditions["kms:KeyId"] = []string{param.KeyId}
+	}
+}
+
+func (service *ParameterService) keyIdOrDefault(keyId string) string {
+
+	if keyId == "" {
+		return service.dataStore.DefaultKeyId()
+	}
+
+	return keyId
+}
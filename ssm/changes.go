@@ -0,0 +1,97 @@
+package ssm
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	awstypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// The operations of Parameter Store Change events.
+const (
+	ChangeCreate = "Create"
+	ChangeUpdate = "Update"
+	ChangeDelete = "Delete"
+)
+
+// ParameterChange describes a parameter that was created, updated or
+// deleted.
+type ParameterChange struct {
+	Operation   string
+	AccountId   string
+	Region      string
+	Name        string
+	ARN         string
+	Type        awstypes.ParameterType
+	Description string
+	Version     int64
+	Time        time.Time
+}
+
+// ChangeListener records parameter changes in the transaction that stores
+// them, so a change is never committed without its record. Listeners share
+// the database of the services they listen to.
+type ChangeListener interface {
+	// RecordChange adds the change to the transaction. The returned func is
+	// called once the transaction ended, committed or not.
+	RecordChange(ctx context.Context, txn *badger.Txn, change *ParameterChange) (func(committed bool), error)
+}
+
+func (service *ParameterService) AddChangeListener(listener ChangeListener) {
+
+	service.changeListeners = append(service.changeListeners, listener)
+}
+
+// recordChange returns the func the data store calls in the transaction of
+// a change, and finish, which tells the listeners how it ended. An empty
+// operation is derived from the stored version.
+func (service *ParameterService) recordChange(ctx context.Context, operation string, name ParamName) (
+	func(txn *badger.Txn, param *ParameterData) error, func(err error)) {
+
+	var done []func(committed bool)
+	record := func(txn *badger.Txn, param *ParameterData) error {
+
+		change := ParameterChange{
+			Operation:   cmp.Or(operation, changeOperation(param.Version)),
+			AccountId:   service.accountId,
+			Region:      service.region,
+			Name:        string(name),
+			ARN:         service.createParameterArn(name),
+			Type:        param.Type,
+			Description: param.Description,
+			Version:     param.Version,
+			Time:        time.Now().UTC(),
+		}
+
+		for _, listener := range service.changeListeners {
+
+			finish, err := listener.RecordChange(ctx, txn, &change)
+			if err != nil {
+				return err
+			}
+			done = append(done, finish)
+		}
+
+		return nil
+	}
+
+	finish := func(err error) {
+		for _, listenerDone := range slices.Backward(done) {
+			listenerDone(err == nil)
+		}
+	}
+
+	return record, finish
+}
+
+func changeOperation(version int64) string {
+
+	if version == 1 {
+		return ChangeCreate
+	}
+
+	return ChangeUpdate
+}
@@ -0,0 +1,197 @@
+package ssm
+
+import (
+	"context"
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"home-ssm/tracing"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// CopyParametersRequest copies the parameters named in Names and those
+// under Path from one region to another.
+type CopyParametersRequest struct {
+	SourceRegion      string
+	DestinationRegion string
+	Names             []string
+	Path              string
+	Recursive         bool
+	Overwrite         bool
+}
+
+type CopyParametersResponse struct {
+	CopiedParameters  []string
+	InvalidParameters []string
+}
+
+// HandleCopy serves the cross-region copy admin endpoint. The caller needs
+// ssm:GetParameter on the source and ssm:PutParameter on the destination
+// parameter.
+func (api *ParameterApi) HandleCopy(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	awslib.GetRequestInfo(r.Context()).Operation = "CopyParameters"
+
+	creds, err := api.parseCredentials(r)
+	if err != nil {
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
+		awslib.WriteErrorResponseJSON(w, awslib.ErrorCodes[awslib.ErrInternalError], r.URL, api.credentials.Region)
+		return
+	}
+
+	var request CopyParametersRequest
+	if err := decodeRequest(r.Body, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response, err := api.copyParameters(r, creds, &request)
+	if err != nil {
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
+		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
+		return
+	}
+
+	awslib.Logger(r.Context()).Info("parameters copied", "source", request.SourceRegion,
+		"destination", request.DestinationRegion, "copied", len(response.CopiedParameters))
+
+	awslib.WriteSuccessResponseJSON(w, response)
+}
+
+func (api *ParameterApi) copyParameters(
+	r *http.Request, creds *aws.Credentials, request *CopyParametersRequest) (*CopyParametersResponse, error) {
+
+	ctx, span := tracing.Start(r.Context(), "ParameterApi.copyParameters")
+	defer span.End()
+
+	source, ok := api.services[serviceKey(creds.AccountID, request.SourceRegion)]
+	if !ok {
+		return nil, ErrUnknownRegion
+	}
+
+	destination, ok := api.services[serviceKey(creds.AccountID, request.DestinationRegion)]
+	if !ok || destination == source {
+		return nil, ErrUnknownRegion
+	}
+
+	names := request.Names
+	if request.Path != "" {
+
+		paramPath, err := NewParamPath(&request.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		filter := paramPath.asOneLevelRegex()
+		if request.Recursive {
+			filter = paramPath.asRecursiveRegex()
+		}
+
+		params, err := source.dataStore.findParametersByKey(ctx, []string{filter})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, param := range params {
+			names = append(names, string(param.Name))
+		}
+	}
+
+	// authorize every parameter before copying any
+	for _, name := range names {
+
+		if err := api.authorizeCopy(r, source, creds, "GetParameter", name); err != nil {
+			return nil, err
+		}
+
+		if err := api.authorizeCopy(r, destination, creds, "PutParameter", name); err != nil {
+			return nil, err
+		}
+	}
+
+	var response CopyParametersResponse
+	for _, name := range names {
+
+		if err := source.CopyParameter(ctx, creds, name, destination, request.Overwrite); err != nil {
+			awslib.Logger(ctx).Warn("parameter not copied", "name", name, "error", err)
+			response.InvalidParameters = append(response.InvalidParameters, name)
+			continue
+		}
+
+		response.CopiedParameters = append(response.CopiedParameters, name)
+	}
+
+	return &response, nil
+}
+
+func (api *ParameterApi) authorizeCopy(
+	r *http.Request, service *ParameterService, creds *aws.Credentials, operation string, name string) error {
+
+	if api.authorizer == nil {
+		return nil
+	}
+
+	request := policy.Request{
+		Action:   "ssm:" + operation,
+		Resource: service.resourceArn(name),
+	}
+
+	return api.authorizeRequest(r, service, creds, &request, name, nil)
+}
+
+// CopyParameter copies a parameter to another region. SecureStrings are
+// re-encrypted with the same key if the destination has it, else with its
+// default key.
+func (service *ParameterService) CopyParameter(
+	ctx context.Context, creds *aws.Credentials, name string, destination *ParameterService, overwrite bool) error {
+
+	ctx, span := tracing.Start(ctx, "ParameterService.CopyParameter", tracing.ParameterName(name))
+	defer span.End()
+
+	param, err := service.getParameterByName(ctx, name, true)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return err
+	}
+
+	param.Name = param.Name.asPathName()
+	param.LastModifiedDate = float64(time.Now().UnixNano()) / float64(time.Second)
+	param.LastModifiedUser = destination.CreateUserArn(creds)
+	param.ResourcePolicies = nil
+
+	if param.Type == awstypes.ParameterTypeSecureString {
+
+		if _, err := destination.dataStore.FindKey(param.KeyId); err != nil {
+			param.KeyId = destination.dataStore.DefaultKeyId()
+		}
+
+		if err := destination.authorizeKey(ctx, param.KeyId, KeyOperationEncrypt); err != nil {
+			tracing.RecordError(span, err)
+			return err
+		}
+
+		encryptedValue, err := destination.dataStore.Encrypt(ctx, param.Value, param.KeyId)
+		if err != nil {
+			tracing.RecordError(span, err)
+			return ErrInvalidKeyId
+		}
+
+		param.Value = encryptedValue
+	}
+
+	record, finish := destination.recordChange(ctx, "", param.Name)
+	_, err = destination.dataStore.putParameter(ctx, string(param.Name), param, overwrite, record)
+	finish(err)
+	tracing.RecordError(span, err)
+
+	return err
+}
@@ -0,0 +1,236 @@
+package ssm
+
+import (
+	"context"
+	"encoding/json"
+	"home-ssm/awslib"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// otherKeys are the keys of a region without the key of testKeys.
+var otherKeys = []KmsKey{
+	{KeyId: "other-key", Alias: "other", Key: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=", Grants: []KeyGrant{
+		{AccessKey: "admin", Operations: []string{KeyOperationEncrypt, KeyOperationDecrypt}}}},
+}
+
+// newCopyTestApi returns an api serving us-east-1, eu-west-1 with the
+// same keys and ap-south-1 with otherKeys, all in one database.
+func newCopyTestApi(t *testing.T) (*ParameterApi, map[string]*ParameterService) {
+
+	t.Helper()
+
+	api, service := newTestApi(t)
+	services := map[string]*ParameterService{"us-east-1": service}
+	for region, keys := range map[string][]KmsKey{"eu-west-1": testKeys, "ap-south-1": otherKeys} {
+
+		services[region] = NewParameterService(region, testAccountId,
+			NewAccountDataStore(service.dataStore.db, testAccountId, region, keys))
+		api.AddService(services[region])
+	}
+
+	return api, services
+}
+
+func callCopy(api *ParameterApi, accessKey string, body string) *httptest.ResponseRecorder {
+
+	handler := awslib.WithRequestId(func(w http.ResponseWriter, r *http.Request) {
+
+		info := awslib.GetRequestInfo(r.Context())
+		info.Principal = &aws.Credentials{AccessKeyID: accessKey, AccountID: testAccountId}
+		info.Region = "us-east-1"
+		api.HandleCopy(w, r)
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/admin/copy", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	return w
+}
+
+// parameterValue returns the decrypted value of the parameter, "" if it's
+// missing.
+func parameterValue(t *testing.T, service *ParameterService, name string) string {
+
+	t.Helper()
+
+	param, err := service.getParameterByName(context.Background(), name, true)
+	if err != nil {
+		return ""
+	}
+
+	return param.Value
+}
+
+func TestCopyParameters(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		body    string
+		code    string
+		copied  []string
+		invalid []string
+		// values are the values in eu-west-1 afterwards
+		values map[string]string
+	}{
+		{
+			name:   "names",
+			body:   `{"SourceRegion":"us-east-1","DestinationRegion":"eu-west-1","Names":["/app/a","/app/db/b"]}`,
+			copied: []string{"/app/a", "/app/db/b"},
+			values: map[string]string{"/app/a": "a", "/app/db/b": "b", "/app/c": "old"},
+		},
+		{
+			name:    "existing without overwrite",
+			body:    `{"SourceRegion":"us-east-1","DestinationRegion":"eu-west-1","Names":["/app/a","/app/c"]}`,
+			copied:  []string{"/app/a"},
+			invalid: []string{"/app/c"},
+			values:  map[string]string{"/app/a": "a", "/app/c": "old"},
+		},
+		{
+			name:   "existing with overwrite",
+			body:   `{"SourceRegion":"us-east-1","DestinationRegion":"eu-west-1","Names":["/app/c"],"Overwrite":true}`,
+			copied: []string{"/app/c"},
+			values: map[string]string{"/app/c": "c"},
+		},
+		{
+			name:    "missing",
+			body:    `{"SourceRegion":"us-east-1","DestinationRegion":"eu-west-1","Names":["/app/missing"]}`,
+			invalid: []string{"/app/missing"},
+		},
+		{
+			name:   "one level",
+			body:   `{"SourceRegion":"us-east-1","DestinationRegion":"eu-west-1","Path":"/app"}`,
+			copied: []string{"/app/a"},
+			// /app/c exists
+			invalid: []string{"/app/c"},
+			values:  map[string]string{"/app/a": "a", "/app/db/b": ""},
+		},
+		{
+			name:   "recursive",
+			body:   `{"SourceRegion":"us-east-1","DestinationRegion":"eu-west-1","Path":"/app","Recursive":true,"Overwrite":true}`,
+			copied: []string{"/app/a", "/app/c", "/app/db/b"},
+			values: map[string]string{"/app/a": "a", "/app/c": "c", "/app/db/b": "b"},
+		},
+		{
+			name: "unknown region",
+			body: `{"SourceRegion":"us-east-1","DestinationRegion":"us-west-2","Names":["/app/a"]}`,
+			code: "ValidationException",
+		},
+		{
+			name: "same region",
+			body: `{"SourceRegion":"us-east-1","DestinationRegion":"us-east-1","Names":["/app/a"]}`,
+			code: "ValidationException",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			api, services := newCopyTestApi(t)
+			for name, value := range map[string]string{"/app/a": "a", "/app/c": "c", "/app/db/b": "b"} {
+				putTestParameter(t, services["us-east-1"], name, value)
+			}
+			putTestParameter(t, services["eu-west-1"], "/app/c", "old")
+
+			w := callCopy(api, "admin", test.body)
+			if test.code != "" {
+				if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), test.code) {
+					t.Errorf("got %d %s, want %s", w.Code, w.Body.String(), test.code)
+				}
+				return
+			}
+
+			var response CopyParametersResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("got %d %s", w.Code, w.Body.String())
+			}
+
+			slices.Sort(response.CopiedParameters)
+			if !slices.Equal(response.CopiedParameters, test.copied) || !slices.Equal(response.InvalidParameters, test.invalid) {
+				t.Errorf("copied %v, invalid %v, want %v, %v",
+					response.CopiedParameters, response.InvalidParameters, test.copied, test.invalid)
+			}
+
+			for name, value := range test.values {
+				if got := parameterValue(t, services["eu-west-1"], name); got != value {
+					t.Errorf("%s = %q in eu-west-1, want %q", name, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestCopySecureString(t *testing.T) {
+
+	tests := []struct {
+		destination string
+		keyId       string
+	}{
+		// the destination has the key of the parameter
+		{"eu-west-1", "alias/admin"},
+		// else its default key is used
+		{"ap-south-1", "alias/other"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.destination, func(t *testing.T) {
+
+			api, services := newCopyTestApi(t)
+			putTestParameterOfType(t, services["us-east-1"], "/app/secret", "s3cret", awstypes.ParameterTypeSecureString)
+
+			w := callCopy(api, "admin", `{"SourceRegion":"us-east-1","DestinationRegion":"`+test.destination+`","Names":["/app/secret"]}`)
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"CopiedParameters":["/app/secret"]`) {
+				t.Fatalf("got %d %s", w.Code, w.Body.String())
+			}
+
+			source, err := services["us-east-1"].getParameterByName(context.Background(), "/app/secret", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			copied, err := services[test.destination].getParameterByName(context.Background(), "/app/secret", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if copied.Type != awstypes.ParameterTypeSecureString || copied.KeyId != test.keyId {
+				t.Errorf("copied as %s with key %s, want SecureString with %s", copied.Type, copied.KeyId, test.keyId)
+			}
+
+			// encrypted again rather than copying the ciphertext
+			if copied.Value == source.Value || copied.Value == "s3cret" {
+				t.Errorf("copied ciphertext %q, source %q", copied.Value, source.Value)
+			}
+
+			if value := parameterValue(t, services[test.destination], "/app/secret"); value != "s3cret" {
+				t.Errorf("copied value decrypts to %q", value)
+			}
+		})
+	}
+}
+
+func TestCopyAuthorization(t *testing.T) {
+
+	api, services := newCopyTestApi(t)
+	putTestParameter(t, services["us-east-1"], "/app/a", "a")
+	putTestParameter(t, services["us-east-1"], "/app/b", "b")
+
+	// restricted may get the parameters but not put them
+	w := callCopy(api, "restricted", `{"SourceRegion":"us-east-1","DestinationRegion":"eu-west-1","Names":["/app/a","/app/b"]}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "AccessDeniedException") {
+		t.Fatalf("got %d %s, want AccessDeniedException", w.Code, w.Body.String())
+	}
+
+	for _, name := range []string{"/app/a", "/app/b"} {
+		if value := parameterValue(t, services["eu-west-1"], name); value != "" {
+			t.Errorf("%s copied although the copy was denied", name)
+		}
+	}
+}
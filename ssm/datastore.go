@@ -1,41 +1,134 @@
 package ssm
 
 import (
+	"context"
 	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"github.com/dgraph-io/badger/v4"
-	"io"
-	"log"
+	"home-ssm/tracing"
+	"log/slog"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
 )
 
 type DataStore struct {
-	db   *badger.DB
+	db *badger.DB
+	// prefix is the namespace of the parameters, empty for the primary
+	// region of the default account
+	prefix string
+	// storedKeys adds the keys created through the KMS API to the
+	// configured ones, only the default account has them
+	storedKeys bool
+	// keys are the configured keys, replaced as a whole by SetKeys
+	mu   sync.RWMutex
 	keys []KmsKey
 }
 
 type KmsKey struct {
-	KeyId string `yaml:"id"`
-	Alias string `yaml:"alias"`
-	Key   string `yaml:"key"`
+	KeyId string `yaml:"id" json:"KeyId"`
+	Alias string `yaml:"alias" json:"-"`
+	Key   string `yaml:"key" json:"Key"`
+	// WrappingKeyId is the configured key the material of a created key is
+	// stored under.
+	WrappingKeyId string `yaml:"-" json:"WrappingKeyId,omitempty"`
+	// Grants restrict key usage to the listed access keys
+	Grants       []KeyGrant `yaml:"grants" json:"-"`
+	Description  string     `yaml:"description" json:"Description,omitempty"`
+	CreationDate time.Time  `yaml:"-" json:"CreationDate"`
+}
+
+func (key *KmsKey) decode() ([]byte, error) {
+
+	material, err := base64.StdEncoding.DecodeString(key.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(material) {
+	case 16, 24, 32:
+		return material, nil
+	}
+
+	return nil, aes.KeySizeError(len(material))
 }
 
 func NewDataStore(db *badger.DB, keys []KmsKey) *DataStore {
 
-	return &DataStore{db: db, keys: keys}
+	return &DataStore{db: db, storedKeys: true, keys: keys}
 }
 
-func (ds *DataStore) delete(key string) error {
+// NewRegionDataStore returns a store that keeps the parameters of an
+// additional region under their own prefix.
+func NewRegionDataStore(db *badger.DB, region string, keys []KmsKey) *DataStore {
+
+	return &DataStore{db: db, prefix: "regions/" + region, storedKeys: true, keys: keys}
+}
+
+// NewAccountDataStore returns a store that keeps the parameters of another
+// account under their own prefix, in its primary region if region is
+// empty. The prefixes of an account's regions are siblings, so none holds
+// another's keys. Only the configured keys are used.
+func NewAccountDataStore(db *badger.DB, accountId string, region string, keys []KmsKey) *DataStore {
+
+	prefix := "accounts/" + accountId + "/params"
+	if region != "" {
+		prefix = "accounts/" + accountId + "/regions/" + region + "/params"
+	}
+
+	return &DataStore{db: db, prefix: prefix, keys: keys}
+}
+
+// KeyPrefix returns the prefix of the keys of the store's parameters.
+func (ds *DataStore) KeyPrefix() string {
+
+	return ds.prefix + "/"
+}
+
+func (ds *DataStore) configKeys() []KmsKey {
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	return ds.keys
+}
+
+// delete removes a parameter, record is called in the transaction with the
+// parameter if it existed.
+func (ds *DataStore) delete(ctx context.Context, key string, record func(txn *badger.Txn, param *ParameterData) error) error {
+
+	_, span := tracing.Start(ctx, "DataStore.delete", tracing.ParameterName(key))
+	defer span.End()
 
 	err := ds.db.Update(
 		func(txn *badger.Txn) error {
-			return txn.Delete([]byte(key))
+
+			item, err := txn.Get([]byte(ds.prefix + key))
+			if err == nil && record != nil {
+
+				var param ParameterData
+				if err := item.Value(func(val []byte) error {
+					return json.Unmarshal(val, &param)
+				}); err != nil {
+					return err
+				}
+
+				if err := record(txn, &param); err != nil {
+					return err
+				}
+
+			} else if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+
+				return err
+			}
+
+			return txn.Delete([]byte(ds.prefix + key))
 		})
 
+	tracing.RecordError(span, err)
 	if err != nil {
 
 		if errors.Is(err, badger.ErrKeyNotFound) {
@@ -49,18 +142,24 @@ func (ds *DataStore) delete(key string) error {
 	return nil
 }
 
-func (ds *DataStore) findParametersByKey(filters []string) ([]ParameterData, error) {
+func (ds *DataStore) findParametersByKey(ctx context.Context, filters []string) ([]ParameterData, error) {
+
+	_, span := tracing.Start(ctx, "DataStore.findParametersByKey")
+	defer span.End()
 
 	var result []ParameterData
 
 	err := ds.db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.PrefetchValues = false
+		if ds.prefix != "" {
+			opts.Prefix = []byte(ds.prefix + "/")
+		}
 		it := txn.NewIterator(opts)
 		defer it.Close()
 		for it.Rewind(); it.Valid(); it.Next() {
 			item := it.Item()
-			key := string(item.Key())
+			key := strings.TrimPrefix(string(item.Key()), ds.prefix)
 			for _, filter := range filters {
 
 				match, _ := regexp.MatchString(filter, key)
@@ -88,6 +187,7 @@ func (ds *DataStore) findParametersByKey(filters []string) ([]ParameterData, err
 		return nil
 	})
 
+	tracing.RecordError(span, err)
 	if err != nil {
 		return nil, err
 	}
@@ -95,12 +195,15 @@ func (ds *DataStore) findParametersByKey(filters []string) ([]ParameterData, err
 	return result, nil
 }
 
-func (ds *DataStore) getParameter(key string) (*ParameterData, error) {
+func (ds *DataStore) getParameter(ctx context.Context, key string) (*ParameterData, error) {
+
+	_, span := tracing.Start(ctx, "DataStore.getParameter", tracing.ParameterName(key))
+	defer span.End()
 
 	var param ParameterData
 
 	err := ds.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(key))
+		item, err := txn.Get([]byte(ds.prefix + key))
 		if err != nil {
 			if errors.Is(err, badger.ErrKeyNotFound) {
 
@@ -115,22 +218,29 @@ func (ds *DataStore) getParameter(key string) (*ParameterData, error) {
 		})
 	})
 
+	tracing.RecordError(span, err)
 	if err != nil {
-		log.Println("An error occurred.", err)
+		slog.Debug("get parameter failed", "error", err)
 		return nil, err
 	}
 
 	return &param, nil
 }
 
-func (ds *DataStore) putParameter(key string, value *ParameterData, overwrite bool) (int64, error) {
+// putParameter stores the parameter as its next version, record is called
+// in the transaction with the stored parameter unless it's nil.
+func (ds *DataStore) putParameter(ctx context.Context, key string, value *ParameterData, overwrite bool,
+	record func(txn *badger.Txn, param *ParameterData) error) (int64, error) {
+
+	_, span := tracing.Start(ctx, "DataStore.putParameter", tracing.ParameterName(key))
+	defer span.End()
 
 	var newVersion int64 = 1
 	var existingParam ParameterData
 
 	err := ds.db.Update(func(txn *badger.Txn) error {
 
-		item, err := txn.Get([]byte(key))
+		item, err := txn.Get([]byte(ds.prefix + key))
 
 		if err == nil {
 
@@ -145,6 +255,10 @@ func (ds *DataStore) putParameter(key string, value *ParameterData, overwrite bo
 			}
 
 			newVersion = existingParam.Version + 1
+			if value.ResourcePolicies == nil {
+				// overwriting the value keeps the parameter shared
+				value.ResourcePolicies = existingParam.ResourcePolicies
+			}
 
 		} else if !errors.Is(err, badger.ErrKeyNotFound) {
 
@@ -157,9 +271,18 @@ func (ds *DataStore) putParameter(key string, value *ParameterData, overwrite bo
 			return err
 		}
 
-		return txn.Set([]byte(key), paramBytes)
+		if err := txn.Set([]byte(ds.prefix+key), paramBytes); err != nil {
+			return err
+		}
+
+		if record != nil {
+			return record(txn, value)
+		}
+
+		return nil
 	})
 
+	tracing.RecordError(span, err)
 	if err != nil {
 		return -1, err
 	}
@@ -167,80 +290,81 @@ func (ds *DataStore) putParameter(key string, value *ParameterData, overwrite bo
 	return newVersion, nil
 }
 
-func (ds *DataStore) findKeyId(keyId string) ([]byte, error) {
+// updateParameter changes a stored parameter in place, keeping its version.
+func (ds *DataStore) updateParameter(ctx context.Context, key string, update func(param *ParameterData) error) error {
 
-	// TODO doesn't handle ARNs
-	for _, key := range ds.keys {
+	_, span := tracing.Start(ctx, "DataStore.updateParameter", tracing.ParameterName(key))
+	defer span.End()
 
-		if "alias/"+key.Alias == keyId || keyId == key.KeyId {
+	err := ds.db.Update(func(txn *badger.Txn) error {
 
-			return base64.StdEncoding.DecodeString(key.Key)
+		item, err := txn.Get([]byte(ds.prefix + key))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrParameterNotFound
+		} else if err != nil {
+			return err
+		}
+
+		var param ParameterData
+		if err := item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &param)
+		}); err != nil {
+			return err
+		}
+
+		if err := update(&param); err != nil {
+			return err
+		}
+
+		paramBytes, err := json.Marshal(&param)
+		if err != nil {
+			return err
 		}
-	}
 
-	return nil, ErrInvalidKeyId
+		return txn.Set([]byte(ds.prefix+key), paramBytes)
+	})
+
+	tracing.RecordError(span, err)
+
+	return err
 }
 
-func (ds *DataStore) encrypt(stringToEncrypt string, keyId string) (string, error) {
+func (ds *DataStore) Encrypt(ctx context.Context, stringToEncrypt string, keyId string) (string, error) {
 
-	key, err := ds.findKeyId(keyId)
-	if err != nil {
-		return "", err
-	}
+	_, span := tracing.Start(ctx, "DataStore.Encrypt", tracing.KeyId(keyId))
+	defer span.End()
 
-	// Since the key is in string format, convert it to bytes
-	block, err := aes.NewCipher(key)
+	kmsKey, err := ds.FindKey(keyId)
 	if err != nil {
 		return "", err
 	}
 
-	// Create a new GCM - https://en.wikipedia.org/wiki/Galois/Counter_Mode
-	// https://golang.org/pkg/crypto/cipher/#NewGCM
-	aesGCM, err := cipher.NewGCM(block)
+	ciphertext, err := kmsKey.Seal([]byte(stringToEncrypt), nil)
 	if err != nil {
 		return "", err
 	}
 
-	// Create a nonce. Nonce should never be reused with the same key.
-	// Since we use GCM, we recommend using 12 bytes.
-	nonce := make([]byte, aesGCM.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		return "", err
-	}
-
-	// Encrypt the data using aesGCM.Seal. Since we don't want to save the nonce somewhere else in this case, we add it as a prefix to the encrypted data. The first nonce argument in Seal is the prefix.
-	ciphertext := aesGCM.Seal(nonce, nonce, []byte(stringToEncrypt), nil)
 	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
-func (ds *DataStore) decrypt(encryptedString string, keyId string) (string, error) {
-
-	key, err := ds.findKeyId(keyId)
-	if err != nil {
-		return "", err
-	}
+func (ds *DataStore) Decrypt(ctx context.Context, encryptedString string, keyId string) (string, error) {
 
-	enc, err := base64.StdEncoding.DecodeString(encryptedString)
-	if err != nil {
-		return "", err
-	}
+	_, span := tracing.Start(ctx, "DataStore.Decrypt", tracing.KeyId(keyId))
+	defer span.End()
 
-	block, err := aes.NewCipher(key)
+	kmsKey, err := ds.FindKey(keyId)
 	if err != nil {
 		return "", err
 	}
 
-	aesGCM, err := cipher.NewGCM(block)
+	enc, err := base64.StdEncoding.DecodeString(encryptedString)
 	if err != nil {
 		return "", err
 	}
 
-	nonceSize := aesGCM.NonceSize()
-
-	nonce, ciphertext := enc[:nonceSize], enc[nonceSize:]
-
-	plaintext, err := aesGCM.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := kmsKey.Open(enc, nil)
 	if err != nil {
+		tracing.RecordError(span, err)
 		return "", err
 	}
 
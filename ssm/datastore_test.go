@@ -0,0 +1,54 @@
+package ssm
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+func TestNamespacesAreSeparate(t *testing.T) {
+
+	db := openTestDB(t)
+	services := map[string]*ParameterService{
+		"primary":        NewParameterService("us-east-1", testAccountId, NewDataStore(db, nil)),
+		"region":         NewParameterService("eu-west-1", testAccountId, NewRegionDataStore(db, "eu-west-1", nil)),
+		"account":        NewParameterService("us-east-1", "111111111111", NewAccountDataStore(db, "111111111111", "", nil)),
+		"account region": NewParameterService("eu-west-1", "111111111111", NewAccountDataStore(db, "111111111111", "eu-west-1", nil)),
+	}
+
+	// the names collide with the keys of the other namespaces
+	names := []string{"/x", "/regions/eu-west-1/x", "/params/x"}
+	for _, service := range services {
+		for _, name := range names {
+			putTestParameter(t, service, name, service.region+"/"+service.accountId)
+		}
+	}
+
+	for label, service := range services {
+		t.Run(label, func(t *testing.T) {
+
+			response, err := service.GetParametersByPath(context.Background(), &awsssm.GetParametersByPathInput{
+				Path: aws.String("/"), Recursive: aws.Bool(true)}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, param := range response.Parameters {
+
+				got = append(got, string(param.Name))
+				if param.Value != service.region+"/"+service.accountId {
+					t.Errorf("%s has the value %q of another namespace", param.Name, param.Value)
+				}
+			}
+
+			slices.Sort(got)
+			if want := slices.Sorted(slices.Values(names)); !slices.Equal(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
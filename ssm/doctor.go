@@ -0,0 +1,67 @@
+package ssm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	awstypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Problem is a stored record that can't be used.
+type Problem struct {
+	Key     string
+	Message string
+}
+
+// CheckParameters reads every parameter and returns how many there are,
+// reporting those that can't be read and SecureStrings whose key is
+// missing or can't decrypt the value.
+func (ds *DataStore) CheckParameters(ctx context.Context) (int, []Problem, error) {
+
+	var params []ParameterData
+	var problems []Problem
+	count := 0
+
+	err := ds.db.View(func(txn *badger.Txn) error {
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte(ds.KeyPrefix())
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+
+			count++
+			var param ParameterData
+			err := it.Item().Value(func(val []byte) error { return json.Unmarshal(val, &param) })
+			if err != nil {
+				problems = append(problems, Problem{Key: string(it.Item().Key()), Message: fmt.Sprintf("unreadable: %v", err)})
+				continue
+			}
+
+			params = append(params, param)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for _, param := range params {
+
+		if param.Type != awstypes.ParameterTypeSecureString {
+			continue
+		}
+
+		if _, err := ds.FindKey(param.KeyId); err != nil {
+			problems = append(problems, Problem{Key: string(param.Name), Message: fmt.Sprintf("key %s doesn't exist", param.KeyId)})
+		} else if _, err := ds.Decrypt(ctx, param.Value, param.KeyId); err != nil {
+			problems = append(problems, Problem{Key: string(param.Name), Message: fmt.Sprintf("can't be decrypted with key %s", param.KeyId)})
+		}
+	}
+
+	return count, problems, nil
+}
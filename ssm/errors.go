@@ -3,6 +3,7 @@ package ssm
 import (
 	"errors"
 	"home-ssm/awslib"
+	"home-ssm/policy"
 	"net/http"
 )
 
@@ -19,11 +20,31 @@ var (
 	ErrInvalidFilterValue       = errors.New("The filter value isn't valid. Verify the value and try again.")
 	ErrUnsupportedParameterType = errors.New("The parameter type isn't supported.")
 	ErrInvalidPath              = errors.New("The parameter doesn't meet the parameter name requirements. The parameter name must begin with a forward slash '/'.")
+	ErrThrottled                = errors.New("Rate exceeded")
+	ErrMalformedRequest         = errors.New("The request body isn't a single valid JSON object.")
+	ErrUnknownRegion            = errors.New("The region isn't served by this server.")
+	ErrInvalidRevision          = errors.New("The revision isn't valid or its changes have expired, watch again with a snapshot.")
+
+	ErrResourceNotFound               = errors.New("The specified parameter to be shared could not be found.")
+	ErrResourcePolicyInvalidParameter = errors.New("Only Advanced tier parameters can be shared, and PolicyId and PolicyHash are required to change a policy.")
+	ErrMalformedResourcePolicy        = errors.New("The specified policy document is malformed or invalid, or excessive PutResourcePolicy or DeleteResourcePolicy calls have been made.")
+	ErrResourcePolicyConflict         = errors.New("The hash provided in the call doesn't match the stored hash. This exception is thrown when trying to update an obsolete policy version or when multiple requests to update a policy are sent.")
+	ErrResourcePolicyNotFound         = errors.New("No policies with the specified policy ID and hash could be found.")
 )
 
 type errorCodeMap map[error]awslib.APIError
 
 var SsmErrorCodes = errorCodeMap{
+	ErrThrottled: {
+		Code:           "ThrottlingException",
+		Description:    ErrThrottled.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrMalformedRequest: {
+		Code:           "SerializationException",
+		Description:    ErrMalformedRequest.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
 	ErrInternalError: {
 		Code:           "InternalError",
 		Description:    ErrInternalError.Error(),
@@ -79,11 +100,51 @@ var SsmErrorCodes = errorCodeMap{
 		Description:    ErrInvalidFilterValue.Error(),
 		HTTPStatusCode: http.StatusBadRequest,
 	},
+	ErrReferenceWithoutDecryption: {
+		Code:           "ValidationException",
+		Description:    ErrReferenceWithoutDecryption.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
 	ErrInvalidPath: {
 		Code:           "ValidationException",
 		Description:    ErrInvalidPath.Error(),
 		HTTPStatusCode: http.StatusBadRequest,
 	},
+	ErrUnknownRegion: {
+		Code:           "ValidationException",
+		Description:    ErrUnknownRegion.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidRevision: {
+		Code:           "InvalidRevisionException",
+		Description:    ErrInvalidRevision.Error(),
+		HTTPStatusCode: http.StatusGone,
+	},
+	ErrResourceNotFound: {
+		Code:           "ResourceNotFoundException",
+		Description:    ErrResourceNotFound.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrResourcePolicyInvalidParameter: {
+		Code:           "ResourcePolicyInvalidParameterException",
+		Description:    ErrResourcePolicyInvalidParameter.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrMalformedResourcePolicy: {
+		Code:           "MalformedResourcePolicyDocumentException",
+		Description:    ErrMalformedResourcePolicy.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrResourcePolicyConflict: {
+		Code:           "ResourcePolicyConflictException",
+		Description:    ErrResourcePolicyConflict.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrResourcePolicyNotFound: {
+		Code:           "ResourcePolicyNotFoundException",
+		Description:    ErrResourcePolicyNotFound.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
 }
 
 func translateToApiError(err error) awslib.APIError {
@@ -94,5 +155,15 @@ func translateToApiError(err error) awslib.APIError {
 		return value
 	}
 
+	var accessDenied *policy.AccessDeniedError
+	if errors.As(err, &accessDenied) {
+
+		return awslib.APIError{
+			Code:           "AccessDeniedException",
+			Description:    accessDenied.Error(),
+			HTTPStatusCode: http.StatusBadRequest,
+		}
+	}
+
 	return awslib.ErrorCodes[awslib.ErrInternalError]
 }
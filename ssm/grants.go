@@ -0,0 +1,128 @@
+package ssm
+
+import (
+	"context"
+	"fmt"
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"slices"
+)
+
+const (
+	KeyOperationEncrypt = "Encrypt"
+	KeyOperationDecrypt = "Decrypt"
+	// GenerateDataKey is only used by the KMS API
+	KeyOperationGenerateDataKey = "GenerateDataKey"
+)
+
+// KeyGrant allows an access key, every access key of a user, or sessions
+// of a role, to use a KmsKey for the listed operations. Users may be
+// created through IAM after the grant.
+type KeyGrant struct {
+	AccessKey  string   `yaml:"accessKey"`
+	User       string   `yaml:"user"`
+	Role       string   `yaml:"role"`
+	Operations []string `yaml:"operations"`
+}
+
+func (grant *KeyGrant) grantee() string {
+
+	if grant.Role != "" {
+		return "role/" + grant.Role
+	} else if grant.User != "" {
+		return "user/" + grant.User
+	}
+
+	return grant.AccessKey
+}
+
+func (grant *KeyGrant) Validate() error {
+
+	if len(slices.DeleteFunc([]string{grant.AccessKey, grant.User, grant.Role}, func(s string) bool { return s == "" })) != 1 {
+		return fmt.Errorf("grant must have one of accessKey, user or role")
+	}
+
+	if len(grant.Operations) == 0 {
+		return fmt.Errorf("grant for %s has no operations", grant.grantee())
+	}
+
+	for _, operation := range grant.Operations {
+		if !slices.Contains([]string{KeyOperationEncrypt, KeyOperationDecrypt, KeyOperationGenerateDataKey}, operation) {
+			return fmt.Errorf("grant for %s has unknown operation %q", grant.grantee(), operation)
+		}
+	}
+
+	return nil
+}
+
+// Allows reports whether the grantee, an access key, "user/<name>" or
+// "role/<name>", may use the key for the operation. Keys without any
+// grants may be used by every credential.
+func (key *KmsKey) Allows(grantee string, operation string) bool {
+
+	if len(key.Grants) == 0 {
+		return true
+	}
+
+	for _, grant := range key.Grants {
+		if grant.grantee() == grantee && slices.Contains(grant.Operations, operation) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorizeKey checks the grants of the key against the request principal.
+// Unknown keys are left for encrypt and decrypt to report.
+func (service *ParameterService) authorizeKey(ctx context.Context, keyId string, operation string) error {
+
+	kmsKey, err := service.dataStore.FindKey(keyId)
+	if err != nil {
+		return nil
+	}
+
+	return AuthorizeKey(ctx, kmsKey, operation, service.region, service.accountId)
+}
+
+// AuthorizeKey checks the grants of the key against the request principal,
+// requests without a principal are internal and always allowed.
+func AuthorizeKey(ctx context.Context, kmsKey *KmsKey, operation string, region string, accountId string) error {
+
+	info := awslib.GetRequestInfo(ctx)
+	principal := info.Principal
+	if principal == nil {
+		return nil
+	}
+
+	// temporary credentials use the grants of the user or role they came
+	// from, users only have grants in their own account
+	grantees := []string{principal.AccessKeyID}
+	if session := info.Session; session != nil {
+
+		grantees = []string{session.ParentAccessKey}
+		if session.RoleName != "" {
+			grantees = []string{"role/" + session.RoleName}
+		}
+	}
+
+	if principal.AccountID == accountId && (info.Session == nil || info.Session.RoleName == "") {
+		grantees = append(grantees, "user/"+principal.Source)
+	}
+
+	if slices.ContainsFunc(grantees, func(grantee string) bool { return kmsKey.Allows(grantee, operation) }) {
+		return nil
+	}
+
+	return &policy.AccessDeniedError{
+		PrincipalArn:  awslib.PrincipalArn(accountId, principal),
+		Action:        "kms:" + operation,
+		Resource:      kmsKey.Arn(region, accountId),
+		ResourceBased: true,
+	}
+}
+
+func (key *KmsKey) Arn(region string, accountId string) string {
+
+	return fmt.Sprintf("arn:aws:kms:%s:%s:key/%s", region, accountId, key.KeyId)
+}
@@ -0,0 +1,55 @@
+package ssm
+
+import (
+	"home-ssm/awslib"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestAuthorizeKey(t *testing.T) {
+
+	key := KmsKey{KeyId: "granted", Grants: []KeyGrant{
+		{AccessKey: "AKIACONFIG", Operations: []string{KeyOperationDecrypt}},
+		{User: "ci", Operations: []string{KeyOperationDecrypt}},
+		{Role: "deploy", Operations: []string{KeyOperationEncrypt, KeyOperationDecrypt}},
+	}}
+
+	tests := []struct {
+		name      string
+		principal aws.Credentials
+		session   *awslib.Session
+		operation string
+		allowed   bool
+	}{
+		{"access key", aws.Credentials{AccessKeyID: "AKIACONFIG", AccountID: testAccountId}, nil, KeyOperationDecrypt, true},
+		{"operation not granted", aws.Credentials{AccessKeyID: "AKIACONFIG", AccountID: testAccountId}, nil, KeyOperationEncrypt, false},
+		{"iam user", aws.Credentials{AccessKeyID: "AKIAIAM", Source: "ci", AccountID: testAccountId}, nil, KeyOperationDecrypt, true},
+		{"user of another account", aws.Credentials{AccessKeyID: "AKIAOTHER", Source: "ci", AccountID: "111111111111"}, nil, KeyOperationDecrypt, false},
+		{"session of user", aws.Credentials{AccessKeyID: "ASIA1", Source: "ci", AccountID: testAccountId},
+			&awslib.Session{ParentAccessKey: "AKIAIAM"}, KeyOperationDecrypt, true},
+		{"session of role", aws.Credentials{AccessKeyID: "ASIA2", Source: "deploy/s", AccountID: testAccountId},
+			&awslib.Session{ParentAccessKey: "AKIAOTHER", RoleName: "deploy"}, KeyOperationEncrypt, true},
+		{"other user", aws.Credentials{AccessKeyID: "AKIAOTHER", Source: "dev", AccountID: testAccountId}, nil, KeyOperationDecrypt, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			var err error
+			handler := awslib.WithRequestId(func(w http.ResponseWriter, r *http.Request) {
+
+				info := awslib.GetRequestInfo(r.Context())
+				info.Principal, info.Session = &test.principal, test.session
+				err = AuthorizeKey(r.Context(), &key, test.operation, "us-east-1", testAccountId)
+			})
+			handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+
+			if (err == nil) != test.allowed {
+				t.Errorf("AuthorizeKey() = %v, want allowed %v", err, test.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package ssm
+
+import (
+	"crypto/aes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	healthProbeKey = "health/probe"
+	healthProbeTTL = time.Minute
+)
+
+// CheckReady verifies the database is open and writable and that every
+// configured key can be used for encryption.
+func (ds *DataStore) CheckReady() error {
+
+	if ds.db == nil || ds.db.IsClosed() {
+		return errors.New("database is not open")
+	}
+
+	if ds.db.Opts().ReadOnly {
+		return errors.New("database is read-only")
+	}
+
+	// the probe overwrites the same key and expires, so frequent probes
+	// don't pile up in the database
+	err := ds.db.Update(func(txn *badger.Txn) error {
+
+		entry := badger.NewEntry([]byte(healthProbeKey), []byte(time.Now().UTC().Format(time.RFC3339))).
+			WithTTL(healthProbeTTL)
+
+		return txn.SetEntry(entry)
+	})
+
+	if err != nil {
+		return fmt.Errorf("database is not writable: %w", err)
+	}
+
+	return ValidateKeys(ds.configKeys())
+}
+
+// ValidateKeys returns an error if there are no keys, if ids or aliases
+// are missing or repeated, or if any key isn't base64 encoded AES-128,
+// AES-192 or AES-256 key material.
+func ValidateKeys(keys []KmsKey) error {
+
+	if len(keys) == 0 {
+		return errors.New("no keys configured")
+	}
+
+	var errs []error
+	ids := make(map[string]bool)
+	aliases := make(map[string]bool)
+	for i, key := range keys {
+
+		if key.KeyId == "" || key.Alias == "" {
+			errs = append(errs, fmt.Errorf("key %d needs an id and alias", i+1))
+		}
+
+		if ids[key.KeyId] {
+			errs = append(errs, fmt.Errorf("duplicate key id %s", key.KeyId))
+		}
+		ids[key.KeyId] = true
+
+		if aliases[key.Alias] {
+			errs = append(errs, fmt.Errorf("duplicate key alias alias/%s", key.Alias))
+		}
+		aliases[key.Alias] = true
+
+		var sizeErr aes.KeySizeError
+		if _, err := key.decode(); errors.As(err, &sizeErr) {
+			errs = append(errs, fmt.Errorf("key alias/%s is %d bytes, it must be 16, 24 or 32", key.Alias, int(sizeErr)))
+		} else if err != nil {
+			errs = append(errs, fmt.Errorf("key alias/%s isn't base64: %w", key.Alias, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (service *ParameterService) CheckReady() error {
+
+	if service.dataStore == nil {
+		return errors.New("data store is not initialized")
+	}
+
+	return service.dataStore.CheckReady()
+}
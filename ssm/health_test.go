@@ -0,0 +1,80 @@
+package ssm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestCheckReady(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		keys  []KmsKey
+		close bool
+		// blockWrites makes every write fail with badger.ErrBlockedWrites
+		blockWrites bool
+		ready       bool
+	}{
+		{"ready", testKeys, false, false, true},
+		{"closed database", testKeys, true, false, false},
+		{"not writable", testKeys, false, true, false},
+		{"no keys", nil, false, false, false},
+		{"invalid key", []KmsKey{{KeyId: "bad", Alias: "bad", Key: "c2hvcnQ="}}, false, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			db := openTestDB(t)
+			dataStore := NewDataStore(db, test.keys)
+			if test.close {
+				db.Close()
+			}
+
+			if test.blockWrites {
+				writer := db.NewStreamWriter()
+				if err := writer.PrepareIncremental(); err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(writer.Cancel)
+			}
+
+			err := dataStore.CheckReady()
+			if (err == nil) != test.ready {
+				t.Fatalf("CheckReady() = %v, want ready %v", err, test.ready)
+			}
+
+			if test.blockWrites && !errors.Is(err, badger.ErrBlockedWrites) {
+				t.Errorf("CheckReady() = %v, want %v", err, badger.ErrBlockedWrites)
+			}
+		})
+	}
+}
+
+func TestCheckReadyProbeExpires(t *testing.T) {
+
+	db := openTestDB(t)
+	if err := NewDataStore(db, testKeys).CheckReady(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := db.View(func(txn *badger.Txn) error {
+
+		item, err := txn.Get([]byte(healthProbeKey))
+		if err != nil {
+			return err
+		}
+
+		if item.ExpiresAt() == 0 {
+			t.Errorf("probe %s doesn't expire", healthProbeKey)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,236 @@
+package ssm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"home-ssm/tracing"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	journalPrefix = "changes/"
+
+	DefaultJournalRetention = 24 * time.Hour
+)
+
+type WatchConfig struct {
+	// Disabled turns off the change journal and the /watch endpoint.
+	Disabled bool `yaml:"disabled"`
+	// RetentionHours is how long watchers can resume from a revision,
+	// defaults to 24.
+	RetentionHours int `yaml:"retentionHours"`
+}
+
+// journalEntry is a change and the revision it was recorded at.
+type journalEntry struct {
+	Revision int64
+	Change   ParameterChange
+}
+
+// journalRecord is a stored change. Previous is the revision of the change
+// before it, so a gap left by expired changes is noticed.
+type journalRecord struct {
+	Previous int64
+	Change   ParameterChange
+}
+
+// ChangeJournal records parameter changes in revision order, so watchers
+// can resume after the last revision they saw. Revisions are nanosecond
+// times that never go backwards; entries expire through badger TTLs.
+type ChangeJournal struct {
+	db        *badger.DB
+	retention time.Duration
+
+	mu sync.Mutex
+	// revision is the revision of the last recorded change
+	revision int64
+	// changed is closed and replaced on every change
+	changed chan struct{}
+}
+
+func NewChangeJournal(db *badger.DB, config WatchConfig) (*ChangeJournal, error) {
+
+	journal := ChangeJournal{
+		db:        db,
+		retention: DefaultJournalRetention,
+		changed:   make(chan struct{}),
+	}
+
+	if config.RetentionHours > 0 {
+		journal.retention = time.Duration(config.RetentionHours) * time.Hour
+	}
+
+	// continue after the last recorded revision, whatever the clock says
+	err := db.View(func(txn *badger.Txn) error {
+
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = true
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		it.Seek([]byte(journalPrefix + "~"))
+		if it.ValidForPrefix([]byte(journalPrefix)) {
+			revision, err := parseJournalKey(it.Item().Key())
+			if err != nil {
+				return err
+			}
+			journal.revision = revision
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &journal, nil
+}
+
+func journalKey(revision int64) []byte {
+
+	return []byte(fmt.Sprintf("%s%016x", journalPrefix, revision))
+}
+
+func parseJournalKey(key []byte) (int64, error) {
+
+	return strconv.ParseInt(string(key[len(journalPrefix):]), 16, 64)
+}
+
+func formatRevision(revision int64) string {
+
+	return strconv.FormatInt(revision, 10)
+}
+
+func parseRevision(token string) (int64, error) {
+
+	revision, err := strconv.ParseInt(token, 10, 64)
+	if err != nil || revision < 0 {
+		return 0, ErrInvalidRevision
+	}
+
+	return revision, nil
+}
+
+// RecordChange adds the change to the transaction that makes it, and wakes
+// the watchers once it's committed. The lock is held until the transaction
+// ended, so a revision is only handed out once every change before it is
+// committed.
+func (journal *ChangeJournal) RecordChange(ctx context.Context, txn *badger.Txn, change *ParameterChange) (func(committed bool), error) {
+
+	_, span := tracing.Start(ctx, "ChangeJournal.RecordChange", tracing.ParameterName(change.Name))
+	defer span.End()
+
+	journal.mu.Lock()
+
+	value, err := json.Marshal(journalRecord{Previous: journal.revision, Change: *change})
+	revision := max(time.Now().UnixNano(), journal.revision+1)
+	if err == nil {
+		err = txn.SetEntry(badger.NewEntry(journalKey(revision), value).WithTTL(journal.retention))
+	}
+
+	tracing.RecordError(span, err)
+	if err != nil {
+		journal.mu.Unlock()
+		return nil, fmt.Errorf("record parameter change: %w", err)
+	}
+
+	return func(committed bool) {
+
+		if committed {
+			journal.revision = revision
+			close(journal.changed)
+			journal.changed = make(chan struct{})
+		}
+
+		journal.mu.Unlock()
+	}, nil
+}
+
+// current returns the revision of the last recorded change, and a channel
+// closed on the next change.
+func (journal *ChangeJournal) current() (int64, <-chan struct{}) {
+
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	return journal.revision, journal.changed
+}
+
+// read returns up to limit changes after a revision, the revision to read
+// from next time, and a channel closed on the next change. A revision whose
+// later changes have expired fails with ErrInvalidRevision.
+func (journal *ChangeJournal) read(
+	ctx context.Context, after int64, limit int) ([]journalEntry, int64, <-chan struct{}, error) {
+
+	_, span := tracing.Start(ctx, "ChangeJournal.read")
+	defer span.End()
+
+	revision, changed := journal.current()
+	if after > revision {
+		return nil, 0, nil, ErrInvalidRevision
+	}
+
+	var entries []journalEntry
+	err := journal.db.View(func(txn *badger.Txn) error {
+
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(journalPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(journalKey(after + 1)); it.Valid(); it.Next() {
+
+			entry := journalEntry{}
+			entry.Revision, _ = parseJournalKey(it.Item().Key())
+			if entry.Revision > revision {
+				break
+			}
+
+			var record journalRecord
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &record)
+			})
+			if err != nil {
+				return err
+			}
+
+			// the changes between the revision and the first one left expired
+			if len(entries) == 0 && record.Previous > after {
+				return ErrInvalidRevision
+			}
+
+			entry.Change = record.Change
+			entries = append(entries, entry)
+			if len(entries) == limit {
+				revision = entry.Revision
+				break
+			}
+		}
+
+		// every change after the revision expired
+		if len(entries) == 0 && after < revision {
+			return ErrInvalidRevision
+		}
+
+		return nil
+	})
+
+	tracing.RecordError(span, err)
+	if err != nil {
+		if !errors.Is(err, ErrInvalidRevision) {
+			slog.Error("Failed to read change journal.", "error", err)
+		}
+		return nil, 0, nil, err
+	}
+
+	return entries, revision, changed, nil
+}
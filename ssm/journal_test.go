@@ -0,0 +1,258 @@
+package ssm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/dgraph-io/badger/v4"
+)
+
+func newTestJournal(t *testing.T) (*ChangeJournal, *badger.DB) {
+
+	t.Helper()
+
+	db := openTestDB(t)
+	journal, err := NewChangeJournal(db, WatchConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return journal, db
+}
+
+// recordTestChanges records a change of each name in its own transaction
+// and returns their revisions.
+func recordTestChanges(journal *ChangeJournal, names ...string) []int64 {
+
+	var revisions []int64
+	for _, name := range names {
+
+		var done func(committed bool)
+		err := journal.db.Update(func(txn *badger.Txn) error {
+			var err error
+			done, err = journal.RecordChange(context.Background(), txn, &ParameterChange{Operation: ChangeUpdate, Name: name})
+			return err
+		})
+		done(err == nil)
+
+		revision, _ := journal.current()
+		revisions = append(revisions, revision)
+	}
+
+	return revisions
+}
+
+func TestChangeJournalRead(t *testing.T) {
+
+	journal, _ := newTestJournal(t)
+	if revision, _ := journal.current(); revision != 0 {
+		t.Fatalf("empty journal at revision %d", revision)
+	}
+
+	revisions := recordTestChanges(journal, "/a", "/b", "/c")
+	tests := []struct {
+		name     string
+		after    int64
+		limit    int
+		want     []string
+		revision int64
+		err      error
+	}{
+		{"from the start", 0, 10, []string{"/a", "/b", "/c"}, revisions[2], nil},
+		{"after a change", revisions[0], 10, []string{"/b", "/c"}, revisions[2], nil},
+		{"between changes", revisions[0] + 1, 10, []string{"/b", "/c"}, revisions[2], nil},
+		{"batch", 0, 2, []string{"/a", "/b"}, revisions[1], nil},
+		{"up to date", revisions[2], 10, nil, revisions[2], nil},
+		{"future", revisions[2] + 1, 10, nil, 0, ErrInvalidRevision},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			entries, revision, _, err := journal.read(context.Background(), test.after, test.limit)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("read() = %v, want %v", err, test.err)
+			}
+
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Change.Name)
+			}
+
+			if !slices.Equal(names, test.want) || revision != test.revision {
+				t.Errorf("got %v at %d, want %v at %d", names, revision, test.want, test.revision)
+			}
+		})
+	}
+}
+
+func TestChangeJournalCurrent(t *testing.T) {
+
+	journal, _ := newTestJournal(t)
+	revisions := recordTestChanges(journal, "/a")
+
+	// watchers without a revision mustn't move the journal on
+	for range 3 {
+		if revision, _ := journal.current(); revision != revisions[0] {
+			t.Fatalf("current() = %d, want %d", revision, revisions[0])
+		}
+	}
+
+	_, changed := journal.current()
+	recordTestChanges(journal, "/b")
+	select {
+	case <-changed:
+	default:
+		t.Error("the change didn't close the channel")
+	}
+}
+
+func TestChangeJournalExpired(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		expired []int
+		after   int
+		valid   bool
+	}{
+		{"first expired", []int{0}, -1, false},
+		{"after the expired", []int{0}, 0, true},
+		{"all expired", []int{0, 1, 2}, 0, false},
+		{"all expired and up to date", []int{0, 1, 2}, 2, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			journal, db := newTestJournal(t)
+			revisions := recordTestChanges(journal, "/a", "/b", "/c")
+
+			// expire the entries as their TTL would
+			err := db.Update(func(txn *badger.Txn) error {
+				for _, i := range test.expired {
+					if err := txn.Delete(journalKey(revisions[i])); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			after := int64(0)
+			if test.after >= 0 {
+				after = revisions[test.after]
+			}
+
+			entries, _, _, err := journal.read(context.Background(), after, 10)
+			if (err == nil) != test.valid {
+				t.Errorf("read() = %v %v, want valid %v", entries, err, test.valid)
+			}
+		})
+	}
+}
+
+// failingListener fails every change after the journal recorded it.
+type failingListener struct{}
+
+func (failingListener) RecordChange(context.Context, *badger.Txn, *ParameterChange) (func(bool), error) {
+
+	return nil, badger.ErrConflict
+}
+
+func TestChangeJournalRollback(t *testing.T) {
+
+	journal, db := newTestJournal(t)
+	service := NewParameterService("us-east-1", testAccountId, NewDataStore(db, testKeys))
+	service.AddChangeListener(journal)
+	putTestParameter(t, service, "/a", "value")
+	revision, changed := journal.current()
+
+	service.AddChangeListener(failingListener{})
+	creds := aws.Credentials{AccessKeyID: "admin", AccountID: testAccountId}
+	_, err := service.PutParameter(context.Background(), &creds, &awsssm.PutParameterInput{
+		Name: aws.String("/b"), Value: aws.String("value"), Type: awstypes.ParameterTypeString})
+	if err == nil {
+		t.Fatal("change stored although a listener failed")
+	}
+
+	if current, _ := journal.current(); current != revision {
+		t.Errorf("revision moved to %d by a change that wasn't stored", current)
+	}
+
+	select {
+	case <-changed:
+		t.Error("watchers woken by a change that wasn't stored")
+	default:
+	}
+
+	entries, _, _, err := journal.read(context.Background(), 0, 10)
+	if err != nil || len(entries) != 1 || entries[0].Change.Name != "/a" {
+		t.Errorf("read() = %d entries, %v, want the change of /a only", len(entries), err)
+	}
+}
+
+func TestChangeJournalConcurrent(t *testing.T) {
+
+	journal, db := newTestJournal(t)
+	service := NewParameterService("us-east-1", testAccountId, NewDataStore(db, testKeys))
+	service.AddChangeListener(journal)
+
+	creds := aws.Credentials{AccessKeyID: "admin", AccountID: testAccountId}
+	errs := make(chan error, 20)
+	for i := range cap(errs) {
+		go func() {
+			_, err := service.PutParameter(context.Background(), &creds, &awsssm.PutParameterInput{
+				Name: aws.String(fmt.Sprintf("/p%d", i)), Value: aws.String("value"), Type: awstypes.ParameterTypeString})
+			errs <- err
+		}()
+	}
+
+	for range cap(errs) {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every stored change links to the one before it, so none was
+	// committed out of revision order
+	previous := int64(0)
+	err := db.View(func(txn *badger.Txn) error {
+
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(journalPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+
+			var record journalRecord
+			if err := it.Item().Value(func(val []byte) error { return json.Unmarshal(val, &record) }); err != nil {
+				return err
+			}
+
+			revision, _ := parseJournalKey(it.Item().Key())
+			if record.Previous != previous {
+				t.Errorf("change %s at %d follows %d, want %d", record.Change.Name, revision, record.Previous, previous)
+			}
+			previous = revision
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if revision, _ := journal.current(); revision != previous {
+		t.Errorf("current() = %d, want the last stored revision %d", revision, previous)
+	}
+}
@@ -0,0 +1,465 @@
+package ssm
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"home-ssm/awslib"
+	"home-ssm/metrics"
+	"home-ssm/tracing"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	awstypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Keys created through the KMS API are stored beside the parameters; the
+// configured keys are never written to the database.
+const (
+	keyPrefix      = "kms/key/"
+	aliasPrefix    = "kms/alias/"
+	rotationPrefix = "kms/rotation/"
+)
+
+var ErrAliasAlreadyExists = errors.New("An alias with the name already exists.")
+
+// KeyAlias maps an alias name, without the "alias/" prefix, to a key id.
+type KeyAlias struct {
+	Name  string
+	KeyId string
+}
+
+// Seal encrypts with AES-GCM and prefixes the random nonce to the result.
+func (key *KmsKey) Seal(plaintext []byte, additionalData []byte) ([]byte, error) {
+
+	aesGCM, err := key.newGCM()
+	if err != nil {
+		return nil, err
+	}
+
+	// Nonce should never be reused with the same key.
+	nonce := make([]byte, aesGCM.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aesGCM.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts the output of Seal.
+func (key *KmsKey) Open(ciphertext []byte, additionalData []byte) ([]byte, error) {
+
+	metrics.Decrypts.WithLabelValues(key.KeyId).Inc()
+
+	return key.open(ciphertext, additionalData)
+}
+
+// open is Open without counting a decrypt, for unwrapping key material.
+func (key *KmsKey) open(ciphertext []byte, additionalData []byte) ([]byte, error) {
+
+	aesGCM, err := key.newGCM()
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := aesGCM.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
+
+	return aesGCM.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func (key *KmsKey) newGCM() (cipher.AEAD, error) {
+
+	material, err := key.decode()
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(material)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// DefaultKeyId is the key used for SecureString parameters without a KeyId.
+func (ds *DataStore) DefaultKeyId() string {
+
+	keys := ds.configKeys()
+	if len(keys) == 0 {
+		return ""
+	}
+
+	return "alias/" + keys[0].Alias
+}
+
+// FindKey resolves a key id, key ARN, alias name or alias ARN to its key.
+func (ds *DataStore) FindKey(keyId string) (*KmsKey, error) {
+
+	return ds.findKey(ds.configKeys(), keyId)
+}
+
+// findKey resolves keyId as FindKey would with keys configured.
+func (ds *DataStore) findKey(keys []KmsKey, keyId string) (*KmsKey, error) {
+
+	// arn:aws:kms:<region>:<account>:key/<id> or :alias/<name>
+	if strings.HasPrefix(keyId, "arn:") {
+
+		fields := strings.SplitN(keyId, ":", 6)
+		if len(fields) != 6 {
+			return nil, ErrInvalidKeyId
+		}
+
+		keyId = strings.TrimPrefix(fields[5], "key/")
+	}
+
+	if aliasName, ok := strings.CutPrefix(keyId, "alias/"); ok {
+
+		for _, key := range keys {
+			if key.Alias == aliasName {
+				return &key, nil
+			}
+		}
+
+		if !ds.storedKeys {
+			return nil, ErrInvalidKeyId
+		}
+
+		var target string
+		if err := ds.getJSON(aliasPrefix+aliasName, &target); err != nil {
+			return nil, err
+		}
+
+		keyId = target
+	}
+
+	for _, key := range keys {
+		if key.KeyId == keyId {
+			return &key, nil
+		}
+	}
+
+	if !ds.storedKeys {
+		return nil, ErrInvalidKeyId
+	}
+
+	var key KmsKey
+	if err := ds.getJSON(keyPrefix+keyId, &key); err != nil {
+		return nil, err
+	}
+
+	if err := unwrap(keys, &key); err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// unwrap replaces the stored material of a created key with the material
+// decrypted under its wrapping key, which must be one of keys.
+func unwrap(keys []KmsKey, key *KmsKey) error {
+
+	i := slices.IndexFunc(keys, func(wrapping KmsKey) bool { return wrapping.KeyId == key.WrappingKeyId })
+	if i < 0 {
+		return fmt.Errorf("key %s is wrapped under key %s, which isn't configured", key.KeyId, key.WrappingKeyId)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(key.Key)
+	if err != nil {
+		return err
+	}
+
+	material, err := keys[i].open(sealed, []byte(key.KeyId))
+	if err != nil {
+		return fmt.Errorf("key %s can't be unwrapped: %w", key.KeyId, err)
+	}
+
+	key.Key = base64.StdEncoding.EncodeToString(material)
+
+	return nil
+}
+
+// KeyReference is data encrypted under KeyId, an empty KeyId being the
+// default key.
+type KeyReference struct {
+	KeyId string
+	// User names the data in errors, e.g. "secret home/db".
+	User string
+}
+
+// SetKeys replaces the configured keys. It fails, keeping the current keys,
+// if a SecureString parameter or one of the other references could no
+// longer be decrypted because its key was removed or its material changed.
+func (ds *DataStore) SetKeys(ctx context.Context, keys []KmsKey, references []KeyReference) error {
+
+	_, span := tracing.Start(ctx, "DataStore.SetKeys")
+	defer span.End()
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	params, err := ds.findParametersByKey(ctx, []string{"^/"})
+	if err != nil {
+		tracing.RecordError(span, err)
+		return err
+	}
+
+	for _, param := range params {
+		if param.Type == awstypes.ParameterTypeSecureString {
+			references = append(references, KeyReference{KeyId: param.KeyId, User: "SecureString " + string(param.Name)})
+		}
+	}
+
+	err = ds.scanJSON(keyPrefix, func(_ string, value []byte) error {
+
+		var key KmsKey
+		if err := json.Unmarshal(value, &key); err != nil {
+			return err
+		}
+
+		references = append(references, KeyReference{KeyId: key.WrappingKeyId, User: "KMS key " + key.KeyId})
+		return nil
+	})
+	if err != nil {
+		tracing.RecordError(span, err)
+		return err
+	}
+
+	for _, reference := range references {
+
+		current, err := ds.findKey(ds.keys, defaultKeyId(ds.keys, reference.KeyId))
+		if err != nil || !slices.ContainsFunc(ds.keys, func(key KmsKey) bool { return key.KeyId == current.KeyId }) {
+			continue
+		}
+
+		replacement, err := ds.findKey(keys, defaultKeyId(keys, reference.KeyId))
+		if err != nil || replacement.Key != current.Key {
+			return fmt.Errorf("key alias/%s is still used by %s", current.Alias, reference.User)
+		}
+	}
+
+	ds.keys = keys
+
+	return nil
+}
+
+func defaultKeyId(keys []KmsKey, keyId string) string {
+
+	if keyId == "" && len(keys) > 0 {
+		return "alias/" + keys[0].Alias
+	}
+
+	return keyId
+}
+
+// Keys returns the configured keys followed by the created keys.
+func (ds *DataStore) Keys() ([]KmsKey, error) {
+
+	configKeys := ds.configKeys()
+	keys := append([]KmsKey{}, configKeys...)
+
+	err := ds.scanJSON(keyPrefix, func(_ string, value []byte) error {
+
+		var key KmsKey
+		if err := json.Unmarshal(value, &key); err != nil {
+			return err
+		}
+
+		if err := unwrap(configKeys, &key); err != nil {
+			return err
+		}
+
+		keys = append(keys, key)
+		return nil
+	})
+
+	return keys, err
+}
+
+// Aliases returns the configured aliases followed by the created aliases.
+func (ds *DataStore) Aliases() ([]KeyAlias, error) {
+
+	var aliases []KeyAlias
+	for _, key := range ds.configKeys() {
+		aliases = append(aliases, KeyAlias{Name: key.Alias, KeyId: key.KeyId})
+	}
+
+	err := ds.scanJSON(aliasPrefix, func(name string, value []byte) error {
+
+		var target string
+		if err := json.Unmarshal(value, &target); err != nil {
+			return err
+		}
+
+		aliases = append(aliases, KeyAlias{Name: name, KeyId: target})
+		return nil
+	})
+
+	return aliases, err
+}
+
+// CreateKey generates a new AES-256 key. Its material is stored wrapped
+// under the configured key wrappingKeyId, so a copy of the database alone
+// can't decrypt what the key protects.
+func (ds *DataStore) CreateKey(ctx context.Context, description string, wrappingKeyId string) (*KmsKey, error) {
+
+	_, span := tracing.Start(ctx, "DataStore.CreateKey")
+	defer span.End()
+
+	keys := ds.configKeys()
+	wrapping, err := ds.findKey(keys, wrappingKeyId)
+	if err == nil && !slices.ContainsFunc(keys, func(key KmsKey) bool { return key.KeyId == wrapping.KeyId }) {
+		err = fmt.Errorf("%w: created keys can't wrap other keys", ErrInvalidKeyId)
+	}
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, err
+	}
+
+	material := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, material); err != nil {
+		return nil, err
+	}
+
+	key := KmsKey{
+		KeyId:         awslib.NewUUID(),
+		Key:           base64.StdEncoding.EncodeToString(material),
+		WrappingKeyId: wrapping.KeyId,
+		Description:   description,
+		CreationDate:  time.Now().UTC(),
+	}
+
+	// the key id is bound to the wrapped material so it can't be moved
+	sealed, err := wrapping.Seal(material, []byte(key.KeyId))
+	if err != nil {
+		return nil, err
+	}
+
+	stored := key
+	stored.Key = base64.StdEncoding.EncodeToString(sealed)
+
+	err = ds.setJSON(keyPrefix+key.KeyId, &stored, false)
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// CreateAlias points a new alias, without the "alias/" prefix, at the key.
+func (ds *DataStore) CreateAlias(ctx context.Context, aliasName string, keyId string) error {
+
+	_, span := tracing.Start(ctx, "DataStore.CreateAlias", tracing.KeyId(keyId))
+	defer span.End()
+
+	for _, key := range ds.configKeys() {
+		if key.Alias == aliasName {
+			return ErrAliasAlreadyExists
+		}
+	}
+
+	err := ds.setJSON(aliasPrefix+aliasName, keyId, false)
+	tracing.RecordError(span, err)
+
+	return err
+}
+
+func (ds *DataStore) KeyRotationEnabled(keyId string) (bool, error) {
+
+	var enabled bool
+	err := ds.getJSON(rotationPrefix+keyId, &enabled)
+	if errors.Is(err, ErrInvalidKeyId) {
+		return false, nil
+	}
+
+	return enabled, err
+}
+
+// SetKeyRotation records the rotation setting. Key material is never
+// rotated, so existing ciphertexts stay readable.
+func (ds *DataStore) SetKeyRotation(ctx context.Context, keyId string, enabled bool) error {
+
+	_, span := tracing.Start(ctx, "DataStore.SetKeyRotation", tracing.KeyId(keyId))
+	defer span.End()
+
+	err := ds.setJSON(rotationPrefix+keyId, enabled, true)
+	tracing.RecordError(span, err)
+
+	return err
+}
+
+// getJSON reads a KMS entry, ErrInvalidKeyId if it doesn't exist.
+func (ds *DataStore) getJSON(key string, value any) error {
+
+	return ds.db.View(func(txn *badger.Txn) error {
+
+		item, err := txn.Get([]byte(key))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrInvalidKeyId
+		} else if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, value)
+		})
+	})
+}
+
+func (ds *DataStore) setJSON(key string, value any, overwrite bool) error {
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return ds.db.Update(func(txn *badger.Txn) error {
+
+		if !overwrite {
+
+			_, err := txn.Get([]byte(key))
+			if err == nil {
+				return ErrAliasAlreadyExists
+			} else if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+		}
+
+		return txn.Set([]byte(key), data)
+	})
+}
+
+// scanJSON calls fn with the key suffix and value of every entry under the prefix.
+func (ds *DataStore) scanJSON(prefix string, fn func(name string, value []byte) error) error {
+
+	return ds.db.View(func(txn *badger.Txn) error {
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek([]byte(prefix)); it.ValidForPrefix([]byte(prefix)); it.Next() {
+
+			item := it.Item()
+			name := strings.TrimPrefix(string(item.Key()), prefix)
+			if err := item.Value(func(val []byte) error { return fn(name, val) }); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,138 @@
+package ssm
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+var wrappingKeys = []KmsKey{
+	{KeyId: "first", Alias: "first", Key: "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="},
+	{KeyId: "second", Alias: "second", Key: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="},
+}
+
+func TestCreateKey(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		wrappingKeyId string
+		// wrappedBy is the configured key the material is stored under
+		wrappedBy string
+	}{
+		{"default key", "alias/first", "first"},
+		{"by key id", "second", "second"},
+		{"by key arn", "arn:aws:kms:us-east-1:000000000000:key/second", "second"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			db := openTestDB(t)
+			ds := NewDataStore(db, wrappingKeys)
+			created, err := ds.CreateKey(context.Background(), "test", test.wrappingKeyId)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var stored []byte
+			err = db.View(func(txn *badger.Txn) error {
+				item, err := txn.Get([]byte(keyPrefix + created.KeyId))
+				if err != nil {
+					return err
+				}
+				stored, err = item.ValueCopy(nil)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Contains(string(stored), created.Key) {
+				t.Fatal("key material stored in plain text")
+			}
+
+			found, err := ds.FindKey(created.KeyId)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if found.Key != created.Key || found.WrappingKeyId != test.wrappedBy {
+				t.Errorf("found key wrapped by %s, want the created material wrapped by %s", found.WrappingKeyId, test.wrappedBy)
+			}
+
+			sealed, err := created.Seal([]byte("secret"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if plaintext, err := found.Open(sealed, nil); err != nil || string(plaintext) != "secret" {
+				t.Errorf("Open() = %q, %v", plaintext, err)
+			}
+
+			keys, err := ds.Keys()
+			if err != nil || len(keys) != 3 || keys[2].Key != created.Key {
+				t.Errorf("Keys() = %d keys, %v, want the created key unwrapped", len(keys), err)
+			}
+		})
+	}
+}
+
+func TestCreateKeyWrappingKey(t *testing.T) {
+
+	ds := NewDataStore(openTestDB(t), wrappingKeys)
+	created, err := ds.CreateKey(context.Background(), "", "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		wrappingKeyId string
+	}{
+		{"unknown key", "missing"},
+		{"created key", created.KeyId},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			if _, err := ds.CreateKey(context.Background(), "", test.wrappingKeyId); !errors.Is(err, ErrInvalidKeyId) {
+				t.Errorf("CreateKey() = %v, want %v", err, ErrInvalidKeyId)
+			}
+		})
+	}
+}
+
+func TestSetKeysKeepsWrappingKeys(t *testing.T) {
+
+	changed := KmsKey{KeyId: "second", Alias: "second", Key: base64.StdEncoding.EncodeToString(make([]byte, 32))}
+	tests := []struct {
+		name    string
+		keys    []KmsKey
+		allowed bool
+	}{
+		{"unchanged", wrappingKeys, true},
+		{"other key removed", wrappingKeys[1:], true},
+		{"wrapping key removed", wrappingKeys[:1], false},
+		{"wrapping key changed", []KmsKey{wrappingKeys[0], changed}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			// the first key is the default, so it only wraps keys when named
+			ds := NewDataStore(openTestDB(t), wrappingKeys)
+			if _, err := ds.CreateKey(context.Background(), "", "second"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := ds.SetKeys(context.Background(), test.keys, nil); (err == nil) != test.allowed {
+				t.Errorf("SetKeys() = %v, want allowed %v", err, test.allowed)
+			}
+		})
+	}
+}
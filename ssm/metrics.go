@@ -0,0 +1,127 @@
+package ssm
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var knownOperations = []string{
+	"AddTagsToResource",
+	"DeleteParameter",
+	"DeleteParameters",
+	"DeleteResourcePolicy",
+	"DescribeParameters",
+	"GetParameter",
+	"GetParameters",
+	"GetParametersByPath",
+	"GetResourcePolicies",
+	"ListTagsForResource",
+	"PutParameter",
+	"PutResourcePolicy",
+	"RemoveTagsFromResource",
+}
+
+// StorageCollector reports parameter counts and database sizes at scrape
+// time. The counts are only taken again once the database changed.
+type StorageCollector struct {
+	db         *badger.DB
+	services   []*ParameterService
+	parameters *prometheus.Desc
+	lsmSize    *prometheus.Desc
+	vlogSize   *prometheus.Desc
+
+	mu sync.Mutex
+	// version is the database version the counts were taken at
+	version uint64
+	counts  map[parameterCount]int
+}
+
+type parameterCount struct {
+	accountId string
+	region    string
+	paramType string
+	tier      string
+}
+
+func NewStorageCollector(service *ParameterService) *StorageCollector {
+
+	return &StorageCollector{
+		db:       service.dataStore.db,
+		services: []*ParameterService{service},
+		parameters: prometheus.NewDesc("home_ssm_parameters",
+			"Number of stored parameters by account, region, type and tier.", []string{"account", "region", "type", "tier"}, nil),
+		lsmSize: prometheus.NewDesc("home_ssm_badger_lsm_size_bytes",
+			"Size of the badger LSM tree.", nil, nil),
+		vlogSize: prometheus.NewDesc("home_ssm_badger_vlog_size_bytes",
+			"Size of the badger value log.", nil, nil),
+	}
+}
+
+// AddService counts the parameters of another region or account too.
+func (c *StorageCollector) AddService(service *ParameterService) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.services = append(c.services, service)
+	c.counts = nil
+}
+
+func (c *StorageCollector) Describe(ch chan<- *prometheus.Desc) {
+
+	ch <- c.parameters
+	ch <- c.lsmSize
+	ch <- c.vlogSize
+}
+
+func (c *StorageCollector) Collect(ch chan<- prometheus.Metric) {
+
+	lsm, vlog := c.db.Size()
+	ch <- prometheus.MustNewConstMetric(c.lsmSize, prometheus.GaugeValue, float64(lsm))
+	ch <- prometheus.MustNewConstMetric(c.vlogSize, prometheus.GaugeValue, float64(vlog))
+
+	counts, err := c.parameterCounts()
+	if err != nil {
+		slog.Error("Failed to count parameters.", "error", err)
+		return
+	}
+
+	for key, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.parameters, prometheus.GaugeValue,
+			float64(count), key.accountId, key.region, key.paramType, key.tier)
+	}
+}
+
+// parameterCounts returns the counts of the last scrape unless a write
+// moved the database version on since.
+func (c *StorageCollector) parameterCounts() (map[parameterCount]int, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	version := c.db.MaxVersion()
+	if c.counts != nil && version == c.version {
+		return c.counts, nil
+	}
+
+	counts := make(map[parameterCount]int)
+	for _, service := range c.services {
+
+		parameters, err := service.dataStore.findParametersByKey(context.Background(), []string{"^/"})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, param := range parameters {
+			counts[parameterCount{service.accountId, service.region, string(param.Type), string(param.Tier)}]++
+		}
+	}
+
+	c.version, c.counts = version, counts
+
+	return counts, nil
+}
@@ -0,0 +1,96 @@
+package ssm
+
+import (
+	"testing"
+
+	awstypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// parameterGauges returns home_ssm_parameters by account, region, type and
+// tier joined with "/".
+func parameterGauges(t *testing.T, collector *StorageCollector) map[string]float64 {
+
+	t.Helper()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gauges := map[string]float64{}
+	for _, family := range families {
+
+		if family.GetName() != "home_ssm_parameters" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+
+			key := labels["account"] + "/" + labels["region"] + "/" + labels["type"] + "/" + labels["tier"]
+			gauges[key] = metric.GetGauge().GetValue()
+		}
+	}
+
+	return gauges
+}
+
+func TestStorageCollector(t *testing.T) {
+
+	db := openTestDB(t)
+	service := NewParameterService("us-east-1", testAccountId, NewDataStore(db, testKeys))
+	other := NewParameterService("eu-west-1", "111111111111", NewAccountDataStore(db, "111111111111", "eu-west-1", testKeys))
+
+	putTieredParameter(t, service, "/a", awstypes.ParameterTierStandard)
+	putTieredParameter(t, service, "/b", awstypes.ParameterTierAdvanced)
+	putTieredParameter(t, other, "/a", awstypes.ParameterTierStandard)
+	putTieredParameter(t, other, "/b", awstypes.ParameterTierStandard)
+
+	collector := NewStorageCollector(service)
+	collector.AddService(other)
+
+	want := map[string]float64{
+		"000000000000/us-east-1/String/Standard": 1,
+		"000000000000/us-east-1/String/Advanced": 1,
+		"111111111111/eu-west-1/String/Standard": 2,
+	}
+
+	gauges := parameterGauges(t, collector)
+	if len(gauges) != len(want) {
+		t.Fatalf("parameters %v, want %v", gauges, want)
+	}
+	for key, count := range want {
+		if gauges[key] != count {
+			t.Errorf("parameters %s = %v, want %v", key, gauges[key], count)
+		}
+	}
+}
+
+func TestStorageCollectorRecount(t *testing.T) {
+
+	service := NewParameterService("us-east-1", testAccountId, NewDataStore(openTestDB(t), testKeys))
+	putTieredParameter(t, service, "/a", awstypes.ParameterTierStandard)
+
+	collector := NewStorageCollector(service)
+	parameterGauges(t, collector)
+
+	// a count no scan would produce tells whether the database was scanned
+	marker := parameterCount{"marker", "", "", ""}
+	collector.counts[marker] = 1
+	if gauges := parameterGauges(t, collector); gauges["marker///"] != 1 {
+		t.Fatalf("parameters counted again without a write: %v", gauges)
+	}
+
+	putTieredParameter(t, service, "/b", awstypes.ParameterTierStandard)
+	gauges := parameterGauges(t, collector)
+	if _, ok := gauges["marker///"]; ok || gauges["000000000000/us-east-1/String/Standard"] != 2 {
+		t.Errorf("parameters not counted again after a write: %v", gauges)
+	}
+}
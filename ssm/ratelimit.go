@@ -0,0 +1,194 @@
+package ssm
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	RateLimitProfileStandard       = "aws-standard"
+	RateLimitProfileHighThroughput = "aws-high-throughput"
+
+	maxLimitedAccessKeys = 10000
+)
+
+// AWS default throughput quotas of the parameter store, by operation.
+var rateLimitProfiles = map[string]map[string]RateLimit{
+	RateLimitProfileStandard: {
+		"GetParameter":        {Rate: 40},
+		"GetParameters":       {Rate: 40},
+		"GetParametersByPath": {Rate: 40},
+		"PutParameter":        {Rate: 3},
+	},
+	RateLimitProfileHighThroughput: {
+		"GetParameter":        {Rate: 10000},
+		"GetParameters":       {Rate: 10000},
+		"GetParametersByPath": {Rate: 10000},
+		"PutParameter":        {Rate: 10},
+	},
+}
+
+type RateLimit struct {
+	// Rate is the sustained requests per second, zero is unlimited.
+	Rate float64 `yaml:"rate"`
+	// Burst is the bucket size, defaults to the rate rounded up.
+	Burst int `yaml:"burst"`
+}
+
+type RateLimitConfig struct {
+	// Profile presets the operation limits, aws-standard or aws-high-throughput.
+	Profile      string               `yaml:"profile"`
+	Global       RateLimit            `yaml:"global"`
+	PerAccessKey RateLimit            `yaml:"perAccessKey"`
+	Operations   map[string]RateLimit `yaml:"operations"`
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = math.Ceil(limit.Rate)
+	}
+
+	return &tokenBucket{rate: limit.Rate, burst: burst, tokens: burst}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// RateLimiter applies token bucket limits globally, per operation and per
+// access key. A request must get a token from every bucket it falls in.
+type RateLimiter struct {
+	mu           sync.Mutex
+	global       *tokenBucket
+	operations   map[string]*tokenBucket
+	perAccessKey RateLimit
+	accessKeys   map[string]*tokenBucket
+}
+
+// NewRateLimiter returns nil when no limit is configured.
+func NewRateLimiter(config RateLimitConfig) (*RateLimiter, error) {
+
+	operations := make(map[string]RateLimit)
+	if config.Profile != "" {
+
+		profile, ok := rateLimitProfiles[config.Profile]
+		if !ok {
+			return nil, fmt.Errorf("unknown rate limit profile %q", config.Profile)
+		}
+
+		for operation, limit := range profile {
+			operations[operation] = limit
+		}
+	}
+
+	for operation, limit := range config.Operations {
+		operations[operation] = limit
+	}
+
+	limiter := RateLimiter{
+		operations:   make(map[string]*tokenBucket),
+		perAccessKey: config.PerAccessKey,
+		accessKeys:   make(map[string]*tokenBucket),
+	}
+
+	if config.Global.Rate > 0 {
+		limiter.global = newTokenBucket(config.Global)
+	}
+
+	for operation, limit := range operations {
+		if limit.Rate > 0 {
+			limiter.operations[operation] = newTokenBucket(limit)
+		}
+	}
+
+	if limiter.global == nil && len(limiter.operations) == 0 && config.PerAccessKey.Rate <= 0 {
+		return nil, nil
+	}
+
+	return &limiter, nil
+}
+
+// Allow takes a token for the request, it reports false once any bucket is empty.
+func (l *RateLimiter) Allow(accessKey string, operation string, now time.Time) bool {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	buckets := []*tokenBucket{l.global, l.operations[operation]}
+	if l.perAccessKey.Rate > 0 {
+
+		bucket, ok := l.accessKeys[accessKey]
+		if !ok {
+
+			// temporary credentials are numerous, evict buckets rather than grow
+			if len(l.accessKeys) >= maxLimitedAccessKeys {
+				l.evict(now)
+			}
+
+			bucket = newTokenBucket(l.perAccessKey)
+			l.accessKeys[accessKey] = bucket
+		}
+
+		buckets = append(buckets, bucket)
+	}
+
+	buckets = slices.DeleteFunc(buckets, func(bucket *tokenBucket) bool { return bucket == nil })
+	for _, bucket := range buckets {
+		bucket.refill(now)
+	}
+
+	// only take tokens when every bucket has one
+	if slices.ContainsFunc(buckets, func(bucket *tokenBucket) bool { return bucket.tokens < 1 }) {
+		return false
+	}
+
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+
+	return true
+}
+
+// evict drops the buckets that have refilled, which a new bucket would
+// replace unchanged, and then the longest unused ones. A tenth of the
+// buckets is freed, so a full map isn't sorted on every new access key.
+func (l *RateLimiter) evict(now time.Time) {
+
+	var used []string
+	for accessKey, bucket := range l.accessKeys {
+
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate >= bucket.burst {
+			delete(l.accessKeys, accessKey)
+		} else {
+			used = append(used, accessKey)
+		}
+	}
+
+	slices.SortFunc(used, func(a string, b string) int {
+		return l.accessKeys[a].last.Compare(l.accessKeys[b].last)
+	})
+
+	for _, accessKey := range used {
+
+		if len(l.accessKeys) < maxLimitedAccessKeys-maxLimitedAccessKeys/10 {
+			break
+		}
+		delete(l.accessKeys, accessKey)
+	}
+}
@@ -0,0 +1,74 @@
+package ssm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		config RateLimitConfig
+		// allowed is how many of five requests at once are allowed
+		allowed int
+	}{
+		{"under the limit", RateLimitConfig{Global: RateLimit{Rate: 100}}, 5},
+		{"global", RateLimitConfig{Global: RateLimit{Rate: 1, Burst: 3}}, 3},
+		{"operation", RateLimitConfig{Operations: map[string]RateLimit{"GetParameter": {Rate: 2}}}, 2},
+		{"other operation", RateLimitConfig{Operations: map[string]RateLimit{"PutParameter": {Rate: 1}}, Global: RateLimit{Rate: 10}}, 5},
+		{"per access key", RateLimitConfig{PerAccessKey: RateLimit{Rate: 4}}, 4},
+		{"profile", RateLimitConfig{Profile: RateLimitProfileStandard, Operations: map[string]RateLimit{"GetParameter": {Rate: 1}}}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			limiter, err := NewRateLimiter(test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			now := time.Now()
+			allowed := 0
+			for range 5 {
+				if limiter.Allow("AKIA1", "GetParameter", now) {
+					allowed++
+				}
+			}
+
+			if allowed != test.allowed {
+				t.Errorf("allowed %d requests, want %d", allowed, test.allowed)
+			}
+
+			if !limiter.Allow("AKIA1", "GetParameter", now.Add(time.Minute)) {
+				t.Error("not allowed after the buckets refilled")
+			}
+		})
+	}
+}
+
+func TestRateLimiterEvictsIdleBuckets(t *testing.T) {
+
+	limiter, err := NewRateLimiter(RateLimitConfig{PerAccessKey: RateLimit{Rate: 0.01}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the limited key keeps using its bucket while the others fill the map
+	now := time.Now()
+	limiter.Allow("limited", "GetParameter", now)
+	for i := range maxLimitedAccessKeys * 2 {
+
+		now = now.Add(time.Millisecond)
+		limiter.Allow(fmt.Sprintf("ASIA%d", i), "GetParameter", now)
+		if i%500 == 0 && limiter.Allow("limited", "GetParameter", now) {
+			t.Fatalf("limited access key allowed again after %d new keys", i)
+		}
+	}
+
+	if len(limiter.accessKeys) > maxLimitedAccessKeys {
+		t.Errorf("%d buckets, want at most %d", len(limiter.accessKeys), maxLimitedAccessKeys)
+	}
+}
@@ -0,0 +1,66 @@
+package ssm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	awstypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+const secretsManagerReferencePrefix = "/aws/reference/secretsmanager/"
+
+var ErrReferenceWithoutDecryption = errors.New(
+	"WithDecryption flag must be True for retrieving a Secret Value from Secrets Manager")
+
+// SecretReference is the current value of a secret referenced by a parameter.
+type SecretReference struct {
+	ARN          string
+	Value        string
+	CreatedDate  time.Time
+	SourceResult string
+}
+
+// SecretResolver looks up the secrets referenced through
+// /aws/reference/secretsmanager/<name>.
+type SecretResolver interface {
+	// ResolveSecret returns ErrParameterNotFound if the secret doesn't exist.
+	ResolveSecret(ctx context.Context, secretId string) (*SecretReference, error)
+}
+
+func (service *ParameterService) SetSecretResolver(resolver SecretResolver) {
+
+	service.secretResolver = resolver
+}
+
+func isSecretReference(name string) bool {
+
+	return strings.HasPrefix(name, secretsManagerReferencePrefix)
+}
+
+func (service *ParameterService) getSecretReference(ctx context.Context, name string, withDecryption bool) (*GetParameterItem, error) {
+
+	if service.secretResolver == nil {
+		return nil, ErrParameterNotFound
+	}
+
+	if !withDecryption {
+		return nil, ErrReferenceWithoutDecryption
+	}
+
+	secret, err := service.secretResolver.ResolveSecret(ctx, strings.TrimPrefix(name, secretsManagerReferencePrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	return &GetParameterItem{
+		ARN:              secret.ARN,
+		DataType:         "text",
+		LastModifiedDate: float64(secret.CreatedDate.UnixMilli()) / 1000,
+		Name:             ParamName(name),
+		SourceResult:     secret.SourceResult,
+		Type:             awstypes.ParameterTypeSecureString,
+		Value:            secret.Value,
+	}, nil
+}
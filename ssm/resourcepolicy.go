@@ -0,0 +1,214 @@
+package ssm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"home-ssm/tracing"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// sharedActions are the actions resource policies can grant other
+// accounts.
+var sharedActions = []string{"ssm:GetParameter", "ssm:GetParameters", "ssm:DescribeParameters"}
+
+// PutResourcePolicy attaches a new policy to an Advanced parameter, or
+// replaces the policy with PolicyId if PolicyHash matches its current hash.
+func (service *ParameterService) PutResourcePolicy(
+	ctx context.Context, request *awsssm.PutResourcePolicyInput) (*awsssm.PutResourcePolicyOutput, error) {
+
+	ctx, span := tracing.Start(ctx, "ParameterService.PutResourcePolicy", tracing.ParameterName(aws.ToString(request.ResourceArn)))
+	defer span.End()
+
+	name, err := service.parameterNameFromArn(aws.ToString(request.ResourceArn))
+	if err != nil {
+		return nil, err
+	}
+
+	document := aws.ToString(request.Policy)
+	if err := validateResourcePolicy(document); err != nil {
+		return nil, err
+	}
+
+	resourcePolicy := ResourcePolicy{
+		PolicyId:   aws.ToString(request.PolicyId),
+		PolicyHash: policyHash(document),
+		Policy:     document,
+	}
+
+	err = service.dataStore.updateParameter(ctx, name, func(param *ParameterData) error {
+
+		if param.Tier != awstypes.ParameterTierAdvanced {
+			return ErrResourcePolicyInvalidParameter
+		}
+
+		if resourcePolicy.PolicyId == "" {
+			resourcePolicy.PolicyId = awslib.NewUUID()
+			param.ResourcePolicies = append(param.ResourcePolicies, resourcePolicy)
+			return nil
+		}
+
+		i, err := findResourcePolicy(param.ResourcePolicies, resourcePolicy.PolicyId, aws.ToString(request.PolicyHash))
+		if err != nil {
+			return err
+		}
+
+		param.ResourcePolicies[i] = resourcePolicy
+		return nil
+	})
+
+	if errors.Is(err, ErrParameterNotFound) {
+		err = ErrResourceNotFound
+	}
+
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &awsssm.PutResourcePolicyOutput{
+		PolicyId:   aws.String(resourcePolicy.PolicyId),
+		PolicyHash: aws.String(resourcePolicy.PolicyHash),
+	}, nil
+}
+
+func (service *ParameterService) GetResourcePolicies(
+	ctx context.Context, request *awsssm.GetResourcePoliciesInput) (*awsssm.GetResourcePoliciesOutput, error) {
+
+	ctx, span := tracing.Start(ctx, "ParameterService.GetResourcePolicies", tracing.ParameterName(aws.ToString(request.ResourceArn)))
+	defer span.End()
+
+	name, err := service.parameterNameFromArn(aws.ToString(request.ResourceArn))
+	if err != nil {
+		return nil, err
+	}
+
+	param, err := service.dataStore.getParameter(ctx, name)
+	if errors.Is(err, ErrParameterNotFound) {
+		return nil, ErrResourceNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	response := awsssm.GetResourcePoliciesOutput{Policies: []awstypes.GetResourcePoliciesResponseEntry{}}
+	for _, resourcePolicy := range param.ResourcePolicies {
+
+		response.Policies = append(response.Policies, awstypes.GetResourcePoliciesResponseEntry{
+			PolicyId:   aws.String(resourcePolicy.PolicyId),
+			PolicyHash: aws.String(resourcePolicy.PolicyHash),
+			Policy:     aws.String(resourcePolicy.Policy),
+		})
+	}
+
+	return &response, nil
+}
+
+// DeleteResourcePolicy removes the policy with PolicyId if PolicyHash
+// matches its current hash.
+func (service *ParameterService) DeleteResourcePolicy(
+	ctx context.Context, request *awsssm.DeleteResourcePolicyInput) (*awsssm.DeleteResourcePolicyOutput, error) {
+
+	ctx, span := tracing.Start(ctx, "ParameterService.DeleteResourcePolicy", tracing.ParameterName(aws.ToString(request.ResourceArn)))
+	defer span.End()
+
+	name, err := service.parameterNameFromArn(aws.ToString(request.ResourceArn))
+	if err != nil {
+		return nil, err
+	}
+
+	if aws.ToString(request.PolicyId) == "" || aws.ToString(request.PolicyHash) == "" {
+		return nil, ErrResourcePolicyInvalidParameter
+	}
+
+	err = service.dataStore.updateParameter(ctx, name, func(param *ParameterData) error {
+
+		i, err := findResourcePolicy(param.ResourcePolicies, aws.ToString(request.PolicyId), aws.ToString(request.PolicyHash))
+		if err != nil {
+			return err
+		}
+
+		param.ResourcePolicies = slices.Delete(param.ResourcePolicies, i, i+1)
+		return nil
+	})
+
+	if errors.Is(err, ErrParameterNotFound) {
+		err = ErrResourceNotFound
+	}
+
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &awsssm.DeleteResourcePolicyOutput{}, nil
+}
+
+// parameterNameFromArn returns the name of a parameter of this service's
+// account and region given by ARN.
+func (service *ParameterService) parameterNameFromArn(arn string) (string, error) {
+
+	region, accountId, name, ok := parseParameterArn(arn)
+	if !ok || region != service.region || accountId != service.accountId {
+		return "", ErrResourceNotFound
+	}
+
+	paramName, err := NewParamName(&name)
+	if err != nil {
+		return "", ErrResourceNotFound
+	}
+
+	return string(paramName.asPathName()), nil
+}
+
+func findResourcePolicy(policies []ResourcePolicy, policyId string, policyHash string) (int, error) {
+
+	i := slices.IndexFunc(policies, func(resourcePolicy ResourcePolicy) bool { return resourcePolicy.PolicyId == policyId })
+	if i < 0 {
+		return -1, ErrResourcePolicyNotFound
+	}
+
+	if policies[i].PolicyHash != policyHash {
+		return -1, ErrResourcePolicyConflict
+	}
+
+	return i, nil
+}
+
+// validateResourcePolicy accepts policies whose statements name their
+// principals and only grant the shared read actions.
+func validateResourcePolicy(document string) error {
+
+	parsed, err := policy.ParseResourcePolicy("resource-policy", document)
+	if err != nil {
+		return ErrMalformedResourcePolicy
+	}
+
+	for _, statement := range parsed.Statement {
+
+		if len(statement.NotAction) > 0 {
+			return ErrMalformedResourcePolicy
+		}
+
+		for _, action := range statement.Action {
+			if !slices.Contains(sharedActions, action) {
+				return ErrMalformedResourcePolicy
+			}
+		}
+	}
+
+	return nil
+}
+
+func policyHash(document string) string {
+
+	sum := sha256.Sum256([]byte(document))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,278 @@
+package ssm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"home-ssm/metrics"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const sharedPolicy = `{"Statement":{"Effect":"Allow","Principal":{"AWS":"111111111111"},
+	"Action":["ssm:GetParameter","ssm:DescribeParameters"],"Resource":"*"}}`
+
+func putTieredParameter(t *testing.T, service *ParameterService, name string, tier awstypes.ParameterTier) {
+
+	t.Helper()
+
+	creds := aws.Credentials{AccessKeyID: "admin", AccountID: service.accountId}
+	_, err := service.PutParameter(context.Background(), &creds, &awsssm.PutParameterInput{
+		Name: aws.String(name), Value: aws.String("value"), Type: awstypes.ParameterTypeString, Tier: tier, Overwrite: aws.Bool(true)})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func parameterArn(service *ParameterService, name string) *string {
+
+	return aws.String(service.createParameterArn(ParamName(name)))
+}
+
+func TestPutResourcePolicy(t *testing.T) {
+
+	tests := []struct {
+		name string
+		// put returns the request given the id and hash of an attached
+		// policy
+		put      func(service *ParameterService, policyId string, policyHash string) *awsssm.PutResourcePolicyInput
+		err      error
+		policies int
+	}{
+		{
+			name: "new policy",
+			put: func(service *ParameterService, _ string, _ string) *awsssm.PutResourcePolicyInput {
+				return &awsssm.PutResourcePolicyInput{ResourceArn: parameterArn(service, "/advanced"), Policy: aws.String(sharedPolicy)}
+			},
+			policies: 2,
+		},
+		{
+			name: "replace with current hash",
+			put: func(service *ParameterService, policyId string, policyHash string) *awsssm.PutResourcePolicyInput {
+				return &awsssm.PutResourcePolicyInput{ResourceArn: parameterArn(service, "/advanced"), Policy: aws.String(sharedPolicy),
+					PolicyId: aws.String(policyId), PolicyHash: aws.String(policyHash)}
+			},
+			policies: 1,
+		},
+		{
+			name: "replace with obsolete hash",
+			put: func(service *ParameterService, policyId string, _ string) *awsssm.PutResourcePolicyInput {
+				return &awsssm.PutResourcePolicyInput{ResourceArn: parameterArn(service, "/advanced"), Policy: aws.String(sharedPolicy),
+					PolicyId: aws.String(policyId), PolicyHash: aws.String(policyHash("{}"))}
+			},
+			err:      ErrResourcePolicyConflict,
+			policies: 1,
+		},
+		{
+			name: "replace unknown policy",
+			put: func(service *ParameterService, _ string, policyHash string) *awsssm.PutResourcePolicyInput {
+				return &awsssm.PutResourcePolicyInput{ResourceArn: parameterArn(service, "/advanced"), Policy: aws.String(sharedPolicy),
+					PolicyId: aws.String("missing"), PolicyHash: aws.String(policyHash)}
+			},
+			err:      ErrResourcePolicyNotFound,
+			policies: 1,
+		},
+		{
+			name: "standard tier",
+			put: func(service *ParameterService, _ string, _ string) *awsssm.PutResourcePolicyInput {
+				return &awsssm.PutResourcePolicyInput{ResourceArn: parameterArn(service, "/standard"), Policy: aws.String(sharedPolicy)}
+			},
+			err:      ErrResourcePolicyInvalidParameter,
+			policies: 1,
+		},
+		{
+			name: "missing parameter",
+			put: func(service *ParameterService, _ string, _ string) *awsssm.PutResourcePolicyInput {
+				return &awsssm.PutResourcePolicyInput{ResourceArn: parameterArn(service, "/missing"), Policy: aws.String(sharedPolicy)}
+			},
+			err:      ErrResourceNotFound,
+			policies: 1,
+		},
+		{
+			name: "other account",
+			put: func(service *ParameterService, _ string, _ string) *awsssm.PutResourcePolicyInput {
+				return &awsssm.PutResourcePolicyInput{ResourceArn: aws.String("arn:aws:ssm:us-east-1:111111111111:parameter/advanced"),
+					Policy: aws.String(sharedPolicy)}
+			},
+			err:      ErrResourceNotFound,
+			policies: 1,
+		},
+		{
+			name: "write action",
+			put: func(service *ParameterService, _ string, _ string) *awsssm.PutResourcePolicyInput {
+				return &awsssm.PutResourcePolicyInput{ResourceArn: parameterArn(service, "/advanced"), Policy: aws.String(
+					`{"Statement":{"Effect":"Allow","Principal":{"AWS":"111111111111"},"Action":"ssm:PutParameter","Resource":"*"}}`)}
+			},
+			err:      ErrMalformedResourcePolicy,
+			policies: 1,
+		},
+		{
+			name: "not action",
+			put: func(service *ParameterService, _ string, _ string) *awsssm.PutResourcePolicyInput {
+				return &awsssm.PutResourcePolicyInput{ResourceArn: parameterArn(service, "/advanced"), Policy: aws.String(
+					`{"Statement":{"Effect":"Allow","Principal":{"AWS":"111111111111"},"NotAction":"ssm:PutParameter","Resource":"*"}}`)}
+			},
+			err:      ErrMalformedResourcePolicy,
+			policies: 1,
+		},
+		{
+			name: "no principal",
+			put: func(service *ParameterService, _ string, _ string) *awsssm.PutResourcePolicyInput {
+				return &awsssm.PutResourcePolicyInput{ResourceArn: parameterArn(service, "/advanced"), Policy: aws.String(
+					`{"Statement":{"Effect":"Allow","Action":"ssm:GetParameter","Resource":"*"}}`)}
+			},
+			err:      ErrMalformedResourcePolicy,
+			policies: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			service := NewParameterService("us-east-1", testAccountId, NewDataStore(openTestDB(t), testKeys))
+			putTieredParameter(t, service, "/advanced", awstypes.ParameterTierAdvanced)
+			putTieredParameter(t, service, "/standard", awstypes.ParameterTierStandard)
+
+			attached, err := service.PutResourcePolicy(context.Background(), &awsssm.PutResourcePolicyInput{
+				ResourceArn: parameterArn(service, "/advanced"), Policy: aws.String(sharedPolicy)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = service.PutResourcePolicy(context.Background(),
+				test.put(service, aws.ToString(attached.PolicyId), aws.ToString(attached.PolicyHash)))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("PutResourcePolicy() = %v, want %v", err, test.err)
+			}
+
+			policies, err := service.GetResourcePolicies(context.Background(),
+				&awsssm.GetResourcePoliciesInput{ResourceArn: parameterArn(service, "/advanced")})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(policies.Policies) != test.policies {
+				t.Errorf("%d policies attached, want %d", len(policies.Policies), test.policies)
+			}
+		})
+	}
+}
+
+func TestDeleteResourcePolicy(t *testing.T) {
+
+	// current stands for the id or hash of the attached policy
+	const current = "current"
+	tests := []struct {
+		name       string
+		policyId   string
+		policyHash string
+		err        error
+	}{
+		{"current hash", current, current, nil},
+		{"obsolete hash", current, policyHash("{}"), ErrResourcePolicyConflict},
+		{"unknown policy", "missing", current, ErrResourcePolicyNotFound},
+		{"no hash", current, "", ErrResourcePolicyInvalidParameter},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			service := NewParameterService("us-east-1", testAccountId, NewDataStore(openTestDB(t), testKeys))
+			putTieredParameter(t, service, "/advanced", awstypes.ParameterTierAdvanced)
+			attached, err := service.PutResourcePolicy(context.Background(), &awsssm.PutResourcePolicyInput{
+				ResourceArn: parameterArn(service, "/advanced"), Policy: aws.String(sharedPolicy)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			request := awsssm.DeleteResourcePolicyInput{
+				ResourceArn: parameterArn(service, "/advanced"),
+				PolicyId:    aws.String(test.policyId),
+				PolicyHash:  aws.String(test.policyHash),
+			}
+			if test.policyId == current {
+				request.PolicyId = attached.PolicyId
+			}
+			if test.policyHash == current {
+				request.PolicyHash = attached.PolicyHash
+			}
+
+			if _, err := service.DeleteResourcePolicy(context.Background(), &request); !errors.Is(err, test.err) {
+				t.Fatalf("DeleteResourcePolicy() = %v, want %v", err, test.err)
+			}
+
+			want := 1
+			if test.err == nil {
+				want = 0
+			}
+
+			policies, err := service.GetResourcePolicies(context.Background(),
+				&awsssm.GetResourcePoliciesInput{ResourceArn: parameterArn(service, "/advanced")})
+			if err != nil || len(policies.Policies) != want {
+				t.Errorf("GetResourcePolicies() = %v, %v, want %d policies", policies, err, want)
+			}
+		})
+	}
+}
+
+// requestCount returns the api_requests_total counter of the labels.
+func requestCount(t *testing.T, operation string, code string) float64 {
+
+	t.Helper()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+
+		if family.GetName() != "home_ssm_api_requests_total" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+
+			if labels["operation"] == operation && labels["code"] == code {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+
+	return 0
+}
+
+func TestResourcePolicyMetrics(t *testing.T) {
+
+	api, service := newTestApi(t)
+	putTieredParameter(t, service, "/advanced", awstypes.ParameterTierAdvanced)
+	arn := service.createParameterArn("/advanced")
+
+	for _, operation := range []string{"PutResourcePolicy", "GetResourcePolicies"} {
+
+		body, err := json.Marshal(map[string]string{"ResourceArn": arn, "Policy": sharedPolicy})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		before := requestCount(t, operation, metrics.SuccessCode)
+		w := callApi(api, "admin", operation, string(body))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s got %d %s", operation, w.Code, w.Body.String())
+		}
+
+		if requestCount(t, operation, metrics.SuccessCode) != before+1 {
+			t.Errorf("%s isn't counted under its own operation", operation)
+		}
+	}
+}
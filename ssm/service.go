@@ -1,9 +1,12 @@
 package ssm
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"home-ssm/awslib"
+	"home-ssm/tracing"
+	"log/slog"
 	"slices"
 	"strings"
 
@@ -14,9 +17,11 @@ import (
 )
 
 type ParameterService struct {
-	dataStore *DataStore
-	accountId string
-	region    string
+	dataStore       *DataStore
+	accountId       string
+	region          string
+	secretResolver  SecretResolver
+	changeListeners []ChangeListener
 }
 
 func NewParameterService(region string, accountId string, dataStore *DataStore) *ParameterService {
@@ -36,20 +41,23 @@ func (service *ParameterService) Close() {
 		err := service.dataStore.db.Close()
 		if err != nil {
 
-			log.Println("Failed to close database.", err)
+			slog.Error("Failed to close database.", "error", err)
 		}
 	}
 }
 
 func (service *ParameterService) DeleteParameter(
-	request *awsssm.DeleteParameterInput) (*awsssm.DeleteParameterOutput, error) {
+	ctx context.Context, request *awsssm.DeleteParameterInput) (*awsssm.DeleteParameterOutput, error) {
+
+	ctx, span := tracing.Start(ctx, "ParameterService.DeleteParameter", tracing.ParameterName(aws.ToString(request.Name)))
+	defer span.End()
 
 	paramName, err := NewParamName(request.Name)
 	if err != nil {
 		return nil, ErrInvalidName
 	}
 
-	err = service.dataStore.delete(string(paramName.asPathName()))
+	err = service.deleteParameter(ctx, paramName)
 	if err != nil {
 		return nil, err
 	}
@@ -58,7 +66,10 @@ func (service *ParameterService) DeleteParameter(
 }
 
 func (service *ParameterService) DeleteParameters(
-	request *awsssm.DeleteParametersInput) (*awsssm.DeleteParametersOutput, error) {
+	ctx context.Context, request *awsssm.DeleteParametersInput) (*awsssm.DeleteParametersOutput, error) {
+
+	ctx, span := tracing.Start(ctx, "ParameterService.DeleteParameters")
+	defer span.End()
 
 	var response awsssm.DeleteParametersOutput
 	for _, name := range request.Names {
@@ -70,7 +81,7 @@ func (service *ParameterService) DeleteParameters(
 
 		} else {
 
-			err := service.dataStore.delete(string(paramName.asPathName()))
+			err := service.deleteParameter(ctx, paramName)
 			if err == nil {
 
 				response.DeletedParameters = append(response.DeletedParameters, name)
@@ -86,7 +97,10 @@ func (service *ParameterService) DeleteParameters(
 }
 
 func (service *ParameterService) DescribeParameters(
-	request *awsssm.DescribeParametersInput) (*DescribeParametersResponse, error) {
+	ctx context.Context, request *awsssm.DescribeParametersInput) (*DescribeParametersResponse, error) {
+
+	ctx, span := tracing.Start(ctx, "ParameterService.DescribeParameters")
+	defer span.End()
 
 	// TODO incomplete implementation
 
@@ -140,7 +154,7 @@ func (service *ParameterService) DescribeParameters(
 		}
 	}
 
-	parameters, err := service.dataStore.findParametersByKey(filters)
+	parameters, err := service.dataStore.findParametersByKey(ctx, filters)
 	if err != nil {
 
 		return nil, err
@@ -157,30 +171,35 @@ func (service *ParameterService) DescribeParameters(
 }
 
 func (service *ParameterService) GetParameter(
-	request *awsssm.GetParameterInput) (*GetParameterResponse, error) {
+	ctx context.Context, request *awsssm.GetParameterInput) (*GetParameterResponse, error) {
 
-	result, err := service.getParameterByName(
+	ctx, span := tracing.Start(ctx, "ParameterService.GetParameter", tracing.ParameterName(aws.ToString(request.Name)))
+	defer span.End()
+
+	item, err := service.getParameterItem(ctx,
 		aws.ToString(request.Name), aws.ToBool(request.WithDecryption))
 	if err != nil {
 		return nil, err
 	}
 
 	response := GetParameterResponse{
-		Parameter: result.toGetParameterItem(service.createParameterArn),
+		Parameter: item,
 	}
 
 	return &response, nil
 }
 
 func (service *ParameterService) GetParameters(
-	request *awsssm.GetParametersInput) (*GetParametersResponse, error) {
+	ctx context.Context, request *awsssm.GetParametersInput) (*GetParametersResponse, error) {
+
+	ctx, span := tracing.Start(ctx, "ParameterService.GetParameters")
+	defer span.End()
 
 	var response GetParametersResponse
 	for _, name := range request.Names {
 
-		param, err := service.getParameterByName(name, aws.ToBool(request.WithDecryption))
+		item, err := service.getParameterItem(ctx, name, aws.ToBool(request.WithDecryption))
 		if err == nil {
-			item := param.toGetParameterItem(service.createParameterArn)
 			response.Parameters = append(response.Parameters, *item)
 		} else {
 			response.InvalidParameters = append(response.InvalidParameters, name)
@@ -190,8 +209,14 @@ func (service *ParameterService) GetParameters(
 	return &response, nil
 }
 
-func (service *ParameterService) GetParametersByPath(
-	request *awsssm.GetParametersByPathInput) (*GetParametersByPathResponse, error) {
+// GetParametersByPath returns the parameters under the path the caller is
+// allowed to see, a nil allowed returns all. Hidden parameters are skipped
+// before their keys are checked, so they can't fail the request.
+func (service *ParameterService) GetParametersByPath(ctx context.Context,
+	request *awsssm.GetParametersByPathInput, allowed func(name ParamName) bool) (*GetParametersByPathResponse, error) {
+
+	ctx, span := tracing.Start(ctx, "ParameterService.GetParametersByPath", tracing.ParameterName(aws.ToString(request.Path)))
+	defer span.End()
 
 	// TODO incomplete implementation
 
@@ -214,7 +239,7 @@ func (service *ParameterService) GetParametersByPath(
 		filters = append(filters, paramPath.asOneLevelRegex())
 	}
 
-	parameters, err := service.dataStore.findParametersByKey(filters)
+	parameters, err := service.dataStore.findParametersByKey(ctx, filters)
 	if err != nil {
 
 		return nil, err
@@ -223,9 +248,17 @@ func (service *ParameterService) GetParametersByPath(
 	var response GetParametersByPathResponse
 	for _, param := range parameters {
 
+		if allowed != nil && !allowed(param.Name) {
+			continue
+		}
+
 		if aws.ToBool(request.WithDecryption) && param.Type == awstypes.ParameterTypeSecureString {
 
-			decryptedValue, err := service.dataStore.decrypt(param.Value, param.KeyId)
+			if err := service.authorizeKey(ctx, param.KeyId, KeyOperationDecrypt); err != nil {
+				return nil, err
+			}
+
+			decryptedValue, err := service.dataStore.Decrypt(ctx, param.Value, param.KeyId)
 			if err != nil {
 				return nil, ErrInvalidKeyId
 			}
@@ -241,24 +274,31 @@ func (service *ParameterService) GetParametersByPath(
 }
 
 func (service *ParameterService) PutParameter(
-	creds *aws.Credentials, request *awsssm.PutParameterInput) (*awsssm.PutParameterOutput, error) {
+	ctx context.Context, creds *aws.Credentials, request *awsssm.PutParameterInput) (*awsssm.PutParameterOutput, error) {
+
+	ctx, span := tracing.Start(ctx, "ParameterService.PutParameter", tracing.ParameterName(aws.ToString(request.Name)))
+	defer span.End()
 
 	param, err := NewParameterData(request)
 	if err != nil {
 		return nil, err
 	}
 
-	param.LastModifiedUser = service.createUserArn(creds)
+	param.LastModifiedUser = service.CreateUserArn(creds)
 
 	if param.Type == awstypes.ParameterTypeSecureString {
 
 		if param.KeyId == "" {
-			param.KeyId = "alias/" + service.dataStore.keys[0].Alias
+			param.KeyId = service.dataStore.DefaultKeyId()
+		}
+
+		if err := service.authorizeKey(ctx, param.KeyId, KeyOperationEncrypt); err != nil {
+			return nil, err
 		}
 
-		encryptedValue, err := service.dataStore.encrypt(param.Value, param.KeyId)
+		encryptedValue, err := service.dataStore.Encrypt(ctx, param.Value, param.KeyId)
 		if err != nil {
-			if errors.Is(err, badger.ErrKeyNotFound) {
+			if errors.Is(err, badger.ErrKeyNotFound) || errors.Is(err, ErrInvalidKeyId) {
 				return nil, ErrInvalidKeyId
 			}
 			return nil, ErrInternalError
@@ -267,22 +307,27 @@ func (service *ParameterService) PutParameter(
 		param.Value = encryptedValue
 	}
 
-	newVersion, err := service.dataStore.putParameter(string(param.Name), param, aws.ToBool(request.Overwrite))
+	record, finish := service.recordChange(ctx, "", param.Name)
+	newVersion, err := service.dataStore.putParameter(ctx, string(param.Name), param, aws.ToBool(request.Overwrite), record)
+	finish(err)
 	if err != nil {
 
 		return nil, err
 	}
 
-	return &awsssm.PutParameterOutput{Tier: awstypes.ParameterTierStandard, Version: newVersion}, nil
+	return &awsssm.PutParameterOutput{Tier: param.Tier, Version: newVersion}, nil
 }
 
 func (service *ParameterService) AddTagsToResource(
-	request *awsssm.AddTagsToResourceInput) (*awsssm.AddTagsToResourceOutput, error) {
+	ctx context.Context, request *awsssm.AddTagsToResourceInput) (*awsssm.AddTagsToResourceOutput, error) {
+
+	ctx, span := tracing.Start(ctx, "ParameterService.AddTagsToResource", tracing.ParameterName(aws.ToString(request.ResourceId)))
+	defer span.End()
 
 	var response awsssm.AddTagsToResourceOutput
 	if request.ResourceType == awstypes.ResourceTypeForTaggingParameter {
 
-		param, err := service.getParameterByName(aws.ToString(request.ResourceId), false)
+		param, err := service.getParameterByName(ctx, aws.ToString(request.ResourceId), false)
 		if err != nil {
 			return nil, err
 		}
@@ -307,7 +352,7 @@ func (service *ParameterService) AddTagsToResource(
 			}
 		}
 
-		_, err = service.dataStore.putParameter(string(param.Name), param, true)
+		_, err = service.dataStore.putParameter(ctx, string(param.Name), param, true, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -317,12 +362,15 @@ func (service *ParameterService) AddTagsToResource(
 }
 
 func (service *ParameterService) RemoveTagsFromResource(
-	request *awsssm.RemoveTagsFromResourceInput) (*awsssm.RemoveTagsFromResourceOutput, error) {
+	ctx context.Context, request *awsssm.RemoveTagsFromResourceInput) (*awsssm.RemoveTagsFromResourceOutput, error) {
+
+	ctx, span := tracing.Start(ctx, "ParameterService.RemoveTagsFromResource", tracing.ParameterName(aws.ToString(request.ResourceId)))
+	defer span.End()
 
 	var response awsssm.RemoveTagsFromResourceOutput
 	if request.ResourceType == awstypes.ResourceTypeForTaggingParameter {
 
-		param, err := service.getParameterByName(aws.ToString(request.ResourceId), false)
+		param, err := service.getParameterByName(ctx, aws.ToString(request.ResourceId), false)
 		if err != nil {
 			return nil, err
 		}
@@ -338,7 +386,7 @@ func (service *ParameterService) RemoveTagsFromResource(
 			}
 		}
 
-		_, err = service.dataStore.putParameter(string(param.Name), param, true)
+		_, err = service.dataStore.putParameter(ctx, string(param.Name), param, true, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -348,12 +396,15 @@ func (service *ParameterService) RemoveTagsFromResource(
 }
 
 func (service *ParameterService) ListTagsForResource(
-	request *awsssm.ListTagsForResourceInput) (*awsssm.ListTagsForResourceOutput, error) {
+	ctx context.Context, request *awsssm.ListTagsForResourceInput) (*awsssm.ListTagsForResourceOutput, error) {
+
+	ctx, span := tracing.Start(ctx, "ParameterService.ListTagsForResource", tracing.ParameterName(aws.ToString(request.ResourceId)))
+	defer span.End()
 
 	var response awsssm.ListTagsForResourceOutput
 	if request.ResourceType == awstypes.ResourceTypeForTaggingParameter {
 
-		param, err := service.getParameterByName(aws.ToString(request.ResourceId), false)
+		param, err := service.getParameterByName(ctx, aws.ToString(request.ResourceId), false)
 		if err != nil {
 			return nil, err
 		}
@@ -368,19 +419,51 @@ func (service *ParameterService) ListTagsForResource(
 	return &response, nil
 }
 
-func (service *ParameterService) createUserArn(creds *aws.Credentials) string {
+// deleteParameter deletes a parameter, the change is only reported for
+// parameters that existed.
+func (service *ParameterService) deleteParameter(ctx context.Context, paramName ParamName) error {
 
-	return fmt.Sprintf("arn:aws:iam::%s:user/%s", service.accountId, creds.Source)
+	record, finish := service.recordChange(ctx, ChangeDelete, paramName.asPathName())
+	err := service.dataStore.delete(ctx, string(paramName.asPathName()), record)
+	finish(err)
+
+	return err
+}
+
+// CreateUserArn returns the ARN of the principal in its own account, which
+// is the service's account unless the parameter is shared.
+func (service *ParameterService) CreateUserArn(creds *aws.Credentials) string {
+
+	if creds.AccountID != "" {
+		return awslib.PrincipalArn(creds.AccountID, creds)
+	}
+
+	return awslib.PrincipalArn(service.accountId, creds)
+}
+
+// getParameterItem returns a stored parameter or a Secrets Manager reference.
+func (service *ParameterService) getParameterItem(ctx context.Context, name string, withDecryption bool) (*GetParameterItem, error) {
+
+	if isSecretReference(name) {
+		return service.getSecretReference(ctx, name, withDecryption)
+	}
+
+	param, err := service.getParameterByName(ctx, name, withDecryption)
+	if err != nil {
+		return nil, err
+	}
+
+	return param.toGetParameterItem(service.createParameterArn), nil
 }
 
-func (service *ParameterService) getParameterByName(name string, withDecryption bool) (*ParameterData, error) {
+func (service *ParameterService) getParameterByName(ctx context.Context, name string, withDecryption bool) (*ParameterData, error) {
 
 	paramName, err := NewParamName(&name)
 	if err != nil {
 		return nil, ErrInvalidName
 	}
 
-	result, err := service.dataStore.getParameter(string(paramName.asPathName()))
+	result, err := service.dataStore.getParameter(ctx, string(paramName.asPathName()))
 	if err != nil {
 		return nil, err
 	}
@@ -390,7 +473,11 @@ func (service *ParameterService) getParameterByName(name string, withDecryption
 
 	if result.Type == "SecureString" && withDecryption {
 
-		decryptedValue, err := service.dataStore.decrypt(result.Value, result.KeyId)
+		if err := service.authorizeKey(ctx, result.KeyId, KeyOperationDecrypt); err != nil {
+			return nil, err
+		}
+
+		decryptedValue, err := service.dataStore.Decrypt(ctx, result.Value, result.KeyId)
 		if err != nil {
 			if errors.Is(err, badger.ErrKeyNotFound) {
 				return nil, ErrInvalidKeyId
@@ -0,0 +1,159 @@
+package ssm
+
+import (
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// parseParameterArn splits arn:aws:ssm:<region>:<account>:parameter/<name>,
+// the name parameters shared by other accounts are read with.
+func parseParameterArn(arn string) (region string, accountId string, name string, ok bool) {
+
+	if !strings.HasPrefix(arn, "arn:") {
+		return "", "", "", false
+	}
+
+	fields := strings.SplitN(arn, ":", 6)
+	if len(fields) != 6 || fields[2] != "ssm" {
+		return "", "", "", false
+	}
+
+	resource, found := strings.CutPrefix(fields[5], "parameter/")
+	if !found {
+		return "", "", "", false
+	}
+
+	return fields[3], fields[4], "/" + resource, true
+}
+
+// resolveName returns the service and name of a parameter given by name,
+// or by ARN in any account and region.
+func (api *ParameterApi) resolveName(r *http.Request, name string) (*ParameterService, string, error) {
+
+	region, accountId, paramName, ok := parseParameterArn(name)
+	if !ok {
+		return api.serviceFor(r), name, nil
+	}
+
+	service, ok := api.services[serviceKey(accountId, region)]
+	if !ok {
+		return nil, "", ErrParameterNotFound
+	}
+
+	return service, paramName, nil
+}
+
+// resourceService returns the service of the account and region in a
+// resource ARN, or the caller's service, which reports it as not found.
+func (api *ParameterApi) resourceService(r *http.Request, arn string) *ParameterService {
+
+	service, _, err := api.resolveName(r, arn)
+	if err != nil {
+		return api.serviceFor(r)
+	}
+
+	return service
+}
+
+// authorizeShared allows principals of other accounts to read Advanced
+// parameters whose resource policies allow them. Missing parameters are
+// denied rather than reported so their names don't leak.
+func (api *ParameterApi) authorizeShared(
+	r *http.Request, service *ParameterService, creds *aws.Credentials, action string, name string) error {
+
+	principalArn := service.CreateUserArn(creds)
+	request := policy.Request{
+		Action:   action,
+		Resource: service.resourceArn(name),
+		Context:  policy.GlobalContext(creds, principalArn, awslib.GetRequestInfo(r.Context()).SourceIp),
+	}
+
+	denied := policy.AccessDeniedError{
+		PrincipalArn:  principalArn,
+		Action:        request.Action,
+		Resource:      request.Resource,
+		ResourceBased: true,
+	}
+
+	paramName, err := NewParamName(&name)
+	if err != nil || !slices.Contains(sharedActions, action) {
+		return &denied
+	}
+
+	param, err := service.dataStore.getParameter(r.Context(), string(paramName.asPathName()))
+	if err != nil || param.Tier != awstypes.ParameterTierAdvanced {
+		return &denied
+	}
+
+	var documents []*policy.Document
+	for _, resourcePolicy := range param.ResourcePolicies {
+
+		document, err := policy.ParseResourcePolicy(resourcePolicy.PolicyId, resourcePolicy.Policy)
+		if err != nil {
+			continue
+		}
+
+		documents = append(documents, document)
+	}
+
+	decision := policy.Evaluate(documents, &request)
+	if decision == policy.Allow {
+		return nil
+	}
+
+	denied.Explicit = decision == policy.ExplicitDeny
+
+	return &denied
+}
+
+// describeSharedParameters lists the parameters of other accounts in the
+// caller's region that their resource policies share with the caller,
+// named by ARN.
+func (api *ParameterApi) describeSharedParameters(
+	creds *aws.Credentials, request *awsssm.DescribeParametersInput, w http.ResponseWriter, r *http.Request) {
+
+	region := api.serviceFor(r).region
+	response := DescribeParametersResponse{Parameters: []DescribeParameterItem{}}
+	for _, key := range slices.Sorted(maps.Keys(api.services)) {
+
+		service := api.services[key]
+		if service.accountId == creds.AccountID || service.region != region {
+			continue
+		}
+
+		result, err := service.DescribeParameters(r.Context(), request)
+		if err != nil {
+			awslib.Logger(r.Context()).Warn("request failed", "error", err)
+			awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
+			return
+		}
+
+		for _, param := range result.Parameters {
+
+			if api.authorizeShared(r, service, creds, "ssm:DescribeParameters", string(param.Name)) != nil {
+				continue
+			}
+
+			if api.authorizer != nil {
+
+				policyRequest := policy.Request{Action: "ssm:DescribeParameters", Resource: param.ARN}
+				if api.authorizeRequest(r, service, creds, &policyRequest, string(param.Name), nil) != nil {
+					continue
+				}
+			}
+
+			param.Name = ParamName(param.ARN)
+			response.Parameters = append(response.Parameters, param)
+		}
+	}
+
+	awslib.WriteSuccessResponseJSON(w, response)
+}
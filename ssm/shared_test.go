@@ -0,0 +1,203 @@
+package ssm
+
+import (
+	"context"
+	"encoding/json"
+	"home-ssm/awslib"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+const ownerAccountId = "222222222222"
+
+// newSharedTestApi returns an api serving an owner account, whose
+// parameters sharedPolicy shares with account 111111111111, and the
+// accounts of the callers.
+func newSharedTestApi(t *testing.T) (*ParameterApi, *ParameterService) {
+
+	t.Helper()
+
+	api, service := newTestApi(t)
+	for _, accountId := range []string{"111111111111", "333333333333"} {
+		api.AddService(NewParameterService("us-east-1", accountId,
+			NewAccountDataStore(service.dataStore.db, accountId, "", testKeys)))
+	}
+
+	owner := NewParameterService("us-east-1", ownerAccountId,
+		NewAccountDataStore(service.dataStore.db, ownerAccountId, "", testKeys))
+	api.AddService(owner)
+
+	return api, owner
+}
+
+// callApiAs sends a request as a principal of the account.
+func callApiAs(api *ParameterApi, accountId string, target string, body string) *httptest.ResponseRecorder {
+
+	handler := awslib.WithRequestId(func(w http.ResponseWriter, r *http.Request) {
+
+		info := awslib.GetRequestInfo(r.Context())
+		info.Principal = &aws.Credentials{AccessKeyID: "caller", AccountID: accountId, Source: "caller"}
+		info.Region = "us-east-1"
+		api.Handle(w, r)
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("X-Amz-Target", "AmazonSSM."+target)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	return w
+}
+
+func TestParseParameterArn(t *testing.T) {
+
+	tests := []struct {
+		arn       string
+		region    string
+		accountId string
+		name      string
+		ok        bool
+	}{
+		{"arn:aws:ssm:us-east-1:222222222222:parameter/app/a", "us-east-1", "222222222222", "/app/a", true},
+		{"arn:aws:ssm:eu-west-1:222222222222:parameter/a", "eu-west-1", "222222222222", "/a", true},
+		{"/app/a", "", "", "", false},
+		{"arn:aws:kms:us-east-1:222222222222:key/a", "", "", "", false},
+		{"arn:aws:ssm:us-east-1:222222222222:document/a", "", "", "", false},
+		{"arn:aws:ssm:us-east-1", "", "", "", false},
+	}
+
+	for _, test := range tests {
+
+		region, accountId, name, ok := parseParameterArn(test.arn)
+		if region != test.region || accountId != test.accountId || name != test.name || ok != test.ok {
+			t.Errorf("parseParameterArn(%q) = %q, %q, %q, %v", test.arn, region, accountId, name, ok)
+		}
+	}
+}
+
+func TestSharedParameters(t *testing.T) {
+
+	const denyPolicy = `{"Statement":[
+		{"Effect":"Allow","Principal":{"AWS":"*"},"Action":"ssm:GetParameter","Resource":"*"},
+		{"Effect":"Deny","Principal":{"AWS":"111111111111"},"Action":"ssm:GetParameter","Resource":"*"}]}`
+
+	tests := []struct {
+		name string
+		// policy is attached to /shared while it's Advanced, tier is the
+		// tier it's then put with
+		policy    string
+		tier      awstypes.ParameterTier
+		accountId string
+		target    string
+		body      string
+		code      string
+	}{
+		{"shared", sharedPolicy, awstypes.ParameterTierAdvanced, "111111111111", "GetParameter",
+			`{"Name":"arn:aws:ssm:us-east-1:222222222222:parameter/shared"}`, ""},
+		{"other account", sharedPolicy, awstypes.ParameterTierAdvanced, "333333333333", "GetParameter",
+			`{"Name":"arn:aws:ssm:us-east-1:222222222222:parameter/shared"}`, "AccessDeniedException"},
+		{"not shared", "", awstypes.ParameterTierAdvanced, "111111111111", "GetParameter",
+			`{"Name":"arn:aws:ssm:us-east-1:222222222222:parameter/shared"}`, "AccessDeniedException"},
+		{"explicit deny", denyPolicy, awstypes.ParameterTierAdvanced, "111111111111", "GetParameter",
+			`{"Name":"arn:aws:ssm:us-east-1:222222222222:parameter/shared"}`, "AccessDeniedException"},
+		// the policy stays attached when the parameter is overwritten as
+		// Standard, but only Advanced parameters are shared
+		{"standard tier", sharedPolicy, awstypes.ParameterTierStandard, "111111111111", "GetParameter",
+			`{"Name":"arn:aws:ssm:us-east-1:222222222222:parameter/shared"}`, "AccessDeniedException"},
+		// GetParameters isn't granted by the policy
+		{"action not granted", sharedPolicy, awstypes.ParameterTierAdvanced, "111111111111", "GetParameters",
+			`{"Names":["arn:aws:ssm:us-east-1:222222222222:parameter/shared"]}`, "AccessDeniedException"},
+		// missing parameters are denied so their names don't leak
+		{"missing parameter", sharedPolicy, awstypes.ParameterTierAdvanced, "111111111111", "GetParameter",
+			`{"Name":"arn:aws:ssm:us-east-1:222222222222:parameter/missing"}`, "AccessDeniedException"},
+		{"policies of other accounts", sharedPolicy, awstypes.ParameterTierAdvanced, "111111111111", "GetResourcePolicies",
+			`{"ResourceArn":"arn:aws:ssm:us-east-1:222222222222:parameter/shared"}`, "AccessDeniedException"},
+		{"owner", "", awstypes.ParameterTierAdvanced, ownerAccountId, "GetParameter",
+			`{"Name":"arn:aws:ssm:us-east-1:222222222222:parameter/shared"}`, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			api, owner := newSharedTestApi(t)
+			putTieredParameter(t, owner, "/shared", awstypes.ParameterTierAdvanced)
+			if test.policy != "" {
+				_, err := owner.PutResourcePolicy(context.Background(), &awsssm.PutResourcePolicyInput{
+					ResourceArn: parameterArn(owner, "/shared"), Policy: aws.String(test.policy)})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			putTieredParameter(t, owner, "/shared", test.tier)
+
+			w := callApiAs(api, test.accountId, test.target, test.body)
+			if test.code == "" && w.Code != http.StatusOK {
+				t.Fatalf("got %d %s, want 200", w.Code, w.Body.String())
+			}
+
+			if test.code != "" && (w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), test.code)) {
+				t.Fatalf("got %d %s, want %s", w.Code, w.Body.String(), test.code)
+			}
+
+			if test.code == "" && !strings.Contains(w.Body.String(), `"value"`) {
+				t.Errorf("shared value not returned: %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDescribeSharedParameters(t *testing.T) {
+
+	api, owner := newSharedTestApi(t)
+	for _, name := range []string{"/shared", "/private"} {
+		putTieredParameter(t, owner, name, awstypes.ParameterTierAdvanced)
+	}
+	putTieredParameter(t, owner, "/standard", awstypes.ParameterTierAdvanced)
+
+	for _, name := range []string{"/shared", "/standard"} {
+		_, err := owner.PutResourcePolicy(context.Background(), &awsssm.PutResourcePolicyInput{
+			ResourceArn: parameterArn(owner, name), Policy: aws.String(sharedPolicy)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	putTieredParameter(t, owner, "/standard", awstypes.ParameterTierStandard)
+
+	tests := []struct {
+		accountId string
+		want      []string
+	}{
+		{"111111111111", []string{"arn:aws:ssm:us-east-1:222222222222:parameter/shared"}},
+		{"333333333333", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.accountId, func(t *testing.T) {
+
+			w := callApiAs(api, test.accountId, "DescribeParameters", `{"Shared":true,"ParameterFilters":[{"Key":"Path","Option":"Recursive","Values":["/"]}]}`)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d %s", w.Code, w.Body.String())
+			}
+
+			var response DescribeParametersResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, param := range response.Parameters {
+				names = append(names, string(param.Name))
+			}
+
+			if strings.Join(names, ",") != strings.Join(test.want, ",") {
+				t.Errorf("shared parameters %v, want %v", names, test.want)
+			}
+		})
+	}
+}
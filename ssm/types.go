@@ -83,6 +83,13 @@ type ResourceTag struct {
 	Value string
 }
 
+// ResourcePolicy shares an Advanced parameter with other accounts.
+type ResourcePolicy struct {
+	PolicyId   string
+	PolicyHash string
+	Policy     string
+}
+
 type ParameterData struct {
 	AllowedPattern   string
 	DataType         string
@@ -97,6 +104,7 @@ type ParameterData struct {
 	Type             awstypes.ParameterType
 	Value            string
 	Version          int64
+	ResourcePolicies []ResourcePolicy `json:",omitempty"`
 }
 
 func NewParameterData(request *awsssm.PutParameterInput) (*ParameterData, error) {
@@ -0,0 +1,352 @@
+package ssm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+const (
+	DefaultWaitSeconds = 20
+	MaxWaitSeconds     = 300
+
+	watchBatchSize    = 500
+	heartbeatInterval = 15 * time.Second
+)
+
+// WatchEvent is a change of a watched parameter. Values aren't included,
+// watchers read the parameter again.
+type WatchEvent struct {
+	Revision  string
+	Operation string
+	Name      string
+	ARN       string
+	Type      awstypes.ParameterType
+	Version   int64
+	Time      time.Time
+}
+
+// WatchResponse answers a long-poll. Revision is where the next poll
+// continues from.
+type WatchResponse struct {
+	Revision string
+	Snapshot []GetParameterItem `json:",omitempty"`
+	Events   []WatchEvent
+}
+
+// watchRequest holds the query parameters of a watch.
+type watchRequest struct {
+	path           string
+	filter         *regexp.Regexp
+	recursive      bool
+	revision       int64
+	snapshot       bool
+	withDecryption bool
+	wait           time.Duration
+}
+
+// SetJournal serves the /watch endpoint from the journal's changes.
+func (api *ParameterApi) SetJournal(journal *ChangeJournal) {
+
+	api.journal = journal
+}
+
+// HandleWatch streams the changes of the parameters under a path to the
+// caller, as Server-Sent Events if the request accepts text/event-stream
+// and as a long-poll otherwise. The caller needs ssm:GetParametersByPath on
+// the path, and only sees the parameters it's allowed to get.
+func (api *ParameterApi) HandleWatch(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	awslib.GetRequestInfo(r.Context()).Operation = "WatchParameters"
+
+	creds, err := api.parseCredentials(r)
+	if err != nil {
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
+		awslib.WriteErrorResponseJSON(w, awslib.ErrorCodes[awslib.ErrInternalError], r.URL, api.credentials.Region)
+		return
+	}
+
+	if api.limiter != nil && !api.limiter.Allow(creds.AccessKeyID, "WatchParameters", time.Now()) {
+		awslib.Logger(r.Context()).Warn("request throttled")
+		awslib.WriteErrorResponseJSON(w, translateToApiError(ErrThrottled), r.URL, api.credentials.Region)
+		return
+	}
+
+	request, err := parseWatchRequest(r)
+	if err != nil {
+		awslib.Logger(r.Context()).Warn("request failed", "error", err)
+		awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
+		return
+	}
+
+	service := api.serviceFor(r)
+	if api.authorizer != nil {
+
+		policyRequest := policy.Request{
+			Action:   "ssm:GetParametersByPath",
+			Resource: service.resourceArn(request.path),
+		}
+
+		if err := api.authorizeRequest(r, service, creds, &policyRequest, request.path, nil); err != nil {
+			awslib.Logger(r.Context()).Warn("request denied", "error", err)
+			awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
+			return
+		}
+	}
+
+	if request.revision == 0 {
+		request.revision, _ = api.journal.current()
+	}
+
+	// the snapshot is read after taking the revision, so changes made while
+	// it's read are sent as well
+	var snapshot []GetParameterItem
+	if request.snapshot {
+
+		snapshot, err = api.watchSnapshot(r, service, creds, request)
+		if err != nil {
+			awslib.Logger(r.Context()).Warn("request failed", "error", err)
+			awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
+			return
+		}
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		api.streamChanges(w, r, service, creds, request, snapshot)
+		return
+	}
+
+	api.pollChanges(w, r, service, creds, request, snapshot)
+}
+
+func parseWatchRequest(r *http.Request) (*watchRequest, error) {
+
+	query := r.URL.Query()
+	path := query.Get("path")
+	paramPath, err := NewParamPath(&path)
+	if err != nil {
+		return nil, err
+	}
+
+	request := watchRequest{path: path, wait: DefaultWaitSeconds * time.Second}
+	for name, value := range map[string]*bool{
+		"recursive": &request.recursive, "snapshot": &request.snapshot, "withDecryption": &request.withDecryption} {
+
+		if query.Has(name) {
+			if *value, err = strconv.ParseBool(query.Get(name)); err != nil {
+				return nil, ErrInvalidFilterValue
+			}
+		}
+	}
+
+	filter := paramPath.asOneLevelRegex()
+	if request.recursive {
+		filter = paramPath.asRecursiveRegex()
+	}
+
+	if request.filter, err = regexp.Compile(filter); err != nil {
+		return nil, ErrInvalidPath
+	}
+
+	// SSE clients resume with the id of the last event they got
+	token := query.Get("revision")
+	if token == "" {
+		token = r.Header.Get("Last-Event-ID")
+	}
+
+	if token != "" {
+		if request.revision, err = parseRevision(token); err != nil {
+			return nil, err
+		}
+	}
+
+	if query.Has("waitSeconds") {
+
+		seconds, err := strconv.Atoi(query.Get("waitSeconds"))
+		if err != nil || seconds < 0 || seconds > MaxWaitSeconds {
+			return nil, ErrInvalidFilterValue
+		}
+
+		request.wait = time.Duration(seconds) * time.Second
+	}
+
+	return &request, nil
+}
+
+func (api *ParameterApi) watchSnapshot(
+	r *http.Request, service *ParameterService, creds *aws.Credentials, request *watchRequest) ([]GetParameterItem, error) {
+
+	response, err := service.GetParametersByPath(r.Context(), &awsssm.GetParametersByPathInput{
+		Path:           aws.String(request.path),
+		Recursive:      aws.Bool(request.recursive),
+		WithDecryption: aws.Bool(request.withDecryption),
+	}, func(name ParamName) bool {
+		return api.isAllowed(r, creds, "GetParametersByPath", name)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]GetParameterItem{}, response.Parameters...), nil
+}
+
+// watchEvents returns the changes after the request's revision the caller
+// may see, and moves the revision past them. More is set when the journal
+// has further changes to read, whether or not the caller saw any.
+func (api *ParameterApi) watchEvents(r *http.Request, service *ParameterService,
+	creds *aws.Credentials, request *watchRequest) (events []WatchEvent, changed <-chan struct{}, more bool, err error) {
+
+	entries, revision, changed, err := api.journal.read(r.Context(), request.revision, watchBatchSize)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	events = []WatchEvent{}
+	for _, entry := range entries {
+
+		change := entry.Change
+		if change.AccountId != service.accountId || change.Region != service.region || !request.filter.MatchString(change.Name) {
+			continue
+		}
+
+		if !api.isAllowed(r, creds, "GetParametersByPath", ParamName(change.Name)) {
+			continue
+		}
+
+		events = append(events, WatchEvent{
+			Revision:  formatRevision(entry.Revision),
+			Operation: change.Operation,
+			Name:      change.Name,
+			ARN:       change.ARN,
+			Type:      change.Type,
+			Version:   change.Version,
+			Time:      change.Time,
+		})
+	}
+
+	request.revision = revision
+
+	return events, changed, len(entries) == watchBatchSize, nil
+}
+
+// pollChanges answers once there are changes, or with none after the
+// request's wait.
+func (api *ParameterApi) pollChanges(w http.ResponseWriter, r *http.Request,
+	service *ParameterService, creds *aws.Credentials, request *watchRequest, snapshot []GetParameterItem) {
+
+	ctx, cancel := context.WithTimeout(r.Context(), request.wait)
+	defer cancel()
+
+	for {
+		events, changed, more, err := api.watchEvents(r, service, creds, request)
+		if err != nil {
+			awslib.Logger(r.Context()).Warn("request failed", "error", err)
+			awslib.WriteErrorResponseJSON(w, translateToApiError(err), r.URL, api.credentials.Region)
+			return
+		}
+
+		if len(events) > 0 || snapshot != nil {
+			awslib.WriteSuccessResponseJSON(w, WatchResponse{
+				Revision: formatRevision(request.revision), Snapshot: snapshot, Events: events})
+			return
+		}
+
+		// a full batch of changes the caller can't see, read on
+		if more && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			if r.Context().Err() == nil {
+				awslib.WriteSuccessResponseJSON(w, WatchResponse{Revision: formatRevision(request.revision), Events: events})
+			}
+			return
+		}
+	}
+}
+
+// streamChanges sends the snapshot and then every change as Server-Sent
+// Events until the client goes away. Event ids are revisions.
+func (api *ParameterApi) streamChanges(w http.ResponseWriter, r *http.Request,
+	service *ParameterService, creds *aws.Credentials, request *watchRequest, snapshot []GetParameterItem) {
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, param := range snapshot {
+		writeServerSentEvent(w, "snapshot", "", param)
+	}
+
+	if snapshot != nil {
+		revision := formatRevision(request.revision)
+		writeServerSentEvent(w, "synced", revision, struct{ Revision string }{revision})
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		events, changed, more, err := api.watchEvents(r, service, creds, request)
+		if err != nil {
+			awslib.Logger(r.Context()).Warn("watch ended", "error", err)
+			writeServerSentEvent(w, "error", "", translateToApiError(err))
+			controller.Flush()
+			return
+		}
+
+		for _, event := range events {
+			writeServerSentEvent(w, "change", event.Revision, event)
+		}
+
+		if err := controller.Flush(); err != nil {
+			return
+		}
+
+		if more && r.Context().Err() == nil {
+			continue
+		}
+
+		select {
+		case <-changed:
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeServerSentEvent(w http.ResponseWriter, event string, id string, data any) {
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, body)
+}
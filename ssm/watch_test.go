@@ -0,0 +1,78 @@
+package ssm
+
+import (
+	"encoding/json"
+	"fmt"
+	"home-ssm/awslib"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// callWatch long-polls the changes as the principal with the access key.
+func callWatch(api *ParameterApi, accessKey string, query string) *httptest.ResponseRecorder {
+
+	handler := awslib.WithRequestId(func(w http.ResponseWriter, r *http.Request) {
+
+		info := awslib.GetRequestInfo(r.Context())
+		info.Principal = &aws.Credentials{AccessKeyID: accessKey, AccountID: testAccountId}
+		info.Region = "us-east-1"
+		api.HandleWatch(w, r)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/watch?"+query, nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	return w
+}
+
+func TestWatchPoll(t *testing.T) {
+
+	api, service := newTestApi(t)
+	journal, err := NewChangeJournal(service.dataStore.db, WatchConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.AddChangeListener(journal)
+	api.SetJournal(journal)
+
+	// revision zero means now, so watch from a change
+	putTestParameter(t, service, "/app/start", "app-value")
+	start, _ := journal.current()
+	// more hidden changes than fit in a batch before the visible one
+	for i := range watchBatchSize + 1 {
+		putTestParameter(t, service, fmt.Sprintf("/secret/%d", i), "secret-value")
+	}
+	putTestParameter(t, service, "/app/a", "app-value")
+
+	tests := []struct {
+		name      string
+		accessKey string
+		events    int
+	}{
+		{"restricted", "restricted", 1},
+		{"unrestricted", "admin", watchBatchSize},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			w := callWatch(api, test.accessKey, fmt.Sprintf("path=%%2F&recursive=true&waitSeconds=1&revision=%d", start))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d %s", w.Code, w.Body.String())
+			}
+
+			var response WatchResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(response.Events) != test.events {
+				t.Errorf("got %d events, want %d: %.300s", len(response.Events), test.events, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package sts
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const (
+	minSessionDuration          = 900
+	defaultSessionTokenDuration = 43200
+	maxSessionTokenDuration     = 129600
+)
+
+var sessionNamePattern = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+// Api serves the STS Query protocol: GetCallerIdentity, AssumeRole and
+// GetSessionToken.
+type Api struct {
+	region     string
+	accountId  string
+	mu         sync.RWMutex
+	roles      map[string]*Role
+	sessions   *SessionStore
+	authorizer *policy.Authorizer
+}
+
+func NewApi(region string, accountId string, roles []Role, sessions *SessionStore, authorizer *policy.Authorizer) *Api {
+
+	api := Api{
+		region:     region,
+		accountId:  accountId,
+		sessions:   sessions,
+		authorizer: authorizer,
+	}
+	api.SetRoles(roles)
+
+	return &api
+}
+
+// SetRoles replaces the roles that can be assumed after a config reload.
+func (api *Api) SetRoles(roles []Role) {
+
+	byName := make(map[string]*Role)
+	for _, role := range roles {
+		byName[role.Name] = &role
+	}
+
+	api.mu.Lock()
+	api.roles = byName
+	api.mu.Unlock()
+}
+
+func (api *Api) role(name string) (*Role, bool) {
+
+	api.mu.RLock()
+	defer api.mu.RUnlock()
+
+	role, ok := api.roles[name]
+	return role, ok
+}
+
+func (api *Api) Handle(w http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	params := r.URL.Query()
+	if form, err := url.ParseQuery(string(body)); err == nil {
+		for key, values := range form {
+			params[key] = values
+		}
+	}
+
+	info := awslib.GetRequestInfo(r.Context())
+	action := params.Get("Action")
+	info.Operation = action
+
+	if info.Principal == nil {
+		api.writeError(w, r, ErrMissingAuthentication)
+		return
+	}
+
+	var response any
+	switch action {
+	case "GetCallerIdentity":
+		response = api.getCallerIdentity(info)
+	case "AssumeRole":
+		response, err = api.assumeRole(r, info, params)
+	case "GetSessionToken":
+		response, err = api.getSessionToken(info, params)
+	default:
+		err = ErrInvalidAction
+	}
+
+	if err != nil {
+		api.writeError(w, r, err)
+		return
+	}
+
+	awslib.WriteSuccessResponseXML(w, response)
+}
+
+func (api *Api) writeError(w http.ResponseWriter, r *http.Request, err error) {
+
+	awslib.Logger(r.Context()).Warn("request failed", "error", err)
+	awslib.WriteErrorResponseXML(w, translateToApiError(err), xmlns)
+}
+
+func (api *Api) getCallerIdentity(info *awslib.RequestInfo) *GetCallerIdentityResponse {
+
+	userId := info.Principal.AccessKeyID
+	if info.Session != nil {
+
+		userId = info.Session.ParentAccessKey
+		if role, ok := api.role(info.Session.RoleName); ok {
+			userId = role.roleId(api.accountId) + ":" + sessionName(info.Principal)
+		}
+	}
+
+	accountId := api.callerAccount(info)
+	response := GetCallerIdentityResponse{Xmlns: xmlns}
+	response.Result = GetCallerIdentityResult{
+		Arn:     awslib.PrincipalArn(accountId, info.Principal),
+		UserId:  userId,
+		Account: accountId,
+	}
+	response.ResponseMetadata.RequestId = info.RequestId
+
+	return &response
+}
+
+func (api *Api) assumeRole(r *http.Request, info *awslib.RequestInfo, params url.Values) (*AssumeRoleResponse, error) {
+
+	roleArn := params.Get("RoleArn")
+	if roleArn == "" {
+		return nil, ErrMissingRoleArn
+	}
+
+	name := params.Get("RoleSessionName")
+	if name == "" {
+		return nil, ErrMissingSessionName
+	}
+
+	if !sessionNamePattern.MatchString(name) {
+		return nil, ErrInvalidSessionName
+	}
+
+	principalArn := awslib.PrincipalArn(api.callerAccount(info), info.Principal)
+	denied := &policy.AccessDeniedError{
+		PrincipalArn:  principalArn,
+		Action:        "sts:AssumeRole",
+		Resource:      roleArn,
+		ResourceBased: true,
+	}
+
+	// roles trust the users of their own account only
+	role, ok := api.role(strings.TrimPrefix(roleArn, "arn:aws:iam::"+api.accountId+":role/"))
+	if !ok || role.arn(api.accountId) != roleArn || api.callerAccount(info) != api.accountId || !role.trusts(callerName(info)) {
+		return nil, denied
+	}
+
+	request := policy.Request{
+		Action:   "sts:AssumeRole",
+		Resource: roleArn,
+		Context:  policy.GlobalContext(info.Principal, principalArn, info.SourceIp),
+	}
+	if err := api.authorizer.Authorize(info.Principal, principalArn, &request); err != nil {
+		return nil, err
+	}
+
+	duration, err := parseDuration(params.Get("DurationSeconds"), defaultMaxSessionDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	if duration > time.Duration(role.maxSessionDuration())*time.Second {
+		return nil, ErrDurationExceedsMax
+	}
+
+	session, err := api.newSession(info, awslib.AssumedRoleSource(role.Name, name), duration)
+	if err != nil {
+		return nil, err
+	}
+	session.RoleName = role.Name
+
+	// a role without policies allows nothing, rather than leaving its
+	// sessions unrestricted
+	documents, ok := api.authorizer.Policies(role.PolicyKey())
+	if !ok {
+		documents = []*policy.Document{{Name: role.Name}}
+	}
+	api.sessions.add(session, documents)
+
+	response := AssumeRoleResponse{Xmlns: xmlns}
+	response.Result.Credentials = newCredentials(session)
+	response.Result.AssumedRoleUser = AssumedRoleUser{
+		Arn:           awslib.PrincipalArn(api.accountId, &session.Credentials),
+		AssumedRoleId: role.roleId(api.accountId) + ":" + name,
+	}
+	response.ResponseMetadata.RequestId = info.RequestId
+
+	return &response, nil
+}
+
+func (api *Api) getSessionToken(info *awslib.RequestInfo, params url.Values) (*GetSessionTokenResponse, error) {
+
+	if info.Session != nil {
+		return nil, ErrSessionCredentials
+	}
+
+	duration, err := parseDuration(params.Get("DurationSeconds"), defaultSessionTokenDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	if duration > maxSessionTokenDuration*time.Second {
+		return nil, ErrDurationExceedsLimit
+	}
+
+	session, err := api.newSession(info, info.Principal.Source, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	// the session has the same permissions as the user
+	documents, _ := api.authorizer.Policies(info.Principal.AccessKeyID)
+	api.sessions.add(session, documents)
+
+	response := GetSessionTokenResponse{Xmlns: xmlns}
+	response.Result.Credentials = newCredentials(session)
+	response.ResponseMetadata.RequestId = info.RequestId
+
+	return &response, nil
+}
+
+func (api *Api) newSession(info *awslib.RequestInfo, source string, duration time.Duration) (*awslib.Session, error) {
+
+	accessKey, err := randomString(10, base32.StdEncoding.EncodeToString)
+	if err != nil {
+		return nil, err
+	}
+
+	secretKey, err := randomString(30, base64.StdEncoding.EncodeToString)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := randomString(96, base64.StdEncoding.EncodeToString)
+	if err != nil {
+		return nil, err
+	}
+
+	parentAccessKey := info.Principal.AccessKeyID
+	if info.Session != nil {
+		parentAccessKey = info.Session.ParentAccessKey
+	}
+
+	return &awslib.Session{
+		Credentials: aws.Credentials{
+			AccessKeyID:     "ASIA" + accessKey,
+			SecretAccessKey: secretKey,
+			SessionToken:    token,
+			Source:          source,
+			AccountID:       api.callerAccount(info),
+			CanExpire:       true,
+			Expires:         time.Now().Add(duration),
+		},
+		ParentAccessKey: parentAccessKey,
+	}, nil
+}
+
+// callerAccount is the account of the caller, which its sessions belong to.
+func (api *Api) callerAccount(info *awslib.RequestInfo) string {
+
+	return cmp.Or(info.Principal.AccountID, api.accountId)
+}
+
+func newCredentials(session *awslib.Session) Credentials {
+
+	return Credentials{
+		AccessKeyId:     session.Credentials.AccessKeyID,
+		SecretAccessKey: session.Credentials.SecretAccessKey,
+		SessionToken:    session.Credentials.SessionToken,
+		Expiration:      formatExpiration(session.Credentials.Expires),
+	}
+}
+
+func parseDuration(value string, defaultSeconds int) (time.Duration, error) {
+
+	seconds := defaultSeconds
+	if value != "" {
+
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, ErrInvalidDuration
+		}
+
+		seconds = parsed
+	}
+
+	if seconds < minSessionDuration {
+		return 0, ErrDurationTooShort
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// callerName is matched against role principals: the username, or
+// "role/<name>" for role sessions.
+func callerName(info *awslib.RequestInfo) string {
+
+	if info.Session != nil && info.Session.RoleName != "" {
+		return "role/" + info.Session.RoleName
+	}
+
+	return info.Principal.Source
+}
+
+func sessionName(creds *aws.Credentials) string {
+
+	return creds.Source[strings.LastIndex(creds.Source, "/")+1:]
+}
+
+func randomString(size int, encode func([]byte) string) (string, error) {
+
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return encode(b), nil
+}
@@ -0,0 +1,108 @@
+package sts
+
+import (
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const testAccountId = "000000000000"
+
+var accessKeyPattern = regexp.MustCompile(`<AccessKeyId>(\w+)</AccessKeyId>`)
+
+func newTestApi(t *testing.T) (*Api, *policy.Authorizer) {
+
+	t.Helper()
+
+	document, err := policy.Parse("read", `{"Statement":[{"Effect":"Allow","Action":"ssm:GetParameter","Resource":"*"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roles := []Role{{Name: "reader", Policies: []string{"read"}}, {Name: "empty"}}
+	authorizer := policy.NewAuthorizer()
+	authorizer.SetPolicies(roles[0].PolicyKey(), []*policy.Document{document})
+
+	return NewApi("us-east-1", testAccountId, roles, NewSessionStore(authorizer), authorizer), authorizer
+}
+
+// callApi sends a request as the user of the account.
+func callApi(api *Api, accountId string, params url.Values) *httptest.ResponseRecorder {
+
+	handler := awslib.WithRequestId(func(w http.ResponseWriter, r *http.Request) {
+
+		info := awslib.GetRequestInfo(r.Context())
+		info.Principal = &aws.Credentials{AccessKeyID: "AKIA" + accountId, Source: "alice", AccountID: accountId}
+		api.Handle(w, r)
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(params.Encode()))
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	return w
+}
+
+func TestSessions(t *testing.T) {
+
+	assumeRole := func(role string) url.Values {
+		return url.Values{"Action": {"AssumeRole"}, "RoleSessionName": {"test"},
+			"RoleArn": {"arn:aws:iam::" + testAccountId + ":role/" + role}}
+	}
+
+	tests := []struct {
+		name      string
+		accountId string
+		params    url.Values
+		status    int
+		// allowed is whether the session may get a parameter
+		allowed bool
+	}{
+		{"session token", testAccountId, url.Values{"Action": {"GetSessionToken"}}, http.StatusOK, true},
+		{"session token of another account", "111111111111", url.Values{"Action": {"GetSessionToken"}}, http.StatusOK, true},
+		{"role", testAccountId, assumeRole("reader"), http.StatusOK, true},
+		{"role without policies", testAccountId, assumeRole("empty"), http.StatusOK, false},
+		{"role of another account", "111111111111", assumeRole("reader"), http.StatusForbidden, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			api, authorizer := newTestApi(t)
+			w := callApi(api, test.accountId, test.params)
+			if w.Code != test.status {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body.String(), test.status)
+			}
+
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			match := accessKeyPattern.FindStringSubmatch(w.Body.String())
+			if match == nil {
+				t.Fatalf("no credentials in %s", w.Body.String())
+			}
+
+			session, ok := api.sessions.Session(match[1])
+			if !ok {
+				t.Fatalf("session %s wasn't stored", match[1])
+			}
+
+			if session.Credentials.AccountID != test.accountId {
+				t.Errorf("session of account %s, want %s", session.Credentials.AccountID, test.accountId)
+			}
+
+			request := policy.Request{Action: "ssm:GetParameter", Resource: "arn:aws:ssm:us-east-1:" + test.accountId + ":parameter/a"}
+			if err := authorizer.Authorize(&session.Credentials, "", &request); (err == nil) != test.allowed {
+				t.Errorf("Authorize() = %v, want allowed %v", err, test.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package sts
+
+import (
+	"errors"
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"net/http"
+)
+
+var (
+	ErrInvalidAction         = errors.New("Could not find operation for version 2011-06-15.")
+	ErrMissingRoleArn        = errors.New("The request must contain the parameter RoleArn.")
+	ErrMissingSessionName    = errors.New("The request must contain the parameter RoleSessionName.")
+	ErrInvalidSessionName    = errors.New("RoleSessionName must satisfy regular expression pattern: [\\w+=,.@-]{2,64}")
+	ErrInvalidDuration       = errors.New("DurationSeconds must be a number of seconds.")
+	ErrDurationTooShort      = errors.New("DurationSeconds must have value greater than or equal to 900.")
+	ErrDurationExceedsMax    = errors.New("The requested DurationSeconds exceeds the MaxSessionDuration set for this role.")
+	ErrDurationExceedsLimit  = errors.New("DurationSeconds must have value less than or equal to 129600.")
+	ErrSessionCredentials    = errors.New("Cannot call GetSessionToken with session credentials")
+	ErrInternalError         = errors.New("We encountered an internal error, please try again.")
+	ErrMissingAuthentication = errors.New("Request is missing Authentication Token")
+)
+
+type errorCodeMap map[error]awslib.APIError
+
+var StsErrorCodes = errorCodeMap{
+	ErrInvalidAction: {
+		Code:           "InvalidAction",
+		Description:    ErrInvalidAction.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrMissingRoleArn: {
+		Code:           "MissingParameter",
+		Description:    ErrMissingRoleArn.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrMissingSessionName: {
+		Code:           "MissingParameter",
+		Description:    ErrMissingSessionName.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidSessionName: {
+		Code:           "ValidationError",
+		Description:    ErrInvalidSessionName.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidDuration: {
+		Code:           "ValidationError",
+		Description:    ErrInvalidDuration.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrDurationTooShort: {
+		Code:           "ValidationError",
+		Description:    ErrDurationTooShort.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrDurationExceedsMax: {
+		Code:           "ValidationError",
+		Description:    ErrDurationExceedsMax.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrDurationExceedsLimit: {
+		Code:           "ValidationError",
+		Description:    ErrDurationExceedsLimit.Error(),
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrSessionCredentials: {
+		Code:           "AccessDenied",
+		Description:    ErrSessionCredentials.Error(),
+		HTTPStatusCode: http.StatusForbidden,
+	},
+	ErrInternalError: {
+		Code:           "InternalFailure",
+		Description:    ErrInternalError.Error(),
+		HTTPStatusCode: http.StatusInternalServerError,
+	},
+	ErrMissingAuthentication: {
+		Code:           "MissingAuthenticationToken",
+		Description:    ErrMissingAuthentication.Error(),
+		HTTPStatusCode: http.StatusForbidden,
+	},
+}
+
+func translateToApiError(err error) awslib.APIError {
+
+	value, ok := StsErrorCodes[err]
+	if ok {
+
+		return value
+	}
+
+	var accessDenied *policy.AccessDeniedError
+	if errors.As(err, &accessDenied) {
+
+		return awslib.APIError{
+			Code:           "AccessDenied",
+			Description:    accessDenied.Error(),
+			HTTPStatusCode: http.StatusForbidden,
+		}
+	}
+
+	return StsErrorCodes[ErrInternalError]
+}
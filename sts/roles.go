@@ -0,0 +1,77 @@
+package sts
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"slices"
+)
+
+const (
+	defaultMaxSessionDuration = 3600
+	maxRoleSessionDuration    = 43200
+)
+
+// Role can be assumed by the listed principals, its sessions are restricted
+// by the attached policies.
+type Role struct {
+	Name string `yaml:"name"`
+	// Principals are the usernames allowed to assume the role, any user if empty.
+	Principals         []string `yaml:"principals"`
+	Policies           []string `yaml:"policies"`
+	MaxSessionDuration int      `yaml:"maxSessionDuration"`
+}
+
+func (role *Role) Validate() error {
+
+	if role.Name == "" {
+		return fmt.Errorf("role is missing a name")
+	}
+
+	if role.MaxSessionDuration != 0 &&
+		(role.MaxSessionDuration < defaultMaxSessionDuration || role.MaxSessionDuration > maxRoleSessionDuration) {
+
+		return fmt.Errorf("role %s maxSessionDuration must be between %d and %d",
+			role.Name, defaultMaxSessionDuration, maxRoleSessionDuration)
+	}
+
+	return nil
+}
+
+// PolicyKey is the key under which the role policies are registered with
+// the authorizer.
+func (role *Role) PolicyKey() string {
+
+	return "role/" + role.Name
+}
+
+func (role *Role) maxSessionDuration() int {
+
+	if role.MaxSessionDuration == 0 {
+		return defaultMaxSessionDuration
+	}
+
+	return role.MaxSessionDuration
+}
+
+func (role *Role) trusts(caller string) bool {
+
+	if len(role.Principals) == 0 {
+		return true
+	}
+
+	return slices.Contains(role.Principals, caller)
+}
+
+func (role *Role) arn(accountId string) string {
+
+	return fmt.Sprintf("arn:aws:iam::%s:role/%s", accountId, role.Name)
+}
+
+// roleId is a stable AROA prefixed identifier derived from the role name.
+func (role *Role) roleId(accountId string) string {
+
+	sum := sha256.Sum256([]byte(accountId + "/" + role.Name))
+
+	return "AROA" + base32.StdEncoding.EncodeToString(sum[:])[:16]
+}
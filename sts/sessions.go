@@ -0,0 +1,101 @@
+package sts
+
+import (
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"slices"
+	"sync"
+	"time"
+)
+
+// expiredRetention is how long expired sessions are kept so that requests
+// get ExpiredToken rather than InvalidClientTokenId.
+const expiredRetention = time.Hour
+
+// SessionStore holds the temporary credentials issued by STS. Sessions are
+// kept in memory and don't survive a restart.
+type SessionStore struct {
+	mu         sync.RWMutex
+	sessions   map[string]*awslib.Session
+	authorizer *policy.Authorizer
+}
+
+func NewSessionStore(authorizer *policy.Authorizer) *SessionStore {
+
+	return &SessionStore{sessions: make(map[string]*awslib.Session), authorizer: authorizer}
+}
+
+// Session returns the session of the access key. Sessions are purged when
+// new ones are added, so lookups only skip those past their retention.
+func (s *SessionStore) Session(accessKey string) (*awslib.Session, bool) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[accessKey]
+	if !ok || expired(session, time.Now()) {
+		return nil, false
+	}
+
+	return session, true
+}
+
+// add stores the session and attaches the policies that restrict it.
+func (s *SessionStore) add(session *awslib.Session, documents []*policy.Document) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purge(time.Now())
+	s.sessions[session.Credentials.AccessKeyID] = session
+	s.authorizer.SetPolicies(session.Credentials.AccessKeyID, documents)
+}
+
+func (s *SessionStore) purge(now time.Time) {
+
+	for accessKey, session := range s.sessions {
+
+		if expired(session, now) {
+			delete(s.sessions, accessKey)
+			s.authorizer.RemovePolicies(accessKey)
+		}
+	}
+}
+
+// expired reports whether the session is past its retention.
+func expired(session *awslib.Session, now time.Time) bool {
+
+	return now.After(session.Credentials.Expires.Add(expiredRetention))
+}
+
+// Revoke ends the sessions requested with the access keys, e.g. after they
+// were removed from the config.
+func (s *SessionStore) Revoke(parentAccessKeys []string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for accessKey, session := range s.sessions {
+
+		if slices.Contains(parentAccessKeys, session.ParentAccessKey) {
+			delete(s.sessions, accessKey)
+			s.authorizer.RemovePolicies(accessKey)
+		}
+	}
+}
+
+// RevokeRoles ends the sessions of the roles, e.g. after they were removed
+// from the config.
+func (s *SessionStore) RevokeRoles(roleNames []string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for accessKey, session := range s.sessions {
+
+		if session.RoleName != "" && slices.Contains(roleNames, session.RoleName) {
+			delete(s.sessions, accessKey)
+			s.authorizer.RemovePolicies(accessKey)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package sts
+
+import (
+	"home-ssm/awslib"
+	"home-ssm/policy"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestSessionStoreRevoke(t *testing.T) {
+
+	document, err := policy.Parse("read", `{"Statement":[{"Effect":"Allow","Action":"ssm:GetParameter","Resource":"*"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorizer := policy.NewAuthorizer()
+	sessions := NewSessionStore(authorizer)
+	for accessKey, parent := range map[string]string{"ASIA1": "removed", "ASIA2": "removed", "ASIA3": "kept"} {
+
+		sessions.add(&awslib.Session{
+			Credentials:     aws.Credentials{AccessKeyID: accessKey, Expires: time.Now().Add(time.Hour)},
+			ParentAccessKey: parent,
+		}, []*policy.Document{document})
+	}
+
+	sessions.Revoke([]string{"removed", "other"})
+
+	tests := []struct {
+		accessKey string
+		valid     bool
+	}{
+		{"ASIA1", false},
+		{"ASIA2", false},
+		{"ASIA3", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.accessKey, func(t *testing.T) {
+
+			_, found := sessions.Session(test.accessKey)
+			_, attached := authorizer.Policies(test.accessKey)
+			if found != test.valid || attached != test.valid {
+				t.Errorf("session found %v, policies attached %v, want %v", found, attached, test.valid)
+			}
+		})
+	}
+}
+
+func TestSessionStorePurge(t *testing.T) {
+
+	authorizer := policy.NewAuthorizer()
+	sessions := NewSessionStore(authorizer)
+	document, err := policy.Parse("read", `{"Statement":[{"Effect":"Allow","Action":"ssm:GetParameter","Resource":"*"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	add := func(accessKey string, expires time.Time) {
+		sessions.add(&awslib.Session{Credentials: aws.Credentials{AccessKeyID: accessKey, Expires: expires}},
+			[]*policy.Document{document})
+	}
+
+	now := time.Now()
+	add("ASIA2", now.Add(-time.Minute))
+	// added last, so no purge removed it yet
+	add("ASIA1", now.Add(-expiredRetention-time.Minute))
+
+	tests := []struct {
+		accessKey string
+		// found is whether the lookup returns the session, stored whether
+		// it's still in the store
+		found  bool
+		stored bool
+	}{
+		{"ASIA1", false, true},
+		{"ASIA2", true, true},
+	}
+
+	for _, test := range tests {
+
+		_, found := sessions.Session(test.accessKey)
+		_, stored := sessions.sessions[test.accessKey]
+		if found != test.found || stored != test.stored {
+			t.Errorf("%s found %v, stored %v, want %v, %v", test.accessKey, found, stored, test.found, test.stored)
+		}
+	}
+
+	add("ASIA3", now.Add(time.Hour))
+	if _, stored := sessions.sessions["ASIA1"]; stored {
+		t.Error("session past its retention wasn't purged")
+	}
+
+	if _, attached := authorizer.Policies("ASIA1"); attached {
+		t.Error("policies of the purged session are still attached")
+	}
+}
@@ -0,0 +1,65 @@
+package sts
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+const xmlns = "https://sts.amazonaws.com/doc/2011-06-15/"
+
+type ResponseMetadata struct {
+	RequestId string `xml:"RequestId"`
+}
+
+type Credentials struct {
+	AccessKeyId     string `xml:"AccessKeyId"`
+	SecretAccessKey string `xml:"SecretAccessKey"`
+	SessionToken    string `xml:"SessionToken"`
+	Expiration      string `xml:"Expiration"`
+}
+
+type AssumedRoleUser struct {
+	Arn           string `xml:"Arn"`
+	AssumedRoleId string `xml:"AssumedRoleId"`
+}
+
+type GetCallerIdentityResult struct {
+	Arn     string `xml:"Arn"`
+	UserId  string `xml:"UserId"`
+	Account string `xml:"Account"`
+}
+
+type GetCallerIdentityResponse struct {
+	XMLName          xml.Name                `xml:"GetCallerIdentityResponse"`
+	Xmlns            string                  `xml:"xmlns,attr"`
+	Result           GetCallerIdentityResult `xml:"GetCallerIdentityResult"`
+	ResponseMetadata ResponseMetadata        `xml:"ResponseMetadata"`
+}
+
+type AssumeRoleResult struct {
+	Credentials     Credentials     `xml:"Credentials"`
+	AssumedRoleUser AssumedRoleUser `xml:"AssumedRoleUser"`
+}
+
+type AssumeRoleResponse struct {
+	XMLName          xml.Name         `xml:"AssumeRoleResponse"`
+	Xmlns            string           `xml:"xmlns,attr"`
+	Result           AssumeRoleResult `xml:"AssumeRoleResult"`
+	ResponseMetadata ResponseMetadata `xml:"ResponseMetadata"`
+}
+
+type GetSessionTokenResult struct {
+	Credentials Credentials `xml:"Credentials"`
+}
+
+type GetSessionTokenResponse struct {
+	XMLName          xml.Name              `xml:"GetSessionTokenResponse"`
+	Xmlns            string                `xml:"xmlns,attr"`
+	Result           GetSessionTokenResult `xml:"GetSessionTokenResult"`
+	ResponseMetadata ResponseMetadata      `xml:"ResponseMetadata"`
+}
+
+func formatExpiration(t time.Time) string {
+
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
@@ -0,0 +1,155 @@
+package tracing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "home-ssm"
+
+type Config struct {
+	// Endpoint is the OTLP/HTTP collector host:port; tracing is disabled when empty.
+	Endpoint string `yaml:"endpoint"`
+	// Insecure uses http instead of https to reach the collector.
+	Insecure bool `yaml:"insecure"`
+	// RedactNames replaces parameter names with a hash in span attributes.
+	RedactNames bool `yaml:"redactNames"`
+	// SampleRatio is the fraction of new traces sampled, defaults to 1.
+	SampleRatio *float64 `yaml:"sampleRatio"`
+}
+
+var redactNames bool
+
+func init() {
+
+	// X-Ray first so a W3C traceparent wins when a client sends both
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		xray.Propagator{}, propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Init installs a tracer provider exporting to the configured OTLP endpoint.
+// The returned function flushes and stops the exporter.
+func Init(ctx context.Context, config Config, version string) (func(context.Context) error, error) {
+
+	redactNames = config.RedactNames
+
+	if config.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.Endpoint)}
+	if config.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	ratio := 1.0
+	if config.SampleRatio != nil {
+		ratio = *config.SampleRatio
+	}
+
+	provider := NewProvider(sdktrace.NewBatchSpanProcessor(exporter), ratio, version)
+
+	return provider.Shutdown, nil
+}
+
+// NewProvider installs a tracer provider using the given span processor;
+// tests can pass sdktrace.NewSimpleSpanProcessor(tracetest.NewInMemoryExporter()).
+func NewProvider(processor sdktrace.SpanProcessor, sampleRatio float64, version string) *sdktrace.TracerProvider {
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(tracerName),
+			semconv.ServiceVersion(version),
+		)),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	return provider
+}
+
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// RecordError marks the span as failed when err isn't nil.
+func RecordError(span trace.Span, err error) {
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func Operation(operation string) attribute.KeyValue {
+
+	return attribute.String("aws.operation", operation)
+}
+
+func AccessKey(accessKey string) attribute.KeyValue {
+
+	return attribute.String("aws.access_key", accessKey)
+}
+
+func ResultCode(code string) attribute.KeyValue {
+
+	return attribute.String("aws.result_code", code)
+}
+
+func KeyId(keyId string) attribute.KeyValue {
+
+	return attribute.String("aws.kms.key_id", keyId)
+}
+
+// ParameterName returns the parameter name attribute, hashed when
+// redaction is configured.
+func ParameterName(name string) attribute.KeyValue {
+
+	if redactNames {
+		sum := sha256.Sum256([]byte(name))
+		name = "sha256:" + hex.EncodeToString(sum[:8])
+	}
+
+	return attribute.String("aws.ssm.parameter_name", name)
+}
+
+// Middleware starts a server span for each request, continuing any trace
+// sent in traceparent or X-Amzn-Trace-Id headers.
+func Middleware(next http.HandlerFunc) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := otel.Tracer(tracerName).Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(r.RemoteAddr),
+			))
+		defer span.End()
+
+		next(w, r.WithContext(ctx))
+	}
+}
@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTestProvider(t *testing.T, sampleRatio float64) *tracetest.InMemoryExporter {
+
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := NewProvider(sdktrace.NewSimpleSpanProcessor(exporter), sampleRatio, "test")
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	return exporter
+}
+
+func TestMiddleware(t *testing.T) {
+
+	const traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		name        string
+		header      string
+		value       string
+		sampleRatio float64
+		// traceId is the trace the span must continue, any when empty
+		traceId string
+		sampled bool
+	}{
+		{"new trace", "", "", 1, "", true},
+		{"not sampled", "", "", 0, "", false},
+		{"traceparent", "traceparent", "00-" + traceId + "-00f067aa0ba902b7-01", 0, traceId, true},
+		{"x-ray", "X-Amzn-Trace-Id", "Root=1-4bf92f35-77b34da6a3ce929d0e0e4736;Parent=00f067aa0ba902b7;Sampled=1", 0, traceId, true},
+		{"parent not sampled", "traceparent", "00-" + traceId + "-00f067aa0ba902b7-00", 1, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			exporter := newTestProvider(t, test.sampleRatio)
+			handler := Middleware(func(w http.ResponseWriter, r *http.Request) {
+				_, span := Start(r.Context(), "Handler")
+				span.End()
+			})
+
+			r := httptest.NewRequest(http.MethodPost, "/ssm", strings.NewReader("{}"))
+			if test.header != "" {
+				r.Header.Set(test.header, test.value)
+			}
+			handler(httptest.NewRecorder(), r)
+
+			spans := exporter.GetSpans()
+			if !test.sampled {
+				if len(spans) != 0 {
+					t.Errorf("exported %d spans, want none", len(spans))
+				}
+				return
+			}
+
+			if len(spans) != 2 {
+				t.Fatalf("exported %d spans, want 2", len(spans))
+			}
+
+			handlerSpan, serverSpan := spans[0], spans[1]
+			if serverSpan.Name != "POST /ssm" || handlerSpan.Parent.SpanID() != serverSpan.SpanContext.SpanID() {
+				t.Errorf("handler span %s isn't a child of server span %s", handlerSpan.Name, serverSpan.Name)
+			}
+
+			if test.traceId != "" && serverSpan.SpanContext.TraceID().String() != test.traceId {
+				t.Errorf("trace %s, want %s", serverSpan.SpanContext.TraceID(), test.traceId)
+			}
+		})
+	}
+}
+
+func TestParameterName(t *testing.T) {
+
+	defer func() { redactNames = false }()
+
+	tests := []struct {
+		redact bool
+		want   string
+	}{
+		{false, "/app/password"},
+		{true, "sha256:"},
+	}
+
+	for _, test := range tests {
+
+		redactNames = test.redact
+		value := ParameterName("/app/password").Value.AsString()
+		if !strings.HasPrefix(value, test.want) || (test.redact && strings.Contains(value, "password")) {
+			t.Errorf("redact %v: got %s, want %s", test.redact, value, test.want)
+		}
+	}
+}
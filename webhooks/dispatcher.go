@@ -0,0 +1,380 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"home-ssm/metrics"
+	"home-ssm/ssm"
+	"home-ssm/tracing"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	outboxPrefix = "webhooks/outbox/"
+
+	DefaultMaxAttempts    = 10
+	DefaultTimeoutSeconds = 10
+
+	// SignatureHeader carries sha256=<hex HMAC-SHA256 of the body>, keyed
+	// with the webhook's secret.
+	SignatureHeader = "X-Home-Ssm-Signature"
+	// DeliveryHeader carries the event id, which stays the same across
+	// retries.
+	DeliveryHeader = "X-Home-Ssm-Delivery"
+
+	pollInterval = time.Second
+	maxBackoff   = 5 * time.Minute
+)
+
+var operations = []string{ssm.ChangeCreate, ssm.ChangeUpdate, ssm.ChangeDelete}
+
+// Webhook receives the events of parameters under PathPrefix. Empty
+// filters match every parameter and operation.
+type Webhook struct {
+	Name string `yaml:"name"`
+	Url  string `yaml:"url"`
+	// Secret is the key the body is signed with.
+	Secret string `yaml:"secret"`
+	// PathPrefix is matched against the parameter name, e.g. /app/.
+	PathPrefix string `yaml:"pathPrefix"`
+	// Operations are Create, Update or Delete.
+	Operations []string `yaml:"operations"`
+}
+
+type Config struct {
+	Endpoints []Webhook `yaml:"endpoints"`
+	// MaxAttempts is how often an event is sent before it's dropped,
+	// defaults to 10.
+	MaxAttempts int `yaml:"maxAttempts"`
+	// TimeoutSeconds bounds each attempt, defaults to 10.
+	TimeoutSeconds int `yaml:"timeoutSeconds"`
+}
+
+func (config *Config) Validate() error {
+
+	var errs []error
+	if config.MaxAttempts < 0 || config.TimeoutSeconds < 0 {
+		errs = append(errs, errors.New("webhooks: maxAttempts and timeoutSeconds can't be negative"))
+	}
+
+	names := make(map[string]bool)
+	for _, webhook := range config.Endpoints {
+
+		if webhook.Name == "" || names[webhook.Name] {
+			errs = append(errs, fmt.Errorf("webhook %q needs a unique name", webhook.Name))
+		}
+		names[webhook.Name] = true
+
+		if parsed, err := url.Parse(webhook.Url); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("webhook %s: url %q isn't an http or https url", webhook.Name, webhook.Url))
+		}
+
+		if webhook.Secret == "" {
+			errs = append(errs, fmt.Errorf("webhook %s needs a secret", webhook.Name))
+		}
+
+		for _, operation := range webhook.Operations {
+			if !slices.Contains(operations, operation) {
+				errs = append(errs, fmt.Errorf("webhook %s: operation must be one of %s", webhook.Name, strings.Join(operations, ", ")))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (webhook *Webhook) matches(change *ssm.ParameterChange) bool {
+
+	if !strings.HasPrefix(change.Name, webhook.PathPrefix) {
+		return false
+	}
+
+	return len(webhook.Operations) == 0 || slices.Contains(webhook.Operations, change.Operation)
+}
+
+// delivery is an event waiting in the outbox to be sent to one webhook.
+type delivery struct {
+	Webhook     string
+	EventId     string
+	Body        json.RawMessage
+	Attempts    int
+	NextAttempt time.Time
+}
+
+// Dispatcher queues the events of parameter changes in a badger outbox and
+// sends them to the matching webhooks, so events outlive restarts and
+// unreachable receivers. Each webhook gets its events in order.
+type Dispatcher struct {
+	db          *badger.DB
+	webhooks    map[string]Webhook
+	maxAttempts int
+	client      *http.Client
+	wake        chan struct{}
+}
+
+func NewDispatcher(db *badger.DB, config Config) (*Dispatcher, error) {
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	dispatcher := Dispatcher{
+		db:          db,
+		webhooks:    make(map[string]Webhook),
+		maxAttempts: DefaultMaxAttempts,
+		client:      &http.Client{Timeout: DefaultTimeoutSeconds * time.Second},
+		wake:        make(chan struct{}, 1),
+	}
+
+	if config.MaxAttempts > 0 {
+		dispatcher.maxAttempts = config.MaxAttempts
+	}
+
+	if config.TimeoutSeconds > 0 {
+		dispatcher.client.Timeout = time.Duration(config.TimeoutSeconds) * time.Second
+	}
+
+	for _, webhook := range config.Endpoints {
+		dispatcher.webhooks[webhook.Name] = webhook
+	}
+
+	return &dispatcher, nil
+}
+
+// outboxKey orders deliveries by the time of their event.
+func outboxKey(event *Event, webhook string) []byte {
+
+	return []byte(fmt.Sprintf("%s%016x/%s/%s", outboxPrefix, time.Now().UnixNano(), event.Id, webhook))
+}
+
+// RecordChange queues the change's event for every matching webhook in the
+// transaction of the change, so it's sent if and only if the change is
+// committed.
+func (dispatcher *Dispatcher) RecordChange(ctx context.Context, txn *badger.Txn, change *ssm.ParameterChange) (func(committed bool), error) {
+
+	_, span := tracing.Start(ctx, "Webhooks.RecordChange", tracing.ParameterName(change.Name))
+	defer span.End()
+
+	event := newEvent(change)
+	body, err := json.Marshal(event)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, err
+	}
+
+	for _, webhook := range dispatcher.webhooks {
+
+		if !webhook.matches(change) {
+			continue
+		}
+
+		value, err := json.Marshal(delivery{Webhook: webhook.Name, EventId: event.Id, Body: body})
+		if err == nil {
+			err = txn.Set(outboxKey(event, webhook.Name), value)
+		}
+
+		if err != nil {
+			tracing.RecordError(span, err)
+			return nil, fmt.Errorf("queue webhook event: %w", err)
+		}
+	}
+
+	return func(committed bool) {
+
+		if !committed {
+			return
+		}
+
+		select {
+		case dispatcher.wake <- struct{}{}:
+		default:
+		}
+	}, nil
+}
+
+// Run sends queued events until ctx is done. Events that aren't delivered
+// stay in the outbox for the next start.
+func (dispatcher *Dispatcher) Run(ctx context.Context) {
+
+	for {
+		dispatcher.deliverDue(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-dispatcher.wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// deliverDue sends the deliveries that are due in order. A webhook's
+// later events wait while an earlier one is retried.
+func (dispatcher *Dispatcher) deliverDue(ctx context.Context, now time.Time) {
+
+	keys, deliveries, err := dispatcher.readOutbox()
+	if err != nil {
+		slog.Error("Failed to read webhook outbox.", "error", err)
+		return
+	}
+
+	waiting := make(map[string]bool)
+	for i, delivery := range deliveries {
+
+		if waiting[delivery.Webhook] {
+			continue
+		}
+
+		webhook, ok := dispatcher.webhooks[delivery.Webhook]
+		if !ok {
+			slog.Warn("Dropping event of a removed webhook.", "webhook", delivery.Webhook, "eventId", delivery.EventId)
+			dispatcher.updateOutbox(keys[i], nil)
+			continue
+		}
+
+		if now.Before(delivery.NextAttempt) {
+			waiting[webhook.Name] = true
+			continue
+		}
+
+		err := dispatcher.send(ctx, &webhook, &delivery)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			metrics.WebhookDeliveries.WithLabelValues(webhook.Name, "delivered").Inc()
+			dispatcher.updateOutbox(keys[i], nil)
+			continue
+		}
+
+		delivery.Attempts++
+		if delivery.Attempts >= dispatcher.maxAttempts {
+			metrics.WebhookDeliveries.WithLabelValues(webhook.Name, "dropped").Inc()
+			slog.Error("Dropping webhook event after its last attempt.", "webhook", webhook.Name,
+				"eventId", delivery.EventId, "attempts", delivery.Attempts, "error", err)
+			dispatcher.updateOutbox(keys[i], nil)
+			continue
+		}
+
+		metrics.WebhookDeliveries.WithLabelValues(webhook.Name, "failed").Inc()
+		delivery.NextAttempt = now.Add(backoff(delivery.Attempts))
+		slog.Warn("Webhook delivery failed.", "webhook", webhook.Name, "eventId", delivery.EventId,
+			"attempts", delivery.Attempts, "retryAt", delivery.NextAttempt, "error", err)
+
+		dispatcher.updateOutbox(keys[i], &delivery)
+		waiting[webhook.Name] = true
+	}
+}
+
+func (dispatcher *Dispatcher) readOutbox() ([][]byte, []delivery, error) {
+
+	var keys [][]byte
+	var deliveries []delivery
+	err := dispatcher.db.View(func(txn *badger.Txn) error {
+
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(outboxPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+
+			var entry delivery
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &entry)
+			})
+			if err != nil {
+				return err
+			}
+
+			keys = append(keys, it.Item().KeyCopy(nil))
+			deliveries = append(deliveries, entry)
+		}
+
+		return nil
+	})
+
+	return keys, deliveries, err
+}
+
+// updateOutbox stores a delivery for its next attempt, or removes it if
+// it's nil.
+func (dispatcher *Dispatcher) updateOutbox(key []byte, entry *delivery) {
+
+	err := dispatcher.db.Update(func(txn *badger.Txn) error {
+
+		if entry == nil {
+			return txn.Delete(key)
+		}
+
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		return txn.Set(key, value)
+	})
+
+	if err != nil {
+		slog.Error("Failed to update webhook outbox.", "error", err)
+	}
+}
+
+func (dispatcher *Dispatcher) send(ctx context.Context, webhook *Webhook, delivery *delivery) error {
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(DeliveryHeader, delivery.EventId)
+	request.Header.Set(SignatureHeader, "sha256="+Sign(webhook.Secret, delivery.Body))
+
+	response, err := dispatcher.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", response.Status)
+	}
+
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of body, as sent in SignatureHeader.
+func Sign(secret string, body []byte) string {
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff doubles the wait after each failed attempt, starting at a second.
+func backoff(attempts int) time.Duration {
+
+	if attempts > 20 {
+		return maxBackoff
+	}
+
+	return min(time.Second<<(attempts-1), maxBackoff)
+}
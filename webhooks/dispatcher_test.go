@@ -0,0 +1,370 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"home-ssm/ssm"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/dgraph-io/badger/v4"
+)
+
+const testSecret = "webhook-secret"
+
+func openTestDB(t *testing.T) *badger.DB {
+
+	t.Helper()
+
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// receiver records the requests it gets and answers with the queued
+// status codes, 200 once they're used up.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, string) {
+
+	t.Helper()
+
+	recv := receiver{statuses: statuses}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		body, _ := io.ReadAll(r.Body)
+
+		recv.mu.Lock()
+		defer recv.mu.Unlock()
+
+		recv.requests = append(recv.requests, r)
+		recv.bodies = append(recv.bodies, body)
+
+		status := http.StatusOK
+		if len(recv.statuses) > 0 {
+			status, recv.statuses = recv.statuses[0], recv.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	return &recv, server.URL
+}
+
+func newTestDispatcher(t *testing.T, db *badger.DB, url string) (*Dispatcher, *ssm.ParameterService) {
+
+	t.Helper()
+
+	dispatcher, err := NewDispatcher(db, Config{
+		MaxAttempts: 3,
+		Endpoints:   []Webhook{{Name: "app", Url: url, Secret: testSecret, PathPrefix: "/app/"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := ssm.NewParameterService("us-east-1", "000000000000", ssm.NewDataStore(db, nil))
+	service.AddChangeListener(dispatcher)
+
+	return dispatcher, service
+}
+
+func putParameter(service *ssm.ParameterService, name string, overwrite bool) error {
+
+	creds := aws.Credentials{AccessKeyID: "admin", AccountID: "000000000000"}
+	_, err := service.PutParameter(context.Background(), &creds, &awsssm.PutParameterInput{
+		Name:      aws.String(name),
+		Value:     aws.String("value"),
+		Type:      awstypes.ParameterTypeString,
+		Overwrite: aws.Bool(overwrite),
+	})
+
+	return err
+}
+
+func outbox(t *testing.T, dispatcher *Dispatcher) []delivery {
+
+	t.Helper()
+
+	_, deliveries, err := dispatcher.readOutbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return deliveries
+}
+
+func TestRecordChange(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		change func(service *ssm.ParameterService) error
+		// failed changes must not queue events
+		failed     bool
+		operations []string
+	}{
+		{
+			name:       "create",
+			change:     func(service *ssm.ParameterService) error { return putParameter(service, "/app/b", false) },
+			operations: []string{ssm.ChangeCreate},
+		},
+		{
+			name:       "update",
+			change:     func(service *ssm.ParameterService) error { return putParameter(service, "/app/a", true) },
+			operations: []string{ssm.ChangeUpdate},
+		},
+		{
+			name:   "create existing",
+			change: func(service *ssm.ParameterService) error { return putParameter(service, "/app/a", false) },
+			failed: true,
+		},
+		{
+			name: "delete",
+			change: func(service *ssm.ParameterService) error {
+				_, err := service.DeleteParameter(context.Background(), &awsssm.DeleteParameterInput{Name: aws.String("/app/a")})
+				return err
+			},
+			operations: []string{ssm.ChangeDelete},
+		},
+		{
+			name:   "other path",
+			change: func(service *ssm.ParameterService) error { return putParameter(service, "/other/a", false) },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			dispatcher, service := newTestDispatcher(t, openTestDB(t), "http://127.0.0.1:1")
+			if err := putParameter(service, "/app/a", false); err != nil {
+				t.Fatal(err)
+			}
+			queued := len(outbox(t, dispatcher))
+
+			if err := test.change(service); (err != nil) != test.failed {
+				t.Fatalf("change error %v, want failed %v", err, test.failed)
+			}
+
+			var operations []string
+			for _, delivery := range outbox(t, dispatcher)[queued:] {
+
+				var event Event
+				if err := json.Unmarshal(delivery.Body, &event); err != nil {
+					t.Fatal(err)
+				}
+
+				if delivery.EventId != event.Id || delivery.Webhook != "app" {
+					t.Errorf("delivery of event %s to %s, want event %s to app", delivery.EventId, delivery.Webhook, event.Id)
+				}
+				operations = append(operations, event.Detail.Operation)
+			}
+
+			if len(operations) != len(test.operations) || (len(operations) > 0 && operations[0] != test.operations[0]) {
+				t.Errorf("queued %v, want %v", operations, test.operations)
+			}
+		})
+	}
+}
+
+// failingListener fails every change after the dispatcher queued its events.
+type failingListener struct{}
+
+func (failingListener) RecordChange(context.Context, *badger.Txn, *ssm.ParameterChange) (func(bool), error) {
+
+	return nil, badger.ErrConflict
+}
+
+func TestRecordChangeRollback(t *testing.T) {
+
+	db := openTestDB(t)
+	dispatcher, service := newTestDispatcher(t, db, "http://127.0.0.1:1")
+	service.AddChangeListener(failingListener{})
+
+	if err := putParameter(service, "/app/a", false); err == nil {
+		t.Fatal("change stored although a listener failed")
+	}
+
+	if deliveries := outbox(t, dispatcher); len(deliveries) != 0 {
+		t.Errorf("%d events queued for a change that wasn't stored", len(deliveries))
+	}
+}
+
+func TestSignature(t *testing.T) {
+
+	db := openTestDB(t)
+	recv, url := newReceiver(t)
+	dispatcher, service := newTestDispatcher(t, db, url)
+	if err := putParameter(service, "/app/a", false); err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher.deliverDue(context.Background(), time.Now())
+	if len(recv.requests) != 1 {
+		t.Fatalf("received %d requests, want 1", len(recv.requests))
+	}
+
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write(recv.bodies[0])
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	request := recv.requests[0]
+	if got := request.Header.Get(SignatureHeader); got != want {
+		t.Errorf("%s = %s, want %s", SignatureHeader, got, want)
+	}
+
+	var event Event
+	if err := json.Unmarshal(recv.bodies[0], &event); err != nil {
+		t.Fatal(err)
+	}
+
+	if request.Header.Get(DeliveryHeader) != event.Id || event.Detail.Name != "/app/a" {
+		t.Errorf("delivery %s of event %s for %s", request.Header.Get(DeliveryHeader), event.Id, event.Detail.Name)
+	}
+
+	if deliveries := outbox(t, dispatcher); len(deliveries) != 0 {
+		t.Errorf("%d deliveries left in the outbox after delivery", len(deliveries))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{5, 16 * time.Second},
+		{9, 256 * time.Second},
+		{10, maxBackoff},
+		{21, maxBackoff},
+		{100, maxBackoff},
+	}
+
+	for _, test := range tests {
+
+		if got := backoff(test.attempts); got != test.want {
+			t.Errorf("backoff(%d) = %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+
+	db := openTestDB(t)
+	recv, url := newReceiver(t, http.StatusInternalServerError, http.StatusServiceUnavailable)
+	dispatcher, service := newTestDispatcher(t, db, url)
+	for _, name := range []string{"/app/a", "/app/b"} {
+		if err := putParameter(service, name, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	steps := []struct {
+		name string
+		at   time.Time
+		// received is the number of requests so far, attempts those of
+		// the first event and pending the events left
+		received int
+		attempts int
+		pending  int
+	}{
+		{"first attempt fails", now, 1, 1, 2},
+		{"waits for the backoff", now.Add(time.Second / 2), 1, 1, 2},
+		{"second attempt fails", now.Add(time.Second), 2, 2, 2},
+		{"backoff doubled", now.Add(2 * time.Second), 2, 2, 2},
+		{"both delivered in order", now.Add(3 * time.Second), 4, 0, 0},
+	}
+
+	for _, step := range steps {
+
+		dispatcher.deliverDue(context.Background(), step.at)
+
+		deliveries := outbox(t, dispatcher)
+		if len(recv.requests) != step.received || len(deliveries) != step.pending {
+			t.Fatalf("%s: received %d, pending %d, want %d, %d",
+				step.name, len(recv.requests), len(deliveries), step.received, step.pending)
+		}
+
+		if step.pending > 0 && deliveries[0].Attempts != step.attempts {
+			t.Errorf("%s: %d attempts, want %d", step.name, deliveries[0].Attempts, step.attempts)
+		}
+	}
+
+	// retries carry the same event id, and the later event waited for them
+	ids := []string{}
+	for _, request := range recv.requests {
+		ids = append(ids, request.Header.Get(DeliveryHeader))
+	}
+
+	if ids[0] != ids[1] || ids[1] != ids[2] || ids[2] == ids[3] {
+		t.Errorf("delivered event ids %v, want the first event three times then the second", ids)
+	}
+}
+
+func TestDropAfterMaxAttempts(t *testing.T) {
+
+	db := openTestDB(t)
+	recv, url := newReceiver(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	dispatcher, service := newTestDispatcher(t, db, url)
+	if err := putParameter(service, "/app/a", false); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for i := range 3 {
+		dispatcher.deliverDue(context.Background(), now.Add(time.Duration(i)*maxBackoff))
+	}
+
+	if len(recv.requests) != 3 {
+		t.Errorf("received %d requests, want 3", len(recv.requests))
+	}
+
+	if deliveries := outbox(t, dispatcher); len(deliveries) != 0 {
+		t.Errorf("%d deliveries left after the last attempt", len(deliveries))
+	}
+}
+
+func TestRedeliveryAfterRestart(t *testing.T) {
+
+	db := openTestDB(t)
+	recv, url := newReceiver(t, http.StatusInternalServerError)
+	dispatcher, service := newTestDispatcher(t, db, url)
+	if err := putParameter(service, "/app/a", false); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	dispatcher.deliverDue(context.Background(), now)
+
+	restarted, _ := newTestDispatcher(t, db, url)
+	restarted.deliverDue(context.Background(), now.Add(time.Second))
+
+	if len(recv.requests) != 2 || recv.requests[0].Header.Get(DeliveryHeader) != recv.requests[1].Header.Get(DeliveryHeader) {
+		t.Fatalf("received %d requests, want the event sent again after the restart", len(recv.requests))
+	}
+
+	if deliveries := outbox(t, restarted); len(deliveries) != 0 {
+		t.Errorf("%d deliveries left after redelivery", len(deliveries))
+	}
+}
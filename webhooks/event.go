@@ -0,0 +1,53 @@
+package webhooks
+
+import (
+	"home-ssm/awslib"
+	"home-ssm/ssm"
+	"time"
+)
+
+const (
+	eventSource     = "aws.ssm"
+	eventDetailType = "Parameter Store Change"
+)
+
+// Event has the shape EventBridge gives Parameter Store Change events, so
+// receivers written for those can take webhooks too.
+type Event struct {
+	Version    string      `json:"version"`
+	Id         string      `json:"id"`
+	DetailType string      `json:"detail-type"`
+	Source     string      `json:"source"`
+	Account    string      `json:"account"`
+	Time       time.Time   `json:"time"`
+	Region     string      `json:"region"`
+	Resources  []string    `json:"resources"`
+	Detail     EventDetail `json:"detail"`
+}
+
+type EventDetail struct {
+	Operation   string `json:"operation"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+func newEvent(change *ssm.ParameterChange) *Event {
+
+	return &Event{
+		Version:    "0",
+		Id:         awslib.NewUUID(),
+		DetailType: eventDetailType,
+		Source:     eventSource,
+		Account:    change.AccountId,
+		Time:       change.Time.Truncate(time.Second),
+		Region:     change.Region,
+		Resources:  []string{change.ARN},
+		Detail: EventDetail{
+			Operation:   change.Operation,
+			Name:        change.Name,
+			Type:        string(change.Type),
+			Description: change.Description,
+		},
+	}
+}